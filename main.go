@@ -1,28 +1,36 @@
 package main
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
 	"log"
 	"log/slog"
 	"net/http"
 	"os"
+	"path/filepath"
 
-	_ "github.com/go-sql-driver/mysql"
 	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/email"
 	"github.com/snirkop89/mx-store/pkg/handlers"
+	"github.com/snirkop89/mx-store/pkg/jobs"
+	"github.com/snirkop89/mx-store/pkg/outbox"
+	"github.com/snirkop89/mx-store/pkg/payments"
+	"github.com/snirkop89/mx-store/pkg/pricing"
 	"github.com/snirkop89/mx-store/pkg/repository"
+	"github.com/snirkop89/mx-store/pkg/server"
+	"github.com/snirkop89/mx-store/pkg/sse"
+	"github.com/snirkop89/mx-store/pkg/webhooks"
 )
 
 var db *sql.DB
 
-func initDB() {
+func initDB(dsn string) repository.Dialect {
 	var err error
+	var dialect repository.Dialect
 	// Initialize the db variable
-	dsn := os.Getenv("DSN")
-	if dsn == "" {
-		log.Fatal("missing DSN in ENV")
-	}
-	db, err = sql.Open("mysql", dsn)
+	db, dialect, err = repository.Open(dsn)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -31,21 +39,77 @@ func initDB() {
 	if err = db.Ping(); err != nil {
 		log.Fatal(err)
 	}
+	return dialect
 }
 
 func main() {
+	args := os.Args[1:]
+	if len(args) >= 2 && args[0] == "config" && args[1] == "print" {
+		os.Exit(printConfig(args[2:]))
+	}
+	if len(args) >= 1 && args[0] == "migrate" {
+		os.Exit(migrate(args[1:]))
+	}
+	if len(args) >= 2 && args[0] == "payments" && args[1] == "replay" {
+		os.Exit(replayPayments(args[2:]))
+	}
+
+	cfg, err := config.Load(os.Args[0], args, os.Getenv)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := cfg.Validate(); err != nil {
+		log.Fatalf("invalid config:\n%v", err)
+	}
+
 	r := mux.NewRouter()
 
-	// Setup MySQL
-	initDB()
-	defer db.Close()
+	// Setup the database
+	dialect := initDB(cfg.Database.DSN)
+	if cfg.Database.AutoMigrate {
+		if err := repository.Migrate(context.Background(), db, dialect); err != nil {
+			log.Fatal(err)
+		}
+	}
 
-	// Setup Static folder for static files and images
-	fs := http.FileServer(http.Dir("./static"))
+	// Setup Static folder for static files and images. Uploads are mounted
+	// separately so the upload directory can live outside the static folder.
+	uploads := http.FileServer(http.Dir(cfg.Storage.UploadDir))
+	r.PathPrefix("/static/uploads/").Handler(http.StripPrefix("/static/uploads/", uploads))
+	fs := http.FileServer(http.Dir(cfg.Storage.StaticDir))
 	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", fs))
 
-	repo := repository.NewRepository(db)
-	handler := handlers.NewHandler(repo)
+	repo := repository.NewRepository(db, dialect, cfg.Database.QueryTimeout)
+	provider, err := payments.New(cfg.Payments)
+	if err != nil {
+		log.Fatal(err)
+	}
+	queue := jobs.New(repo.Job, cfg.Jobs)
+	hooks := webhooks.New(repo.Webhook, queue)
+	scheduler := pricing.NewScheduler(repo.Price, cfg.Prices.ScheduleInterval)
+	scheduler.Schedule(queue)
+	// Domain events are relayed from the outbox to the subscribers in the
+	// process, the webhooks and, when configured, the broker file
+	bus := &outbox.Bus{}
+	relay := outbox.New(repo.Outbox, cfg.Outbox)
+	relay.Add("bus", bus)
+	relay.Add("webhooks", webhooks.NewSink(hooks, repo))
+	if cfg.Outbox.BrokerFile != "" {
+		broker, err := outbox.OpenFileBroker(cfg.Outbox.BrokerFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer broker.Close()
+		relay.Add("broker", outbox.BrokerSink{Publisher: broker, Prefix: cfg.Outbox.SubjectPrefix})
+	}
+	relay.Schedule(queue)
+	live := sse.NewHub(cfg.Events)
+	mailer, err := email.New(cfg.Email, filepath.Join(cfg.Templates.Dir, "email"), queue)
+	if err != nil {
+		log.Fatal(err)
+	}
+	handler := handlers.NewHandler(repo, cfg, provider, mailer, queue, hooks, live)
+	bus.Subscribe(handler.PublishLive)
 
 	// User shopping Routes
 	r.HandleFunc("/", handler.ShoppingHomepage).Methods("GET")
@@ -54,9 +118,29 @@ func main() {
 	r.HandleFunc("/addtocart/{product_id}", handler.AddToCart).Methods("POST")
 	r.HandleFunc("/gotocart", handler.ShoppingCartView).Methods("GET")
 	r.HandleFunc("/updateorderitem", handler.UpdateOrderItemQuantity).Methods("PUT")
+	r.HandleFunc("/cartcoupon", handler.ApplyCoupon).Methods("POST")
+	r.HandleFunc("/cartcoupon", handler.RemoveCoupon).Methods("DELETE")
+	r.HandleFunc("/checkout", handler.CheckoutView).Methods("GET")
+	r.HandleFunc("/checkout/address", handler.SaveCheckoutAddress).Methods("POST")
+	r.HandleFunc("/checkout/shipping", handler.CheckoutShippingView).Methods("GET")
+	r.HandleFunc("/checkout/shipping", handler.SelectShippingMethod).Methods("POST")
+	r.HandleFunc("/addresses/{id}", handler.DeleteAddress).Methods("DELETE")
+	r.HandleFunc("/placeorder", handler.PlaceOrder).Methods("POST")
+	r.HandleFunc("/myorders", handler.MyOrdersView).Methods("GET")
+	r.HandleFunc("/myorders/{id}/return", handler.ReturnRequestView).Methods("GET")
+	r.HandleFunc("/myorders/{id}/returns", handler.RequestReturn).Methods("POST")
+
+	// Live updates of the storefront and the admin
+	r.HandleFunc("/events", handler.StoreEvents).Methods("GET")
+	r.HandleFunc("/admin/events", handler.AdminEvents).Methods("GET")
+
+	// Payment provider webhooks
+	r.HandleFunc("/webhooks/payments", handler.PaymentWebhook).Methods("POST")
 
 	// Admin Routes
 	r.HandleFunc("/seed-products", handler.SeedProducts).Methods("POST")
+	r.HandleFunc("/dashboard", handler.DashboardPage).Methods("GET")
+	r.HandleFunc("/dashboardstats", handler.DashboardStats).Methods("GET")
 	r.HandleFunc("/manageproducts", handler.ProductsPage).Methods("GET")
 	r.HandleFunc("/allproducts", handler.AllProductsView).Methods("GET")
 	r.HandleFunc("/products", handler.ListProducts).Methods("GET")
@@ -66,7 +150,161 @@ func main() {
 	r.HandleFunc("/editproduct/{id}", handler.EditProductView).Methods("GET")
 	r.HandleFunc("/products/{id}", handler.UpdateProduct).Methods("PUT")
 	r.HandleFunc("/products/{id}", handler.DeleteProduct).Methods("DELETE")
+	r.HandleFunc("/archivedproducts", handler.ArchivedProductsView).Methods("GET")
+	r.HandleFunc("/products/{id}/restore", handler.RestoreProduct).Methods("PUT")
+	r.HandleFunc("/products/{id}/purge", handler.PurgeProduct).Methods("DELETE")
+	r.HandleFunc("/managecoupons", handler.CouponsPage).Methods("GET")
+	r.HandleFunc("/coupons", handler.ListCoupons).Methods("GET")
+	r.HandleFunc("/coupons", handler.CreateCoupon).Methods("POST")
+	r.HandleFunc("/coupons/{id}/active", handler.SetCouponActive).Methods("PUT")
+	r.HandleFunc("/managepromotions", handler.PromotionsPage).Methods("GET")
+	r.HandleFunc("/promotions", handler.ListPromotions).Methods("GET")
+	r.HandleFunc("/promotions", handler.CreatePromotion).Methods("POST")
+	r.HandleFunc("/promotions/{id}/active", handler.SetPromotionActive).Methods("PUT")
+	r.HandleFunc("/managetaxes", handler.TaxRatesPage).Methods("GET")
+	r.HandleFunc("/taxrates", handler.ListTaxRates).Methods("GET")
+	r.HandleFunc("/taxrates", handler.SaveTaxRate).Methods("POST")
+	r.HandleFunc("/taxrates/{id}", handler.DeleteTaxRate).Methods("DELETE")
+	r.HandleFunc("/manageshipping", handler.ShippingMethodsPage).Methods("GET")
+	r.HandleFunc("/shippingmethods", handler.ListShippingMethods).Methods("GET")
+	r.HandleFunc("/shippingmethods", handler.CreateShippingMethod).Methods("POST")
+	r.HandleFunc("/shippingmethods/{id}/active", handler.SetShippingMethodActive).Methods("PUT")
+	r.HandleFunc("/managereturns", handler.ReturnsPage).Methods("GET")
+	r.HandleFunc("/returns", handler.ListReturns).Methods("GET")
+	r.HandleFunc("/returns/{id}/approve", handler.ApproveReturn).Methods("PUT")
+	r.HandleFunc("/returns/{id}/reject", handler.RejectReturn).Methods("PUT")
+	r.HandleFunc("/returns/{id}/receive", handler.ReceiveReturn).Methods("PUT")
+	r.HandleFunc("/returns/{id}/refund", handler.RefundReturn).Methods("PUT")
+	r.HandleFunc("/devmail", handler.DevMailPage).Methods("GET")
+	r.HandleFunc("/devmail/messages", handler.ListDevMail).Methods("GET")
+	r.HandleFunc("/devmail/messages", handler.ClearDevMail).Methods("DELETE")
+	r.HandleFunc("/devmail/messages/{id}", handler.DevMailMessage).Methods("GET")
+	r.HandleFunc("/devmail/samples", handler.SendSampleMail).Methods("POST")
+	r.HandleFunc("/managejobs", handler.JobsPage).Methods("GET")
+	r.HandleFunc("/jobs", handler.ListJobs).Methods("GET")
+	r.HandleFunc("/jobs/{id}/retry", handler.RetryJob).Methods("PUT")
+	r.HandleFunc("/managewebhooks", handler.WebhooksPage).Methods("GET")
+	r.HandleFunc("/webhooksubscriptions", handler.ListWebhookSubscriptions).Methods("GET")
+	r.HandleFunc("/webhooksubscriptions", handler.CreateWebhookSubscription).Methods("POST")
+	r.HandleFunc("/webhooksubscriptions/{id}/active", handler.SetWebhookSubscriptionActive).Methods("PUT")
+	r.HandleFunc("/webhooksubscriptions/{id}", handler.DeleteWebhookSubscription).Methods("DELETE")
+	r.HandleFunc("/webhookdeliveries", handler.ListWebhookDeliveries).Methods("GET")
+	r.HandleFunc("/webhookdeliveries/{id}/redeliver", handler.RedeliverWebhook).Methods("POST")
+	r.HandleFunc("/activitylog", handler.ActivityLogPage).Methods("GET")
+	r.HandleFunc("/auditevents", handler.ListAuditEvents).Methods("GET")
+	r.HandleFunc("/products/{id}/prices", handler.ListProductPrices).Methods("GET")
+	r.HandleFunc("/products/{id}/prices", handler.SchedulePrice).Methods("POST")
+	r.HandleFunc("/products/{id}/prices/{price_id}", handler.CancelScheduledPrice).Methods("DELETE")
+
+	srv, err := server.New(cfg.Server, r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	// Event streams never finish on their own
+	srv.HTTP.RegisterOnShutdown(live.Close)
+
+	// Run background jobs and relay events while serving. ctx is only
+	// cancelled once the server has drained all requests, and jobs and
+	// events that are running then are finished before they stop.
+	ctx, cancel := context.WithCancel(context.Background())
+	jobsDone := make(chan struct{})
+	go func() {
+		defer close(jobsDone)
+		queue.Run(ctx)
+	}()
+	relayDone := make(chan struct{})
+	go func() {
+		defer close(relayDone)
+		relay.Run(ctx)
+	}()
+
+	runErr := srv.Run(ctx)
+	cancel()
+	<-jobsDone
+	<-relayDone
+
+	// The server has drained all requests, and the job queue and the relay
+	// have stopped, so nothing is using the pool anymore
+	if err := db.Close(); err != nil {
+		slog.Error("Closing database", "err", err)
+	}
+	if runErr != nil {
+		log.Fatal(runErr)
+	}
+	slog.Info("Server stopped")
+}
+
+// printConfig implements the "config print" command. It prints the effective
+// configuration with secrets redacted and reports validation errors.
+func printConfig(args []string) int {
+	cfg, err := config.Load("config print", args, os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if err := cfg.Print(os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	if err := cfg.Validate(); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid config:\n%v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// migrate implements the "migrate" command, which applies all pending
+// migrations for the configured database.
+func migrate(args []string) int {
+	cfg, err := config.Load("migrate", args, os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if cfg.Database.DSN == "" {
+		fmt.Fprintln(os.Stderr, "database.dsn is required")
+		return 1
+	}
+
+	dialect := initDB(cfg.Database.DSN)
+	defer db.Close()
+
+	if err := repository.Migrate(context.Background(), db, dialect); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	slog.Info("Migrations applied", "dialect", dialect)
+	return 0
+}
+
+// replayPayments implements the "payments replay" command, which processes
+// the payment webhooks that failed processing again.
+func replayPayments(args []string) int {
+	cfg, err := config.Load("payments replay", args, os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if cfg.Database.DSN == "" {
+		fmt.Fprintln(os.Stderr, "database.dsn is required")
+		return 1
+	}
+	provider, err := payments.New(cfg.Payments)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
 
-	slog.Info("Starting server", "addr", ":5000")
-	http.ListenAndServe(":5000", r)
+	dialect := initDB(cfg.Database.DSN)
+	defer db.Close()
+
+	repo := repository.NewRepository(db, dialect, cfg.Database.QueryTimeout)
+	// The events of the orders this changes are relayed by the server
+	replayed, err := payments.NewWebhooks(repo, provider).Replay(context.Background())
+	slog.Info("Payment events replayed", "provider", provider.Name(), "count", replayed)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
 }
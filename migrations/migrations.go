@@ -0,0 +1,8 @@
+// Package migrations embeds the schema migrations. Every SQL dialect has its
+// own directory with files named <version>_<name>.up.sql and .down.sql.
+package migrations
+
+import "embed"
+
+//go:embed */*.sql
+var FS embed.FS
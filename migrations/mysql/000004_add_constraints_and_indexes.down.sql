@@ -0,0 +1,18 @@
+ALTER TABLE products
+    DROP CONSTRAINT chk_products_price,
+    DROP INDEX idx_products_date_created;
+
+ALTER TABLE orders
+    DROP INDEX idx_orders_order_date,
+    DROP INDEX idx_orders_user_id;
+
+ALTER TABLE order_items
+    DROP FOREIGN KEY fk_order_items_product,
+    DROP FOREIGN KEY fk_order_items_order,
+    DROP CONSTRAINT chk_order_items_cost,
+    DROP CONSTRAINT chk_order_items_quantity;
+
+ALTER TABLE order_items
+    DROP INDEX idx_order_items_product_id,
+    DROP PRIMARY KEY,
+    MODIFY product_id VARCHAR(50);
@@ -0,0 +1,21 @@
+-- Order items that point to a missing order or product cannot satisfy the new
+-- foreign keys and never showed up in GetOrderWithProducts anyway.
+DELETE FROM order_items WHERE order_id NOT IN (SELECT order_id FROM orders);
+DELETE FROM order_items WHERE product_id IS NULL OR product_id NOT IN (SELECT product_id FROM products);
+
+ALTER TABLE order_items
+    MODIFY product_id VARCHAR(50) NOT NULL,
+    ADD PRIMARY KEY (order_id, product_id),
+    ADD INDEX idx_order_items_product_id (product_id),
+    ADD CONSTRAINT fk_order_items_order FOREIGN KEY (order_id) REFERENCES orders (order_id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_order_items_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE RESTRICT,
+    ADD CONSTRAINT chk_order_items_quantity CHECK (quantity > 0),
+    ADD CONSTRAINT chk_order_items_cost CHECK (cost >= 0);
+
+ALTER TABLE orders
+    ADD INDEX idx_orders_user_id (user_id),
+    ADD INDEX idx_orders_order_date (order_date);
+
+ALTER TABLE products
+    ADD INDEX idx_products_date_created (date_created),
+    ADD CONSTRAINT chk_products_price CHECK (price >= 0);
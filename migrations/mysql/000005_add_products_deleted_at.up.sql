@@ -0,0 +1,3 @@
+ALTER TABLE products
+    ADD COLUMN deleted_at DATETIME NULL,
+    ADD INDEX idx_products_deleted_at (deleted_at);
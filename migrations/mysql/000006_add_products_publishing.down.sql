@@ -0,0 +1,6 @@
+ALTER TABLE products
+    DROP INDEX idx_products_status_publish_at,
+    DROP CONSTRAINT chk_products_status,
+    DROP COLUMN unpublish_at,
+    DROP COLUMN publish_at,
+    DROP COLUMN status;
@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_events (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    occurred_at DATETIME(6) NOT NULL,
+    actor VARCHAR(100) NOT NULL,
+    action VARCHAR(30) NOT NULL,
+    entity_type VARCHAR(30) NOT NULL,
+    entity_id VARCHAR(50) NOT NULL,
+    before_data JSON NULL,
+    after_data JSON NULL,
+    ip VARCHAR(45) NOT NULL,
+    INDEX idx_audit_events_occurred_at (occurred_at),
+    INDEX idx_audit_events_entity (entity_type, entity_id),
+    INDEX idx_audit_events_actor (actor),
+    INDEX idx_audit_events_action (action)
+);
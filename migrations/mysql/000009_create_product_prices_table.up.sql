@@ -0,0 +1,19 @@
+-- One row per price a product has had or will have. Rows with applied_at set
+-- are history, rows without it are scheduled changes waiting for effective_at.
+CREATE TABLE IF NOT EXISTS product_prices (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    product_id VARCHAR(50) NOT NULL,
+    price FLOAT NOT NULL,
+    effective_at DATETIME(6) NOT NULL,
+    applied_at DATETIME(6) NULL,
+    created_at DATETIME(6) NOT NULL,
+    INDEX idx_product_prices_product (product_id, effective_at),
+    INDEX idx_product_prices_due (applied_at, effective_at),
+    CONSTRAINT fk_product_prices_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE CASCADE,
+    CONSTRAINT chk_product_prices_price CHECK (price >= 0)
+);
+
+-- Start the history with the current prices
+INSERT INTO product_prices (product_id, price, effective_at, applied_at, created_at)
+    SELECT product_id, price, COALESCE(date_created, CURRENT_TIMESTAMP(6)), COALESCE(date_created, CURRENT_TIMESTAMP(6)), CURRENT_TIMESTAMP(6)
+    FROM products;
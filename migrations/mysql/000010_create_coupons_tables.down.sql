@@ -0,0 +1,7 @@
+ALTER TABLE orders
+    DROP CHECK chk_orders_discount,
+    DROP COLUMN discount,
+    DROP COLUMN coupon_code;
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupon_products;
+DROP TABLE IF EXISTS coupons;
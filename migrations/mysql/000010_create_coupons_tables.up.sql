@@ -0,0 +1,48 @@
+-- max_uses and max_uses_per_customer of 0 mean unlimited. times_used is
+-- incremented together with the redemption so the limit holds under
+-- concurrent checkouts.
+CREATE TABLE IF NOT EXISTS coupons (
+    coupon_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    code VARCHAR(50) NOT NULL,
+    discount_type VARCHAR(10) NOT NULL,
+    amount FLOAT NOT NULL,
+    min_cart_value FLOAT NOT NULL DEFAULT 0,
+    starts_at DATETIME(6) NULL,
+    ends_at DATETIME(6) NULL,
+    max_uses INT NOT NULL DEFAULT 0,
+    max_uses_per_customer INT NOT NULL DEFAULT 0,
+    times_used INT NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created DATETIME(6) NOT NULL,
+    UNIQUE KEY uq_coupons_code (code),
+    CONSTRAINT chk_coupons_discount_type CHECK (discount_type IN ('percent', 'fixed')),
+    CONSTRAINT chk_coupons_amount CHECK (amount >= 0),
+    CONSTRAINT chk_coupons_min_cart_value CHECK (min_cart_value >= 0)
+);
+
+-- Coupons without rows here apply to every product
+CREATE TABLE IF NOT EXISTS coupon_products (
+    coupon_id BIGINT NOT NULL,
+    product_id VARCHAR(50) NOT NULL,
+    PRIMARY KEY (coupon_id, product_id),
+    INDEX idx_coupon_products_product_id (product_id),
+    CONSTRAINT fk_coupon_products_coupon FOREIGN KEY (coupon_id) REFERENCES coupons (coupon_id) ON DELETE CASCADE,
+    CONSTRAINT fk_coupon_products_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    coupon_id BIGINT NOT NULL,
+    order_id VARCHAR(50) NOT NULL,
+    user_id VARCHAR(50) NOT NULL,
+    discount FLOAT NOT NULL,
+    redeemed_at DATETIME(6) NOT NULL,
+    INDEX idx_coupon_redemptions_customer (coupon_id, user_id),
+    CONSTRAINT fk_coupon_redemptions_coupon FOREIGN KEY (coupon_id) REFERENCES coupons (coupon_id) ON DELETE RESTRICT,
+    CONSTRAINT fk_coupon_redemptions_order FOREIGN KEY (order_id) REFERENCES orders (order_id) ON DELETE CASCADE
+);
+
+ALTER TABLE orders
+    ADD COLUMN coupon_code VARCHAR(50) NULL,
+    ADD COLUMN discount FLOAT NOT NULL DEFAULT 0,
+    ADD CONSTRAINT chk_orders_discount CHECK (discount >= 0);
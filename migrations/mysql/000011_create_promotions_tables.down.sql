@@ -0,0 +1,6 @@
+ALTER TABLE order_items
+    DROP CHECK chk_order_items_discount,
+    DROP COLUMN discount;
+DROP TABLE IF EXISTS order_promotions;
+DROP TABLE IF EXISTS promotion_products;
+DROP TABLE IF EXISTS promotions;
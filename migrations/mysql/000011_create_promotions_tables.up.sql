@@ -0,0 +1,50 @@
+-- Automatic promotions. Which columns are used depends on kind:
+-- buy_x_get_y uses buy_quantity and free_quantity, spend_threshold uses
+-- min_subtotal and percent and bundle uses bundle_price.
+CREATE TABLE IF NOT EXISTS promotions (
+    promotion_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(20) NOT NULL,
+    buy_quantity INT NOT NULL DEFAULT 0,
+    free_quantity INT NOT NULL DEFAULT 0,
+    min_subtotal FLOAT NOT NULL DEFAULT 0,
+    percent FLOAT NOT NULL DEFAULT 0,
+    bundle_price FLOAT NOT NULL DEFAULT 0,
+    priority INT NOT NULL DEFAULT 0,
+    stackable BOOLEAN NOT NULL DEFAULT FALSE,
+    starts_at DATETIME(6) NULL,
+    ends_at DATETIME(6) NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created DATETIME(6) NOT NULL,
+    INDEX idx_promotions_priority (priority),
+    CONSTRAINT chk_promotions_kind CHECK (kind IN ('buy_x_get_y', 'spend_threshold', 'bundle')),
+    CONSTRAINT chk_promotions_percent CHECK (percent >= 0 AND percent <= 100)
+);
+
+-- Promotions without rows here apply to every product, bundles list the
+-- products that make up the bundle
+CREATE TABLE IF NOT EXISTS promotion_products (
+    promotion_id BIGINT NOT NULL,
+    product_id VARCHAR(50) NOT NULL,
+    PRIMARY KEY (promotion_id, product_id),
+    INDEX idx_promotion_products_product_id (product_id),
+    CONSTRAINT fk_promotion_products_promotion FOREIGN KEY (promotion_id) REFERENCES promotions (promotion_id) ON DELETE CASCADE,
+    CONSTRAINT fk_promotion_products_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE CASCADE
+);
+
+-- The promotions applied to an order, with the label shown at checkout
+CREATE TABLE IF NOT EXISTS order_promotions (
+    id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    order_id VARCHAR(50) NOT NULL,
+    promotion_id BIGINT NULL,
+    label VARCHAR(100) NOT NULL,
+    discount FLOAT NOT NULL,
+    INDEX idx_order_promotions_order_id (order_id),
+    CONSTRAINT fk_order_promotions_order FOREIGN KEY (order_id) REFERENCES orders (order_id) ON DELETE CASCADE,
+    CONSTRAINT fk_order_promotions_promotion FOREIGN KEY (promotion_id) REFERENCES promotions (promotion_id) ON DELETE SET NULL
+);
+
+-- cost is what the line costs after promotions, discount what they took off
+ALTER TABLE order_items
+    ADD COLUMN discount FLOAT NOT NULL DEFAULT 0,
+    ADD CONSTRAINT chk_order_items_discount CHECK (discount >= 0);
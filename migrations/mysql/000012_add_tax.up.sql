@@ -0,0 +1,27 @@
+-- Tax rates by region and product tax class. An empty state is the rate for
+-- the whole country, used when the state has no rate of its own.
+CREATE TABLE IF NOT EXISTS tax_rates (
+    tax_rate_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    country VARCHAR(2) NOT NULL,
+    state VARCHAR(50) NOT NULL DEFAULT '',
+    tax_class VARCHAR(20) NOT NULL DEFAULT 'standard',
+    name VARCHAR(100) NOT NULL,
+    rate FLOAT NOT NULL,
+    UNIQUE INDEX idx_tax_rates_region_class (country, state, tax_class),
+    CONSTRAINT chk_tax_rates_rate CHECK (rate >= 0 AND rate <= 100)
+);
+
+ALTER TABLE products ADD COLUMN tax_class VARCHAR(20) NOT NULL DEFAULT 'standard';
+
+-- tax is the tax on the line after discounts, at tax_rate percent. Whether
+-- it is included in cost is recorded on the order.
+ALTER TABLE order_items
+    ADD COLUMN tax FLOAT NOT NULL DEFAULT 0,
+    ADD COLUMN tax_rate FLOAT NOT NULL DEFAULT 0,
+    ADD COLUMN tax_name VARCHAR(100) NULL,
+    ADD CONSTRAINT chk_order_items_tax CHECK (tax >= 0);
+
+ALTER TABLE orders
+    ADD COLUMN tax FLOAT NOT NULL DEFAULT 0,
+    ADD COLUMN prices_include_tax BOOLEAN NOT NULL DEFAULT FALSE,
+    ADD CONSTRAINT chk_orders_tax CHECK (tax >= 0);
@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS order_addresses;
+ALTER TABLE orders
+    DROP CHECK chk_orders_shipping_cost,
+    DROP COLUMN shipping_cost,
+    DROP COLUMN shipping_method;
+ALTER TABLE products
+    DROP CHECK chk_products_weight,
+    DROP COLUMN weight;
+DROP TABLE IF EXISTS shipping_methods;
+DROP TABLE IF EXISTS addresses;
@@ -0,0 +1,59 @@
+-- The address book of each customer
+CREATE TABLE IF NOT EXISTS addresses (
+    address_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    user_id VARCHAR(50) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    line1 VARCHAR(200) NOT NULL,
+    line2 VARCHAR(200) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL,
+    state VARCHAR(50) NOT NULL DEFAULT '',
+    postal_code VARCHAR(20) NOT NULL,
+    country VARCHAR(2) NOT NULL,
+    phone VARCHAR(30) NOT NULL DEFAULT '',
+    date_created DATETIME(6) NOT NULL,
+    INDEX idx_addresses_user_id (user_id)
+);
+
+-- flat methods cost price, weight methods price plus per_kg for every
+-- kilogram. Carts of free_over or more ship for free when it is set.
+CREATE TABLE IF NOT EXISTS shipping_methods (
+    shipping_method_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(10) NOT NULL,
+    price FLOAT NOT NULL DEFAULT 0,
+    per_kg FLOAT NOT NULL DEFAULT 0,
+    free_over FLOAT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created DATETIME(6) NOT NULL,
+    CONSTRAINT chk_shipping_methods_kind CHECK (kind IN ('flat', 'weight')),
+    CONSTRAINT chk_shipping_methods_price CHECK (price >= 0),
+    CONSTRAINT chk_shipping_methods_per_kg CHECK (per_kg >= 0),
+    CONSTRAINT chk_shipping_methods_free_over CHECK (free_over >= 0)
+);
+
+ALTER TABLE products
+    ADD COLUMN weight FLOAT NOT NULL DEFAULT 0,
+    ADD CONSTRAINT chk_products_weight CHECK (weight >= 0);
+
+ALTER TABLE orders
+    ADD COLUMN shipping_method VARCHAR(100) NULL,
+    ADD COLUMN shipping_cost FLOAT NOT NULL DEFAULT 0,
+    ADD CONSTRAINT chk_orders_shipping_cost CHECK (shipping_cost >= 0);
+
+-- The addresses an order was placed with, copied so that later changes to
+-- the address book do not change past orders
+CREATE TABLE IF NOT EXISTS order_addresses (
+    order_id VARCHAR(50) NOT NULL,
+    kind VARCHAR(10) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    line1 VARCHAR(200) NOT NULL,
+    line2 VARCHAR(200) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL,
+    state VARCHAR(50) NOT NULL DEFAULT '',
+    postal_code VARCHAR(20) NOT NULL,
+    country VARCHAR(2) NOT NULL,
+    phone VARCHAR(30) NOT NULL DEFAULT '',
+    PRIMARY KEY (order_id, kind),
+    CONSTRAINT chk_order_addresses_kind CHECK (kind IN ('shipping', 'billing')),
+    CONSTRAINT fk_order_addresses_order FOREIGN KEY (order_id) REFERENCES orders (order_id) ON DELETE CASCADE
+);
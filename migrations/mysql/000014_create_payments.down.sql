@@ -0,0 +1,2 @@
+ALTER TABLE orders MODIFY COLUMN order_status VARCHAR(15);
+DROP TABLE IF EXISTS payments;
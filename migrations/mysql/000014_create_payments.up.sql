@@ -0,0 +1,23 @@
+-- Every attempt to pay for an order. provider_ref is the provider's ID for
+-- the payment, NULL when the attempt failed before the provider saw it.
+CREATE TABLE IF NOT EXISTS payments (
+    payment_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    order_id VARCHAR(50) NOT NULL,
+    provider VARCHAR(20) NOT NULL,
+    provider_ref VARCHAR(100) NULL,
+    amount FLOAT NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(15) NOT NULL,
+    captured_amount FLOAT NOT NULL DEFAULT 0,
+    refunded_amount FLOAT NOT NULL DEFAULT 0,
+    failure_reason VARCHAR(255) NULL,
+    date_created DATETIME(6) NOT NULL,
+    date_modified DATETIME(6) NOT NULL,
+    UNIQUE KEY uq_payments_provider_ref (provider, provider_ref),
+    INDEX idx_payments_order_id (order_id),
+    CONSTRAINT chk_payments_status CHECK (status IN ('authorized', 'failed', 'captured', 'refunded')),
+    CONSTRAINT chk_payments_amount CHECK (amount >= 0),
+    CONSTRAINT fk_payments_order FOREIGN KEY (order_id) REFERENCES orders (order_id) ON DELETE CASCADE
+);
+
+ALTER TABLE orders MODIFY COLUMN order_status VARCHAR(30);
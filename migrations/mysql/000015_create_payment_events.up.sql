@@ -0,0 +1,17 @@
+-- Webhooks received from payment providers. The unique provider event ID
+-- keeps an event that is sent again from being processed twice.
+CREATE TABLE IF NOT EXISTS payment_events (
+    payment_event_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    provider VARCHAR(20) NOT NULL,
+    provider_event_id VARCHAR(100) NOT NULL,
+    event_type VARCHAR(100) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    error TEXT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    received_at DATETIME(6) NOT NULL,
+    processed_at DATETIME(6) NULL,
+    UNIQUE KEY uq_payment_events_provider_event_id (provider, provider_event_id),
+    INDEX idx_payment_events_status (status),
+    CONSTRAINT chk_payment_events_status CHECK (status IN ('received', 'processed', 'ignored', 'failed'))
+);
@@ -0,0 +1,8 @@
+DROP TABLE IF EXISTS order_return_items;
+DROP TABLE IF EXISTS order_returns;
+ALTER TABLE orders
+    DROP CHECK chk_orders_refunded_amount,
+    DROP COLUMN refunded_amount;
+ALTER TABLE products
+    DROP CHECK chk_products_stock,
+    DROP COLUMN stock;
@@ -0,0 +1,40 @@
+-- NULL stock means the product's stock is not tracked
+ALTER TABLE products
+    ADD COLUMN stock INT NULL,
+    ADD CONSTRAINT chk_products_stock CHECK (stock >= 0);
+
+ALTER TABLE orders
+    ADD COLUMN refunded_amount FLOAT NOT NULL DEFAULT 0,
+    ADD CONSTRAINT chk_orders_refunded_amount CHECK (refunded_amount >= 0);
+
+-- A customer's request to send back items of an order. refund_amount is
+-- what was refunded once the items were received.
+CREATE TABLE IF NOT EXISTS order_returns (
+    return_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    order_id VARCHAR(50) NOT NULL,
+    user_id VARCHAR(50) NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    reason VARCHAR(500) NOT NULL DEFAULT '',
+    note VARCHAR(500) NOT NULL DEFAULT '',
+    refund_amount FLOAT NOT NULL DEFAULT 0,
+    refund_ref VARCHAR(100) NULL,
+    date_created DATETIME(6) NOT NULL,
+    date_modified DATETIME(6) NOT NULL,
+    INDEX idx_order_returns_order_id (order_id),
+    INDEX idx_order_returns_user_id (user_id),
+    CONSTRAINT chk_order_returns_status CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunded')),
+    CONSTRAINT chk_order_returns_refund_amount CHECK (refund_amount >= 0),
+    CONSTRAINT fk_order_returns_order FOREIGN KEY (order_id) REFERENCES orders (order_id) ON DELETE CASCADE
+);
+
+-- amount is what the returned quantity was paid, after discounts and with tax
+CREATE TABLE IF NOT EXISTS order_return_items (
+    return_id BIGINT NOT NULL,
+    product_id VARCHAR(50) NOT NULL,
+    quantity INT NOT NULL,
+    amount FLOAT NOT NULL,
+    PRIMARY KEY (return_id, product_id),
+    CONSTRAINT chk_order_return_items_quantity CHECK (quantity > 0),
+    CONSTRAINT fk_order_return_items_return FOREIGN KEY (return_id) REFERENCES order_returns (return_id) ON DELETE CASCADE,
+    CONSTRAINT fk_order_return_items_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE RESTRICT
+);
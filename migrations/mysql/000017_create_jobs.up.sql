@@ -0,0 +1,19 @@
+-- Work done in the background. Workers claim due queued jobs by setting them
+-- running until locked_until, after which another worker may take over.
+CREATE TABLE IF NOT EXISTS jobs (
+    job_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    kind VARCHAR(100) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    max_attempts INT NOT NULL,
+    run_at DATETIME(6) NOT NULL,
+    locked_until DATETIME(6) NULL,
+    last_error TEXT NULL,
+    date_created DATETIME(6) NOT NULL,
+    date_modified DATETIME(6) NOT NULL,
+    INDEX idx_jobs_status_run_at (status, run_at),
+    INDEX idx_jobs_kind (kind),
+    CONSTRAINT chk_jobs_status CHECK (status IN ('queued', 'running', 'succeeded', 'dead')),
+    CONSTRAINT chk_jobs_max_attempts CHECK (max_attempts > 0)
+);
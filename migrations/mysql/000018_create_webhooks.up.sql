@@ -0,0 +1,35 @@
+-- Subscriptions of other systems to changes in the store. Every event sent
+-- to a subscription is a delivery, signed with the subscription's secret.
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    subscription_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    url VARCHAR(500) NOT NULL,
+    secret VARCHAR(100) NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created DATETIME(6) NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscription_events (
+    subscription_id BIGINT NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    PRIMARY KEY (subscription_id, event),
+    CONSTRAINT fk_webhook_subscription_events_subscription FOREIGN KEY (subscription_id)
+        REFERENCES webhook_subscriptions (subscription_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id BIGINT NOT NULL AUTO_INCREMENT PRIMARY KEY,
+    subscription_id BIGINT NOT NULL,
+    event_id VARCHAR(50) NOT NULL,
+    event VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    response_status INT NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    date_created DATETIME(6) NOT NULL,
+    delivered_at DATETIME(6) NULL,
+    INDEX idx_webhook_deliveries_subscription_id (subscription_id),
+    CONSTRAINT fk_webhook_deliveries_subscription FOREIGN KEY (subscription_id)
+        REFERENCES webhook_subscriptions (subscription_id) ON DELETE CASCADE,
+    CONSTRAINT chk_webhook_deliveries_status CHECK (status IN ('pending', 'delivered', 'failed'))
+);
@@ -0,0 +1,4 @@
+UPDATE order_returns SET status = 'received' WHERE status = 'refunding';
+ALTER TABLE order_returns DROP CHECK chk_order_returns_status;
+ALTER TABLE order_returns ADD CONSTRAINT chk_order_returns_status
+    CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunded'));
@@ -0,0 +1,5 @@
+-- Returns are claimed for their refund by moving them to refunding first, so
+-- that only one request refunds them.
+ALTER TABLE order_returns DROP CHECK chk_order_returns_status;
+ALTER TABLE order_returns ADD CONSTRAINT chk_order_returns_status
+    CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunding', 'refunded'));
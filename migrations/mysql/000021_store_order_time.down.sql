@@ -0,0 +1 @@
+ALTER TABLE orders MODIFY order_date DATE;
@@ -0,0 +1,5 @@
+-- order_date was a DATE, which dropped the time orders were placed at. Sales
+-- are summed up over periods that start and end at any time, so it keeps
+-- the time to the microsecond like the other timestamps. Orders placed
+-- before keep midnight of their day.
+ALTER TABLE orders MODIFY order_date DATETIME(6);
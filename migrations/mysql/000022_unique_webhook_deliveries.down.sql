@@ -0,0 +1 @@
+DROP INDEX uq_webhook_deliveries_subscription_event ON webhook_deliveries;
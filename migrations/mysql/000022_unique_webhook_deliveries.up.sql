@@ -0,0 +1,7 @@
+-- A subscription gets each event once, so that publishing an event again,
+-- as the outbox does when it retries, skips the deliveries made before
+-- instead of sending them twice. Of duplicates made earlier the first
+-- delivery is kept.
+DELETE d FROM webhook_deliveries d
+JOIN webhook_deliveries k ON k.subscription_id = d.subscription_id AND k.event_id = d.event_id AND k.delivery_id < d.delivery_id;
+CREATE UNIQUE INDEX uq_webhook_deliveries_subscription_event ON webhook_deliveries (subscription_id, event_id);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS coupon_categories;
+ALTER TABLE products DROP COLUMN category;
@@ -0,0 +1,11 @@
+-- Products can be put in a category, and coupons restricted to categories
+-- as well as to single products. Coupons without rows here or in
+-- coupon_products apply to every product.
+ALTER TABLE products ADD COLUMN category VARCHAR(100) NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS coupon_categories (
+    coupon_id BIGINT NOT NULL,
+    category VARCHAR(100) NOT NULL,
+    PRIMARY KEY (coupon_id, category),
+    CONSTRAINT fk_coupon_categories_coupon FOREIGN KEY (coupon_id) REFERENCES coupons (coupon_id) ON DELETE CASCADE
+);
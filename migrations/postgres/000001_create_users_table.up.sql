@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS products;
+CREATE TABLE IF NOT EXISTS products (
+    product_id UUID NOT NULL PRIMARY KEY,
+    product_name VARCHAR(100) NOT NULL,
+    price NUMERIC(10, 2) NOT NULL,
+    description TEXT NOT NULL,
+    product_image VARCHAR(50),
+    date_created TIMESTAMPTZ,
+    date_modified TIMESTAMPTZ
+);
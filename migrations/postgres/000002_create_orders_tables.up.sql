@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS orders;
+CREATE TABLE IF NOT EXISTS orders (
+    order_id UUID PRIMARY KEY NOT NULL,
+    user_id VARCHAR(50),
+    order_status VARCHAR(15),
+    order_date TIMESTAMPTZ
+);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS order_items;
+CREATE TABLE IF NOT EXISTS order_items (
+    order_id UUID NOT NULL,
+    product_id UUID,
+    quantity INT DEFAULT 1,
+    cost NUMERIC(12, 2)
+);
@@ -0,0 +1,14 @@
+ALTER TABLE products DROP CONSTRAINT chk_products_price;
+DROP INDEX idx_products_date_created;
+
+DROP INDEX idx_orders_order_date;
+DROP INDEX idx_orders_user_id;
+
+DROP INDEX idx_order_items_product_id;
+ALTER TABLE order_items
+    DROP CONSTRAINT chk_order_items_cost,
+    DROP CONSTRAINT chk_order_items_quantity,
+    DROP CONSTRAINT fk_order_items_product,
+    DROP CONSTRAINT fk_order_items_order,
+    DROP CONSTRAINT order_items_pkey,
+    ALTER COLUMN product_id DROP NOT NULL;
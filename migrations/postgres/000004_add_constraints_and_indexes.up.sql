@@ -0,0 +1,19 @@
+-- Order items that point to a missing order or product cannot satisfy the new
+-- foreign keys and never showed up in GetOrderWithProducts anyway.
+DELETE FROM order_items WHERE order_id NOT IN (SELECT order_id FROM orders);
+DELETE FROM order_items WHERE product_id IS NULL OR product_id NOT IN (SELECT product_id FROM products);
+
+ALTER TABLE order_items
+    ALTER COLUMN product_id SET NOT NULL,
+    ADD PRIMARY KEY (order_id, product_id),
+    ADD CONSTRAINT fk_order_items_order FOREIGN KEY (order_id) REFERENCES orders (order_id) ON DELETE CASCADE,
+    ADD CONSTRAINT fk_order_items_product FOREIGN KEY (product_id) REFERENCES products (product_id) ON DELETE RESTRICT,
+    ADD CONSTRAINT chk_order_items_quantity CHECK (quantity > 0),
+    ADD CONSTRAINT chk_order_items_cost CHECK (cost >= 0);
+CREATE INDEX idx_order_items_product_id ON order_items (product_id);
+
+CREATE INDEX idx_orders_user_id ON orders (user_id);
+CREATE INDEX idx_orders_order_date ON orders (order_date);
+
+CREATE INDEX idx_products_date_created ON products (date_created);
+ALTER TABLE products ADD CONSTRAINT chk_products_price CHECK (price >= 0);
@@ -0,0 +1,7 @@
+-- Existing products were live, so they start out published.
+ALTER TABLE products
+    ADD COLUMN status VARCHAR(10) NOT NULL DEFAULT 'published',
+    ADD COLUMN publish_at TIMESTAMPTZ NULL,
+    ADD COLUMN unpublish_at TIMESTAMPTZ NULL,
+    ADD CONSTRAINT chk_products_status CHECK (status IN ('draft', 'published', 'unlisted'));
+CREATE INDEX idx_products_status_publish_at ON products (status, publish_at);
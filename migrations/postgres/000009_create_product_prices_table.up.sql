@@ -0,0 +1,17 @@
+-- One row per price a product has had or will have. Rows with applied_at set
+-- are history, rows without it are scheduled changes waiting for effective_at.
+CREATE TABLE IF NOT EXISTS product_prices (
+    id BIGSERIAL PRIMARY KEY,
+    product_id UUID NOT NULL REFERENCES products (product_id) ON DELETE CASCADE,
+    price NUMERIC(10, 2) NOT NULL CHECK (price >= 0),
+    effective_at TIMESTAMPTZ NOT NULL,
+    applied_at TIMESTAMPTZ NULL,
+    created_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_product_prices_product ON product_prices (product_id, effective_at);
+CREATE INDEX idx_product_prices_due ON product_prices (effective_at) WHERE applied_at IS NULL;
+
+-- Start the history with the current prices
+INSERT INTO product_prices (product_id, price, effective_at, applied_at, created_at)
+    SELECT product_id, price, COALESCE(date_created, NOW()), COALESCE(date_created, NOW()), NOW()
+    FROM products;
@@ -0,0 +1,6 @@
+ALTER TABLE orders
+    DROP COLUMN discount,
+    DROP COLUMN coupon_code;
+DROP TABLE IF EXISTS coupon_redemptions;
+DROP TABLE IF EXISTS coupon_products;
+DROP TABLE IF EXISTS coupons;
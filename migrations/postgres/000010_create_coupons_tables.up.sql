@@ -0,0 +1,39 @@
+-- max_uses and max_uses_per_customer of 0 mean unlimited. times_used is
+-- incremented together with the redemption so the limit holds under
+-- concurrent checkouts.
+CREATE TABLE IF NOT EXISTS coupons (
+    coupon_id BIGSERIAL PRIMARY KEY,
+    code VARCHAR(50) NOT NULL UNIQUE,
+    discount_type VARCHAR(10) NOT NULL CHECK (discount_type IN ('percent', 'fixed')),
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount >= 0),
+    min_cart_value NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (min_cart_value >= 0),
+    starts_at TIMESTAMPTZ NULL,
+    ends_at TIMESTAMPTZ NULL,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    max_uses_per_customer INTEGER NOT NULL DEFAULT 0,
+    times_used INTEGER NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created TIMESTAMPTZ NOT NULL
+);
+
+-- Coupons without rows here apply to every product
+CREATE TABLE IF NOT EXISTS coupon_products (
+    coupon_id BIGINT NOT NULL REFERENCES coupons (coupon_id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products (product_id) ON DELETE CASCADE,
+    PRIMARY KEY (coupon_id, product_id)
+);
+CREATE INDEX idx_coupon_products_product_id ON coupon_products (product_id);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id BIGSERIAL PRIMARY KEY,
+    coupon_id BIGINT NOT NULL REFERENCES coupons (coupon_id) ON DELETE RESTRICT,
+    order_id UUID NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    user_id VARCHAR(50) NOT NULL,
+    discount NUMERIC(10, 2) NOT NULL,
+    redeemed_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_coupon_redemptions_customer ON coupon_redemptions (coupon_id, user_id);
+
+ALTER TABLE orders
+    ADD COLUMN coupon_code VARCHAR(50) NULL,
+    ADD COLUMN discount NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (discount >= 0);
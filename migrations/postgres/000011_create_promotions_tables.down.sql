@@ -0,0 +1,4 @@
+ALTER TABLE order_items DROP COLUMN discount;
+DROP TABLE IF EXISTS order_promotions;
+DROP TABLE IF EXISTS promotion_products;
+DROP TABLE IF EXISTS promotions;
@@ -0,0 +1,9 @@
+ALTER TABLE orders
+    DROP COLUMN prices_include_tax,
+    DROP COLUMN tax;
+ALTER TABLE order_items
+    DROP COLUMN tax_name,
+    DROP COLUMN tax_rate,
+    DROP COLUMN tax;
+ALTER TABLE products DROP COLUMN tax_class;
+DROP TABLE IF EXISTS tax_rates;
@@ -0,0 +1,24 @@
+-- Tax rates by region and product tax class. An empty state is the rate for
+-- the whole country, used when the state has no rate of its own.
+CREATE TABLE IF NOT EXISTS tax_rates (
+    tax_rate_id BIGSERIAL PRIMARY KEY,
+    country VARCHAR(2) NOT NULL,
+    state VARCHAR(50) NOT NULL DEFAULT '',
+    tax_class VARCHAR(20) NOT NULL DEFAULT 'standard',
+    name VARCHAR(100) NOT NULL,
+    rate NUMERIC(6, 3) NOT NULL CHECK (rate >= 0 AND rate <= 100),
+    UNIQUE (country, state, tax_class)
+);
+
+ALTER TABLE products ADD COLUMN tax_class VARCHAR(20) NOT NULL DEFAULT 'standard';
+
+-- tax is the tax on the line after discounts, at tax_rate percent. Whether
+-- it is included in cost is recorded on the order.
+ALTER TABLE order_items
+    ADD COLUMN tax NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (tax >= 0),
+    ADD COLUMN tax_rate NUMERIC(6, 3) NOT NULL DEFAULT 0,
+    ADD COLUMN tax_name VARCHAR(100) NULL;
+
+ALTER TABLE orders
+    ADD COLUMN tax NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (tax >= 0),
+    ADD COLUMN prices_include_tax BOOLEAN NOT NULL DEFAULT FALSE;
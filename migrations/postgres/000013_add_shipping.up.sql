@@ -0,0 +1,50 @@
+-- The address book of each customer
+CREATE TABLE IF NOT EXISTS addresses (
+    address_id BIGSERIAL PRIMARY KEY,
+    user_id VARCHAR(50) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    line1 VARCHAR(200) NOT NULL,
+    line2 VARCHAR(200) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL,
+    state VARCHAR(50) NOT NULL DEFAULT '',
+    postal_code VARCHAR(20) NOT NULL,
+    country VARCHAR(2) NOT NULL,
+    phone VARCHAR(30) NOT NULL DEFAULT '',
+    date_created TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_addresses_user_id ON addresses (user_id);
+
+-- flat methods cost price, weight methods price plus per_kg for every
+-- kilogram. Carts of free_over or more ship for free when it is set.
+CREATE TABLE IF NOT EXISTS shipping_methods (
+    shipping_method_id BIGSERIAL PRIMARY KEY,
+    name VARCHAR(100) NOT NULL,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('flat', 'weight')),
+    price NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (price >= 0),
+    per_kg NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (per_kg >= 0),
+    free_over NUMERIC(10, 2) NULL CHECK (free_over >= 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created TIMESTAMPTZ NOT NULL
+);
+
+ALTER TABLE products ADD COLUMN weight NUMERIC(10, 3) NOT NULL DEFAULT 0 CHECK (weight >= 0);
+
+ALTER TABLE orders
+    ADD COLUMN shipping_method VARCHAR(100) NULL,
+    ADD COLUMN shipping_cost NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (shipping_cost >= 0);
+
+-- The addresses an order was placed with, copied so that later changes to
+-- the address book do not change past orders
+CREATE TABLE IF NOT EXISTS order_addresses (
+    order_id UUID NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    kind VARCHAR(10) NOT NULL CHECK (kind IN ('shipping', 'billing')),
+    name VARCHAR(100) NOT NULL,
+    line1 VARCHAR(200) NOT NULL,
+    line2 VARCHAR(200) NOT NULL DEFAULT '',
+    city VARCHAR(100) NOT NULL,
+    state VARCHAR(50) NOT NULL DEFAULT '',
+    postal_code VARCHAR(20) NOT NULL,
+    country VARCHAR(2) NOT NULL,
+    phone VARCHAR(30) NOT NULL DEFAULT '',
+    PRIMARY KEY (order_id, kind)
+);
@@ -0,0 +1,2 @@
+ALTER TABLE orders ALTER COLUMN order_status TYPE VARCHAR(15);
+DROP TABLE IF EXISTS payments;
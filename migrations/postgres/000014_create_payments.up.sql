@@ -0,0 +1,20 @@
+-- Every attempt to pay for an order. provider_ref is the provider's ID for
+-- the payment, NULL when the attempt failed before the provider saw it.
+CREATE TABLE IF NOT EXISTS payments (
+    payment_id BIGSERIAL PRIMARY KEY,
+    order_id UUID NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    provider VARCHAR(20) NOT NULL,
+    provider_ref VARCHAR(100) NULL,
+    amount NUMERIC(10, 2) NOT NULL CHECK (amount >= 0),
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR(15) NOT NULL CHECK (status IN ('authorized', 'failed', 'captured', 'refunded')),
+    captured_amount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    refunded_amount NUMERIC(10, 2) NOT NULL DEFAULT 0,
+    failure_reason VARCHAR(255) NULL,
+    date_created TIMESTAMPTZ NOT NULL,
+    date_modified TIMESTAMPTZ NOT NULL,
+    UNIQUE (provider, provider_ref)
+);
+CREATE INDEX idx_payments_order_id ON payments (order_id);
+
+ALTER TABLE orders ALTER COLUMN order_status TYPE VARCHAR(30);
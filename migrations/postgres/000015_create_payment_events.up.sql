@@ -0,0 +1,16 @@
+-- Webhooks received from payment providers. The unique provider event ID
+-- keeps an event that is sent again from being processed twice.
+CREATE TABLE IF NOT EXISTS payment_events (
+    payment_event_id BIGSERIAL PRIMARY KEY,
+    provider VARCHAR(20) NOT NULL,
+    provider_event_id VARCHAR(100) NOT NULL,
+    event_type VARCHAR(100) NOT NULL,
+    payload TEXT NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('received', 'processed', 'ignored', 'failed')),
+    error TEXT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    received_at TIMESTAMPTZ NOT NULL,
+    processed_at TIMESTAMPTZ NULL,
+    UNIQUE (provider, provider_event_id)
+);
+CREATE INDEX idx_payment_events_status ON payment_events (status);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS order_return_items;
+DROP TABLE IF EXISTS order_returns;
+ALTER TABLE orders DROP COLUMN refunded_amount;
+ALTER TABLE products DROP COLUMN stock;
@@ -0,0 +1,30 @@
+-- NULL stock means the product's stock is not tracked
+ALTER TABLE products ADD COLUMN stock INTEGER NULL CHECK (stock >= 0);
+
+ALTER TABLE orders ADD COLUMN refunded_amount NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (refunded_amount >= 0);
+
+-- A customer's request to send back items of an order. refund_amount is
+-- what was refunded once the items were received.
+CREATE TABLE IF NOT EXISTS order_returns (
+    return_id BIGSERIAL PRIMARY KEY,
+    order_id UUID NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    user_id VARCHAR(50) NOT NULL,
+    status VARCHAR(10) NOT NULL CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunded')),
+    reason VARCHAR(500) NOT NULL DEFAULT '',
+    note VARCHAR(500) NOT NULL DEFAULT '',
+    refund_amount NUMERIC(10, 2) NOT NULL DEFAULT 0 CHECK (refund_amount >= 0),
+    refund_ref VARCHAR(100) NULL,
+    date_created TIMESTAMPTZ NOT NULL,
+    date_modified TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_order_returns_order_id ON order_returns (order_id);
+CREATE INDEX idx_order_returns_user_id ON order_returns (user_id);
+
+-- amount is what the returned quantity was paid, after discounts and with tax
+CREATE TABLE IF NOT EXISTS order_return_items (
+    return_id BIGINT NOT NULL REFERENCES order_returns (return_id) ON DELETE CASCADE,
+    product_id UUID NOT NULL REFERENCES products (product_id) ON DELETE RESTRICT,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    amount NUMERIC(10, 2) NOT NULL,
+    PRIMARY KEY (return_id, product_id)
+);
@@ -0,0 +1,15 @@
+-- Domain events, saved in the same transaction as the change they are about.
+-- The relay claims unpublished events by moving available_at past the time
+-- it needs to publish them, and sets published_at once every sink has them.
+CREATE TABLE IF NOT EXISTS outbox (
+    event_id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(50) NOT NULL,
+    aggregate_id VARCHAR(50) NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    available_at TIMESTAMPTZ NOT NULL,
+    last_error TEXT NULL,
+    date_created TIMESTAMPTZ NOT NULL,
+    published_at TIMESTAMPTZ NULL
+);
+CREATE INDEX idx_outbox_published_at_available_at ON outbox (published_at, available_at);
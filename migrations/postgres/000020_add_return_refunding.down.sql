@@ -0,0 +1,4 @@
+UPDATE order_returns SET status = 'received' WHERE status = 'refunding';
+ALTER TABLE order_returns DROP CONSTRAINT IF EXISTS order_returns_status_check;
+ALTER TABLE order_returns ADD CONSTRAINT order_returns_status_check
+    CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunded'));
@@ -0,0 +1,5 @@
+-- Returns are claimed for their refund by moving them to refunding first, so
+-- that only one request refunds them.
+ALTER TABLE order_returns DROP CONSTRAINT IF EXISTS order_returns_status_check;
+ALTER TABLE order_returns ADD CONSTRAINT order_returns_status_check
+    CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunding', 'refunded'));
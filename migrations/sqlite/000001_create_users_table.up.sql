@@ -0,0 +1,10 @@
+DROP TABLE IF EXISTS products;
+CREATE TABLE IF NOT EXISTS products (
+    product_id TEXT NOT NULL PRIMARY KEY,
+    product_name TEXT NOT NULL,
+    price REAL NOT NULL,
+    description TEXT NOT NULL,
+    product_image TEXT,
+    date_created DATETIME,
+    date_modified DATETIME
+);
@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS orders;
+CREATE TABLE IF NOT EXISTS orders (
+    order_id TEXT PRIMARY KEY NOT NULL,
+    user_id TEXT,
+    order_status TEXT,
+    order_date DATETIME
+);
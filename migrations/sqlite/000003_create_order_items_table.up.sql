@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS order_items;
+CREATE TABLE IF NOT EXISTS order_items (
+    order_id TEXT NOT NULL,
+    product_id TEXT,
+    quantity INTEGER DEFAULT 1,
+    cost REAL
+);
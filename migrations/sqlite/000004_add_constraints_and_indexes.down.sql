@@ -0,0 +1,25 @@
+DROP INDEX idx_orders_order_date;
+DROP INDEX idx_orders_user_id;
+
+CREATE TABLE order_items_old (
+    order_id TEXT NOT NULL,
+    product_id TEXT,
+    quantity INTEGER DEFAULT 1,
+    cost REAL
+);
+INSERT INTO order_items_old SELECT order_id, product_id, quantity, cost FROM order_items;
+DROP TABLE order_items;
+ALTER TABLE order_items_old RENAME TO order_items;
+
+CREATE TABLE products_old (
+    product_id TEXT NOT NULL PRIMARY KEY,
+    product_name TEXT NOT NULL,
+    price REAL NOT NULL,
+    description TEXT NOT NULL,
+    product_image TEXT,
+    date_created DATETIME,
+    date_modified DATETIME
+);
+INSERT INTO products_old SELECT product_id, product_name, price, description, product_image, date_created, date_modified FROM products;
+DROP TABLE products;
+ALTER TABLE products_old RENAME TO products;
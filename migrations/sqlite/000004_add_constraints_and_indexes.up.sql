@@ -0,0 +1,34 @@
+-- SQLite cannot add constraints to existing tables, so products and
+-- order_items are rebuilt. Order items that point to a missing order or
+-- product cannot satisfy the new foreign keys and are dropped.
+CREATE TABLE products_new (
+    product_id TEXT NOT NULL PRIMARY KEY,
+    product_name TEXT NOT NULL,
+    price REAL NOT NULL CHECK (price >= 0),
+    description TEXT NOT NULL,
+    product_image TEXT,
+    date_created DATETIME,
+    date_modified DATETIME
+);
+INSERT INTO products_new SELECT product_id, product_name, price, description, product_image, date_created, date_modified FROM products;
+DROP TABLE products;
+ALTER TABLE products_new RENAME TO products;
+CREATE INDEX idx_products_date_created ON products (date_created);
+
+CREATE TABLE order_items_new (
+    order_id TEXT NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    product_id TEXT NOT NULL REFERENCES products (product_id) ON DELETE RESTRICT,
+    quantity INTEGER DEFAULT 1 CHECK (quantity > 0),
+    cost REAL CHECK (cost >= 0),
+    PRIMARY KEY (order_id, product_id)
+);
+INSERT INTO order_items_new
+    SELECT order_id, product_id, quantity, cost FROM order_items
+    WHERE order_id IN (SELECT order_id FROM orders)
+      AND product_id IN (SELECT product_id FROM products);
+DROP TABLE order_items;
+ALTER TABLE order_items_new RENAME TO order_items;
+CREATE INDEX idx_order_items_product_id ON order_items (product_id);
+
+CREATE INDEX idx_orders_user_id ON orders (user_id);
+CREATE INDEX idx_orders_order_date ON orders (order_date);
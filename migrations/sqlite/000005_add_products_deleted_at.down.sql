@@ -0,0 +1,2 @@
+DROP INDEX idx_products_deleted_at;
+ALTER TABLE products DROP COLUMN deleted_at;
@@ -0,0 +1,4 @@
+DROP INDEX idx_products_status_publish_at;
+ALTER TABLE products DROP COLUMN unpublish_at;
+ALTER TABLE products DROP COLUMN publish_at;
+ALTER TABLE products DROP COLUMN status;
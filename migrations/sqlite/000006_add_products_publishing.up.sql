@@ -0,0 +1,5 @@
+-- Existing products were live, so they start out published.
+ALTER TABLE products ADD COLUMN status TEXT NOT NULL DEFAULT 'published' CHECK (status IN ('draft', 'published', 'unlisted'));
+ALTER TABLE products ADD COLUMN publish_at DATETIME NULL;
+ALTER TABLE products ADD COLUMN unpublish_at DATETIME NULL;
+CREATE INDEX idx_products_status_publish_at ON products (status, publish_at);
@@ -0,0 +1,17 @@
+-- One row per price a product has had or will have. Rows with applied_at set
+-- are history, rows without it are scheduled changes waiting for effective_at.
+CREATE TABLE IF NOT EXISTS product_prices (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    product_id TEXT NOT NULL REFERENCES products (product_id) ON DELETE CASCADE,
+    price REAL NOT NULL CHECK (price >= 0),
+    effective_at DATETIME NOT NULL,
+    applied_at DATETIME NULL,
+    created_at DATETIME NOT NULL
+);
+CREATE INDEX idx_product_prices_product ON product_prices (product_id, effective_at);
+CREATE INDEX idx_product_prices_due ON product_prices (effective_at) WHERE applied_at IS NULL;
+
+-- Start the history with the current prices
+INSERT INTO product_prices (product_id, price, effective_at, applied_at, created_at)
+    SELECT product_id, price, COALESCE(date_created, CURRENT_TIMESTAMP), COALESCE(date_created, CURRENT_TIMESTAMP), CURRENT_TIMESTAMP
+    FROM products;
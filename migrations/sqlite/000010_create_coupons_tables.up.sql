@@ -0,0 +1,38 @@
+-- max_uses and max_uses_per_customer of 0 mean unlimited. times_used is
+-- incremented together with the redemption so the limit holds under
+-- concurrent checkouts.
+CREATE TABLE IF NOT EXISTS coupons (
+    coupon_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    code TEXT NOT NULL UNIQUE,
+    discount_type TEXT NOT NULL CHECK (discount_type IN ('percent', 'fixed')),
+    amount REAL NOT NULL CHECK (amount >= 0),
+    min_cart_value REAL NOT NULL DEFAULT 0 CHECK (min_cart_value >= 0),
+    starts_at DATETIME NULL,
+    ends_at DATETIME NULL,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    max_uses_per_customer INTEGER NOT NULL DEFAULT 0,
+    times_used INTEGER NOT NULL DEFAULT 0,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created DATETIME NOT NULL
+);
+
+-- Coupons without rows here apply to every product
+CREATE TABLE IF NOT EXISTS coupon_products (
+    coupon_id INTEGER NOT NULL REFERENCES coupons (coupon_id) ON DELETE CASCADE,
+    product_id TEXT NOT NULL REFERENCES products (product_id) ON DELETE CASCADE,
+    PRIMARY KEY (coupon_id, product_id)
+);
+CREATE INDEX idx_coupon_products_product_id ON coupon_products (product_id);
+
+CREATE TABLE IF NOT EXISTS coupon_redemptions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    coupon_id INTEGER NOT NULL REFERENCES coupons (coupon_id) ON DELETE RESTRICT,
+    order_id TEXT NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    discount REAL NOT NULL,
+    redeemed_at DATETIME NOT NULL
+);
+CREATE INDEX idx_coupon_redemptions_customer ON coupon_redemptions (coupon_id, user_id);
+
+ALTER TABLE orders ADD COLUMN coupon_code TEXT NULL;
+ALTER TABLE orders ADD COLUMN discount REAL NOT NULL DEFAULT 0 CHECK (discount >= 0);
@@ -0,0 +1,42 @@
+-- Automatic promotions. Which columns are used depends on kind:
+-- buy_x_get_y uses buy_quantity and free_quantity, spend_threshold uses
+-- min_subtotal and percent and bundle uses bundle_price.
+CREATE TABLE IF NOT EXISTS promotions (
+    promotion_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('buy_x_get_y', 'spend_threshold', 'bundle')),
+    buy_quantity INTEGER NOT NULL DEFAULT 0,
+    free_quantity INTEGER NOT NULL DEFAULT 0,
+    min_subtotal REAL NOT NULL DEFAULT 0,
+    percent REAL NOT NULL DEFAULT 0 CHECK (percent >= 0 AND percent <= 100),
+    bundle_price REAL NOT NULL DEFAULT 0,
+    priority INTEGER NOT NULL DEFAULT 0,
+    stackable BOOLEAN NOT NULL DEFAULT FALSE,
+    starts_at DATETIME NULL,
+    ends_at DATETIME NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created DATETIME NOT NULL
+);
+CREATE INDEX idx_promotions_priority ON promotions (priority);
+
+-- Promotions without rows here apply to every product, bundles list the
+-- products that make up the bundle
+CREATE TABLE IF NOT EXISTS promotion_products (
+    promotion_id INTEGER NOT NULL REFERENCES promotions (promotion_id) ON DELETE CASCADE,
+    product_id TEXT NOT NULL REFERENCES products (product_id) ON DELETE CASCADE,
+    PRIMARY KEY (promotion_id, product_id)
+);
+CREATE INDEX idx_promotion_products_product_id ON promotion_products (product_id);
+
+-- The promotions applied to an order, with the label shown at checkout
+CREATE TABLE IF NOT EXISTS order_promotions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id TEXT NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    promotion_id INTEGER NULL REFERENCES promotions (promotion_id) ON DELETE SET NULL,
+    label TEXT NOT NULL,
+    discount REAL NOT NULL
+);
+CREATE INDEX idx_order_promotions_order_id ON order_promotions (order_id);
+
+-- cost is what the line costs after promotions, discount what they took off
+ALTER TABLE order_items ADD COLUMN discount REAL NOT NULL DEFAULT 0 CHECK (discount >= 0);
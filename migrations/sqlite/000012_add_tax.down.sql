@@ -0,0 +1,7 @@
+ALTER TABLE orders DROP COLUMN prices_include_tax;
+ALTER TABLE orders DROP COLUMN tax;
+ALTER TABLE order_items DROP COLUMN tax_name;
+ALTER TABLE order_items DROP COLUMN tax_rate;
+ALTER TABLE order_items DROP COLUMN tax;
+ALTER TABLE products DROP COLUMN tax_class;
+DROP TABLE IF EXISTS tax_rates;
@@ -0,0 +1,22 @@
+-- Tax rates by region and product tax class. An empty state is the rate for
+-- the whole country, used when the state has no rate of its own.
+CREATE TABLE IF NOT EXISTS tax_rates (
+    tax_rate_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    country TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT '',
+    tax_class TEXT NOT NULL DEFAULT 'standard',
+    name TEXT NOT NULL,
+    rate REAL NOT NULL CHECK (rate >= 0 AND rate <= 100),
+    UNIQUE (country, state, tax_class)
+);
+
+ALTER TABLE products ADD COLUMN tax_class TEXT NOT NULL DEFAULT 'standard';
+
+-- tax is the tax on the line after discounts, at tax_rate percent. Whether
+-- it is included in cost is recorded on the order.
+ALTER TABLE order_items ADD COLUMN tax REAL NOT NULL DEFAULT 0 CHECK (tax >= 0);
+ALTER TABLE order_items ADD COLUMN tax_rate REAL NOT NULL DEFAULT 0;
+ALTER TABLE order_items ADD COLUMN tax_name TEXT NULL;
+
+ALTER TABLE orders ADD COLUMN tax REAL NOT NULL DEFAULT 0 CHECK (tax >= 0);
+ALTER TABLE orders ADD COLUMN prices_include_tax BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,6 @@
+DROP TABLE IF EXISTS order_addresses;
+ALTER TABLE orders DROP COLUMN shipping_cost;
+ALTER TABLE orders DROP COLUMN shipping_method;
+ALTER TABLE products DROP COLUMN weight;
+DROP TABLE IF EXISTS shipping_methods;
+DROP TABLE IF EXISTS addresses;
@@ -0,0 +1,49 @@
+-- The address book of each customer
+CREATE TABLE IF NOT EXISTS addresses (
+    address_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id TEXT NOT NULL,
+    name TEXT NOT NULL,
+    line1 TEXT NOT NULL,
+    line2 TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT '',
+    postal_code TEXT NOT NULL,
+    country TEXT NOT NULL,
+    phone TEXT NOT NULL DEFAULT '',
+    date_created DATETIME NOT NULL
+);
+CREATE INDEX idx_addresses_user_id ON addresses (user_id);
+
+-- flat methods cost price, weight methods price plus per_kg for every
+-- kilogram. Carts of free_over or more ship for free when it is set.
+CREATE TABLE IF NOT EXISTS shipping_methods (
+    shipping_method_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    name TEXT NOT NULL,
+    kind TEXT NOT NULL CHECK (kind IN ('flat', 'weight')),
+    price REAL NOT NULL DEFAULT 0 CHECK (price >= 0),
+    per_kg REAL NOT NULL DEFAULT 0 CHECK (per_kg >= 0),
+    free_over REAL NULL CHECK (free_over >= 0),
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created DATETIME NOT NULL
+);
+
+ALTER TABLE products ADD COLUMN weight REAL NOT NULL DEFAULT 0 CHECK (weight >= 0);
+
+ALTER TABLE orders ADD COLUMN shipping_method TEXT NULL;
+ALTER TABLE orders ADD COLUMN shipping_cost REAL NOT NULL DEFAULT 0 CHECK (shipping_cost >= 0);
+
+-- The addresses an order was placed with, copied so that later changes to
+-- the address book do not change past orders
+CREATE TABLE IF NOT EXISTS order_addresses (
+    order_id TEXT NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('shipping', 'billing')),
+    name TEXT NOT NULL,
+    line1 TEXT NOT NULL,
+    line2 TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL,
+    state TEXT NOT NULL DEFAULT '',
+    postal_code TEXT NOT NULL,
+    country TEXT NOT NULL,
+    phone TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY (order_id, kind)
+);
@@ -0,0 +1,18 @@
+-- Every attempt to pay for an order. provider_ref is the provider's ID for
+-- the payment, NULL when the attempt failed before the provider saw it.
+CREATE TABLE IF NOT EXISTS payments (
+    payment_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id TEXT NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    provider TEXT NOT NULL,
+    provider_ref TEXT NULL,
+    amount REAL NOT NULL CHECK (amount >= 0),
+    currency TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('authorized', 'failed', 'captured', 'refunded')),
+    captured_amount REAL NOT NULL DEFAULT 0,
+    refunded_amount REAL NOT NULL DEFAULT 0,
+    failure_reason TEXT NULL,
+    date_created DATETIME NOT NULL,
+    date_modified DATETIME NOT NULL,
+    UNIQUE (provider, provider_ref)
+);
+CREATE INDEX idx_payments_order_id ON payments (order_id);
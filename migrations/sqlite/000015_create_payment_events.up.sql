@@ -0,0 +1,16 @@
+-- Webhooks received from payment providers. The unique provider event ID
+-- keeps an event that is sent again from being processed twice.
+CREATE TABLE IF NOT EXISTS payment_events (
+    payment_event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    provider TEXT NOT NULL,
+    provider_event_id TEXT NOT NULL,
+    event_type TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('received', 'processed', 'ignored', 'failed')),
+    error TEXT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    received_at DATETIME NOT NULL,
+    processed_at DATETIME NULL,
+    UNIQUE (provider, provider_event_id)
+);
+CREATE INDEX idx_payment_events_status ON payment_events (status);
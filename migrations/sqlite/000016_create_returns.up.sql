@@ -0,0 +1,30 @@
+-- NULL stock means the product's stock is not tracked
+ALTER TABLE products ADD COLUMN stock INTEGER NULL CHECK (stock >= 0);
+
+ALTER TABLE orders ADD COLUMN refunded_amount REAL NOT NULL DEFAULT 0 CHECK (refunded_amount >= 0);
+
+-- A customer's request to send back items of an order. refund_amount is
+-- what was refunded once the items were received.
+CREATE TABLE IF NOT EXISTS order_returns (
+    return_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id TEXT NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunded')),
+    reason TEXT NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    refund_amount REAL NOT NULL DEFAULT 0 CHECK (refund_amount >= 0),
+    refund_ref TEXT NULL,
+    date_created DATETIME NOT NULL,
+    date_modified DATETIME NOT NULL
+);
+CREATE INDEX idx_order_returns_order_id ON order_returns (order_id);
+CREATE INDEX idx_order_returns_user_id ON order_returns (user_id);
+
+-- amount is what the returned quantity was paid, after discounts and with tax
+CREATE TABLE IF NOT EXISTS order_return_items (
+    return_id INTEGER NOT NULL REFERENCES order_returns (return_id) ON DELETE CASCADE,
+    product_id TEXT NOT NULL REFERENCES products (product_id) ON DELETE RESTRICT,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    amount REAL NOT NULL,
+    PRIMARY KEY (return_id, product_id)
+);
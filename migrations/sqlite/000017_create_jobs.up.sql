@@ -0,0 +1,17 @@
+-- Work done in the background. Workers claim due queued jobs by setting them
+-- running until locked_until, after which another worker may take over.
+CREATE TABLE IF NOT EXISTS jobs (
+    job_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    kind TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('queued', 'running', 'succeeded', 'dead')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL CHECK (max_attempts > 0),
+    run_at DATETIME NOT NULL,
+    locked_until DATETIME NULL,
+    last_error TEXT NULL,
+    date_created DATETIME NOT NULL,
+    date_modified DATETIME NOT NULL
+);
+CREATE INDEX idx_jobs_status_run_at ON jobs (status, run_at);
+CREATE INDEX idx_jobs_kind ON jobs (kind);
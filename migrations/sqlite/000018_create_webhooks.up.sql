@@ -0,0 +1,30 @@
+-- Subscriptions of other systems to changes in the store. Every event sent
+-- to a subscription is a delivery, signed with the subscription's secret.
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    subscription_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    url TEXT NOT NULL,
+    secret TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    date_created DATETIME NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_subscription_events (
+    subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions (subscription_id) ON DELETE CASCADE,
+    event TEXT NOT NULL,
+    PRIMARY KEY (subscription_id, event)
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    subscription_id INTEGER NOT NULL REFERENCES webhook_subscriptions (subscription_id) ON DELETE CASCADE,
+    event_id TEXT NOT NULL,
+    event TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('pending', 'delivered', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    response_status INTEGER NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    date_created DATETIME NOT NULL,
+    delivered_at DATETIME NULL
+);
+CREATE INDEX idx_webhook_deliveries_subscription_id ON webhook_deliveries (subscription_id);
@@ -0,0 +1,15 @@
+-- Domain events, saved in the same transaction as the change they are about.
+-- The relay claims unpublished events by moving available_at past the time
+-- it needs to publish them, and sets published_at once every sink has them.
+CREATE TABLE IF NOT EXISTS outbox (
+    event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    event_type TEXT NOT NULL,
+    aggregate_id TEXT NOT NULL,
+    payload TEXT NOT NULL,
+    attempts INTEGER NOT NULL DEFAULT 0,
+    available_at DATETIME NOT NULL,
+    last_error TEXT NULL,
+    date_created DATETIME NOT NULL,
+    published_at DATETIME NULL
+);
+CREATE INDEX idx_outbox_published_at_available_at ON outbox (published_at, available_at);
@@ -0,0 +1,36 @@
+-- Returns are claimed for their refund by moving them to refunding first, so
+-- that only one request refunds them. SQLite cannot change a check
+-- constraint, so order_returns is rebuilt, and order_return_items with it:
+-- dropping order_returns while its items refer to it would delete them.
+CREATE TABLE order_returns_new (
+    return_id INTEGER PRIMARY KEY AUTOINCREMENT,
+    order_id TEXT NOT NULL REFERENCES orders (order_id) ON DELETE CASCADE,
+    user_id TEXT NOT NULL,
+    status TEXT NOT NULL CHECK (status IN ('requested', 'approved', 'rejected', 'received', 'refunding', 'refunded')),
+    reason TEXT NOT NULL DEFAULT '',
+    note TEXT NOT NULL DEFAULT '',
+    refund_amount REAL NOT NULL DEFAULT 0 CHECK (refund_amount >= 0),
+    refund_ref TEXT NULL,
+    date_created DATETIME NOT NULL,
+    date_modified DATETIME NOT NULL
+);
+INSERT INTO order_returns_new
+    SELECT return_id, order_id, user_id, status, reason, note, refund_amount, refund_ref, date_created, date_modified
+    FROM order_returns;
+
+CREATE TABLE order_return_items_new (
+    return_id INTEGER NOT NULL REFERENCES order_returns_new (return_id) ON DELETE CASCADE,
+    product_id TEXT NOT NULL REFERENCES products (product_id) ON DELETE RESTRICT,
+    quantity INTEGER NOT NULL CHECK (quantity > 0),
+    amount REAL NOT NULL,
+    PRIMARY KEY (return_id, product_id)
+);
+INSERT INTO order_return_items_new SELECT return_id, product_id, quantity, amount FROM order_return_items;
+
+DROP TABLE order_return_items;
+DROP TABLE order_returns;
+-- Renaming order_returns_new points the items at it under its new name
+ALTER TABLE order_returns_new RENAME TO order_returns;
+ALTER TABLE order_return_items_new RENAME TO order_return_items;
+CREATE INDEX idx_order_returns_order_id ON order_returns (order_id);
+CREATE INDEX idx_order_returns_user_id ON order_returns (user_id);
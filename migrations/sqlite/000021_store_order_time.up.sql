@@ -0,0 +1,3 @@
+-- Only MySQL stored order dates without their time. The version is kept in
+-- step with it.
+SELECT 1;
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS uq_webhook_deliveries_subscription_event;
@@ -0,0 +1,8 @@
+-- A subscription gets each event once, so that publishing an event again,
+-- as the outbox does when it retries, skips the deliveries made before
+-- instead of sending them twice. Of duplicates made earlier the first
+-- delivery is kept.
+DELETE FROM webhook_deliveries WHERE delivery_id NOT IN (
+    SELECT MIN(delivery_id) FROM webhook_deliveries GROUP BY subscription_id, event_id
+);
+CREATE UNIQUE INDEX uq_webhook_deliveries_subscription_event ON webhook_deliveries (subscription_id, event_id);
@@ -0,0 +1,10 @@
+-- Products can be put in a category, and coupons restricted to categories
+-- as well as to single products. Coupons without rows here or in
+-- coupon_products apply to every product.
+ALTER TABLE products ADD COLUMN category TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE IF NOT EXISTS coupon_categories (
+    coupon_id INTEGER NOT NULL REFERENCES coupons (coupon_id) ON DELETE CASCADE,
+    category TEXT NOT NULL,
+    PRIMARY KEY (coupon_id, category)
+);
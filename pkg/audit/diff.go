@@ -0,0 +1,82 @@
+// Package audit builds the change sets stored with audit events.
+package audit
+
+import (
+	"encoding/json"
+	"reflect"
+)
+
+// ignoredFields change on every write and would only add noise to the log.
+var ignoredFields = map[string]bool{
+	"DateModified": true,
+}
+
+// Diff returns JSON objects holding the fields of before and after that
+// differ. Either side may be nil, for created or deleted entities, in which
+// case the other side contains every field.
+func Diff(before, after any) (string, string, error) {
+	b, err := toMap(before)
+	if err != nil {
+		return "", "", err
+	}
+	a, err := toMap(after)
+	if err != nil {
+		return "", "", err
+	}
+
+	changedBefore, changedAfter := map[string]any{}, map[string]any{}
+	for k, v := range b {
+		if ignoredFields[k] {
+			continue
+		}
+		if av, ok := a[k]; !ok || !reflect.DeepEqual(v, av) {
+			changedBefore[k] = v
+		}
+	}
+	for k, v := range a {
+		if ignoredFields[k] {
+			continue
+		}
+		if bv, ok := b[k]; !ok || !reflect.DeepEqual(v, bv) {
+			changedAfter[k] = v
+		}
+	}
+
+	beforeJSON, err := encode(changedBefore, isNil(before))
+	if err != nil {
+		return "", "", err
+	}
+	afterJSON, err := encode(changedAfter, isNil(after))
+	if err != nil {
+		return "", "", err
+	}
+	return beforeJSON, afterJSON, nil
+}
+
+func toMap(v any) (map[string]any, error) {
+	m := map[string]any{}
+	if isNil(v) {
+		return m, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return m, json.Unmarshal(data, &m)
+}
+
+func encode(m map[string]any, missing bool) (string, error) {
+	if missing {
+		return "", nil
+	}
+	data, err := json.Marshal(m)
+	return string(data), err
+}
+
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	rv := reflect.ValueOf(v)
+	return rv.Kind() == reflect.Pointer && rv.IsNil()
+}
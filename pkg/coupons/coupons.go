@@ -0,0 +1,75 @@
+// Package coupons decides whether a coupon can be used on a cart and how much
+// it takes off.
+package coupons
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// The errors are meant to be shown to the customer as they are.
+var (
+	ErrInactive      = errors.New("this coupon is no longer available")
+	ErrNotStarted    = errors.New("this coupon is not valid yet")
+	ErrExpired       = errors.New("this coupon has expired")
+	ErrBelowMinimum  = errors.New("your cart is below the minimum value for this coupon")
+	ErrNotApplicable = errors.New("this coupon does not apply to any item in your cart")
+	ErrUsedUp        = errors.New("this coupon has been used up")
+	ErrCustomerLimit = errors.New("you have already used this coupon")
+)
+
+// Discount returns the amount coupon takes off items at now, rounded to the
+// cent. The coupon applies to the item costs left after promotions. Usage limits are checked separately by CheckUsage, since they depend
+// on who is buying.
+func Discount(coupon *models.Coupon, items []models.OrderItem, now time.Time) (float64, error) {
+	if !coupon.Active {
+		return 0, ErrInactive
+	}
+	if coupon.StartsAt != nil && now.Before(*coupon.StartsAt) {
+		return 0, ErrNotStarted
+	}
+	if coupon.EndsAt != nil && !now.Before(*coupon.EndsAt) {
+		return 0, ErrExpired
+	}
+
+	var subtotal, eligible float64
+	for _, item := range items {
+		subtotal += item.Cost
+		if coupon.AppliesTo(item.ProductID, item.Product.Category) {
+			eligible += item.Cost
+		}
+	}
+	if subtotal < coupon.MinCartValue {
+		return 0, fmt.Errorf("%w ($%.2f)", ErrBelowMinimum, coupon.MinCartValue)
+	}
+	if eligible == 0 {
+		return 0, ErrNotApplicable
+	}
+
+	var discount float64
+	switch coupon.DiscountType {
+	case models.DiscountPercent:
+		discount = eligible * min(coupon.Amount, 100) / 100
+	case models.DiscountFixed:
+		discount = min(coupon.Amount, eligible)
+	default:
+		return 0, fmt.Errorf("unknown discount type %q", coupon.DiscountType)
+	}
+	return math.Round(discount*100) / 100, nil
+}
+
+// CheckUsage reports whether the coupon can be redeemed once more by a
+// customer who already redeemed it customerUses times.
+func CheckUsage(coupon *models.Coupon, customerUses int) error {
+	if coupon.MaxUses > 0 && coupon.TimesUsed >= coupon.MaxUses {
+		return ErrUsedUp
+	}
+	if coupon.MaxUsesPerCustomer > 0 && customerUses >= coupon.MaxUsesPerCustomer {
+		return ErrCustomerLimit
+	}
+	return nil
+}
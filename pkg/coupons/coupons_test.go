@@ -0,0 +1,75 @@
+package coupons
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+func TestDiscount(t *testing.T) {
+	now := time.Now()
+	past, future := now.Add(-time.Hour), now.Add(time.Hour)
+	lamp, desk := uuid.New(), uuid.New()
+	items := []models.OrderItem{
+		{ProductID: lamp, Quantity: 2, Product: models.Product{Price: 10, Category: "Lighting"}, Cost: 20},
+		{ProductID: desk, Quantity: 1, Product: models.Product{Price: 80}, Cost: 80},
+	}
+
+	tests := []struct {
+		name    string
+		coupon  models.Coupon
+		want    float64
+		wantErr error
+	}{
+		{"percent", models.Coupon{DiscountType: models.DiscountPercent, Amount: 10, Active: true}, 10, nil},
+		{"fixed", models.Coupon{DiscountType: models.DiscountFixed, Amount: 15, Active: true}, 15, nil},
+		{"restricted percent", models.Coupon{DiscountType: models.DiscountPercent, Amount: 50, ProductIDs: []uuid.UUID{lamp}, Active: true}, 10, nil},
+		{"restricted to a category", models.Coupon{DiscountType: models.DiscountPercent, Amount: 50, Categories: []string{"lighting"}, Active: true}, 10, nil},
+		{"restricted to a product or a category", models.Coupon{DiscountType: models.DiscountPercent, Amount: 50, ProductIDs: []uuid.UUID{desk}, Categories: []string{"Lighting"}, Active: true}, 50, nil},
+		{"fixed capped at eligible items", models.Coupon{DiscountType: models.DiscountFixed, Amount: 50, ProductIDs: []uuid.UUID{lamp}, Active: true}, 20, nil},
+		{"rounded to the cent", models.Coupon{DiscountType: models.DiscountPercent, Amount: 33.333, Active: true}, 33.33, nil},
+		{"minimum met", models.Coupon{DiscountType: models.DiscountFixed, Amount: 5, MinCartValue: 100, Active: true}, 5, nil},
+		{"inside window", models.Coupon{DiscountType: models.DiscountFixed, Amount: 5, StartsAt: &past, EndsAt: &future, Active: true}, 5, nil},
+		{"inactive", models.Coupon{DiscountType: models.DiscountFixed, Amount: 5}, 0, ErrInactive},
+		{"not started", models.Coupon{DiscountType: models.DiscountFixed, Amount: 5, StartsAt: &future, Active: true}, 0, ErrNotStarted},
+		{"expired", models.Coupon{DiscountType: models.DiscountFixed, Amount: 5, EndsAt: &past, Active: true}, 0, ErrExpired},
+		{"below minimum", models.Coupon{DiscountType: models.DiscountFixed, Amount: 5, MinCartValue: 100.01, Active: true}, 0, ErrBelowMinimum},
+		{"no eligible items", models.Coupon{DiscountType: models.DiscountFixed, Amount: 5, ProductIDs: []uuid.UUID{uuid.New()}, Active: true}, 0, ErrNotApplicable},
+		{"no item in the category", models.Coupon{DiscountType: models.DiscountFixed, Amount: 5, Categories: []string{"Desks"}, Active: true}, 0, ErrNotApplicable},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := Discount(&tc.coupon, items, now)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tc.wantErr)
+			}
+			if got != tc.want {
+				t.Fatalf("discount = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestCheckUsage(t *testing.T) {
+	tests := []struct {
+		name         string
+		coupon       models.Coupon
+		customerUses int
+		wantErr      error
+	}{
+		{"unlimited", models.Coupon{TimesUsed: 1000}, 1000, nil},
+		{"below limits", models.Coupon{MaxUses: 10, MaxUsesPerCustomer: 2, TimesUsed: 9}, 1, nil},
+		{"used up", models.Coupon{MaxUses: 10, TimesUsed: 10}, 0, ErrUsedUp},
+		{"customer limit", models.Coupon{MaxUsesPerCustomer: 1}, 1, ErrCustomerLimit},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if err := CheckUsage(&tc.coupon, tc.customerUses); !errors.Is(err, tc.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tc.wantErr)
+			}
+		})
+	}
+}
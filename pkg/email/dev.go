@@ -0,0 +1,87 @@
+package email
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+)
+
+// catcherSize is how many messages the catcher of New keeps.
+const catcherSize = 100
+
+// Dir writes every message to an .eml file in Path, which most mail clients
+// open. It is meant for development.
+type Dir struct {
+	Path string
+}
+
+func (d *Dir) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(d.Path, 0o755); err != nil {
+		return err
+	}
+	name := msg.Date.UTC().Format("20060102T150405") + "-" + msg.ID + ".eml"
+	return os.WriteFile(filepath.Join(d.Path, name), data, 0o644)
+}
+
+// Catcher keeps the last messages sent in memory instead of delivering
+// them, for reading them in the admin during development.
+type Catcher struct {
+	mu       sync.RWMutex
+	size     int
+	messages []Message
+}
+
+// NewCatcher creates a catcher that keeps the last size messages.
+func NewCatcher(size int) *Catcher {
+	return &Catcher{size: size}
+}
+
+func (c *Catcher) Send(ctx context.Context, msg Message) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = append(c.messages, msg)
+	if len(c.messages) > c.size {
+		c.messages = slices.Delete(c.messages, 0, len(c.messages)-c.size)
+	}
+	return nil
+}
+
+// Messages returns the caught messages, newest first.
+func (c *Catcher) Messages() []Message {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	messages := slices.Clone(c.messages)
+	slices.Reverse(messages)
+	return messages
+}
+
+// Message returns the caught message with the ID id.
+func (c *Catcher) Message(id string) (Message, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	for _, msg := range c.messages {
+		if msg.ID == id {
+			return msg, true
+		}
+	}
+	return Message{}, false
+}
+
+// Clear forgets all caught messages.
+func (c *Catcher) Clear() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.messages = nil
+}
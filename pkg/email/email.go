@@ -0,0 +1,136 @@
+// Package email sends the store's notification emails in the background.
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/jobs"
+)
+
+// Message is an email with a plain text and an HTML version of its body.
+type Message struct {
+	// ID is unique to the message and becomes its Message-ID.
+	ID      string
+	From    string
+	To      []string
+	Subject string
+	Text    string
+	HTML    string
+	Date    time.Time
+}
+
+// Sender delivers messages.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// SendJob is the job that sends a message.
+var SendJob = jobs.Kind[Message]{Name: "email.send"}
+
+// New creates the mailer configured by cfg, reading the message templates
+// from templateDir. Messages are sent by jobs in q.
+func New(cfg config.Email, templateDir string, q *jobs.Queue) (*Mailer, error) {
+	var sender Sender
+	switch cfg.Mode {
+	case "smtp":
+		sender = &SMTP{Host: cfg.SMTPHost, Port: cfg.SMTPPort, Username: cfg.SMTPUsername, Password: cfg.SMTPPassword}
+	case "dir":
+		sender = &Dir{Path: cfg.Dir}
+	case "catcher":
+		sender = NewCatcher(catcherSize)
+	default:
+		return nil, fmt.Errorf("unknown email mode %q", cfg.Mode)
+	}
+	templates, err := ParseTemplates(templateDir)
+	if err != nil {
+		return nil, err
+	}
+	SendJob.Handle(q, func(ctx context.Context, msg Message) error {
+		if err := sender.Send(ctx, msg); err != nil {
+			return err
+		}
+		slog.Info("Sent email", "id", msg.ID, "to", msg.To, "subject", msg.Subject)
+		return nil
+	})
+	return NewMailer(q, sender, templates, cfg.From, cfg.AdminAddress, cfg.StoreURL), nil
+}
+
+// Bytes formats the message for delivery, as a multipart/alternative MIME
+// message with quoted-printable parts.
+func (m Message) Bytes() ([]byte, error) {
+	var buf bytes.Buffer
+	body := multipart.NewWriter(&buf)
+
+	var out bytes.Buffer
+	headers := [][2]string{
+		{"From", m.From},
+		{"To", strings.Join(m.To, ", ")},
+		{"Subject", mime.QEncoding.Encode("utf-8", m.Subject)},
+		{"Date", m.Date.Format(time.RFC1123Z)},
+		{"Message-ID", "<" + m.ID + "@" + domain(m.From) + ">"},
+		{"MIME-Version", "1.0"},
+		{"Content-Type", "multipart/alternative; boundary=" + body.Boundary()},
+	}
+	for _, h := range headers {
+		fmt.Fprintf(&out, "%s: %s\r\n", h[0], h[1])
+	}
+	out.WriteString("\r\n")
+
+	// Clients show the last part they understand, so HTML goes last
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", m.Text},
+		{"text/html; charset=utf-8", m.HTML},
+	} {
+		w, err := body.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		if _, err := qp.Write([]byte(part.content)); err != nil {
+			return nil, err
+		}
+		if err := qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+	if err := body.Close(); err != nil {
+		return nil, err
+	}
+	out.Write(buf.Bytes())
+	return out.Bytes(), nil
+}
+
+// address returns the bare address of a "Name <address>" mailbox.
+func address(mailbox string) (string, error) {
+	addr, err := mail.ParseAddress(mailbox)
+	if err != nil {
+		return "", fmt.Errorf("invalid address %q: %w", mailbox, err)
+	}
+	return addr.Address, nil
+}
+
+func domain(mailbox string) string {
+	addr, err := address(mailbox)
+	if err != nil {
+		return "localhost"
+	}
+	_, host, _ := strings.Cut(addr, "@")
+	if host == "" {
+		return "localhost"
+	}
+	return host
+}
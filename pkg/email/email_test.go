@@ -0,0 +1,247 @@
+package email
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/jobs"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{
+		ID:      "abc",
+		From:    "MX Store <store@example.com>",
+		To:      []string{"fk@htmxrocks.com"},
+		Subject: "Your order is confirmed ✓",
+		Text:    "Thank you for your order!",
+		HTML:    `<p style="color: red">Thank you for your order!</p>`,
+		Date:    time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC),
+	}
+	data, err := msg.Bytes()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for header, want := range map[string]string{
+		"From":       msg.From,
+		"To":         "fk@htmxrocks.com",
+		"Message-ID": "<abc@example.com>",
+	} {
+		if got := parsed.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	if subject != msg.Subject {
+		t.Errorf("Subject = %q, want %q", subject, msg.Subject)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q, %v", parsed.Header.Get("Content-Type"), err)
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", msg.Text},
+		{"text/html; charset=utf-8", msg.HTML},
+	} {
+		part, err := parts.NextRawPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := part.Header.Get("Content-Type"); got != want.contentType {
+			t.Errorf("part Content-Type = %q, want %q", got, want.contentType)
+		}
+		body, err := io.ReadAll(quotedprintable.NewReader(part))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want.body {
+			t.Errorf("part body = %q, want %q", body, want.body)
+		}
+	}
+	if _, err := parts.NextPart(); err != io.EOF {
+		t.Errorf("more parts than text and HTML: %v", err)
+	}
+}
+
+func TestCatcher(t *testing.T) {
+	catcher := NewCatcher(2)
+	for _, id := range []string{"a", "b", "c"} {
+		if err := catcher.Send(context.Background(), Message{ID: id}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var ids []string
+	for _, msg := range catcher.Messages() {
+		ids = append(ids, msg.ID)
+	}
+	if strings.Join(ids, ",") != "c,b" {
+		t.Errorf("messages = %v, want the newest two, newest first", ids)
+	}
+	if _, ok := catcher.Message("a"); ok {
+		t.Error("oldest message was kept")
+	}
+	if msg, ok := catcher.Message("b"); !ok || msg.ID != "b" {
+		t.Errorf("Message(b) = %v, %v", msg, ok)
+	}
+}
+
+func TestDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "mail")
+	sender := &Dir{Path: dir}
+	msg := Message{ID: "abc", From: "store@example.com", To: []string{"fk@htmxrocks.com"}, Subject: "Hello", Date: time.Now()}
+	if err := sender.Send(context.Background(), msg); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "*-abc.eml"))
+	if err != nil || len(files) != 1 {
+		t.Fatalf("files = %v, %v", files, err)
+	}
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "Subject: Hello\r\n") {
+		t.Errorf("file is not the message:\n%s", data)
+	}
+}
+
+func TestMailer(t *testing.T) {
+	templates, err := ParseTemplates("../../templates/email")
+	if err != nil {
+		t.Fatal(err)
+	}
+	order := models.Order{
+		OrderID:     uuid.MustParse("7c9e6679-7425-40de-944b-e07fc1f90ae7"),
+		UserID:      "fk@htmxrocks.com",
+		OrderStatus: models.OrderStatusPaid,
+		OrderDate:   time.Now(),
+		Items: []models.OrderItem{
+			{Quantity: 2, Cost: 20, Product: models.Product{ProductName: "Test <Laptop>", Price: 10}},
+		},
+		ShippingAddress: &models.Address{Name: "Fred", Line1: "1 Main St", City: "Springfield", Country: "US"},
+		ShippingMethod:  "Standard",
+		ShippingCost:    5,
+	}
+
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		send     func(m *Mailer) error
+		to       string
+		subject  string
+		contains []string
+	}{
+		{
+			name:     "order confirmation",
+			send:     func(m *Mailer) error { return m.OrderConfirmation(ctx, order) },
+			to:       "fk@htmxrocks.com",
+			subject:  "Your order 7c9e6679 is confirmed",
+			contains: []string{"Thank you for your order!", "2 x Test <Laptop>: $20.00", "Total: $25.00", "1 Main St"},
+		},
+		{
+			name:     "order alert",
+			send:     func(m *Mailer) error { return m.OrderAlert(ctx, order) },
+			to:       "admin@example.com",
+			subject:  "New order 7c9e6679 from fk@htmxrocks.com",
+			contains: []string{"fk@htmxrocks.com placed order 7c9e6679-7425-40de-944b-e07fc1f90ae7"},
+		},
+		{
+			name: "shipping update",
+			send: func(m *Mailer) error {
+				return m.ShippingUpdate(ctx, order, Shipment{Carrier: "UPS", TrackingNumber: "1Z999", TrackingURL: "https://ups.example/1Z999"})
+			},
+			to:       "fk@htmxrocks.com",
+			subject:  "Your order 7c9e6679 has shipped",
+			contains: []string{"has shipped with UPS", "Tracking number: 1Z999", "https://ups.example/1Z999"},
+		},
+		{
+			name: "password reset",
+			send: func(m *Mailer) error {
+				return m.PasswordReset(ctx, "fk@htmxrocks.com", "https://store.example/reset?token=t0k", time.Now().Add(time.Hour))
+			},
+			to:       "fk@htmxrocks.com",
+			subject:  "Reset your password",
+			contains: []string{"https://store.example/reset?token=t0k"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := repository.NewMemoryJobStore()
+			q := jobs.New(store, config.Default().Jobs)
+			m := NewMailer(q, NewCatcher(1), templates, "MX Store <store@example.com>", "admin@example.com", "https://store.example")
+			if err := tt.send(m); err != nil {
+				t.Fatal(err)
+			}
+			msg := queuedMessage(t, store)
+
+			if len(msg.To) != 1 || msg.To[0] != tt.to {
+				t.Errorf("To = %v, want %s", msg.To, tt.to)
+			}
+			if msg.Subject != tt.subject {
+				t.Errorf("Subject = %q, want %q", msg.Subject, tt.subject)
+			}
+			for _, want := range tt.contains {
+				if !strings.Contains(msg.Text, want) {
+					t.Errorf("text does not contain %q:\n%s", want, msg.Text)
+				}
+			}
+			if !strings.Contains(msg.HTML, "https://store.example") || strings.Contains(msg.HTML, "<Laptop>") {
+				t.Errorf("HTML does not link the store or is not escaped:\n%s", msg.HTML)
+			}
+		})
+	}
+
+	t.Run("no admin address", func(t *testing.T) {
+		store := repository.NewMemoryJobStore()
+		q := jobs.New(store, config.Default().Jobs)
+		m := NewMailer(q, NewCatcher(1), templates, "store@example.com", "", "https://store.example")
+		if err := m.OrderAlert(ctx, order); err != nil {
+			t.Fatal(err)
+		}
+		if queued, _ := store.ListJobs(ctx, models.JobQueued, 10, 0); len(queued) != 0 {
+			t.Error("alert was queued without an admin address")
+		}
+	})
+}
+
+// queuedMessage returns the message of the only send job in store.
+func queuedMessage(t *testing.T, store repository.JobStore) Message {
+	t.Helper()
+	queued, err := store.ListJobs(context.Background(), models.JobQueued, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].Kind != SendJob.Name {
+		t.Fatalf("queued jobs = %v, want one %s job", queued, SendJob.Name)
+	}
+	var msg Message
+	if err := json.Unmarshal(queued[0].Payload, &msg); err != nil {
+		t.Fatal(err)
+	}
+	return msg
+}
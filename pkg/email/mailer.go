@@ -0,0 +1,136 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	htmltemplate "html/template"
+	"path/filepath"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/jobs"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// Templates are the message templates. Every message has an HTML template
+// in a .html.tmpl file and a plain text one of the same name in a .txt.tmpl
+// file.
+type Templates struct {
+	html *htmltemplate.Template
+	text *texttemplate.Template
+}
+
+// ParseTemplates parses the message templates in dir.
+func ParseTemplates(dir string) (*Templates, error) {
+	html, err := htmltemplate.ParseGlob(filepath.Join(dir, "*.html.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	text, err := texttemplate.ParseGlob(filepath.Join(dir, "*.txt.tmpl"))
+	if err != nil {
+		return nil, err
+	}
+	return &Templates{html: html, text: text}, nil
+}
+
+// Render executes both versions of the message template name.
+func (t *Templates) Render(name string, data any) (text, html string, err error) {
+	var textBuf, htmlBuf bytes.Buffer
+	if err := t.text.ExecuteTemplate(&textBuf, name, data); err != nil {
+		return "", "", err
+	}
+	if err := t.html.ExecuteTemplate(&htmlBuf, name, data); err != nil {
+		return "", "", err
+	}
+	return textBuf.String(), htmlBuf.String(), nil
+}
+
+// Mailer writes the store's messages and queues jobs that send them.
+type Mailer struct {
+	Jobs      *jobs.Queue
+	Sender    Sender
+	Templates *Templates
+	From      string
+	// AdminAddress is alerted of new orders. No alerts are sent without it.
+	AdminAddress string
+	// StoreURL is where links in messages point to.
+	StoreURL string
+}
+
+// NewMailer creates a mailer that queues messages in q. The jobs of
+// SendJob have to be handled by sender for them to be sent.
+func NewMailer(q *jobs.Queue, sender Sender, templates *Templates, from, adminAddress, storeURL string) *Mailer {
+	return &Mailer{Jobs: q, Sender: sender, Templates: templates, From: from, AdminAddress: adminAddress, StoreURL: storeURL}
+}
+
+// Shipment is what the customer is told when an order ships.
+type Shipment struct {
+	Carrier        string
+	TrackingNumber string
+	TrackingURL    string
+}
+
+// orderData is what the order messages are rendered with.
+type orderData struct {
+	Order    models.Order
+	Shipment Shipment
+	StoreURL string
+}
+
+type passwordResetData struct {
+	ResetURL  string
+	ExpiresAt time.Time
+	StoreURL  string
+}
+
+// OrderConfirmation tells the customer their order was placed.
+func (m *Mailer) OrderConfirmation(ctx context.Context, order models.Order) error {
+	subject := "Your order " + shortID(order) + " is confirmed"
+	return m.send(ctx, "orderConfirmation", subject, orderData{Order: order, StoreURL: m.StoreURL}, order.UserID)
+}
+
+// OrderAlert tells the admin about a new order. It does nothing without an
+// AdminAddress.
+func (m *Mailer) OrderAlert(ctx context.Context, order models.Order) error {
+	if m.AdminAddress == "" {
+		return nil
+	}
+	subject := "New order " + shortID(order) + " from " + order.UserID
+	return m.send(ctx, "orderAlert", subject, orderData{Order: order, StoreURL: m.StoreURL}, m.AdminAddress)
+}
+
+// ShippingUpdate tells the customer their order is on its way.
+func (m *Mailer) ShippingUpdate(ctx context.Context, order models.Order, shipment Shipment) error {
+	subject := "Your order " + shortID(order) + " has shipped"
+	return m.send(ctx, "shippingUpdate", subject, orderData{Order: order, Shipment: shipment, StoreURL: m.StoreURL}, order.UserID)
+}
+
+// PasswordReset sends to the address to a link for choosing a new
+// password, which works until expiresAt.
+func (m *Mailer) PasswordReset(ctx context.Context, to, resetURL string, expiresAt time.Time) error {
+	data := passwordResetData{ResetURL: resetURL, ExpiresAt: expiresAt, StoreURL: m.StoreURL}
+	return m.send(ctx, "passwordReset", "Reset your password", data, to)
+}
+
+func (m *Mailer) send(ctx context.Context, template, subject string, data any, to ...string) error {
+	text, html, err := m.Templates.Render(template, data)
+	if err != nil {
+		return err
+	}
+	_, err = SendJob.Enqueue(ctx, m.Jobs, Message{
+		ID:      uuid.NewString(),
+		From:    m.From,
+		To:      to,
+		Subject: subject,
+		Text:    text,
+		HTML:    html,
+		Date:    time.Now(),
+	})
+	return err
+}
+
+// shortID is the part of the order ID customers are shown in subjects.
+func shortID(order models.Order) string {
+	return order.OrderID.String()[:8]
+}
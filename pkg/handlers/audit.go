@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/snirkop89/mx-store/pkg/audit"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+const (
+	auditActionSeed    = "seed"
+	auditActionCreate  = "create"
+	auditActionUpdate  = "update"
+	auditActionArchive = "archive"
+	auditActionRestore = "restore"
+	auditActionPurge   = "purge"
+	auditActionDelete  = "delete"
+
+	auditActionSchedulePrice = "schedule_price"
+	auditActionCancelPrice   = "cancel_price"
+	auditActionRefund        = "refund"
+	auditActionRetry         = "retry"
+	auditActionRedeliver     = "redeliver"
+
+	auditEntityProduct   = "product"
+	auditEntityCoupon    = "coupon"
+	auditEntityPromotion = "promotion"
+	auditEntityTaxRate   = "tax_rate"
+	auditEntityShipping  = "shipping_method"
+	auditEntityReturn    = "return"
+	auditEntityWebhook   = "webhook_subscription"
+	auditEntityJob       = "job"
+	auditEntityDelivery  = "webhook_delivery"
+)
+
+var auditActions = []string{
+	auditActionSeed,
+	auditActionCreate,
+	auditActionUpdate,
+	auditActionArchive,
+	auditActionRestore,
+	auditActionPurge,
+	auditActionDelete,
+	auditActionSchedulePrice,
+	auditActionCancelPrice,
+	auditActionRefund,
+	auditActionRetry,
+	auditActionRedeliver,
+}
+
+// recordAudit appends an event for a change made through the admin. The
+// change has already been saved at this point, so a failure to record it is
+// logged rather than returned to the user.
+func (h *Handler) recordAudit(r *http.Request, action, entityType, entityID string, before, after any) {
+	beforeJSON, afterJSON, err := audit.Diff(before, after)
+	if err != nil {
+		log.Printf("Failed building audit diff for %s %s: %v\n", entityType, entityID, err)
+	}
+
+	event := models.AuditEvent{
+		Actor:      requestActor(r),
+		Action:     action,
+		EntityType: entityType,
+		EntityID:   entityID,
+		Before:     beforeJSON,
+		After:      afterJSON,
+		IP:         requestIP(r),
+	}
+	if err := h.Repo.Audit.RecordEvent(r.Context(), &event); err != nil {
+		log.Printf("Failed recording audit event %s %s %s: %v\n", action, entityType, entityID, err)
+	}
+}
+
+// recordProductChange records action on a product, diffing the state before
+// it against the product as it is stored now.
+func (h *Handler) recordProductChange(r *http.Request, action string, before *models.Product) {
+	after, err := h.Repo.Product.GetProductByID(r.Context(), before.ProductID)
+	if err != nil {
+		log.Printf("Failed loading product %s for audit: %v\n", before.ProductID, err)
+		return
+	}
+	h.recordAudit(r, action, auditEntityProduct, before.ProductID.String(), before, after)
+}
+
+// requestActor identifies who made the request. The admin has no accounts
+// yet, so this is the basic auth user set by a proxy in front of it, if any.
+func requestActor(r *http.Request) string {
+	if user, _, ok := r.BasicAuth(); ok && user != "" {
+		return user
+	}
+	return "admin"
+}
+
+func requestIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (h *Handler) ActivityLogPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Actions []string
+	}{
+		Actions: auditActions,
+	}
+	tmpl.ExecuteTemplate(w, "activityLog", data)
+}
+
+func (h *Handler) ListAuditEvents(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	page, err := strconv.Atoi(query.Get("page"))
+	if err != nil || page < 1 {
+		page = 1
+	}
+	limit := h.Config.Templates.PageSize
+	offset := (page - 1) * limit
+
+	filter := repository.AuditFilter{
+		Actor:      query.Get("actor"),
+		Action:     query.Get("action"),
+		EntityType: query.Get("entity_type"),
+		EntityID:   query.Get("entity_id"),
+	}
+
+	events, err := h.Repo.Audit.ListEvents(r.Context(), filter, limit, offset)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	totalEvents, err := h.Repo.Audit.CountEvents(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	totalPages := int(math.Ceil(float64(totalEvents) / float64(limit)))
+
+	// Keep the filter on the pagination links
+	query.Del("page")
+
+	data := struct {
+		Events       []models.AuditEvent
+		CurrentPage  int
+		TotalPages   int
+		PreviousPage int
+		NextPage     int
+		Query        string
+	}{
+		Events:       events,
+		CurrentPage:  page,
+		TotalPages:   totalPages,
+		PreviousPage: page - 1,
+		NextPage:     page + 1,
+		Query:        query.Encode(),
+	}
+
+	tmpl.ExecuteTemplate(w, "auditRows", data)
+}
@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+	"github.com/snirkop89/mx-store/pkg/shipping"
+)
+
+// addressForm is one of the address forms of the checkout, filled with what
+// was entered when it is shown again with validation messages.
+type addressForm struct {
+	Prefix string
+	Values url.Values
+}
+
+func (f addressForm) Value(field string) string {
+	return f.Values.Get(f.Prefix + field)
+}
+
+type checkoutAddressData struct {
+	// Addresses is the customer's address book.
+	Addresses []models.Address
+	// ShippingChoice and BillingChoice are the ID of the saved address
+	// picked, or "new" to enter one.
+	ShippingChoice string
+	BillingChoice  string
+	BillingSame    bool
+	Shipping       addressForm
+	Billing        addressForm
+	Messages       []string
+}
+
+type checkoutShippingData struct {
+	ShippingAddress *models.Address
+	BillingAddress  *models.Address
+	Quotes          []shipping.Quote
+	// Selected is the ID of the method chosen before, if any.
+	Selected int64
+	Message  string
+}
+
+type checkoutReviewData struct {
+	Cart            CartTemplateData
+	ShippingAddress *models.Address
+	BillingAddress  *models.Address
+}
+
+// CheckoutView starts the checkout. It takes the cart through picking a
+// shipping and billing address, then a shipping method, to a review of the
+// order before it is placed. Each step replaces the main section of the
+// store.
+func (h *Handler) CheckoutView(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	if len(h.cart.items) == 0 {
+		h.sendCartError(w, r, "Your cart is empty")
+		return
+	}
+	h.sendCheckoutAddress(w, r, nil, nil)
+}
+
+// SaveCheckoutAddress takes the addresses picked from the address book or
+// entered at checkout. New addresses are added to the address book once
+// they are valid.
+func (h *Handler) SaveCheckoutAddress(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	shippingAddress, messages, err := h.checkoutAddress(r, "shipping")
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	billingSame := r.FormValue("billing_same") == "true"
+	billingAddress := shippingAddress
+	if !billingSame {
+		var billingMessages []string
+		billingAddress, billingMessages, err = h.checkoutAddress(r, "billing")
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		messages = append(messages, billingMessages...)
+	}
+	if len(messages) > 0 {
+		h.sendCheckoutAddress(w, r, r.Form, messages)
+		return
+	}
+
+	for _, address := range []*models.Address{shippingAddress, billingAddress} {
+		if address.AddressID != 0 {
+			continue
+		}
+		if err := h.Repo.Address.CreateAddress(r.Context(), address); err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+	}
+
+	h.cart.shippingAddress = shippingAddress
+	h.cart.billingAddress = billingAddress
+	h.sendCheckoutShipping(w, r, "")
+}
+
+// DeleteAddress removes an address from the address book at checkout.
+// Addresses already picked for the cart stay picked.
+func (h *Handler) DeleteAddress(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	addressID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid address ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Repo.Address.DeleteAddress(r.Context(), cartUserID, addressID); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.sendCheckoutAddress(w, r, nil, nil)
+}
+
+// CheckoutShippingView goes back to the shipping step from the review.
+func (h *Handler) CheckoutShippingView(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	if h.cart.shippingAddress == nil {
+		h.sendCheckoutAddress(w, r, nil, nil)
+		return
+	}
+	h.sendCheckoutShipping(w, r, "")
+}
+
+// SelectShippingMethod takes the shipping method and shows the order for
+// review.
+func (h *Handler) SelectShippingMethod(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	if h.cart.shippingAddress == nil {
+		h.sendCheckoutAddress(w, r, nil, []string{"Choose a shipping address first"})
+		return
+	}
+
+	methodID, err := strconv.ParseInt(r.FormValue("shipping_method_id"), 10, 64)
+	if err != nil {
+		h.sendCheckoutShipping(w, r, "Choose a shipping method")
+		return
+	}
+	method, err := h.shippingMethod(r.Context(), methodID)
+	if errors.Is(err, repository.ErrNotFound) {
+		h.sendCheckoutShipping(w, r, "Choose a shipping method")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	h.cart.shippingMethod = method
+	data := checkoutReviewData{
+		Cart:            h.newCartData(r.Context(), "", ""),
+		ShippingAddress: h.cart.shippingAddress,
+		BillingAddress:  h.cart.billingAddress,
+	}
+	tmpl.ExecuteTemplate(w, "checkoutReview", data)
+}
+
+// checkoutAddress reads the address picked or entered in the form with
+// prefix. Invalid input is reported in the messages.
+func (h *Handler) checkoutAddress(r *http.Request, prefix string) (*models.Address, []string, error) {
+	label := capitalize(prefix) + " address"
+	choice := r.FormValue(prefix + "_address")
+	if choice == "new" {
+		address, messages := parseAddress(r, prefix+"_", label)
+		return address, messages, nil
+	}
+
+	addressID, err := strconv.ParseInt(choice, 10, 64)
+	if err != nil {
+		return nil, []string{"Choose a " + strings.ToLower(label)}, nil
+	}
+	address, err := h.Repo.Address.GetAddress(r.Context(), cartUserID, addressID)
+	if errors.Is(err, repository.ErrNotFound) {
+		return nil, []string{"Choose a " + strings.ToLower(label)}, nil
+	}
+	return address, nil, err
+}
+
+// parseAddress reads a new address from the fields starting with prefix and
+// reports every invalid field, starting with label.
+func parseAddress(r *http.Request, prefix, label string) (*models.Address, []string) {
+	value := func(field string) string {
+		return strings.TrimSpace(r.FormValue(prefix + field))
+	}
+	address := &models.Address{
+		UserID:     cartUserID,
+		Name:       value("name"),
+		Line1:      value("line1"),
+		Line2:      value("line2"),
+		City:       value("city"),
+		State:      models.NormalizeRegion(value("state")),
+		PostalCode: strings.ToUpper(value("postal_code")),
+		Country:    models.NormalizeRegion(value("country")),
+		Phone:      value("phone"),
+	}
+
+	var messages []string
+	for _, f := range []struct {
+		value    string
+		name     string
+		required bool
+		max      int
+	}{
+		{address.Name, "Name", true, 100},
+		{address.Line1, "Address", true, 200},
+		{address.Line2, "Address line 2", false, 200},
+		{address.City, "City", true, 100},
+		{address.State, "State", false, 50},
+		{address.PostalCode, "Postal code", true, 20},
+	} {
+		switch {
+		case f.required && f.value == "":
+			messages = append(messages, label+": "+f.name+" is required")
+		case len(f.value) > f.max:
+			messages = append(messages, label+": "+f.name+" can be up to "+strconv.Itoa(f.max)+" characters")
+		}
+	}
+	if len(address.Country) != 2 {
+		messages = append(messages, label+": Country must be a two letter code")
+	}
+	if len(address.Phone) > 30 || strings.Trim(address.Phone, "0123456789 +-()") != "" {
+		messages = append(messages, label+": Invalid phone number")
+	}
+
+	return address, messages
+}
+
+// shippingMethod returns the active shipping method with methodID.
+func (h *Handler) shippingMethod(ctx context.Context, methodID int64) (*models.ShippingMethod, error) {
+	methods, err := h.Repo.Shipping.ListShippingMethods(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, method := range methods {
+		if method.ShippingMethodID == methodID && method.Active {
+			return &method, nil
+		}
+	}
+	return nil, repository.ErrNotFound
+}
+
+// sendCheckoutAddress shows the address step. form holds what was entered
+// when the step is shown again because of messages.
+func (h *Handler) sendCheckoutAddress(w http.ResponseWriter, r *http.Request, form url.Values, messages []string) {
+	addresses, err := h.Repo.Address.ListAddresses(r.Context(), cartUserID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := checkoutAddressData{
+		Addresses: addresses,
+		Shipping:  addressForm{Prefix: "shipping_", Values: form},
+		Billing:   addressForm{Prefix: "billing_", Values: form},
+		Messages:  messages,
+	}
+	if form != nil {
+		data.ShippingChoice = form.Get("shipping_address")
+		data.BillingChoice = form.Get("billing_address")
+		data.BillingSame = form.Get("billing_same") == "true"
+	} else {
+		// Start from what was picked before, or the newest saved address
+		data.ShippingChoice = savedAddressChoice(addresses, h.cart.shippingAddress)
+		data.BillingChoice = savedAddressChoice(addresses, h.cart.billingAddress)
+		data.BillingSame = h.cart.billingAddress == nil || h.cart.billingAddress == h.cart.shippingAddress
+	}
+	tmpl.ExecuteTemplate(w, "checkoutAddress", data)
+}
+
+func savedAddressChoice(addresses []models.Address, picked *models.Address) string {
+	if picked != nil {
+		for _, a := range addresses {
+			if a.AddressID == picked.AddressID {
+				return strconv.FormatInt(a.AddressID, 10)
+			}
+		}
+	}
+	if len(addresses) > 0 {
+		return strconv.FormatInt(addresses[0].AddressID, 10)
+	}
+	return "new"
+}
+
+// sendCheckoutShipping shows the shipping step with what every active
+// method costs for the cart.
+func (h *Handler) sendCheckoutShipping(w http.ResponseWriter, r *http.Request, message string) {
+	methods, err := h.Repo.Shipping.ListShippingMethods(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	now := time.Now()
+	priced, err := h.priceCart(r.Context(), now)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	// A coupon the cart no longer qualifies for takes nothing off
+	discount, _ := h.cart.discount(priced.Items, now)
+
+	data := checkoutShippingData{
+		ShippingAddress: h.cart.shippingAddress,
+		BillingAddress:  h.cart.billingAddress,
+		Quotes:          shipping.Quotes(methods, priced.Items, priced.Subtotal()-discount),
+		Message:         message,
+	}
+	if h.cart.shippingMethod != nil {
+		data.Selected = h.cart.shippingMethod.ShippingMethodID
+	}
+	if len(data.Quotes) == 0 && message == "" {
+		data.Message = "There is no way to ship your order yet, please try again later"
+	}
+	tmpl.ExecuteTemplate(w, "checkoutShipping", data)
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+func (h *Handler) CouponsPage(w http.ResponseWriter, r *http.Request) {
+	// Every live product can be picked as a restriction, listed or not
+	products, err := h.Repo.Product.GetProducts(r.Context(), repository.ProductFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	// The categories in use by those products
+	var categories []string
+	for _, product := range products {
+		if product.Category != "" && !slices.ContainsFunc(categories, func(c string) bool { return strings.EqualFold(c, product.Category) }) {
+			categories = append(categories, product.Category)
+		}
+	}
+	slices.Sort(categories)
+
+	data := struct {
+		Products      []models.Product
+		Categories    []string
+		DiscountTypes []models.DiscountType
+	}{
+		Products:      products,
+		Categories:    categories,
+		DiscountTypes: models.DiscountTypes,
+	}
+	tmpl.ExecuteTemplate(w, "coupons", data)
+}
+
+func (h *Handler) ListCoupons(w http.ResponseWriter, r *http.Request) {
+	h.sendCouponList(w, r, nil, "")
+}
+
+func (h *Handler) CreateCoupon(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	coupon, messages := parseCoupon(r)
+	if len(messages) > 0 {
+		h.sendCouponList(w, r, messages, "danger")
+		return
+	}
+
+	err := h.Repo.Coupon.CreateCoupon(r.Context(), coupon)
+	if errors.Is(err, repository.ErrCouponCodeTaken) {
+		h.sendCouponList(w, r, []string{"Coupon code " + coupon.Code + " already exists"}, "danger")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionCreate, auditEntityCoupon, coupon.Code, nil, coupon)
+
+	h.sendCouponList(w, r, []string{"Coupon " + coupon.Code + " created"}, "success")
+}
+
+// SetCouponActive enables or disables a coupon. Disabled coupons can no
+// longer be applied, but stay on the orders that used them.
+func (h *Handler) SetCouponActive(w http.ResponseWriter, r *http.Request) {
+	couponID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid coupon ID", http.StatusBadRequest)
+		return
+	}
+	active := r.FormValue("active") == "true"
+
+	if err := h.Repo.Coupon.SetCouponActive(r.Context(), couponID, active); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionUpdate, auditEntityCoupon, strconv.FormatInt(couponID, 10),
+		map[string]bool{"Active": !active}, map[string]bool{"Active": active})
+
+	h.sendCouponList(w, r, nil, "")
+}
+
+// parseCoupon reads a coupon from the create form and reports every invalid
+// field.
+func parseCoupon(r *http.Request) (*models.Coupon, []string) {
+	var messages []string
+	coupon := &models.Coupon{
+		Code:         models.NormalizeCouponCode(r.FormValue("code")),
+		DiscountType: models.DiscountType(r.FormValue("discount_type")),
+		Active:       true,
+	}
+
+	if coupon.Code == "" || len(coupon.Code) > 50 || strings.ContainsAny(coupon.Code, " \t") {
+		messages = append(messages, "Code is required, up to 50 characters without spaces")
+	}
+	if !coupon.DiscountType.Valid() {
+		messages = append(messages, "Invalid discount type")
+	}
+
+	amount, err := strconv.ParseFloat(r.FormValue("amount"), 64)
+	switch {
+	case err != nil || amount <= 0:
+		messages = append(messages, "Amount must be a positive number")
+	case coupon.DiscountType == models.DiscountPercent && amount > 100:
+		messages = append(messages, "A percentage cannot be more than 100")
+	}
+	coupon.Amount = amount
+
+	if v := r.FormValue("min_cart_value"); v != "" {
+		coupon.MinCartValue, err = strconv.ParseFloat(v, 64)
+		if err != nil || coupon.MinCartValue < 0 {
+			messages = append(messages, "Invalid minimum cart value")
+		}
+	}
+	for _, f := range []struct {
+		field string
+		label string
+		value *int
+	}{
+		{"max_uses", "usage limit", &coupon.MaxUses},
+		{"max_uses_per_customer", "usage limit per customer", &coupon.MaxUsesPerCustomer},
+	} {
+		v := r.FormValue(f.field)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			messages = append(messages, "Invalid "+f.label)
+		}
+		*f.value = n
+	}
+
+	for _, f := range []struct {
+		field string
+		label string
+		value **time.Time
+	}{
+		{"starts_at", "start date", &coupon.StartsAt},
+		{"ends_at", "end date", &coupon.EndsAt},
+	} {
+		v := r.FormValue(f.field)
+		if v == "" {
+			continue
+		}
+		t, err := time.ParseInLocation(datetimeLocalLayout, v, time.Local)
+		if err != nil {
+			messages = append(messages, "Invalid "+f.label)
+			continue
+		}
+		*f.value = &t
+	}
+	if coupon.StartsAt != nil && coupon.EndsAt != nil && !coupon.EndsAt.After(*coupon.StartsAt) {
+		messages = append(messages, "End date must be after the start date")
+	}
+
+	for _, v := range r.Form["product_ids"] {
+		productID, err := uuid.Parse(v)
+		if err != nil {
+			messages = append(messages, "Invalid product")
+			continue
+		}
+		coupon.ProductIDs = append(coupon.ProductIDs, productID)
+	}
+	for _, v := range r.Form["categories"] {
+		category := models.NormalizeCategory(v)
+		if category == "" || len(category) > 100 {
+			messages = append(messages, "Invalid category")
+			continue
+		}
+		if !slices.ContainsFunc(coupon.Categories, func(c string) bool { return strings.EqualFold(c, category) }) {
+			coupon.Categories = append(coupon.Categories, category)
+		}
+	}
+
+	return coupon, messages
+}
+
+func (h *Handler) sendCouponList(w http.ResponseWriter, r *http.Request, messages []string, alertType string) {
+	list, err := h.Repo.Coupon.ListCoupons(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	// Name the products coupons are restricted to
+	productNames := map[uuid.UUID]string{}
+	for _, coupon := range list {
+		for _, productID := range coupon.ProductIDs {
+			if _, ok := productNames[productID]; ok {
+				continue
+			}
+			product, err := h.Repo.Product.GetProductByID(r.Context(), productID)
+			if err != nil {
+				productNames[productID] = productID.String()
+				continue
+			}
+			productNames[productID] = product.ProductName
+		}
+	}
+
+	data := struct {
+		Coupons      []models.Coupon
+		ProductNames map[uuid.UUID]string
+		Messages     []string
+		AlertType    string
+	}{
+		Coupons:      list,
+		ProductNames: productNames,
+		Messages:     messages,
+		AlertType:    alertType,
+	}
+	tmpl.ExecuteTemplate(w, "couponList", data)
+}
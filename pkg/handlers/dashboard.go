@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// dashboardPeriod is a period the dashboard sums sales up over. It ends now
+// and starts Days days ago at midnight, today's midnight for a single day.
+type dashboardPeriod struct {
+	Value string
+	Label string
+	Days  int
+}
+
+var dashboardPeriods = []dashboardPeriod{
+	{"today", "Today", 1},
+	{"7d", "Last 7 days", 7},
+	{"30d", "Last 30 days", 30},
+	{"90d", "Last 90 days", 90},
+}
+
+const (
+	// defaultDashboardPeriod is the index of the period shown first.
+	defaultDashboardPeriod = 1
+	// lowStockListSize is how many products running out the dashboard lists.
+	lowStockListSize = 20
+)
+
+func findDashboardPeriod(value string) dashboardPeriod {
+	for _, period := range dashboardPeriods {
+		if period.Value == value {
+			return period
+		}
+	}
+	return dashboardPeriods[defaultDashboardPeriod]
+}
+
+// bounds returns when the period starts and ends, at now.
+func (p dashboardPeriod) bounds(now time.Time) (from, to time.Time) {
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return midnight.AddDate(0, 0, 1-p.Days), now
+}
+
+// dashboardDay is a bar of the daily revenue chart. Height is its share of
+// the best day's revenue, in percent.
+type dashboardDay struct {
+	models.DailySales
+	Height float64
+}
+
+// dashboardStats is what the dashboard widgets show. Previous sums up the
+// period of the same length just before, to compare with.
+type dashboardStats struct {
+	Period            dashboardPeriod
+	From, To          time.Time
+	Sales             models.SalesSummary
+	Previous          models.SalesSummary
+	Days              []dashboardDay
+	TopProducts       []models.ProductSales
+	LowStock          []models.StockAlert
+	LowStockThreshold int
+}
+
+// change formats how much now moved from before, empty when there was
+// nothing before to compare with.
+func change(now, before float64) string {
+	if before == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%+.1f%%", (now-before)/before*100)
+}
+
+func (s dashboardStats) RevenueChange() string {
+	return change(s.Sales.Revenue, s.Previous.Revenue)
+}
+
+func (s dashboardStats) OrdersChange() string {
+	return change(float64(s.Sales.Orders), float64(s.Previous.Orders))
+}
+
+func (s dashboardStats) AverageOrderValueChange() string {
+	return change(s.Sales.AverageOrderValue(), s.Previous.AverageOrderValue())
+}
+
+func (h *Handler) DashboardPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Periods         []dashboardPeriod
+		Period          dashboardPeriod
+		RefreshInterval time.Duration
+	}{
+		Periods:         dashboardPeriods,
+		Period:          findDashboardPeriod(r.FormValue("period")),
+		RefreshInterval: h.Config.Dashboard.RefreshInterval,
+	}
+	tmpl.ExecuteTemplate(w, "dashboard", data)
+}
+
+// DashboardStats renders the dashboard widgets for the period asked for.
+func (h *Handler) DashboardStats(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	period := findDashboardPeriod(r.FormValue("period"))
+	now := time.Now()
+	from, to := period.bounds(now)
+	stats := dashboardStats{
+		Period:            period,
+		From:              from,
+		To:                to,
+		LowStockThreshold: h.Config.Dashboard.LowStockThreshold,
+	}
+
+	var err error
+	if stats.Sales, err = h.Repo.Order.SalesSummary(ctx, from, to); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if stats.Previous, err = h.Repo.Order.SalesSummary(ctx, from.Add(-to.Sub(from)), from); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	days, err := h.Repo.Order.DailySales(ctx, from, to, time.Local)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	var best float64
+	for _, day := range days {
+		best = max(best, day.Revenue)
+	}
+	for _, day := range days {
+		var height float64
+		if best > 0 {
+			height = max(day.Revenue, 0) / best * 100
+		}
+		stats.Days = append(stats.Days, dashboardDay{DailySales: day, Height: height})
+	}
+	if stats.TopProducts, err = h.Repo.Order.TopProducts(ctx, from, to, h.Config.Dashboard.TopProducts); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if stats.LowStock, err = h.Repo.Order.LowStock(ctx, stats.LowStockThreshold, from, to, lowStockListSize); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	tmpl.ExecuteTemplate(w, "dashboardStats", stats)
+}
@@ -1,6 +1,7 @@
 package handlers
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"html/template"
@@ -17,31 +18,52 @@ import (
 	"github.com/go-faker/faker/v3"
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/email"
+	"github.com/snirkop89/mx-store/pkg/jobs"
 	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/payments"
 	"github.com/snirkop89/mx-store/pkg/repository"
+	"github.com/snirkop89/mx-store/pkg/sse"
+	"github.com/snirkop89/mx-store/pkg/webhooks"
 	"golang.org/x/text/cases"
 	"golang.org/x/text/language"
 )
 
 var tmpl *template.Template
 
+// placeholderImage is shared by all seeded products and is never removed.
+const placeholderImage = "placeholder.jpeg"
+
 type ProductCRUDTemplateData struct {
 	Messages []string
 	Product  *models.Product
 }
 
 type Handler struct {
-	Repo *repository.Repository
+	Repo     *repository.Repository
+	Config   *config.Config
+	Payments payments.Provider
+	Mail     *email.Mailer
+	Jobs     *jobs.Queue
+	Webhooks *webhooks.Dispatcher
+	Live     *sse.Hub
+
+	cart *cart
 }
 
-func NewHandler(repo *repository.Repository) *Handler {
-	return &Handler{Repo: repo}
+var templateFuncs = template.FuncMap{
+	"productStatuses": func() []models.ProductStatus { return models.ProductStatuses },
+	"taxClasses":      func() []models.TaxClass { return models.TaxClasses },
+	"datetimeLocal":   formatDatetimeLocal,
+	"testCards":       func() []payments.TestCard { return payments.TestCards },
+	"now":             time.Now,
 }
 
-func init() {
-	templatesDir := "./templates"
-	pattern := filepath.Join(templatesDir, "**", "*.html")
-	tmpl = template.Must(template.ParseGlob(pattern))
+func NewHandler(repo *repository.Repository, cfg *config.Config, provider payments.Provider, mailer *email.Mailer, queue *jobs.Queue, hooks *webhooks.Dispatcher, live *sse.Hub) *Handler {
+	pattern := filepath.Join(cfg.Templates.Dir, "**", "*.html")
+	tmpl = template.Must(template.New("").Funcs(templateFuncs).ParseGlob(pattern))
+	return &Handler{Repo: repo, Config: cfg, Payments: provider, Mail: mailer, Jobs: queue, Webhooks: hooks, Live: live, cart: &cart{}}
 }
 
 func (h *Handler) SeedProducts(w http.ResponseWriter, r *http.Request) {
@@ -62,18 +84,20 @@ func (h *Handler) SeedProducts(w http.ResponseWriter, r *http.Request) {
 			ProductName:  productName,
 			Price:        float64(rng.Intn(100000)) / 100, // Random price betwen 0.00 and 999.99
 			Description:  faker.Sentence(),
-			ProductImage: "placeholder.jpeg",
+			ProductImage: placeholderImage,
+			Status:       models.StatusPublished,
 		}
 
-		err := h.Repo.Product.CreateProduct(&product)
+		err := h.Repo.Product.CreateProduct(r.Context(), &product)
 		if err != nil {
 			http.Error(
 				w,
 				fmt.Sprintf("Error creating product %s: %v", product.ProductName, err),
-				http.StatusInternalServerError,
+				errorStatus(err),
 			)
 			return
 		}
+		h.recordAudit(r, auditActionSeed, auditEntityProduct, product.ProductID.String(), nil, &product)
 	}
 	w.WriteHeader(http.StatusCreated)
 	fmt.Fprintf(w, "Successfully added %d dummy products", numProducts)
@@ -87,6 +111,10 @@ func (h *Handler) AllProductsView(w http.ResponseWriter, r *http.Request) {
 	tmpl.ExecuteTemplate(w, "allProducts", nil)
 }
 
+func (h *Handler) ArchivedProductsView(w http.ResponseWriter, r *http.Request) {
+	sendArchivedProducts(w, "", "")
+}
+
 func (h *Handler) ListProducts(w http.ResponseWriter, r *http.Request) {
 	pageStr := r.URL.Query().Get("page")
 	limitStr := r.URL.Query().Get("limit")
@@ -98,20 +126,23 @@ func (h *Handler) ListProducts(w http.ResponseWriter, r *http.Request) {
 
 	limit, err := strconv.Atoi(limitStr)
 	if err != nil || limit <= 0 {
-		limit = 10
+		limit = h.Config.Templates.PageSize
 	}
 
 	offset := (page - 1) * limit
 
-	products, err := h.Repo.Product.ListProducts(limit, offset)
+	archived, _ := strconv.ParseBool(r.URL.Query().Get("archived"))
+	filter := repository.ProductFilter{Archived: archived}
+
+	products, err := h.Repo.Product.ListProducts(r.Context(), filter, limit, offset)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
-	totalProducts, err := h.Repo.Product.GetTotalProductsCount()
+	totalProducts, err := h.Repo.Product.GetTotalProductsCount(r.Context(), filter)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -128,17 +159,18 @@ func (h *Handler) ListProducts(w http.ResponseWriter, r *http.Request) {
 		PreviousPage     int
 		NextPage         int
 		PageButtonsRange []int
+		Archived         bool
 	}{
 		Products:         products,
 		CurrentPage:      page,
 		TotalPages:       totalPages,
+		Limit:            limit,
 		PreviousPage:     prevPage,
 		NextPage:         nextPage,
 		PageButtonsRange: pageButtonsRange,
+		Archived:         archived,
 	}
 
-	// Fake latency
-	// time.Sleep(4 * time.Second)
 	tmpl.ExecuteTemplate(w, "productRows", data)
 }
 
@@ -150,9 +182,9 @@ func (h *Handler) GetProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := h.Repo.Product.GetProductByID(productID)
+	product, err := h.Repo.Product.GetProductByID(r.Context(), productID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -160,12 +192,22 @@ func (h *Handler) GetProduct(w http.ResponseWriter, r *http.Request) {
 }
 
 func (h *Handler) CreateProductView(w http.ResponseWriter, r *http.Request) {
-	tmpl.ExecuteTemplate(w, "createProduct", nil)
+	// New products start out as drafts so they can be prepared before launch
+	tmpl.ExecuteTemplate(w, "createProduct", &models.Product{Status: models.StatusDraft})
 }
 
 func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
-	// Parse the multipart form , 10MB max upload size
-	r.ParseMultipartForm(10 << 20)
+	// Parse the multipart form, limited to the configured max upload size
+	r.Body = http.MaxBytesReader(w, r.Body, h.Config.Storage.MaxUploadSize)
+	if err := r.ParseMultipartForm(h.Config.Storage.MaxUploadSize); err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			http.Error(w, fmt.Sprintf("Upload is larger than %d bytes", tooLarge.Limit), http.StatusRequestEntityTooLarge)
+			return
+		}
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	// Initialize error messges slice
 	var responseMessages []string
@@ -181,6 +223,32 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	status, publishAt, unpublishAt, publishingMessages := parsePublishing(r)
+	if len(publishingMessages) > 0 {
+		sendProductMessages(w, publishingMessages, nil)
+		return
+	}
+	taxClass, ok := parseTaxClass(r)
+	if !ok {
+		sendProductMessages(w, []string{"Invalid tax class"}, nil)
+		return
+	}
+	category, ok := parseCategory(r)
+	if !ok {
+		sendProductMessages(w, []string{"Category can be up to 100 characters"}, nil)
+		return
+	}
+	weight, ok := parseWeight(r)
+	if !ok {
+		sendProductMessages(w, []string{"Invalid weight"}, nil)
+		return
+	}
+	stock, ok := parseStock(r)
+	if !ok {
+		sendProductMessages(w, []string{"Invalid stock"}, nil)
+		return
+	}
+
 	// Process file upload
 	file, handler, err := r.FormFile("product_image")
 	if err != nil {
@@ -207,7 +275,7 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 	}
 	filename := uuid.String() + filepath.Ext(handler.Filename)
 
-	filePath := filepath.Join("static/uploads", filename)
+	filePath := filepath.Join(h.Config.Storage.UploadDir, filename)
 
 	dst, err := os.Create(filePath)
 	if err != nil {
@@ -237,17 +305,27 @@ func (h *Handler) CreateProduct(w http.ResponseWriter, r *http.Request) {
 		Price:        price,
 		Description:  productDescription,
 		ProductImage: filename,
+		Status:       status,
+		PublishAt:    publishAt,
+		UnpublishAt:  unpublishAt,
+		TaxClass:     taxClass,
+		Category:     category,
+		Weight:       weight,
+		Stock:        stock,
 	}
 
-	err = h.Repo.Product.CreateProduct(&product)
+	err = h.Repo.Product.CreateProduct(r.Context(), &product)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
 		responseMessages = append(responseMessages, err.Error())
 		sendProductMessages(w, responseMessages, nil)
 		return
 	}
+	h.recordAudit(r, auditActionCreate, auditEntityProduct, product.ProductID.String(), nil, &product)
 
-	// Fake latency
-	time.Sleep(2 * time.Second)
 	sendProductMessages(w, []string{}, &product)
 }
 
@@ -259,9 +337,9 @@ func (h *Handler) EditProductView(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := h.Repo.Product.GetProductByID(productID)
+	product, err := h.Repo.Product.GetProductByID(r.Context(), productID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
@@ -300,31 +378,97 @@ func (h *Handler) UpdateProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	status, publishAt, unpublishAt, publishingMessages := parsePublishing(r)
+	if len(publishingMessages) > 0 {
+		sendProductMessages(w, publishingMessages, nil)
+		return
+	}
+	taxClass, ok := parseTaxClass(r)
+	if !ok {
+		sendProductMessages(w, []string{"Invalid tax class"}, nil)
+		return
+	}
+	category, ok := parseCategory(r)
+	if !ok {
+		sendProductMessages(w, []string{"Category can be up to 100 characters"}, nil)
+		return
+	}
+	weight, ok := parseWeight(r)
+	if !ok {
+		sendProductMessages(w, []string{"Invalid weight"}, nil)
+		return
+	}
+	stock, ok := parseStock(r)
+	if !ok {
+		sendProductMessages(w, []string{"Invalid stock"}, nil)
+		return
+	}
+
+	version, err := strconv.Atoi(r.FormValue("version"))
+	if err != nil {
+		responseMessages = append(responseMessages, "Missing product version, reload the page and try again")
+		sendProductMessages(w, responseMessages, nil)
+		return
+	}
+
 	product := models.Product{
 		ProductID:   productID,
 		ProductName: productName,
 		Price:       price,
 		Description: productDescription,
+		Status:      status,
+		PublishAt:   publishAt,
+		UnpublishAt: unpublishAt,
+		TaxClass:    taxClass,
+		Category:    category,
+		Weight:      weight,
+		Stock:       stock,
+		Version:     version,
+	}
+
+	before, err := h.Repo.Product.GetProductByID(r.Context(), productID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
 	}
 
-	err = h.Repo.Product.UpdateProduct(&product)
+	err = h.Repo.Product.UpdateProduct(r.Context(), &product)
+	if errors.Is(err, repository.ErrVersionConflict) {
+		current, err := h.Repo.Product.GetProductByID(r.Context(), productID)
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		sendProductConflict(w, &product, current)
+		return
+	}
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
 		responseMessages = append(responseMessages, err.Error())
 		sendProductMessages(w, responseMessages, nil)
 		return
 	}
 
-	updatedProduct, err := h.Repo.Product.GetProductByID(productID)
+	updatedProduct, err := h.Repo.Product.GetProductByID(r.Context(), productID)
 	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			http.Error(w, err.Error(), http.StatusGatewayTimeout)
+			return
+		}
 		responseMessages = append(responseMessages, err.Error())
 		sendProductMessages(w, responseMessages, nil)
 		return
 	}
+	h.recordAudit(r, auditActionUpdate, auditEntityProduct, productID.String(), before, updatedProduct)
 
-	time.Sleep(2 * time.Second)
 	sendProductMessages(w, nil, updatedProduct)
 }
 
+// DeleteProduct archives the product. It disappears from the store and the
+// product list but stays on the orders that reference it.
 func (h Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	productID, err := uuid.Parse(vars["id"])
@@ -333,27 +477,106 @@ func (h Handler) DeleteProduct(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	product, err := h.Repo.Product.GetProductByID(productID)
+	before, err := h.Repo.Product.GetProductByID(r.Context(), productID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 
-	err = h.Repo.Product.DeleteProduct(productID)
+	err = h.Repo.Product.ArchiveProduct(r.Context(), productID)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
+	h.recordProductChange(r, auditActionArchive, before)
 
-	// Remove product image
-	productImagePath := filepath.Join("static/uploads", product.ProductImage)
-	err = os.Remove(productImagePath)
+	tmpl.ExecuteTemplate(w, "allProducts", nil)
+}
+
+func (h Handler) RestoreProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := uuid.Parse(vars["id"])
 	if err != nil {
-		log.Printf("Failed removing product %s image: %v\n", productID, err)
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
 	}
 
-	time.Sleep(2 * time.Second)
-	tmpl.ExecuteTemplate(w, "allProducts", nil)
+	product, err := h.Repo.Product.GetProductByID(r.Context(), productID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	err = h.Repo.Product.RestoreProduct(r.Context(), productID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordProductChange(r, auditActionRestore, product)
+
+	sendArchivedProducts(w, product.ProductName+" was restored", "success")
+}
+
+// PurgeProduct permanently deletes an archived product and its image.
+// Products that were ordered are kept so past orders stay intact.
+func (h Handler) PurgeProduct(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+
+	product, err := h.Repo.Product.GetProductByID(r.Context(), productID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if !product.Archived() {
+		http.Error(w, "Only archived products can be deleted permanently", http.StatusConflict)
+		return
+	}
+
+	err = h.Repo.Product.DeleteProduct(r.Context(), productID)
+	if errors.Is(err, repository.ErrProductInUse) {
+		sendArchivedProducts(w, product.ProductName+" is part of past orders and cannot be deleted permanently", "danger")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionPurge, auditEntityProduct, productID.String(), product, nil)
+
+	// Remove product image
+	if product.ProductImage != "" && product.ProductImage != placeholderImage {
+		productImagePath := filepath.Join(h.Config.Storage.UploadDir, product.ProductImage)
+		err = os.Remove(productImagePath)
+		if err != nil {
+			log.Printf("Failed removing product %s image: %v\n", productID, err)
+		}
+	}
+
+	sendArchivedProducts(w, product.ProductName+" was deleted permanently", "success")
+}
+
+// errorStatus maps a repository error to a response status. Queries that ran
+// past their deadline are reported as a gateway timeout.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, repository.ErrNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, repository.ErrProductInUse), errors.Is(err, repository.ErrVersionConflict),
+		errors.Is(err, repository.ErrOutOfStock), errors.Is(err, repository.ErrReturnStatus),
+		errors.Is(err, repository.ErrJobStatus):
+		return http.StatusConflict
+	case errors.Is(err, repository.ErrReturnQuantity):
+		return http.StatusBadRequest
+	case errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusInternalServerError
+	}
 }
 
 func makeRange(min, max int) []int {
@@ -364,7 +587,107 @@ func makeRange(min, max int) []int {
 	return rangeArray
 }
 
+// datetimeLocalLayout is the value format of <input type="datetime-local">.
+const datetimeLocalLayout = "2006-01-02T15:04"
+
+func formatDatetimeLocal(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Local().Format(datetimeLocalLayout)
+}
+
+// parsePublishing reads the status and the optional publish window from the
+// product form. The window is entered in the server's local time.
+func parsePublishing(r *http.Request) (models.ProductStatus, *time.Time, *time.Time, []string) {
+	var messages []string
+
+	status := models.ProductStatus(r.FormValue("status"))
+	if !status.Valid() {
+		messages = append(messages, "Invalid status")
+	}
+
+	parse := func(field, label string) *time.Time {
+		v := r.FormValue(field)
+		if v == "" {
+			return nil
+		}
+		t, err := time.ParseInLocation(datetimeLocalLayout, v, time.Local)
+		if err != nil {
+			messages = append(messages, "Invalid "+label)
+			return nil
+		}
+		return &t
+	}
+	publishAt := parse("publish_at", "publish date")
+	unpublishAt := parse("unpublish_at", "unpublish date")
+
+	if publishAt != nil && unpublishAt != nil && !unpublishAt.After(*publishAt) {
+		messages = append(messages, "Unpublish date must be after the publish date")
+	}
+
+	return status, publishAt, unpublishAt, messages
+}
+
+// parseTaxClass reads the tax class from the product form. Products are
+// taxed at the standard rate unless another class is picked.
+func parseTaxClass(r *http.Request) (models.TaxClass, bool) {
+	v := r.FormValue("tax_class")
+	if v == "" {
+		return models.TaxClassStandard, true
+	}
+	class := models.TaxClass(v)
+	return class, class.Valid()
+}
+
+// parseCategory reads the category from the product form. Products without
+// one are in no category.
+func parseCategory(r *http.Request) (string, bool) {
+	category := models.NormalizeCategory(r.FormValue("category"))
+	return category, len(category) <= 100
+}
+
+// parseWeight reads the weight in kilograms from the product form. Products
+// without one weigh nothing.
+func parseWeight(r *http.Request) (float64, bool) {
+	v := r.FormValue("weight")
+	if v == "" {
+		return 0, true
+	}
+	weight, err := strconv.ParseFloat(v, 64)
+	return weight, err == nil && weight >= 0
+}
+
+// parseStock reads the units on hand from the product form. Products without
+// a stock are not tracked.
+func parseStock(r *http.Request) (*int, bool) {
+	v := r.FormValue("stock")
+	if v == "" {
+		return nil, true
+	}
+	stock, err := strconv.Atoi(v)
+	return &stock, err == nil && stock >= 0
+}
+
 func sendProductMessages(w http.ResponseWriter, messages []string, product *models.Product) {
 	data := ProductCRUDTemplateData{Messages: messages, Product: product}
 	tmpl.ExecuteTemplate(w, "messages", data)
 }
+
+// sendProductConflict shows the changes another admin saved while the edit
+// form was open, next to the ones that were just submitted.
+func sendProductConflict(w http.ResponseWriter, submitted, current *models.Product) {
+	data := struct {
+		Submitted *models.Product
+		Current   *models.Product
+	}{Submitted: submitted, Current: current}
+	tmpl.ExecuteTemplate(w, "productConflict", data)
+}
+
+func sendArchivedProducts(w http.ResponseWriter, message, alertType string) {
+	data := struct {
+		Message   string
+		AlertType string
+	}{Message: message, AlertType: alertType}
+	tmpl.ExecuteTemplate(w, "archivedProducts", data)
+}
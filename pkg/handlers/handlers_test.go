@@ -0,0 +1,1184 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/email"
+	"github.com/snirkop89/mx-store/pkg/jobs"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/outbox"
+	"github.com/snirkop89/mx-store/pkg/payments"
+	"github.com/snirkop89/mx-store/pkg/repository"
+	"github.com/snirkop89/mx-store/pkg/sse"
+	"github.com/snirkop89/mx-store/pkg/webhooks"
+)
+
+func newTestHandler(t *testing.T) *Handler {
+	t.Helper()
+	cfg := config.Default()
+	cfg.Templates.Dir = "../../templates"
+	cfg.Storage.UploadDir = t.TempDir()
+	cfg.Jobs.PollInterval = 10 * time.Millisecond
+	repo := repository.NewMemoryRepository()
+	queue := jobs.New(repo.Job, cfg.Jobs)
+	mailer, err := email.New(cfg.Email, "../../templates/email", queue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewHandler(repo, cfg, payments.NewFake("whsec_test"), mailer, queue, webhooks.New(repo.Webhook, queue), sse.NewHub(cfg.Events))
+}
+
+// relayEvents publishes the events in the outbox of h to the webhooks, which
+// queues their deliveries.
+func relayEvents(t *testing.T, h *Handler) {
+	t.Helper()
+	relay := outbox.New(h.Repo.Outbox, config.Default().Outbox)
+	relay.Add("webhooks", webhooks.NewSink(h.Webhooks, h.Repo))
+	if _, err := relay.PublishDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// runJobs runs the jobs h queues until the test ends.
+func runJobs(t *testing.T, h *Handler) {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		h.Jobs.Run(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		<-done
+	})
+}
+
+func TestCreateProductUploadTooLarge(t *testing.T) {
+	h := newTestHandler(t)
+	h.Config.Storage.MaxUploadSize = 1 << 10
+
+	var body bytes.Buffer
+	form := multipart.NewWriter(&body)
+	form.WriteField("product_name", "Lamp")
+	form.WriteField("price", "10")
+	form.WriteField("description", "A lamp")
+	image, err := form.CreateFormFile("product_image", "lamp.jpeg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	image.Write(bytes.Repeat([]byte{0xff}, 2<<10))
+	form.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/products", &body)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	rec := httptest.NewRecorder()
+	h.CreateProduct(rec, req)
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusRequestEntityTooLarge)
+	}
+	products, err := h.Repo.Product.GetProducts(context.Background(), repository.ProductFilter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(products) != 0 {
+		t.Errorf("created %d products, want none", len(products))
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/products", strings.NewReader("product_name=Lamp"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec = httptest.NewRecorder()
+	h.CreateProduct(rec, req)
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("status of a form that is not multipart = %d, want %d", rec.Code, http.StatusBadRequest)
+	}
+}
+
+func TestListProducts(t *testing.T) {
+	h := newTestHandler(t)
+	product := models.Product{ProductName: "Test Laptop", Price: 10, Description: "A laptop"}
+	if err := h.Repo.Product.CreateProduct(context.Background(), &product); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ListProducts(rec, httptest.NewRequest(http.MethodGet, "/products", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	if !strings.Contains(rec.Body.String(), "Test Laptop") {
+		t.Fatalf("response does not list the product:\n%s", rec.Body.String())
+	}
+}
+
+func TestGetProductNotFound(t *testing.T) {
+	h := newTestHandler(t)
+	r := mux.NewRouter()
+	r.HandleFunc("/products/{id}", h.GetProduct)
+
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/products/7c9e6679-7425-40de-944b-e07fc1f90ae7", nil))
+
+	if rec.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", rec.Code, http.StatusNotFound)
+	}
+}
+
+func TestUpdateProductConflict(t *testing.T) {
+	h := newTestHandler(t)
+	product := models.Product{ProductName: "Test Laptop", Price: 10, Description: "A laptop"}
+	if err := h.Repo.Product.CreateProduct(context.Background(), &product); err != nil {
+		t.Fatal(err)
+	}
+	other := product
+	other.ProductName = "Renamed Laptop"
+	if err := h.Repo.Product.UpdateProduct(context.Background(), &other); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/products/{id}", h.UpdateProduct)
+	form := url.Values{
+		"product_name": {"My Laptop"},
+		"price":        {"12"},
+		"description":  {"A laptop"},
+		"status":       {"published"},
+		"version":      {strconv.Itoa(product.Version)},
+	}
+	req := httptest.NewRequest(http.MethodPut, "/products/"+product.ProductID.String(), strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, req)
+
+	body := rec.Body.String()
+	if !strings.Contains(body, "changed by someone else") || !strings.Contains(body, "Renamed Laptop") {
+		t.Fatalf("response does not show the conflict:\n%s", body)
+	}
+	got, err := h.Repo.Product.GetProductByID(context.Background(), product.ProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.ProductName != "Renamed Laptop" {
+		t.Fatalf("ProductName = %q, stale update overwrote it", got.ProductName)
+	}
+}
+
+func TestSchedulePrice(t *testing.T) {
+	h := newTestHandler(t)
+	product := models.Product{ProductName: "Test Laptop", Price: 10, Description: "A laptop"}
+	if err := h.Repo.Product.CreateProduct(context.Background(), &product); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/products/{id}/prices", h.SchedulePrice)
+	schedule := func(price string, at time.Time) string {
+		form := url.Values{"price": {price}, "effective_at": {at.Format(datetimeLocalLayout)}}
+		req := httptest.NewRequest(http.MethodPost, "/products/"+product.ProductID.String()+"/prices", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	if body := schedule("8", time.Now().Add(-time.Hour)); !strings.Contains(body, "in the future") {
+		t.Fatalf("scheduling a past price was not rejected:\n%s", body)
+	}
+	if body := schedule("8", time.Now().Add(time.Hour)); !strings.Contains(body, "$8.00") {
+		t.Fatalf("response does not list the scheduled price:\n%s", body)
+	}
+
+	prices, err := h.Repo.Price.ListPrices(context.Background(), product.ProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prices) != 2 || !prices[1].Scheduled() {
+		t.Fatalf("prices = %+v, want the initial price and one scheduled", prices)
+	}
+}
+
+func TestCouponCheckout(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	product := models.Product{ProductName: "Test Laptop", Price: 50, Description: "A laptop", ProductImage: "laptop.jpeg", Category: "Computers"}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	coupon := models.Coupon{Code: "TEN", DiscountType: models.DiscountPercent, Amount: 10, Categories: []string{"computers"}, MaxUsesPerCustomer: 1, Active: true}
+	if err := h.Repo.Coupon.CreateCoupon(ctx, &coupon); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/cartcoupon", h.ApplyCoupon)
+	r.HandleFunc("/placeorder", h.PlaceOrder)
+	do := func(path string, form url.Values) string {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	do("/addtocart/"+product.ProductID.String(), nil)
+	if body := do("/cartcoupon", url.Values{"coupon_code": {"ten"}}); !strings.Contains(body, "$45.00") {
+		t.Fatalf("cart does not show the discounted total:\n%s", body)
+	}
+	checkout(t, h, url.Values{})
+	if body := do("/placeorder", url.Values{"payment_method": {"pm_card_visa"}}); !strings.Contains(body, "Thank you") {
+		t.Fatalf("order was not placed:\n%s", body)
+	}
+
+	orders, err := h.Repo.Order.ListOrders(ctx, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].CouponCode != "TEN" || orders[0].Discount != 5 {
+		t.Fatalf("orders = %+v, want one order with a $5 TEN discount", orders)
+	}
+
+	// The coupon can be used once per customer
+	do("/addtocart/"+product.ProductID.String(), nil)
+	if body := do("/cartcoupon", url.Values{"coupon_code": {"TEN"}}); !strings.Contains(body, "already used") {
+		t.Fatalf("coupon was applied twice:\n%s", body)
+	}
+}
+
+func TestPromotionCheckout(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	product := models.Product{ProductName: "Test Mug", Price: 10, Description: "A mug", ProductImage: "mug.jpeg"}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	promotion := models.Promotion{Name: "Mugs 3 for 2", Kind: models.PromotionBuyXGetY, BuyQuantity: 2, FreeQuantity: 1, Active: true}
+	if err := h.Repo.Promotion.CreatePromotion(ctx, &promotion); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/updateorderitem", h.UpdateOrderItemQuantity)
+	r.HandleFunc("/placeorder", h.PlaceOrder)
+	do := func(method, path string) string {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Body.String()
+	}
+
+	do(http.MethodPost, "/addtocart/"+product.ProductID.String())
+	do(http.MethodPut, "/updateorderitem?action=add&product_id="+product.ProductID.String())
+	body := do(http.MethodPut, "/updateorderitem?action=add&product_id="+product.ProductID.String())
+	if !strings.Contains(body, "Mugs 3 for 2") || !strings.Contains(body, "$20.00") {
+		t.Fatalf("cart does not show the promotion:\n%s", body)
+	}
+	checkout(t, h, url.Values{})
+	if body := do(http.MethodPost, "/placeorder?payment_method=pm_card_visa"); !strings.Contains(body, "Thank you") {
+		t.Fatalf("order was not placed:\n%s", body)
+	}
+
+	orders, err := h.Repo.Order.ListOrders(ctx, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("orders = %+v, want one", orders)
+	}
+	order, err := h.Repo.Order.GetOrderWithProducts(ctx, orders[0].OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.Total() != 20 || len(order.Promotions) != 1 || order.Promotions[0].Discount != 10 {
+		t.Fatalf("order = %+v, want $20 with a $10 promotion", order)
+	}
+}
+
+func TestTaxCheckout(t *testing.T) {
+	h := newTestHandler(t)
+	h.Config.Tax.Country, h.Config.Tax.State = "US", "NY"
+	ctx := context.Background()
+
+	product := models.Product{ProductName: "Test Lamp", Price: 50, Description: "A lamp", ProductImage: "lamp.jpeg"}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	rate := models.TaxRate{Country: "US", State: "NY", TaxClass: models.TaxClassStandard, Name: "Sales tax", Rate: 10}
+	if err := h.Repo.Tax.SaveTaxRate(ctx, &rate); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/placeorder", h.PlaceOrder)
+	do := func(path string) string {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, nil))
+		return rec.Body.String()
+	}
+
+	body := do("/addtocart/" + product.ProductID.String())
+	if !strings.Contains(body, "Sales tax 10%") || !strings.Contains(body, "$55.00") {
+		t.Fatalf("cart does not show the tax:\n%s", body)
+	}
+	checkout(t, h, url.Values{"shipping_state": {"NY"}})
+	if body := do("/placeorder?payment_method=pm_card_visa"); !strings.Contains(body, "$55.00") {
+		t.Fatalf("order does not include the tax:\n%s", body)
+	}
+
+	orders, err := h.Repo.Order.ListOrders(ctx, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 || orders[0].Tax != 5 || orders[0].PricesIncludeTax {
+		t.Fatalf("orders = %+v, want one order with $5 tax on top", orders)
+	}
+}
+
+func TestShippingCheckout(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	product := models.Product{ProductName: "Test Desk", Price: 40, Description: "A desk", ProductImage: "desk.jpeg", Weight: 7.5}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	freeOver := 100.0
+	freight := models.ShippingMethod{Name: "Freight", Kind: models.ShippingWeight, Price: 5, PerKg: 2, FreeOver: &freeOver, Active: true}
+	if err := h.Repo.Shipping.CreateShippingMethod(ctx, &freight); err != nil {
+		t.Fatal(err)
+	}
+	rate := models.TaxRate{Country: "DE", TaxClass: models.TaxClassStandard, Name: "VAT", Rate: 10}
+	if err := h.Repo.Tax.SaveTaxRate(ctx, &rate); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/checkout/address", h.SaveCheckoutAddress)
+	r.HandleFunc("/checkout/shipping", h.SelectShippingMethod)
+	r.HandleFunc("/placeorder", h.PlaceOrder)
+	do := func(path string, form url.Values) string {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	do("/addtocart/"+product.ProductID.String(), nil)
+	if body := do("/placeorder", nil); !strings.Contains(body, "at checkout") {
+		t.Fatalf("order was placed without checkout:\n%s", body)
+	}
+
+	body := do("/checkout/address", url.Values{"shipping_address": {"new"}, "shipping_name": {"Erika"}, "shipping_country": {"Germany"}, "billing_same": {"true"}})
+	for _, want := range []string{"Address is required", "Postal code is required", "Country must be a two letter code", "Erika"} {
+		if !strings.Contains(body, want) {
+			t.Fatalf("address step does not show %q:\n%s", want, body)
+		}
+	}
+
+	address := url.Values{
+		"shipping_address":     {"new"},
+		"shipping_name":        {"Erika Mustermann"},
+		"shipping_line1":       {"Heidestrasse 17"},
+		"shipping_city":        {"Koeln"},
+		"shipping_postal_code": {"51147"},
+		"shipping_country":     {"de"},
+		"billing_address":      {"new"},
+		"billing_name":         {"Mustermann GmbH"},
+		"billing_line1":        {"Hauptstrasse 1"},
+		"billing_city":         {"Berlin"},
+		"billing_postal_code":  {"10115"},
+		"billing_country":      {"DE"},
+	}
+	if body := do("/checkout/address", address); !strings.Contains(body, "Freight") || !strings.Contains(body, "$20.00") {
+		t.Fatalf("shipping step does not quote 5 + 7.5kg * 2:\n%s", body)
+	}
+	body = do("/checkout/shipping", url.Values{"shipping_method_id": {strconv.FormatInt(freight.ShippingMethodID, 10)}})
+	if !strings.Contains(body, "VAT 10%") || !strings.Contains(body, "$64.00") {
+		t.Fatalf("review does not show $40 + $20 shipping + $4 VAT:\n%s", body)
+	}
+	if body := do("/placeorder", url.Values{"payment_method": {"pm_card_visa"}}); !strings.Contains(body, "Thank you") || !strings.Contains(body, "Mustermann GmbH") {
+		t.Fatalf("order was not placed:\n%s", body)
+	}
+
+	orders, err := h.Repo.Order.ListOrders(ctx, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(orders) != 1 {
+		t.Fatalf("orders = %+v, want one", orders)
+	}
+	order, err := h.Repo.Order.GetOrderWithProducts(ctx, orders[0].OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if order.ShippingMethod != "Freight" || order.ShippingCost != 20 || order.Tax != 4 || order.Total() != 64 {
+		t.Fatalf("order = %+v, want $20 Freight and $4 tax", order)
+	}
+	if order.ShippingAddress == nil || order.ShippingAddress.City != "Koeln" || order.BillingAddress == nil || order.BillingAddress.City != "Berlin" {
+		t.Fatalf("addresses = %+v, %+v, want Koeln and Berlin", order.ShippingAddress, order.BillingAddress)
+	}
+
+	// Both new addresses went into the address book
+	addresses, err := h.Repo.Address.ListAddresses(ctx, cartUserID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(addresses) != 2 {
+		t.Fatalf("address book = %+v, want the two addresses", addresses)
+	}
+}
+
+// TestConcurrentCheckout goes through the checkout from several requests at
+// once, for the race detector to check that they share the cart safely.
+func TestConcurrentCheckout(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	product := models.Product{ProductName: "Test Lamp", Price: 20, Description: "A lamp", ProductImage: "lamp.jpeg"}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	standard := models.ShippingMethod{Name: "Standard", Kind: models.ShippingFlat, Price: 5, Active: true}
+	if err := h.Repo.Shipping.CreateShippingMethod(ctx, &standard); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/cart", h.CartView)
+	r.HandleFunc("/checkout/address", h.SaveCheckoutAddress)
+	r.HandleFunc("/checkout/shipping", h.SelectShippingMethod)
+	do := func(path string, form url.Values) {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	address := url.Values{
+		"shipping_address":     {"new"},
+		"shipping_name":        {"Erika Mustermann"},
+		"shipping_line1":       {"Heidestrasse 17"},
+		"shipping_city":        {"Koeln"},
+		"shipping_postal_code": {"51147"},
+		"shipping_country":     {"DE"},
+		"billing_same":         {"true"},
+	}
+
+	var wg sync.WaitGroup
+	for range 16 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			do("/addtocart/"+product.ProductID.String(), nil)
+			do("/checkout/address", address)
+			do("/checkout/shipping", url.Values{"shipping_method_id": {strconv.FormatInt(standard.ShippingMethodID, 10)}})
+			do("/cart", nil)
+		}()
+	}
+	wg.Wait()
+
+	if len(h.cart.items) != 1 || h.cart.shippingAddress == nil || h.cart.shippingMethod == nil {
+		t.Fatalf("cart = %+v, want the lamp shipped with Standard", h.cart)
+	}
+}
+
+func TestPaymentCheckout(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	product := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair", ProductImage: "chair.jpeg"}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	coupon := models.Coupon{Code: "ONCE", DiscountType: models.DiscountFixed, Amount: 5, MaxUses: 1, Active: true}
+	if err := h.Repo.Coupon.CreateCoupon(ctx, &coupon); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/cartcoupon", h.ApplyCoupon)
+	r.HandleFunc("/placeorder", h.PlaceOrder)
+	do := func(path string, form url.Values) string {
+		req := httptest.NewRequest(http.MethodPost, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	lastOrder := func() (*models.Order, []models.Payment) {
+		t.Helper()
+		orders, err := h.Repo.Order.ListOrders(ctx, 1, 0)
+		if err != nil || len(orders) != 1 {
+			t.Fatalf("ListOrders = %+v, %v, want an order", orders, err)
+		}
+		list, err := h.Repo.Payment.ListPayments(ctx, orders[0].OrderID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &orders[0], list
+	}
+
+	do("/addtocart/"+product.ProductID.String(), nil)
+	do("/cartcoupon", url.Values{"coupon_code": {"ONCE"}})
+	checkout(t, h, url.Values{})
+	if body := do("/placeorder", url.Values{"payment_method": {"pm_card_chargeDeclined"}}); !strings.Contains(body, "Your card was declined.") {
+		t.Fatalf("declined payment is not shown:\n%s", body)
+	}
+	order, list := lastOrder()
+	if order.OrderStatus != models.OrderStatusPaymentFailed || len(list) != 1 || list[0].Status != models.PaymentFailed || list[0].Amount != 25 {
+		t.Fatalf("order = %+v with payments %+v, want a failed $25 payment", order, list)
+	}
+
+	// The declined order gave the coupon back, and the cart is kept to pay
+	// with another card
+	if body := do("/placeorder", url.Values{"payment_method": {"pm_card_visa"}}); !strings.Contains(body, "Thank you") || !strings.Contains(body, "payment was received") {
+		t.Fatalf("order was not placed:\n%s", body)
+	}
+	order, list = lastOrder()
+	if order.OrderStatus != models.OrderStatusPaid || order.CouponCode != "ONCE" || len(list) != 1 || list[0].Status != models.PaymentCaptured || list[0].CapturedAmount != 25 {
+		t.Fatalf("order = %+v with payments %+v, want a captured $25 payment", order, list)
+	}
+
+	h.Config.Payments.AutoCapture = false
+	do("/addtocart/"+product.ProductID.String(), nil)
+	checkout(t, h, url.Values{})
+	if body := do("/placeorder", url.Values{"payment_method": {"pm_card_mastercard"}}); !strings.Contains(body, "authorized") {
+		t.Fatalf("order was not placed:\n%s", body)
+	}
+	order, list = lastOrder()
+	if order.OrderStatus != models.OrderStatusOrdered || len(list) != 1 || list[0].Status != models.PaymentAuthorized || list[0].ProviderRef == "" {
+		t.Fatalf("order = %+v with payments %+v, want an authorized payment", order, list)
+	}
+}
+
+func TestOrderEmails(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	h.Mail.AdminAddress = "admin@example.com"
+	runJobs(t, h)
+	catcher, ok := h.catcher()
+	if !ok {
+		t.Fatal("test handler does not catch emails")
+	}
+
+	product := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair", ProductImage: "chair.jpeg"}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/placeorder", h.PlaceOrder)
+	r.HandleFunc("/devmail/messages", h.ListDevMail).Methods("GET")
+	r.HandleFunc("/devmail/messages/{id}", h.DevMailMessage)
+	r.HandleFunc("/devmail/samples", h.SendSampleMail)
+	do := func(method, path string, form url.Values) string {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	waitForEmails := func(n int) []email.Message {
+		t.Helper()
+		for range 100 {
+			if emails := catcher.Messages(); len(emails) >= n {
+				return emails
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("caught %d emails, want %d", len(catcher.Messages()), n)
+		return nil
+	}
+
+	do(http.MethodPost, "/addtocart/"+product.ProductID.String(), nil)
+	checkout(t, h, url.Values{})
+	if body := do(http.MethodPost, "/placeorder", url.Values{"payment_method": {"pm_card_chargeDeclined"}}); !strings.Contains(body, "declined") {
+		t.Fatalf("declined payment is not shown:\n%s", body)
+	}
+	if body := do(http.MethodPost, "/placeorder", url.Values{"payment_method": {"pm_card_visa"}}); !strings.Contains(body, "Thank you") {
+		t.Fatalf("order was not placed:\n%s", body)
+	}
+
+	// Only the order that was paid for is confirmed
+	emails := waitForEmails(2)
+	recipients := map[string]string{}
+	for _, msg := range emails {
+		recipients[msg.To[0]] = msg.Subject
+	}
+	if len(emails) != 2 || !strings.Contains(recipients[cartUserID], "is confirmed") || !strings.Contains(recipients["admin@example.com"], "New order") {
+		t.Fatalf("emails = %v, want a confirmation and an alert", recipients)
+	}
+	confirmation := emails[0]
+	if confirmation.To[0] != cartUserID {
+		confirmation = emails[1]
+	}
+	if !strings.Contains(confirmation.Text, "Test Chair") || !strings.Contains(confirmation.HTML, "Test Chair") {
+		t.Fatalf("confirmation does not list the order:\n%s", confirmation.Text)
+	}
+
+	if body := do(http.MethodGet, "/devmail/messages", nil); !strings.Contains(body, confirmation.Subject) {
+		t.Fatalf("caught emails are not listed:\n%s", body)
+	}
+	if body := do(http.MethodGet, "/devmail/messages/"+confirmation.ID, nil); !strings.Contains(body, "srcdoc=") || !strings.Contains(body, "Thank you for your order!") {
+		t.Fatalf("caught email is not shown:\n%s", body)
+	}
+
+	if body := do(http.MethodPost, "/devmail/samples", url.Values{"kind": {"password_reset"}, "to": {"fk@htmxrocks.com"}}); !strings.Contains(body, "Sample queued") {
+		t.Fatalf("sample was not queued:\n%s", body)
+	}
+	if emails := waitForEmails(3); emails[0].Subject != "Reset your password" {
+		t.Fatalf("newest email = %q, want the password reset sample", emails[0].Subject)
+	}
+}
+
+func TestOutOfStockCheckout(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	stock := 1
+	product := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair", ProductImage: "chair.jpeg", Stock: &stock}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/updateorderitem", h.UpdateOrderItemQuantity)
+	r.HandleFunc("/placeorder", h.PlaceOrder)
+	do := func(method, path string, form url.Values) string {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	do(http.MethodPost, "/addtocart/"+product.ProductID.String(), nil)
+	do(http.MethodPut, "/updateorderitem?action=add&product_id="+product.ProductID.String(), nil)
+	checkout(t, h, url.Values{})
+	if body := do(http.MethodPost, "/placeorder", url.Values{"payment_method": {"pm_card_visa"}}); !strings.Contains(body, "Only 1 of Test Chair left in stock") {
+		t.Fatalf("order of more than in stock was placed:\n%s", body)
+	}
+	if count, _ := h.Repo.Order.GetTotalOrdersCount(ctx); count != 0 {
+		t.Fatalf("%d orders were placed, want none", count)
+	}
+
+	do(http.MethodPut, "/updateorderitem?action=subtract&product_id="+product.ProductID.String(), nil)
+	if body := do(http.MethodPost, "/placeorder", url.Values{"payment_method": {"pm_card_visa"}}); !strings.Contains(body, "Thank you") {
+		t.Fatalf("order was not placed:\n%s", body)
+	}
+	got, err := h.Repo.Product.GetProductByID(ctx, product.ProductID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *got.Stock != 0 {
+		t.Errorf("stock = %d, want 0", *got.Stock)
+	}
+}
+
+func TestReturns(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	stock := 5
+	product := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair", ProductImage: "chair.jpeg", Stock: &stock}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/placeorder", h.PlaceOrder)
+	r.HandleFunc("/myorders", h.MyOrdersView)
+	r.HandleFunc("/myorders/{id}/returns", h.RequestReturn)
+	r.HandleFunc("/returns/{id}/approve", h.ApproveReturn)
+	r.HandleFunc("/returns/{id}/reject", h.RejectReturn)
+	r.HandleFunc("/returns/{id}/receive", h.ReceiveReturn)
+	r.HandleFunc("/updateorderitem", h.UpdateOrderItemQuantity)
+	do := func(method, path string, form url.Values) string {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+	assertOrder := func(orderID uuid.UUID, status string, refunded float64, stock int) {
+		t.Helper()
+		order, err := h.Repo.Order.GetOrderWithProducts(ctx, orderID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.OrderStatus != status || order.RefundedAmount != refunded {
+			t.Errorf("order is %s with $%.2f refunded, want %s with $%.2f", order.OrderStatus, order.RefundedAmount, status, refunded)
+		}
+		got, err := h.Repo.Product.GetProductByID(ctx, product.ProductID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *got.Stock != stock {
+			t.Errorf("stock = %d, want %d", *got.Stock, stock)
+		}
+	}
+
+	do(http.MethodPost, "/addtocart/"+product.ProductID.String(), nil)
+	do(http.MethodPut, "/updateorderitem?action=add&product_id="+product.ProductID.String(), nil)
+	checkout(t, h, url.Values{})
+	if body := do(http.MethodPost, "/placeorder", url.Values{"payment_method": {"pm_card_visa"}}); !strings.Contains(body, "Thank you") {
+		t.Fatalf("order was not placed:\n%s", body)
+	}
+	orders, err := h.Repo.Order.ListUserOrders(ctx, cartUserID)
+	if err != nil || len(orders) != 1 {
+		t.Fatalf("ListUserOrders = %+v, %v, want the order", orders, err)
+	}
+	orderID := orders[0].OrderID
+	assertOrder(orderID, models.OrderStatusPaid, 0, 3)
+
+	if body := do(http.MethodGet, "/myorders", nil); !strings.Contains(body, "Return items") {
+		t.Fatalf("paid order cannot be returned:\n%s", body)
+	}
+	quantity := "quantity_" + product.ProductID.String()
+	returnPath := "/myorders/" + orderID.String() + "/returns"
+	if body := do(http.MethodPost, returnPath, url.Values{quantity: {"3"}, "reason": {"Wobbly"}}); !strings.Contains(body, "more items than can be returned") {
+		t.Fatalf("returning more than ordered was accepted:\n%s", body)
+	}
+	if body := do(http.MethodPost, returnPath, url.Values{quantity: {"1"}}); !strings.Contains(body, "Tell us why") {
+		t.Fatalf("return without a reason was accepted:\n%s", body)
+	}
+
+	// The first return is rejected, the second refunded in part
+	for _, id := range []string{"1", "2"} {
+		if body := do(http.MethodPost, returnPath, url.Values{quantity: {"1"}, "reason": {"Wobbly"}}); !strings.Contains(body, "Return #"+id+" was requested") {
+			t.Fatalf("return was not requested:\n%s", body)
+		}
+	}
+	if body := do(http.MethodPost, returnPath, url.Values{quantity: {"1"}, "reason": {"Wobbly"}}); !strings.Contains(body, "more items than can be returned") {
+		t.Fatalf("returning an item twice was accepted:\n%s", body)
+	}
+	if body := do(http.MethodPut, "/returns/1/reject", url.Values{"note": {"Used"}}); !strings.Contains(body, "Return #1 rejected") {
+		t.Fatalf("return was not rejected:\n%s", body)
+	}
+	if body := do(http.MethodPut, "/returns/1/approve", nil); !strings.Contains(body, "Return #1 is already rejected") {
+		t.Fatalf("rejected return was approved:\n%s", body)
+	}
+	if body := do(http.MethodPut, "/returns/2/receive", url.Values{"refund_amount": {"30"}}); !strings.Contains(body, "only approved returns") {
+		t.Fatalf("requested return was received:\n%s", body)
+	}
+	do(http.MethodPut, "/returns/2/approve", nil)
+	if body := do(http.MethodPut, "/returns/2/receive", url.Values{"refund_amount": {"25"}}); !strings.Contains(body, "Return #2 refunded $25.00") {
+		t.Fatalf("return was not refunded:\n%s", body)
+	}
+	assertOrder(orderID, models.OrderStatusPartiallyRefunded, 25, 4)
+
+	// No more than the items were paid is refunded, and a failed refund
+	// can be made again
+	do(http.MethodPost, returnPath, url.Values{quantity: {"1"}, "reason": {"Wobbly"}})
+	do(http.MethodPut, "/returns/3/approve", nil)
+	if body := do(http.MethodPut, "/returns/3/receive", url.Values{"refund_amount": {"35"}}); !strings.Contains(body, "Refund amount must be between $0.00 and $30.00") {
+		t.Fatalf("refund of more than the items were paid was accepted:\n%s", body)
+	}
+	provider := &failingRefunds{Provider: h.Payments, fail: true}
+	h.Payments = provider
+	if body := do(http.MethodPut, "/returns/3/receive", url.Values{"refund_amount": {"30"}}); !strings.Contains(body, "refund failed") {
+		t.Fatalf("failed refund was not reported:\n%s", body)
+	}
+	provider.fail = false
+	r.HandleFunc("/returns/{id}/refund", h.RefundReturn)
+	if body := do(http.MethodPut, "/returns/3/refund", url.Values{"refund_amount": {"30"}}); !strings.Contains(body, "Return #3 refunded $30.00") {
+		t.Fatalf("return was not refunded:\n%s", body)
+	}
+	if body := do(http.MethodPut, "/returns/3/refund", url.Values{"refund_amount": {"30"}}); !strings.Contains(body, "only received returns can be refunded") {
+		t.Fatalf("return was refunded twice:\n%s", body)
+	}
+	assertOrder(orderID, models.OrderStatusPartiallyRefunded, 55, 5)
+
+	payments, err := h.Repo.Payment.ListPayments(ctx, orderID)
+	if err != nil || len(payments) != 1 {
+		t.Fatalf("ListPayments = %+v, %v, want the payment", payments, err)
+	}
+	if payments[0].Status != models.PaymentCaptured || payments[0].RefundedAmount != 55 {
+		t.Errorf("payment = %+v, want $55.00 of it refunded", payments[0])
+	}
+	if body := do(http.MethodGet, "/myorders", nil); strings.Contains(body, "Return items") || !strings.Contains(body, "$55.00 refunded") {
+		t.Errorf("returned order is not shown as refunded:\n%s", body)
+	}
+}
+
+// failingRefunds is a payment provider whose refunds fail while fail is set.
+type failingRefunds struct {
+	payments.Provider
+	fail bool
+}
+
+func (p *failingRefunds) Refund(ctx context.Context, ref string, amount float64, key string) (string, error) {
+	if p.fail {
+		return "", errors.New("refunds are down")
+	}
+	return p.Provider.Refund(ctx, ref, amount, key)
+}
+
+func TestPaymentWebhook(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	fake := h.Payments.(*payments.Fake)
+
+	product := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair"}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	order := models.Order{UserID: cartUserID, OrderStatus: models.OrderStatusOrdered, Items: []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 30}}}
+	if err := h.Repo.Order.PlaceOrder(ctx, &order); err != nil {
+		t.Fatal(err)
+	}
+	payment := models.Payment{OrderID: order.OrderID, Provider: "fake", ProviderRef: "fake_pi_1", Amount: 30, Currency: "usd", Status: models.PaymentAuthorized}
+	if err := h.Repo.Payment.CreatePayment(ctx, &payment); err != nil {
+		t.Fatal(err)
+	}
+	sub := models.WebhookSubscription{URL: "https://erp.example/hooks", Events: []models.WebhookEvent{models.WebhookOrderStatusChanged}, Active: true}
+	if err := h.Repo.Webhook.CreateWebhookSubscription(ctx, &sub); err != nil {
+		t.Fatal(err)
+	}
+
+	post := func(event payments.Event, secret string) int {
+		payload, header, err := fake.SignEvent(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if secret != "" {
+			header.Set(payments.FakeSignatureHeader, payments.Sign(secret, payload, time.Now()))
+		}
+		req := httptest.NewRequest(http.MethodPost, "/webhooks/payments", strings.NewReader(string(payload)))
+		req.Header = header
+		rec := httptest.NewRecorder()
+		h.PaymentWebhook(rec, req)
+		return rec.Code
+	}
+
+	captured := payments.Event{ID: "evt_1", Type: payments.EventCaptured, PaymentRef: "fake_pi_1", Amount: 30}
+	if code := post(captured, "forged"); code != http.StatusBadRequest {
+		t.Fatalf("forged webhook status = %d, want %d", code, http.StatusBadRequest)
+	}
+	if code := post(payments.Event{ID: "evt_2", Type: payments.EventCaptured, PaymentRef: "fake_pi_9", Amount: 30}, ""); code != http.StatusInternalServerError {
+		t.Fatalf("webhook for a missing payment status = %d, want %d so it is sent again", code, http.StatusInternalServerError)
+	}
+	for range 2 {
+		if code := post(captured, ""); code != http.StatusOK {
+			t.Fatalf("webhook status = %d, want %d", code, http.StatusOK)
+		}
+	}
+
+	got, err := h.Repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.OrderStatus != models.OrderStatusPaid {
+		t.Fatalf("OrderStatus = %q, want %q", got.OrderStatus, models.OrderStatusPaid)
+	}
+	event, err := h.Repo.Payment.GetPaymentEvent(ctx, "fake", "evt_1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Status != models.PaymentEventProcessed || event.Attempts != 1 {
+		t.Fatalf("event = %+v, want processed once", event)
+	}
+	relayEvents(t, h)
+	deliveries, err := h.Repo.Webhook.ListWebhookDeliveries(ctx, sub.SubscriptionID, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || !strings.Contains(string(deliveries[0].Payload), `"status":"paid","previous_status":"ordered"`) {
+		t.Fatalf("deliveries = %+v, want one status change from ordered to paid", deliveries)
+	}
+}
+
+// checkout takes the cart through the address and shipping steps, shipping
+// for free to a new US address. Fields in address replace the defaults.
+func checkout(t *testing.T, h *Handler, address url.Values) {
+	t.Helper()
+	method := models.ShippingMethod{Name: "Pickup", Kind: models.ShippingFlat, Active: true}
+	if err := h.Repo.Shipping.CreateShippingMethod(context.Background(), &method); err != nil {
+		t.Fatal(err)
+	}
+
+	form := url.Values{
+		"shipping_address":     {"new"},
+		"shipping_name":        {"Test Customer"},
+		"shipping_line1":       {"1 Main St"},
+		"shipping_city":        {"Springfield"},
+		"shipping_postal_code": {"12345"},
+		"shipping_country":     {"US"},
+		"billing_same":         {"true"},
+	}
+	for k, v := range address {
+		form[k] = v
+	}
+	post := func(handler http.HandlerFunc, form url.Values) string {
+		req := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec.Body.String()
+	}
+	if body := post(h.SaveCheckoutAddress, form); !strings.Contains(body, "Pickup") {
+		t.Fatalf("address was not accepted:\n%s", body)
+	}
+	body := post(h.SelectShippingMethod, url.Values{"shipping_method_id": {strconv.FormatInt(method.ShippingMethodID, 10)}})
+	if !strings.Contains(body, "Place") {
+		t.Fatalf("shipping method was not accepted:\n%s", body)
+	}
+}
+
+func TestRetryJob(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	job, err := h.Jobs.Enqueue(ctx, "unknown", nil, jobs.MaxAttempts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.Jobs.RunNext(ctx)
+
+	r := mux.NewRouter()
+	r.HandleFunc("/jobs", h.ListJobs).Methods("GET")
+	r.HandleFunc("/jobs/{id}/retry", h.RetryJob).Methods("PUT")
+	do := func(method, path string) (int, string) {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Code, rec.Body.String()
+	}
+
+	if _, body := do(http.MethodGet, "/jobs?status=dead"); !strings.Contains(body, "dead: 1") || !strings.Contains(body, "no handler for jobs") {
+		t.Fatalf("dead job is not listed:\n%s", body)
+	}
+	retry := "/jobs/" + strconv.FormatInt(job.JobID, 10) + "/retry"
+	if _, body := do(http.MethodPut, retry); !strings.Contains(body, "queued to run now") {
+		t.Fatalf("job was not retried:\n%s", body)
+	}
+	if got, err := h.Repo.Job.GetJob(ctx, job.JobID); err != nil || got.Status != models.JobQueued || got.Attempts != 0 {
+		t.Fatalf("retried job = %+v, %v, want queued with no attempts", got, err)
+	}
+	events, err := h.Repo.Audit.ListEvents(ctx, repository.AuditFilter{Action: auditActionRetry, EntityType: auditEntityJob}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EntityID != strconv.FormatInt(job.JobID, 10) || !strings.Contains(events[0].Before, `"dead"`) {
+		t.Fatalf("audit events = %+v, want the retry of the dead job", events)
+	}
+
+	h.Jobs.Handle("unknown", func(ctx context.Context, job *models.Job) error { return nil })
+	h.Jobs.RunNext(ctx)
+	if _, body := do(http.MethodPut, retry); !strings.Contains(body, "running or done already") {
+		t.Fatalf("succeeded job was retried:\n%s", body)
+	}
+	if code, _ := do(http.MethodPut, "/jobs/999/retry"); code != http.StatusNotFound {
+		t.Fatalf("retrying a missing job: status = %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestWebhookSubscriptions(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/webhooksubscriptions", h.CreateWebhookSubscription).Methods("POST")
+	r.HandleFunc("/webhooksubscriptions/{id}/active", h.SetWebhookSubscriptionActive).Methods("PUT")
+	r.HandleFunc("/webhookdeliveries", h.ListWebhookDeliveries).Methods("GET")
+	r.HandleFunc("/webhookdeliveries/{id}/redeliver", h.RedeliverWebhook).Methods("POST")
+	r.HandleFunc("/products/{id}", h.DeleteProduct).Methods("DELETE")
+	do := func(method, path string, form url.Values) (int, string) {
+		req := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code, rec.Body.String()
+	}
+
+	if _, body := do(http.MethodPost, "/webhooksubscriptions", url.Values{"url": {"ftp://erp.example"}}); !strings.Contains(body, "http or https URL") ||
+		!strings.Contains(body, "at least one event") {
+		t.Fatalf("invalid subscription was not rejected:\n%s", body)
+	}
+	form := url.Values{"url": {"https://erp.example/hooks"}, "events": {"product.deleted", "product.updated", "order.created"}}
+	if _, body := do(http.MethodPost, "/webhooksubscriptions", form); !strings.Contains(body, "created") || !strings.Contains(body, "whsec_") {
+		t.Fatalf("subscription was not created with a secret:\n%s", body)
+	}
+	subs, err := h.Repo.Webhook.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(subs) != 1 || !subs[0].Active || len(subs[0].Events) != 3 {
+		t.Fatalf("subscriptions = %+v, want the active one created", subs)
+	}
+
+	product := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair"}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	do(http.MethodDelete, "/products/"+product.ProductID.String(), nil)
+	relayEvents(t, h)
+	_, body := do(http.MethodGet, "/webhookdeliveries", nil)
+	if !strings.Contains(body, "product.deleted") || !strings.Contains(body, "pending") {
+		t.Fatalf("archiving the product queued no delivery:\n%s", body)
+	}
+	deliveries, err := h.Repo.Webhook.ListWebhookDeliveries(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || !strings.Contains(string(deliveries[0].Payload), product.ProductID.String()) {
+		t.Fatalf("deliveries = %+v, want one about the product", deliveries)
+	}
+
+	redeliver := "/webhookdeliveries/" + strconv.FormatInt(deliveries[0].DeliveryID, 10) + "/redeliver"
+	if _, body := do(http.MethodPost, redeliver, nil); !strings.Contains(body, "queued to be sent again") {
+		t.Fatalf("delivery was not sent again:\n%s", body)
+	}
+	events, err := h.Repo.Audit.ListEvents(ctx, repository.AuditFilter{Action: auditActionRedeliver, EntityType: auditEntityDelivery}, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].EntityID != strconv.FormatInt(deliveries[0].DeliveryID, 10) {
+		t.Fatalf("audit events = %+v, want the redelivery", events)
+	}
+	if code, _ := do(http.MethodPost, "/webhookdeliveries/999/redeliver", nil); code != http.StatusNotFound {
+		t.Fatalf("redelivering a missing delivery: status = %d, want %d", code, http.StatusNotFound)
+	}
+
+	path := "/webhooksubscriptions/" + strconv.FormatInt(subs[0].SubscriptionID, 10) + "/active"
+	do(http.MethodPut, path, url.Values{"active": {"false"}})
+	if err := h.Repo.Product.RestoreProduct(ctx, product.ProductID); err != nil {
+		t.Fatal(err)
+	}
+	relayEvents(t, h)
+	if deliveries, _ := h.Repo.Webhook.ListWebhookDeliveries(ctx, 0, 10); len(deliveries) != 1 {
+		t.Fatalf("%d deliveries, want none queued for a disabled subscription", len(deliveries))
+	}
+}
+
+func TestLiveEvents(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+	store := h.Live.Subscribe("", liveStore)
+	admin := h.Live.Subscribe("", liveAdmin)
+
+	stock := 1
+	product := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair", ProductImage: "chair.jpeg", Stock: &stock}
+	if err := h.Repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	r := mux.NewRouter()
+	r.HandleFunc("/addtocart/{product_id}", h.AddToCart)
+	r.HandleFunc("/cartitems", h.CartView)
+	do := func(method, path string) string {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(method, path, nil))
+		return rec.Body.String()
+	}
+	if body := do(http.MethodPost, "/addtocart/"+product.ProductID.String()); !strings.Contains(body, "sse:product-"+product.ProductID.String()) {
+		t.Fatalf("cart does not reload on changes to its product:\n%s", body)
+	}
+
+	// Another shopper buys the last one
+	order := models.Order{UserID: "someone@example.com", OrderStatus: models.OrderStatusOrdered, Items: []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 30}}}
+	if err := h.Repo.Order.PlaceOrder(ctx, &order); err != nil {
+		t.Fatal(err)
+	}
+	events, err := h.Repo.Outbox.ClaimOutboxEvents(ctx, time.Now(), time.Now().Add(time.Minute), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		if err := h.PublishLive(ctx, event); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	select {
+	case event := <-store.C:
+		if event.Name != "product-"+product.ProductID.String() || !strings.Contains(event.Data, "Out of stock") || strings.Contains(event.Data, "hx-post") {
+			t.Fatalf("store event = %+v, want the product out of stock", event)
+		}
+	default:
+		t.Fatal("the store was not told about the stock change")
+	}
+	select {
+	case event := <-admin.C:
+		if event.Name != "order" || !strings.Contains(event.Data, "New order") || !strings.Contains(event.Data, order.OrderID.String()) {
+			t.Fatalf("admin event = %+v, want the new order", event)
+		}
+	default:
+		t.Fatal("the admin was not told about the order")
+	}
+	if body := do(http.MethodGet, "/cartitems"); !strings.Contains(body, "Test Chair is out of stock") {
+		t.Fatalf("cart does not warn that its product sold out:\n%s", body)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	h := newTestHandler(t)
+	ctx := context.Background()
+
+	stock := 3
+	chair := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair", ProductImage: "chair.jpeg", Stock: &stock}
+	table := models.Product{ProductName: "Test Table", Price: 100, Description: "A table", ProductImage: "table.jpeg"}
+	for _, product := range []*models.Product{&chair, &table} {
+		if err := h.Repo.Product.CreateProduct(ctx, product); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, order := range []models.Order{
+		{UserID: "fk@htmxrocks.com", OrderStatus: models.OrderStatusPaid, ShippingCost: 5, Items: []models.OrderItem{{ProductID: chair.ProductID, Quantity: 2, Cost: 60}}},
+		{UserID: "fk@htmxrocks.com", OrderStatus: models.OrderStatusOrdered, Items: []models.OrderItem{{ProductID: table.ProductID, Quantity: 1, Cost: 100}}},
+		// Not paid for, so not a sale
+		{UserID: "fk@htmxrocks.com", OrderStatus: models.OrderStatusPendingPayment, Items: []models.OrderItem{{ProductID: table.ProductID, Quantity: 5, Cost: 500}}},
+	} {
+		if err := h.Repo.Order.PlaceOrder(ctx, &order); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	h.DashboardPage(rec, httptest.NewRequest(http.MethodGet, "/dashboard", nil))
+	if body := rec.Body.String(); !strings.Contains(body, `hx-get="/dashboardstats"`) || !strings.Contains(body, "every 30s") || !strings.Contains(body, "Last 90 days") {
+		t.Fatalf("dashboard page does not load its widgets:\n%s", body)
+	}
+
+	rec = httptest.NewRecorder()
+	h.DashboardStats(rec, httptest.NewRequest(http.MethodGet, "/dashboardstats?period=today", nil))
+	body := rec.Body.String()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d: %s", rec.Code, body)
+	}
+	for _, want := range []string{
+		"$165.00", // revenue
+		">2<",     // orders
+		"$82.50",  // average order value
+		"Daily Revenue, Today",
+		"<td>Test Table</td>",
+		">1</span>", // chairs left
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("widgets do not show %q:\n%s", want, body)
+		}
+	}
+	if strings.Index(body, "<td>Test Chair</td>") > strings.Index(body, "<td>Test Table</td>") {
+		t.Error("the chair, sold the most units of, is not the top product")
+	}
+}
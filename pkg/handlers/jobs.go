@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// jobListSize is how many of the latest jobs the jobs page shows.
+const jobListSize = 50
+
+func (h *Handler) JobsPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Statuses []models.JobStatus
+	}{
+		Statuses: models.JobStatuses,
+	}
+	tmpl.ExecuteTemplate(w, "jobs", data)
+}
+
+func (h *Handler) ListJobs(w http.ResponseWriter, r *http.Request) {
+	h.sendJobList(w, r, nil, "")
+}
+
+// RetryJob runs a dead job again, or a waiting job right away, with all its
+// attempts.
+func (h *Handler) RetryJob(w http.ResponseWriter, r *http.Request) {
+	jobID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid job ID", http.StatusBadRequest)
+		return
+	}
+	before, err := h.Repo.Job.GetJob(r.Context(), jobID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	err = h.Jobs.Requeue(r.Context(), jobID)
+	if err != nil && errorStatus(err) == http.StatusConflict {
+		h.sendJobList(w, r, []string{"Job #" + strconv.FormatInt(jobID, 10) + " is running or done already"}, "danger")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionRetry, auditEntityJob, strconv.FormatInt(jobID, 10),
+		map[string]any{"Status": before.Status, "Attempts": before.Attempts},
+		map[string]any{"Status": models.JobQueued, "Attempts": 0})
+	h.sendJobList(w, r, []string{"Job #" + strconv.FormatInt(jobID, 10) + " was queued to run now"}, "success")
+}
+
+func (h *Handler) sendJobList(w http.ResponseWriter, r *http.Request, messages []string, alertType string) {
+	status := models.JobStatus(r.FormValue("status"))
+	if !status.Valid() {
+		status = ""
+	}
+	list, err := h.Repo.Job.ListJobs(r.Context(), status, jobListSize, 0)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	counts, err := h.Repo.Job.CountJobs(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := struct {
+		Jobs      []models.Job
+		Statuses  []models.JobStatus
+		Counts    map[models.JobStatus]int
+		Messages  []string
+		AlertType string
+	}{
+		Jobs:      list,
+		Statuses:  models.JobStatuses,
+		Counts:    counts,
+		Messages:  messages,
+		AlertType: alertType,
+	}
+	tmpl.ExecuteTemplate(w, "jobList", data)
+}
@@ -0,0 +1,81 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+// Topics of the live events. Shoppers get the storefront's, admins the
+// admin's.
+const (
+	liveStore = "store"
+	liveAdmin = "admin"
+)
+
+// StoreEvents streams the price and stock changes of products to the
+// storefront.
+func (h *Handler) StoreEvents(w http.ResponseWriter, r *http.Request) {
+	h.Live.Serve(w, r, liveStore)
+}
+
+// AdminEvents streams order notifications to the admin pages.
+func (h *Handler) AdminEvents(w http.ResponseWriter, r *http.Request) {
+	h.Live.Serve(w, r, liveAdmin)
+}
+
+// PublishLive pushes what a domain event changed to the open pages. The
+// storefront is sent the product as it is now, named after it, which swaps
+// it into its card and refreshes carts holding it. The admin is told about
+// orders.
+func (h *Handler) PublishLive(ctx context.Context, event models.OutboxEvent) error {
+	switch event.Type {
+	case models.EventProductUpdated, models.EventStockChanged, models.EventProductArchived:
+		var data models.ProductEvent
+		if err := event.Decode(&data); err != nil {
+			return err
+		}
+		product, err := h.Repo.Product.GetProductByID(ctx, data.ProductID)
+		if errors.Is(err, repository.ErrNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, "productOffer", product); err != nil {
+			return err
+		}
+		h.Live.Publish(liveStore, "product-"+product.ProductID.String(), buf.String())
+
+	case models.EventOrderPlaced, models.EventOrderStatusChanged:
+		var data models.OrderEvent
+		if err := event.Decode(&data); err != nil {
+			return err
+		}
+		order, err := h.Repo.Order.GetOrderWithProducts(ctx, data.OrderID)
+		if err != nil {
+			return err
+		}
+		order.OrderStatus = data.Status
+		notification := struct {
+			Order          *models.Order
+			Placed         bool
+			PreviousStatus string
+		}{
+			Order:          order,
+			Placed:         event.Type == models.EventOrderPlaced,
+			PreviousStatus: data.PreviousStatus,
+		}
+		var buf bytes.Buffer
+		if err := tmpl.ExecuteTemplate(&buf, "orderNotification", notification); err != nil {
+			return err
+		}
+		h.Live.Publish(liveAdmin, "order", buf.String())
+	}
+	return nil
+}
@@ -0,0 +1,162 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/mail"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/email"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// mailSample is an email the dev mail page sends samples of.
+type mailSample struct {
+	Kind  string
+	Label string
+}
+
+var mailSamples = []mailSample{
+	{"order_confirmation", "Order confirmation"},
+	{"order_alert", "New order alert"},
+	{"shipping_update", "Shipping update"},
+	{"password_reset", "Password reset"},
+}
+
+// catcher returns the sender that keeps emails in memory, which is only
+// there in catcher mode.
+func (h *Handler) catcher() (*email.Catcher, bool) {
+	catcher, ok := h.Mail.Sender.(*email.Catcher)
+	return catcher, ok
+}
+
+// DevMailPage shows the emails caught in catcher mode and sends samples of
+// each email in every mode.
+func (h *Handler) DevMailPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Mode    string
+		Dir     string
+		Samples []mailSample
+		To      string
+	}{
+		Mode:    h.Config.Email.Mode,
+		Dir:     h.Config.Email.Dir,
+		Samples: mailSamples,
+		To:      cartUserID,
+	}
+	tmpl.ExecuteTemplate(w, "devMail", data)
+}
+
+func (h *Handler) ListDevMail(w http.ResponseWriter, r *http.Request) {
+	h.sendDevMailList(w, nil, "")
+}
+
+// DevMailMessage shows a caught email, the HTML version as the recipient
+// would see it.
+func (h *Handler) DevMailMessage(w http.ResponseWriter, r *http.Request) {
+	catcher, ok := h.catcher()
+	if !ok {
+		http.Error(w, "Emails are only kept in catcher mode", http.StatusNotFound)
+		return
+	}
+	msg, ok := catcher.Message(mux.Vars(r)["id"])
+	if !ok {
+		http.Error(w, "Email not found", http.StatusNotFound)
+		return
+	}
+	tmpl.ExecuteTemplate(w, "devMailMessage", msg)
+}
+
+func (h *Handler) ClearDevMail(w http.ResponseWriter, r *http.Request) {
+	if catcher, ok := h.catcher(); ok {
+		catcher.Clear()
+	}
+	h.sendDevMailList(w, nil, "")
+}
+
+// SendSampleMail sends a sample of one of the emails, made from the latest
+// order, for checking how it looks and that it gets delivered.
+func (h *Handler) SendSampleMail(w http.ResponseWriter, r *http.Request) {
+	to := r.FormValue("to")
+	if _, err := mail.ParseAddress(to); err != nil {
+		h.sendDevMailList(w, []string{"Enter a valid address to send the sample to"}, "danger")
+		return
+	}
+	kind := r.FormValue("kind")
+
+	var err error
+	switch kind {
+	case "order_confirmation", "order_alert", "shipping_update":
+		var order *models.Order
+		order, err = h.latestOrder(r.Context())
+		if err != nil {
+			break
+		}
+		order.UserID = to
+		switch kind {
+		case "order_confirmation":
+			err = h.Mail.OrderConfirmation(r.Context(), *order)
+		case "order_alert":
+			mailer := *h.Mail
+			mailer.AdminAddress = to
+			err = mailer.OrderAlert(r.Context(), *order)
+		case "shipping_update":
+			shipment := email.Shipment{Carrier: "UPS", TrackingNumber: "1Z999AA10123456784", TrackingURL: "https://www.ups.com/track?tracknum=1Z999AA10123456784"}
+			err = h.Mail.ShippingUpdate(r.Context(), *order, shipment)
+		}
+	case "password_reset":
+		err = h.Mail.PasswordReset(r.Context(), to, h.Config.Email.StoreURL+"/reset-password?token=sample", time.Now().Add(time.Hour))
+	default:
+		h.sendDevMailList(w, []string{"Choose an email to send"}, "danger")
+		return
+	}
+	if errors.Is(err, errNoOrders) {
+		h.sendDevMailList(w, []string{"Place an order first, order emails are made from the latest order"}, "danger")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	message := "Sample queued for " + to
+	if _, ok := h.catcher(); ok {
+		message += ", it shows up below once sent"
+	}
+	h.sendDevMailList(w, []string{message}, "success")
+}
+
+var errNoOrders = errors.New("no orders")
+
+func (h *Handler) latestOrder(ctx context.Context) (*models.Order, error) {
+	orders, err := h.Repo.Order.ListOrders(ctx, 1, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(orders) == 0 {
+		return nil, errNoOrders
+	}
+	return h.Repo.Order.GetOrderWithProducts(ctx, orders[0].OrderID)
+}
+
+func (h *Handler) sendDevMailList(w http.ResponseWriter, messages []string, alertType string) {
+	catcher, caught := h.catcher()
+	data := struct {
+		Caught    bool
+		Mode      string
+		Emails    []email.Message
+		Messages  []string
+		AlertType string
+	}{
+		Caught:    caught,
+		Mode:      h.Config.Email.Mode,
+		Messages:  messages,
+		AlertType: alertType,
+	}
+	if caught {
+		data.Emails = catcher.Messages()
+	}
+	tmpl.ExecuteTemplate(w, "devMailList", data)
+}
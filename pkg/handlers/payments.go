@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/payments"
+)
+
+// payOrder authorizes the payment for an order placed pending payment and
+// confirms the order once it is, capturing the payment right away with auto
+// capture. A declined payment fails the order, which gives back its coupon.
+// The returned payment tells which happened.
+func (h *Handler) payOrder(ctx context.Context, order *models.Order, paymentMethod string) (*models.Payment, error) {
+	if order.Total() <= 0 {
+		// Nothing to pay, like an order fully covered by a coupon
+		order.OrderStatus = models.OrderStatusPaid
+		return &models.Payment{OrderID: order.OrderID, Status: models.PaymentCaptured}, h.Repo.Order.SetOrderStatus(ctx, order.OrderID, order.OrderStatus)
+	}
+
+	payment := &models.Payment{
+		OrderID:  order.OrderID,
+		Provider: h.Payments.Name(),
+		Amount:   order.Total(),
+		Currency: h.Config.Payments.Currency,
+	}
+	charge, err := h.Payments.Authorize(ctx, payments.Authorization{
+		OrderID:       order.OrderID,
+		Amount:        payment.Amount,
+		Currency:      payment.Currency,
+		PaymentMethod: paymentMethod,
+	})
+	if err != nil {
+		log.Printf("Failed authorizing payment for order %s: %v\n", order.OrderID, err)
+		payment.Status = models.PaymentFailed
+		payment.FailureReason = "The payment could not be processed, please try again."
+	} else {
+		payment.ProviderRef = charge.Ref
+		payment.Status = charge.Status
+		payment.FailureReason = charge.FailureReason
+	}
+	if err := h.Repo.Payment.CreatePayment(ctx, payment); err != nil {
+		return nil, err
+	}
+
+	if payment.Status == models.PaymentFailed {
+		order.OrderStatus = models.OrderStatusPaymentFailed
+		return payment, h.Repo.Order.SetOrderStatus(ctx, order.OrderID, order.OrderStatus)
+	}
+
+	order.OrderStatus = models.OrderStatusOrdered
+	if h.Config.Payments.AutoCapture {
+		// An order whose capture fails stays confirmed, the authorized
+		// payment can still be captured later
+		if err := h.Payments.Capture(ctx, payment.ProviderRef, payment.Amount); err != nil {
+			log.Printf("Failed capturing payment %s of order %s: %v\n", payment.ProviderRef, order.OrderID, err)
+		} else {
+			payment.Status = models.PaymentCaptured
+			payment.CapturedAmount = payment.Amount
+			if err := h.Repo.Payment.UpdatePayment(ctx, payment); err != nil {
+				return nil, err
+			}
+			order.OrderStatus = models.OrderStatusPaid
+		}
+	}
+	return payment, h.Repo.Order.SetOrderStatus(ctx, order.OrderID, order.OrderStatus)
+}
+
+// PaymentWebhook receives the webhooks of the payment provider. Events that
+// fail processing are answered with an error, so that the provider sends
+// them again.
+func (h *Handler) PaymentWebhook(w http.ResponseWriter, r *http.Request) {
+	payload, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	err = payments.NewWebhooks(h.Repo, h.Payments).Receive(r.Context(), payload, r.Header)
+	if errors.Is(err, payments.ErrInvalidSignature) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed processing payment webhook: %v\n", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
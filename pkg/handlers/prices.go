@@ -0,0 +1,208 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+const (
+	priceChartWidth   = 600
+	priceChartHeight  = 200
+	priceChartPadding = 10
+)
+
+// PriceChart is a step chart of a product's price over time, drawn as an SVG
+// polyline.
+type PriceChart struct {
+	Width  int
+	Height int
+	Points string
+	Min    float64
+	Max    float64
+	Start  time.Time
+	End    time.Time
+}
+
+// newPriceChart plots the applied prices from the first one until now. It
+// returns nil when there is nothing to plot.
+func newPriceChart(prices []models.ProductPrice, now time.Time) *PriceChart {
+	var history []models.ProductPrice
+	for _, p := range prices {
+		if !p.Scheduled() {
+			history = append(history, p)
+		}
+	}
+	if len(history) == 0 {
+		return nil
+	}
+
+	chart := &PriceChart{
+		Width:  priceChartWidth,
+		Height: priceChartHeight,
+		Min:    history[0].Price,
+		Max:    history[0].Price,
+		Start:  history[0].EffectiveAt,
+		End:    now,
+	}
+	for _, p := range history {
+		chart.Min = min(chart.Min, p.Price)
+		chart.Max = max(chart.Max, p.Price)
+	}
+	if !chart.End.After(chart.Start) {
+		chart.End = chart.Start.Add(time.Hour)
+	}
+
+	span := chart.End.Sub(chart.Start).Seconds()
+	priceRange := chart.Max - chart.Min
+	x := func(t time.Time) float64 {
+		innerWidth := float64(chart.Width - 2*priceChartPadding)
+		return priceChartPadding + t.Sub(chart.Start).Seconds()/span*innerWidth
+	}
+	y := func(price float64) float64 {
+		innerHeight := float64(chart.Height - 2*priceChartPadding)
+		if priceRange == 0 {
+			// A price that never changed is drawn in the middle
+			return priceChartPadding + innerHeight/2
+		}
+		return priceChartPadding + (chart.Max-price)/priceRange*innerHeight
+	}
+
+	var points []string
+	point := func(t time.Time, price float64) {
+		points = append(points, fmt.Sprintf("%.1f,%.1f", x(t), y(price)))
+	}
+	for i, p := range history {
+		if i > 0 {
+			point(p.EffectiveAt, history[i-1].Price)
+		}
+		point(p.EffectiveAt, p.Price)
+	}
+	point(chart.End, history[len(history)-1].Price)
+	chart.Points = strings.Join(points, " ")
+	return chart
+}
+
+// ListProductPrices renders the price history, chart and scheduled prices
+// of a product.
+func (h *Handler) ListProductPrices(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	h.sendProductPrices(w, r, productID, "", "")
+}
+
+func (h *Handler) SchedulePrice(w http.ResponseWriter, r *http.Request) {
+	productID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	r.ParseForm()
+
+	price, err := strconv.ParseFloat(r.FormValue("price"), 64)
+	if err != nil || price < 0 {
+		h.sendProductPrices(w, r, productID, "Invalid price", "danger")
+		return
+	}
+	effectiveAt, err := time.ParseInLocation(datetimeLocalLayout, r.FormValue("effective_at"), time.Local)
+	if err != nil {
+		h.sendProductPrices(w, r, productID, "Invalid effective date", "danger")
+		return
+	}
+	if !effectiveAt.After(time.Now()) {
+		h.sendProductPrices(w, r, productID, "Scheduled prices must take effect in the future", "danger")
+		return
+	}
+
+	scheduled := models.ProductPrice{ProductID: productID, Price: price, EffectiveAt: effectiveAt}
+	if err := h.Repo.Price.SchedulePrice(r.Context(), &scheduled); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionSchedulePrice, auditEntityProduct, productID.String(), nil, &scheduled)
+
+	message := fmt.Sprintf("Price of $%.2f scheduled for %s", price, effectiveAt.Format("2006-01-02 15:04"))
+	h.sendProductPrices(w, r, productID, message, "success")
+}
+
+func (h *Handler) CancelScheduledPrice(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	productID, err := uuid.Parse(vars["id"])
+	if err != nil {
+		http.Error(w, "Invalid product ID", http.StatusBadRequest)
+		return
+	}
+	priceID, err := strconv.ParseInt(vars["price_id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid price ID", http.StatusBadRequest)
+		return
+	}
+
+	// Keep the cancelled price for the audit log
+	prices, err := h.Repo.Price.ListPrices(r.Context(), productID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	i := slices.IndexFunc(prices, func(p models.ProductPrice) bool { return p.ID == priceID })
+	if i < 0 {
+		http.Error(w, repository.ErrNotFound.Error(), http.StatusNotFound)
+		return
+	}
+
+	err = h.Repo.Price.CancelScheduledPrice(r.Context(), productID, priceID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionCancelPrice, auditEntityProduct, productID.String(), &prices[i], nil)
+
+	h.sendProductPrices(w, r, productID, "Scheduled price cancelled", "success")
+}
+
+func (h *Handler) sendProductPrices(w http.ResponseWriter, r *http.Request, productID uuid.UUID, message, alertType string) {
+	prices, err := h.Repo.Price.ListPrices(r.Context(), productID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	var history, scheduled []models.ProductPrice
+	for _, p := range prices {
+		if p.Scheduled() {
+			scheduled = append(scheduled, p)
+		} else {
+			history = append(history, p)
+		}
+	}
+	// Newest changes first in the table
+	slices.Reverse(history)
+
+	data := struct {
+		ProductID uuid.UUID
+		Chart     *PriceChart
+		History   []models.ProductPrice
+		Scheduled []models.ProductPrice
+		Message   string
+		AlertType string
+	}{
+		ProductID: productID,
+		Chart:     newPriceChart(prices, time.Now()),
+		History:   history,
+		Scheduled: scheduled,
+		Message:   message,
+		AlertType: alertType,
+	}
+	tmpl.ExecuteTemplate(w, "productPrices", data)
+}
@@ -0,0 +1,196 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+func (h *Handler) PromotionsPage(w http.ResponseWriter, r *http.Request) {
+	products, err := h.Repo.Product.GetProducts(r.Context(), repository.ProductFilter{})
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := struct {
+		Products []models.Product
+		Kinds    []models.PromotionKind
+	}{
+		Products: products,
+		Kinds:    models.PromotionKinds,
+	}
+	tmpl.ExecuteTemplate(w, "promotions", data)
+}
+
+func (h *Handler) ListPromotions(w http.ResponseWriter, r *http.Request) {
+	h.sendPromotionList(w, r, nil, "")
+}
+
+func (h *Handler) CreatePromotion(w http.ResponseWriter, r *http.Request) {
+	r.ParseForm()
+	promotion, messages := parsePromotion(r)
+	if len(messages) > 0 {
+		h.sendPromotionList(w, r, messages, "danger")
+		return
+	}
+
+	if err := h.Repo.Promotion.CreatePromotion(r.Context(), promotion); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionCreate, auditEntityPromotion, strconv.FormatInt(promotion.PromotionID, 10), nil, promotion)
+
+	h.sendPromotionList(w, r, []string{"Promotion " + promotion.Name + " created"}, "success")
+}
+
+// SetPromotionActive enables or disables a promotion. Orders keep the
+// promotions they were placed with.
+func (h *Handler) SetPromotionActive(w http.ResponseWriter, r *http.Request) {
+	promotionID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid promotion ID", http.StatusBadRequest)
+		return
+	}
+	active := r.FormValue("active") == "true"
+
+	if err := h.Repo.Promotion.SetPromotionActive(r.Context(), promotionID, active); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionUpdate, auditEntityPromotion, strconv.FormatInt(promotionID, 10),
+		map[string]bool{"Active": !active}, map[string]bool{"Active": active})
+
+	h.sendPromotionList(w, r, nil, "")
+}
+
+// parsePromotion reads a promotion from the create form and reports every
+// invalid field. Only the fields of the chosen kind are read.
+func parsePromotion(r *http.Request) (*models.Promotion, []string) {
+	var messages []string
+	promotion := &models.Promotion{
+		Name:      strings.TrimSpace(r.FormValue("name")),
+		Kind:      models.PromotionKind(r.FormValue("kind")),
+		Stackable: r.FormValue("stackable") == "true",
+		Active:    true,
+	}
+
+	if promotion.Name == "" || len(promotion.Name) > 100 {
+		messages = append(messages, "Name is required, up to 100 characters")
+	}
+
+	var err error
+	if v := r.FormValue("priority"); v != "" {
+		promotion.Priority, err = strconv.Atoi(v)
+		if err != nil {
+			messages = append(messages, "Invalid priority")
+		}
+	}
+
+	for _, v := range r.Form["product_ids"] {
+		productID, err := uuid.Parse(v)
+		if err != nil {
+			messages = append(messages, "Invalid product")
+			continue
+		}
+		promotion.ProductIDs = append(promotion.ProductIDs, productID)
+	}
+
+	switch promotion.Kind {
+	case models.PromotionBuyXGetY:
+		promotion.BuyQuantity, err = strconv.Atoi(r.FormValue("buy_quantity"))
+		if err != nil || promotion.BuyQuantity <= 0 {
+			messages = append(messages, "Buy quantity must be a positive number")
+		}
+		promotion.FreeQuantity, err = strconv.Atoi(r.FormValue("free_quantity"))
+		if err != nil || promotion.FreeQuantity <= 0 {
+			messages = append(messages, "Free quantity must be a positive number")
+		}
+	case models.PromotionSpendThreshold:
+		promotion.MinSubtotal, err = strconv.ParseFloat(r.FormValue("min_subtotal"), 64)
+		if err != nil || promotion.MinSubtotal < 0 {
+			messages = append(messages, "Invalid minimum spend")
+		}
+		promotion.Percent, err = strconv.ParseFloat(r.FormValue("percent"), 64)
+		if err != nil || promotion.Percent <= 0 || promotion.Percent > 100 {
+			messages = append(messages, "Percentage must be between 0 and 100")
+		}
+	case models.PromotionBundle:
+		promotion.BundlePrice, err = strconv.ParseFloat(r.FormValue("bundle_price"), 64)
+		if err != nil || promotion.BundlePrice < 0 {
+			messages = append(messages, "Invalid bundle price")
+		}
+		if len(promotion.ProductIDs) < 2 {
+			messages = append(messages, "A bundle needs at least two products")
+		}
+	default:
+		messages = append(messages, "Invalid promotion type")
+	}
+
+	for _, f := range []struct {
+		field string
+		label string
+		value **time.Time
+	}{
+		{"starts_at", "start date", &promotion.StartsAt},
+		{"ends_at", "end date", &promotion.EndsAt},
+	} {
+		v := r.FormValue(f.field)
+		if v == "" {
+			continue
+		}
+		t, err := time.ParseInLocation(datetimeLocalLayout, v, time.Local)
+		if err != nil {
+			messages = append(messages, "Invalid "+f.label)
+			continue
+		}
+		*f.value = &t
+	}
+	if promotion.StartsAt != nil && promotion.EndsAt != nil && !promotion.EndsAt.After(*promotion.StartsAt) {
+		messages = append(messages, "End date must be after the start date")
+	}
+
+	return promotion, messages
+}
+
+func (h *Handler) sendPromotionList(w http.ResponseWriter, r *http.Request, messages []string, alertType string) {
+	list, err := h.Repo.Promotion.ListPromotions(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	productNames := map[uuid.UUID]string{}
+	for _, promotion := range list {
+		for _, productID := range promotion.ProductIDs {
+			if _, ok := productNames[productID]; ok {
+				continue
+			}
+			product, err := h.Repo.Product.GetProductByID(r.Context(), productID)
+			if err != nil {
+				productNames[productID] = productID.String()
+				continue
+			}
+			productNames[productID] = product.ProductName
+		}
+	}
+
+	data := struct {
+		Promotions   []models.Promotion
+		ProductNames map[uuid.UUID]string
+		Messages     []string
+		AlertType    string
+	}{
+		Promotions:   list,
+		ProductNames: productNames,
+		Messages:     messages,
+		AlertType:    alertType,
+	}
+	tmpl.ExecuteTemplate(w, "promotionList", data)
+}
@@ -0,0 +1,450 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+	"github.com/snirkop89/mx-store/pkg/returns"
+)
+
+// errNoRefundablePayment is returned when refunding more than is left of the
+// captured payments of an order.
+var errNoRefundablePayment = errors.New("no captured payment of the order covers the refund")
+
+// customerOrder is an order on the customer's orders page.
+type customerOrder struct {
+	models.Order
+	Returns []models.Return
+	// Returnable is set when some of the items can still be returned.
+	Returnable bool
+}
+
+// MyOrdersView lists the orders of the customer with their returns.
+func (h *Handler) MyOrdersView(w http.ResponseWriter, r *http.Request) {
+	h.sendMyOrders(w, r, nil, "")
+}
+
+func (h *Handler) sendMyOrders(w http.ResponseWriter, r *http.Request, messages []string, alertType string) {
+	placed, err := h.Repo.Order.ListUserOrders(r.Context(), cartUserID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	var orders []customerOrder
+	for _, o := range placed {
+		order, previous, err := h.orderWithReturns(r.Context(), o.OrderID)
+		if err != nil {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		returnable := false
+		if returns.Returnable(*order) {
+			for _, left := range returns.Left(*order, previous) {
+				returnable = returnable || left > 0
+			}
+		}
+		orders = append(orders, customerOrder{Order: *order, Returns: previous, Returnable: returnable})
+	}
+
+	data := struct {
+		Orders    []customerOrder
+		Messages  []string
+		AlertType string
+	}{
+		Orders:    orders,
+		Messages:  messages,
+		AlertType: alertType,
+	}
+	tmpl.ExecuteTemplate(w, "myOrders", data)
+}
+
+// orderWithReturns loads an order of the customer with the returns
+// requested for it. Orders of other customers are not found.
+func (h *Handler) orderWithReturns(ctx context.Context, orderID uuid.UUID) (*models.Order, []models.Return, error) {
+	order, err := h.Repo.Order.GetOrderWithProducts(ctx, orderID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if order.UserID != cartUserID {
+		return nil, nil, repository.ErrNotFound
+	}
+	previous, err := h.Repo.Return.ListReturns(ctx, repository.ReturnFilter{OrderID: orderID})
+	if err != nil {
+		return nil, nil, err
+	}
+	return order, previous, nil
+}
+
+// returnLine is an ordered item on the return request form.
+type returnLine struct {
+	Item models.OrderItem
+	// Left is how many units can still be returned and Quantity how many
+	// the customer asked to return.
+	Left     int
+	Quantity int
+}
+
+// ReturnRequestView shows the form to return items of an order.
+func (h *Handler) ReturnRequestView(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+	order, previous, err := h.orderWithReturns(r.Context(), orderID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if !returns.Returnable(*order) {
+		h.sendMyOrders(w, r, []string{returns.ErrNotReturnable.Error()}, "danger")
+		return
+	}
+	h.sendReturnRequest(w, order, previous, nil, "", nil)
+}
+
+// RequestReturn saves the customer's request to return items of an order.
+// An admin approves or rejects it.
+func (h *Handler) RequestReturn(w http.ResponseWriter, r *http.Request) {
+	orderID, err := uuid.Parse(mux.Vars(r)["id"])
+	if err != nil {
+		http.Error(w, "Invalid order ID", http.StatusBadRequest)
+		return
+	}
+	order, previous, err := h.orderWithReturns(r.Context(), orderID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	var messages []string
+	quantities := make(map[uuid.UUID]int)
+	for _, item := range order.Items {
+		v := r.FormValue("quantity_" + item.ProductID.String())
+		if v == "" {
+			continue
+		}
+		quantity, err := strconv.Atoi(v)
+		if err != nil {
+			messages = append(messages, "Invalid quantity for "+item.Product.ProductName)
+			continue
+		}
+		quantities[item.ProductID] = quantity
+	}
+	reason := strings.TrimSpace(r.FormValue("reason"))
+	if reason == "" || len(reason) > 500 {
+		messages = append(messages, "Tell us why you are returning the items, in up to 500 characters")
+	}
+	if len(messages) > 0 {
+		h.sendReturnRequest(w, order, previous, quantities, reason, messages)
+		return
+	}
+
+	items, err := returns.Items(*order, previous, quantities)
+	if err != nil {
+		h.sendReturnRequest(w, order, previous, quantities, reason, []string{err.Error()})
+		return
+	}
+	ret := &models.Return{OrderID: order.OrderID, UserID: order.UserID, Reason: reason, Items: items}
+	err = h.Repo.Return.CreateReturn(r.Context(), ret)
+	if errors.Is(err, repository.ErrReturnQuantity) {
+		// Another request for the same items was saved in the meantime
+		h.sendReturnRequest(w, order, previous, quantities, reason, []string{returns.ErrTooMany.Error()})
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	message := fmt.Sprintf("Return #%d was requested. We will let you know once it is approved.", ret.ReturnID)
+	h.sendMyOrders(w, r, []string{message}, "success")
+}
+
+func (h *Handler) sendReturnRequest(w http.ResponseWriter, order *models.Order, previous []models.Return, quantities map[uuid.UUID]int, reason string, messages []string) {
+	left := returns.Left(*order, previous)
+	var lines []returnLine
+	for _, item := range order.Items {
+		if left[item.ProductID] > 0 {
+			lines = append(lines, returnLine{Item: item, Left: left[item.ProductID], Quantity: quantities[item.ProductID]})
+		}
+	}
+
+	data := struct {
+		Order    *models.Order
+		Lines    []returnLine
+		Reason   string
+		Messages []string
+	}{
+		Order:    order,
+		Lines:    lines,
+		Reason:   reason,
+		Messages: messages,
+	}
+	tmpl.ExecuteTemplate(w, "returnRequest", data)
+}
+
+func (h *Handler) ReturnsPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Statuses []models.ReturnStatus
+	}{
+		Statuses: models.ReturnStatuses,
+	}
+	tmpl.ExecuteTemplate(w, "returns", data)
+}
+
+func (h *Handler) ListReturns(w http.ResponseWriter, r *http.Request) {
+	h.sendReturnList(w, r, nil, "")
+}
+
+// ApproveReturn lets the customer send the items of a return back.
+func (h *Handler) ApproveReturn(w http.ResponseWriter, r *http.Request) {
+	h.setReturnStatus(w, r, models.ReturnApproved)
+}
+
+// RejectReturn turns down a return, with a note telling the customer why.
+func (h *Handler) RejectReturn(w http.ResponseWriter, r *http.Request) {
+	h.setReturnStatus(w, r, models.ReturnRejected)
+}
+
+func (h *Handler) setReturnStatus(w http.ResponseWriter, r *http.Request, status models.ReturnStatus) {
+	ret, ok := h.loadReturn(w, r)
+	if !ok {
+		return
+	}
+	note := strings.TrimSpace(r.FormValue("note"))
+	if len(note) > 500 {
+		h.sendReturnList(w, r, []string{"The note can be up to 500 characters"}, "danger")
+		return
+	}
+
+	err := h.Repo.Return.SetReturnStatus(r.Context(), ret.ReturnID, status, note)
+	if errors.Is(err, repository.ErrReturnStatus) {
+		h.sendReturnList(w, r, []string{fmt.Sprintf("Return #%d is already %s", ret.ReturnID, ret.Status)}, "danger")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionUpdate, auditEntityReturn, strconv.FormatInt(ret.ReturnID, 10),
+		map[string]any{"Status": ret.Status}, map[string]any{"Status": status, "Note": note})
+
+	h.sendReturnList(w, r, []string{fmt.Sprintf("Return #%d %s", ret.ReturnID, status)}, "success")
+}
+
+// ReceiveReturn records that the items of an approved return arrived, puts
+// them back in stock and refunds the amount from the form.
+func (h *Handler) ReceiveReturn(w http.ResponseWriter, r *http.Request) {
+	ret, ok := h.loadReturn(w, r)
+	if !ok {
+		return
+	}
+	amount, ok := h.parseRefundAmount(w, r, ret)
+	if !ok {
+		return
+	}
+
+	err := h.Repo.Return.ReceiveReturn(r.Context(), ret.ReturnID)
+	if errors.Is(err, repository.ErrReturnStatus) {
+		h.sendReturnList(w, r, []string{fmt.Sprintf("Return #%d is %s, only approved returns can be received", ret.ReturnID, ret.Status)}, "danger")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionUpdate, auditEntityReturn, strconv.FormatInt(ret.ReturnID, 10),
+		map[string]any{"Status": ret.Status}, map[string]any{"Status": models.ReturnReceived})
+
+	h.refund(w, r, ret, amount)
+}
+
+// RefundReturn refunds a received return whose refund failed before.
+func (h *Handler) RefundReturn(w http.ResponseWriter, r *http.Request) {
+	ret, ok := h.loadReturn(w, r)
+	if !ok {
+		return
+	}
+	if ret.Status != models.ReturnReceived {
+		h.sendReturnList(w, r, []string{fmt.Sprintf("Return #%d is %s, only received returns can be refunded", ret.ReturnID, ret.Status)}, "danger")
+		return
+	}
+	amount, ok := h.parseRefundAmount(w, r, ret)
+	if !ok {
+		return
+	}
+	h.refund(w, r, ret, amount)
+}
+
+func (h *Handler) refund(w http.ResponseWriter, r *http.Request, ret *models.Return, amount float64) {
+	refundRef, err := h.refundReturn(r.Context(), ret, amount)
+	if errors.Is(err, repository.ErrReturnStatus) {
+		h.sendReturnList(w, r, []string{fmt.Sprintf("Return #%d is being refunded or was refunded already", ret.ReturnID)}, "danger")
+		return
+	}
+	if err != nil {
+		log.Printf("Failed refunding return %d: %v\n", ret.ReturnID, err)
+		message := fmt.Sprintf("Return #%d was received, but the refund failed: %v", ret.ReturnID, err)
+		h.sendReturnList(w, r, []string{message}, "danger")
+		return
+	}
+	h.recordAudit(r, auditActionRefund, auditEntityReturn, strconv.FormatInt(ret.ReturnID, 10),
+		nil, map[string]any{"RefundAmount": amount, "RefundRef": refundRef})
+
+	message := fmt.Sprintf("Return #%d refunded $%.2f", ret.ReturnID, amount)
+	h.sendReturnList(w, r, []string{message}, "success")
+}
+
+// refundReturn gives amount back for a received return through the payment
+// provider, and records it on the payment, the return and the order. A zero
+// amount closes the return without a refund. The return is claimed first,
+// so that of two requests to refund it only one does, and given back to be
+// refunded again when the refund fails.
+func (h *Handler) refundReturn(ctx context.Context, ret *models.Return, amount float64) (string, error) {
+	if err := h.Repo.Return.ClaimRefund(ctx, ret.ReturnID); err != nil {
+		return "", err
+	}
+	refundRef, err := h.refundClaimed(ctx, ret, amount)
+	if err != nil {
+		if releaseErr := h.Repo.Return.ReleaseRefund(ctx, ret.ReturnID); releaseErr != nil {
+			log.Printf("Failed releasing return %d after its refund failed: %v\n", ret.ReturnID, releaseErr)
+		}
+		return "", err
+	}
+	return refundRef, nil
+}
+
+// refundClaimed refunds a return claimed by refundReturn. The refund is
+// made under a key of the return, so that one retried after a failure that
+// left the provider's refund done is not made twice.
+func (h *Handler) refundClaimed(ctx context.Context, ret *models.Return, amount float64) (string, error) {
+	order, err := h.Repo.Order.GetOrderWithProducts(ctx, ret.OrderID)
+	if err != nil {
+		return "", err
+	}
+	if refundable := returns.Refundable(*order, *ret); amount > refundable+0.005 {
+		return "", fmt.Errorf("only $%.2f is left to refund", refundable)
+	}
+
+	var refundRef string
+	if amount > 0 {
+		payment, err := h.refundablePayment(ctx, order.OrderID, amount)
+		if err != nil {
+			return "", err
+		}
+		key := "return-" + order.OrderID.String() + "-" + strconv.FormatInt(ret.ReturnID, 10)
+		refundRef, err = h.Payments.Refund(ctx, payment.ProviderRef, amount, key)
+		if err != nil {
+			return "", err
+		}
+		payment.RefundedAmount += amount
+		if payment.RefundedAmount >= payment.CapturedAmount-0.005 {
+			payment.Status = models.PaymentRefunded
+		}
+		// The provider sends a webhook about the refund too, which sets the
+		// refunded amount of the payment should this fail
+		if err := h.Repo.Payment.UpdatePayment(ctx, payment); err != nil {
+			return "", err
+		}
+	}
+
+	if err := h.Repo.Return.RefundReturn(ctx, ret.ReturnID, amount, refundRef); err != nil {
+		return "", err
+	}
+	if amount == 0 {
+		return "", nil
+	}
+	status := returns.RefundedStatus(*order, order.RefundedAmount+amount)
+	if order.OrderStatus != status && models.CanTransitionOrder(order.OrderStatus, status) {
+		if err := h.Repo.Order.SetOrderStatus(ctx, order.OrderID, status); err != nil {
+			return "", err
+		}
+	}
+	return refundRef, nil
+}
+
+// refundablePayment returns the latest captured payment of an order with at
+// least amount left to refund.
+func (h *Handler) refundablePayment(ctx context.Context, orderID uuid.UUID, amount float64) (*models.Payment, error) {
+	payments, err := h.Repo.Payment.ListPayments(ctx, orderID)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(payments) - 1; i >= 0; i-- {
+		payment := payments[i]
+		if payment.Status == models.PaymentCaptured && payment.CapturedAmount-payment.RefundedAmount >= amount-0.005 {
+			return &payment, nil
+		}
+	}
+	return nil, errNoRefundablePayment
+}
+
+// loadReturn reads the return in the URL, writing an error response when
+// there is none.
+func (h *Handler) loadReturn(w http.ResponseWriter, r *http.Request) (*models.Return, bool) {
+	returnID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid return ID", http.StatusBadRequest)
+		return nil, false
+	}
+	ret, err := h.Repo.Return.GetReturn(r.Context(), returnID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return nil, false
+	}
+	return ret, true
+}
+
+// parseRefundAmount reads the amount to refund for ret from the form. Unless
+// it is between zero and what can be refunded, it sends the returns with an
+// error and reports false.
+func (h *Handler) parseRefundAmount(w http.ResponseWriter, r *http.Request, ret *models.Return) (float64, bool) {
+	order, err := h.Repo.Order.GetOrderWithProducts(r.Context(), ret.OrderID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return 0, false
+	}
+	refundable := returns.Refundable(*order, *ret)
+	amount, err := strconv.ParseFloat(r.FormValue("refund_amount"), 64)
+	if err != nil || amount < 0 || amount > refundable+0.005 {
+		h.sendReturnList(w, r, []string{fmt.Sprintf("Refund amount must be between $0.00 and $%.2f", refundable)}, "danger")
+		return 0, false
+	}
+	return amount, true
+}
+
+// sendReturnList renders the returns with the status picked in the filter,
+// or all of them.
+func (h *Handler) sendReturnList(w http.ResponseWriter, r *http.Request, messages []string, alertType string) {
+	status := models.ReturnStatus(r.FormValue("status"))
+	if !status.Valid() {
+		status = ""
+	}
+	list, err := h.Repo.Return.ListReturns(r.Context(), repository.ReturnFilter{Status: status})
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := struct {
+		Returns   []models.Return
+		Messages  []string
+		AlertType string
+	}{
+		Returns:   list,
+		Messages:  messages,
+		AlertType: alertType,
+	}
+	tmpl.ExecuteTemplate(w, "returnList", data)
+}
@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+func (h *Handler) ShippingMethodsPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Kinds []models.ShippingKind
+	}{
+		Kinds: models.ShippingKinds,
+	}
+	tmpl.ExecuteTemplate(w, "shippingMethods", data)
+}
+
+func (h *Handler) ListShippingMethods(w http.ResponseWriter, r *http.Request) {
+	h.sendShippingMethodList(w, r, nil, "")
+}
+
+func (h *Handler) CreateShippingMethod(w http.ResponseWriter, r *http.Request) {
+	method, messages := parseShippingMethod(r)
+	if len(messages) > 0 {
+		h.sendShippingMethodList(w, r, messages, "danger")
+		return
+	}
+
+	if err := h.Repo.Shipping.CreateShippingMethod(r.Context(), method); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionCreate, auditEntityShipping, strconv.FormatInt(method.ShippingMethodID, 10), nil, method)
+
+	h.sendShippingMethodList(w, r, []string{"Shipping method " + method.Name + " created"}, "success")
+}
+
+// SetShippingMethodActive offers or withdraws a shipping method at checkout.
+// Orders keep the method and cost they were placed with.
+func (h *Handler) SetShippingMethodActive(w http.ResponseWriter, r *http.Request) {
+	methodID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid shipping method ID", http.StatusBadRequest)
+		return
+	}
+	active := r.FormValue("active") == "true"
+
+	if err := h.Repo.Shipping.SetShippingMethodActive(r.Context(), methodID, active); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionUpdate, auditEntityShipping, strconv.FormatInt(methodID, 10),
+		map[string]bool{"Active": !active}, map[string]bool{"Active": active})
+
+	h.sendShippingMethodList(w, r, nil, "")
+}
+
+// parseShippingMethod reads a shipping method from the create form and
+// reports every invalid field. The per kilogram rate is only read for weight
+// based methods.
+func parseShippingMethod(r *http.Request) (*models.ShippingMethod, []string) {
+	var messages []string
+	method := &models.ShippingMethod{
+		Name:   strings.TrimSpace(r.FormValue("name")),
+		Kind:   models.ShippingKind(r.FormValue("kind")),
+		Active: true,
+	}
+
+	if method.Name == "" || len(method.Name) > 100 {
+		messages = append(messages, "Name is required, up to 100 characters")
+	}
+	if !method.Kind.Valid() {
+		messages = append(messages, "Invalid shipping type")
+	}
+
+	var err error
+	method.Price, err = strconv.ParseFloat(r.FormValue("price"), 64)
+	if err != nil || method.Price < 0 {
+		messages = append(messages, "Invalid price")
+	}
+	if method.Kind == models.ShippingWeight {
+		method.PerKg, err = strconv.ParseFloat(r.FormValue("per_kg"), 64)
+		if err != nil || method.PerKg < 0 {
+			messages = append(messages, "Invalid price per kg")
+		}
+	}
+	if v := r.FormValue("free_over"); v != "" {
+		freeOver, err := strconv.ParseFloat(v, 64)
+		if err != nil || freeOver < 0 {
+			messages = append(messages, "Invalid free shipping threshold")
+		} else {
+			method.FreeOver = &freeOver
+		}
+	}
+
+	return method, messages
+}
+
+func (h *Handler) sendShippingMethodList(w http.ResponseWriter, r *http.Request, messages []string, alertType string) {
+	methods, err := h.Repo.Shipping.ListShippingMethods(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := struct {
+		ShippingMethods []models.ShippingMethod
+		Messages        []string
+		AlertType       string
+	}{
+		ShippingMethods: methods,
+		Messages:        messages,
+		AlertType:       alertType,
+	}
+	tmpl.ExecuteTemplate(w, "shippingMethodList", data)
+}
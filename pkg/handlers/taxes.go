@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+func (h *Handler) TaxRatesPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		TaxClasses []models.TaxClass
+		Tax        config.Tax
+	}{
+		TaxClasses: models.TaxClasses,
+		Tax:        h.Config.Tax,
+	}
+	tmpl.ExecuteTemplate(w, "taxRates", data)
+}
+
+func (h *Handler) ListTaxRates(w http.ResponseWriter, r *http.Request) {
+	h.sendTaxRateList(w, r, nil, "")
+}
+
+// SaveTaxRate sets the rate of a region and tax class, replacing the one
+// already set for them.
+func (h *Handler) SaveTaxRate(w http.ResponseWriter, r *http.Request) {
+	rate, messages := parseTaxRate(r)
+	if len(messages) > 0 {
+		h.sendTaxRateList(w, r, messages, "danger")
+		return
+	}
+
+	if err := h.Repo.Tax.SaveTaxRate(r.Context(), rate); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionUpdate, auditEntityTaxRate, strconv.FormatInt(rate.TaxRateID, 10), nil, rate)
+
+	h.sendTaxRateList(w, r, []string{"Tax rate for " + taxRegionLabel(rate) + " saved"}, "success")
+}
+
+func (h *Handler) DeleteTaxRate(w http.ResponseWriter, r *http.Request) {
+	taxRateID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid tax rate ID", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.Repo.Tax.DeleteTaxRate(r.Context(), taxRateID); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionPurge, auditEntityTaxRate, strconv.FormatInt(taxRateID, 10), nil, nil)
+
+	h.sendTaxRateList(w, r, nil, "")
+}
+
+// parseTaxRate reads a tax rate from the form and reports every invalid
+// field.
+func parseTaxRate(r *http.Request) (*models.TaxRate, []string) {
+	var messages []string
+	rate := &models.TaxRate{
+		Country:  models.NormalizeRegion(r.FormValue("country")),
+		State:    models.NormalizeRegion(r.FormValue("state")),
+		TaxClass: models.TaxClass(r.FormValue("tax_class")),
+		Name:     strings.TrimSpace(r.FormValue("name")),
+	}
+
+	if len(rate.Country) != 2 {
+		messages = append(messages, "Country must be a two letter code")
+	}
+	if len(rate.State) > 50 {
+		messages = append(messages, "State can be up to 50 characters")
+	}
+	if !rate.TaxClass.Valid() || rate.TaxClass == models.TaxClassExempt {
+		messages = append(messages, "Invalid tax class")
+	}
+	if rate.Name == "" || len(rate.Name) > 100 {
+		messages = append(messages, "Name is required, up to 100 characters")
+	}
+
+	var err error
+	rate.Rate, err = strconv.ParseFloat(r.FormValue("rate"), 64)
+	if err != nil || rate.Rate < 0 || rate.Rate > 100 {
+		messages = append(messages, "Rate must be a percentage between 0 and 100")
+	}
+
+	return rate, messages
+}
+
+func taxRegionLabel(rate *models.TaxRate) string {
+	if rate.State == "" {
+		return rate.Country
+	}
+	return rate.Country + "-" + rate.State
+}
+
+func (h *Handler) sendTaxRateList(w http.ResponseWriter, r *http.Request, messages []string, alertType string) {
+	rates, err := h.Repo.Tax.ListTaxRates(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := struct {
+		TaxRates  []models.TaxRate
+		Messages  []string
+		AlertType string
+	}{
+		TaxRates:  rates,
+		Messages:  messages,
+		AlertType: alertType,
+	}
+	tmpl.ExecuteTemplate(w, "taxRateList", data)
+}
@@ -1,59 +1,206 @@
 package handlers
 
 import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log"
 	"net/http"
 	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/coupons"
 	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/promotions"
+	"github.com/snirkop89/mx-store/pkg/repository"
+	"github.com/snirkop89/mx-store/pkg/shipping"
+	"github.com/snirkop89/mx-store/pkg/tax"
 )
 
-var (
-	currentCartOrderID uuid.UUID
-	cartItems          []models.OrderItem
-)
+// cartUserID places every order until the store has customer accounts.
+const cartUserID = "fk@htmxrocks.com"
+
+// cart is the one shopping cart there is until the store has customer
+// accounts. Requests are served at once, so the handlers that use it hold mu
+// for the whole request and the helpers they call expect it held.
+type cart struct {
+	mu      sync.Mutex
+	orderID uuid.UUID
+	items   []models.OrderItem
+	coupon  *models.Coupon
+	// Chosen at checkout
+	shippingAddress *models.Address
+	billingAddress  *models.Address
+	shippingMethod  *models.ShippingMethod
+}
+
+// reset starts a fresh cart.
+func (c *cart) reset() {
+	c.orderID = uuid.Nil
+	c.items = nil
+	c.coupon = nil
+	c.shippingAddress = nil
+	c.billingAddress = nil
+	c.shippingMethod = nil
+}
+
+// discount is what the applied coupon takes off the priced cart items. A
+// coupon the cart no longer qualifies for stays applied and takes effect
+// again once it does.
+func (c *cart) discount(items []models.OrderItem, now time.Time) (float64, error) {
+	if c.coupon == nil {
+		return 0, nil
+	}
+	return coupons.Discount(c.coupon, items, now)
+}
+
+// CartTemplateData is rendered by the cart templates.
+type CartTemplateData struct {
+	// OrderItems are priced with the running promotions and taxed.
+	OrderItems []models.OrderItem
+	Message    string
+	AlertType  string
+	// Subtotal is the cart at regular prices, Promotions what the running
+	// promotions take off it.
+	Subtotal   float64
+	Promotions []models.AppliedPromotion
+	// CouponCode is the applied coupon and CouponError why it does not
+	// discount the cart as it is now, if it doesn't.
+	CouponCode  string
+	CouponError string
+	Discount    float64
+	// ShippingMethod was chosen at checkout and costs Shipping, if one was.
+	ShippingMethod string
+	Shipping       float64
+	// Tax is broken down in TaxLines. With PricesIncludeTax it is already
+	// part of the item costs.
+	Tax              float64
+	TaxLines         []models.TaxLine
+	PricesIncludeTax bool
+	TotalCost        float64
+	Action           string
+	RefreshCartItems bool
+}
+
+func (h *Handler) newCartData(ctx context.Context, message, alertType string) CartTemplateData {
+	now := time.Now()
+	priced, err := h.priceCart(ctx, now)
+	if err != nil {
+		// Still show the cart, the order is priced again when it is placed
+		log.Printf("Failed loading promotions: %v\n", err)
+		priced = promotions.Apply(nil, h.cart.items, now)
+	}
+
+	data := CartTemplateData{
+		OrderItems: priced.Items,
+		Message:    message,
+		AlertType:  alertType,
+		Subtotal:   priced.Subtotal() + priced.Discount(),
+		Promotions: priced.Applied,
+		TotalCost:  priced.Subtotal(),
+	}
+	if h.cart.coupon != nil {
+		data.CouponCode = h.cart.coupon.Code
+		discount, err := h.cart.discount(priced.Items, now)
+		if err != nil {
+			data.CouponError = err.Error()
+		}
+		data.Discount = discount
+		data.TotalCost -= discount
+	}
+	if h.cart.shippingMethod != nil {
+		data.ShippingMethod = h.cart.shippingMethod.Name
+		data.Shipping = shipping.Cost(*h.cart.shippingMethod, priced.Items, data.TotalCost)
+		data.TotalCost += data.Shipping
+	}
+
+	taxed, err := h.taxCart(ctx, priced.Items, data.Discount)
+	if err != nil {
+		log.Printf("Failed loading tax rates: %v\n", err)
+		return data
+	}
+	data.OrderItems = taxed.Items
+	data.Tax = taxed.Tax
+	data.TaxLines = taxed.Lines()
+	data.PricesIncludeTax = h.Config.Tax.PricesIncludeTax
+	if !data.PricesIncludeTax {
+		data.TotalCost += taxed.Tax
+	}
+	return data
+}
 
 func (h *Handler) ShoppingHomepage(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
 	data := struct {
 		OrderItems []models.OrderItem
 	}{
-		OrderItems: cartItems,
+		OrderItems: h.cart.items,
 	}
 
 	tmpl.ExecuteTemplate(w, "homepage", data)
 }
 
 func (h *Handler) ShoppingItemsView(w http.ResponseWriter, r *http.Request) {
-	// Fake latency
-	time.Sleep(2 * time.Second)
-
-	products, err := h.Repo.Product.GetProducts("product_image != ''")
+	products, err := h.Repo.Product.GetProducts(r.Context(), repository.ProductFilter{
+		WithImage: true,
+		ListedAt:  time.Now(),
+	})
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		http.Error(w, err.Error(), errorStatus(err))
 		return
 	}
 	tmpl.ExecuteTemplate(w, "shoppingItems", products)
 }
 
+// CartView shows the cart with the products as they are now, warning about
+// those that can no longer be ordered as they are in it.
 func (h *Handler) CartView(w http.ResponseWriter, r *http.Request) {
-	data := struct {
-		OrderItems []models.OrderItem
-		Message    string
-		AlertType  string
-		TotalCost  float64
-	}{
-		OrderItems: cartItems,
-		Message:    "",
-		AlertType:  "",
-		TotalCost:  getTotalCartCost(),
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	var alertType string
+	message := h.refreshCart(r.Context())
+	if message != "" {
+		alertType = "danger"
 	}
+	tmpl.ExecuteTemplate(w, "cartItems", h.newCartData(r.Context(), message, alertType))
+}
 
-	tmpl.ExecuteTemplate(w, "cartItems", data)
+// refreshCart brings the products in the cart up to date and returns why one
+// of them cannot be ordered, if one can't. Products that fail to load are
+// left as they are, the order checks them again when it is placed.
+func (h *Handler) refreshCart(ctx context.Context) string {
+	now := time.Now()
+	var message string
+	for i, item := range h.cart.items {
+		product, err := h.Repo.Product.GetProductByID(ctx, item.ProductID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			log.Printf("Failed to refresh product %s in the cart: %v\n", item.ProductID, err)
+			continue
+		}
+		if err != nil || !product.Purchasable(now) {
+			message = cmp.Or(message, item.Product.ProductName+" is no longer available, please remove it from your cart")
+			continue
+		}
+		h.cart.items[i].Product = *product
+		if product.Stock != nil && *product.Stock < item.Quantity {
+			message = cmp.Or(message, outOfStockMessage(*product))
+		}
+	}
+	return message
 }
 
 func (h *Handler) AddToCart(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
 	vars := mux.Vars(r)
 	productID, err := uuid.Parse(vars["product_id"])
 	if err != nil {
@@ -62,37 +209,40 @@ func (h *Handler) AddToCart(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Generate a new order id for the session if one does not exist
-	if currentCartOrderID == uuid.Nil {
-		currentCartOrderID = uuid.New()
+	if h.cart.orderID == uuid.Nil {
+		h.cart.orderID = uuid.New()
 	}
 
 	var exists bool
-	for _, item := range cartItems {
+	for _, item := range h.cart.items {
 		if item.ProductID == productID {
 			exists = true
 			break
 		}
 	}
 
-	product, err := h.Repo.Product.GetProductByID(productID)
+	product, err := h.Repo.Product.GetProductByID(r.Context(), productID)
 	if err != nil {
-		http.Error(w, "Failed to get product", http.StatusInternalServerError)
+		http.Error(w, "Failed to get product", errorStatus(err))
 		return
 	}
 
 	var cartMessage string
 	var alertType string
-	if !exists {
+	if !product.Purchasable(time.Now()) {
+		cartMessage = product.ProductName + " is not available"
+		alertType = "danger"
+	} else if !exists {
 		// Create a new order item
 		newOrderItem := models.OrderItem{
-			OrderID:   currentCartOrderID,
+			OrderID:   h.cart.orderID,
 			ProductID: productID,
 			Quantity:  1,
 			Product:   *product,
 		}
 
 		// Add new order items to the array
-		cartItems = append(cartItems, newOrderItem)
+		h.cart.items = append(h.cart.items, newOrderItem)
 		cartMessage = product.ProductName + " successfully added"
 		alertType = "success"
 	} else {
@@ -100,26 +250,20 @@ func (h *Handler) AddToCart(w http.ResponseWriter, r *http.Request) {
 		alertType = "danger"
 	}
 
-	data := struct {
-		OrderItems []models.OrderItem
-		Message    string
-		AlertType  string
-		TotalCost  float64
-	}{
-		OrderItems: cartItems,
-		Message:    cartMessage,
-		AlertType:  alertType,
-		TotalCost:  getTotalCartCost(),
-	}
-
-	tmpl.ExecuteTemplate(w, "cartItems", data)
+	tmpl.ExecuteTemplate(w, "cartItems", h.newCartData(r.Context(), cartMessage, alertType))
 }
 
 func (h *Handler) ShoppingCartView(w http.ResponseWriter, r *http.Request) {
-	tmpl.ExecuteTemplate(w, "shoppingCart", cartItems)
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	tmpl.ExecuteTemplate(w, "shoppingCart", h.cart.items)
 }
 
 func (h *Handler) UpdateOrderItemQuantity(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
 	// Get product ID and action from URL parameters
 	var cartMessage string
 	var refreshCartList bool // Signals a refresh of cart items when an item is removed
@@ -134,7 +278,7 @@ func (h *Handler) UpdateOrderItemQuantity(w http.ResponseWriter, r *http.Request
 
 	// find the order item
 	itemIndex := -1
-	for i, item := range cartItems {
+	for i, item := range h.cart.items {
 		if item.ProductID == productID {
 			itemIndex = i
 			break
@@ -148,45 +292,249 @@ func (h *Handler) UpdateOrderItemQuantity(w http.ResponseWriter, r *http.Request
 	// Update quantity based on action
 	switch action {
 	case "add":
-		cartItems[itemIndex].Quantity++
+		h.cart.items[itemIndex].Quantity++
 	case "subtract":
-		cartItems[itemIndex].Quantity--
-		if cartItems[itemIndex].Quantity == 0 {
+		h.cart.items[itemIndex].Quantity--
+		if h.cart.items[itemIndex].Quantity == 0 {
 			// Remove items if quantity is 0
-			cartItems = slices.Delete(cartItems, itemIndex, itemIndex+1)
+			h.cart.items = slices.Delete(h.cart.items, itemIndex, itemIndex+1)
 			refreshCartList = true
 		}
 	case "remove":
 		// Remove items regardless of quantity
-		cartItems = slices.Delete(cartItems, itemIndex, itemIndex+1)
+		h.cart.items = slices.Delete(h.cart.items, itemIndex, itemIndex+1)
 		refreshCartList = true
 	default:
 		cartMessage = "Invalid Action"
 	}
 
-	data := struct {
-		OrderItems       []models.OrderItem
-		Message          string
-		AlertType        string
-		TotalCost        float64
-		Action           string
-		RefreshCartItems bool
-	}{
-		OrderItems:       cartItems,
-		Message:          cartMessage,
-		AlertType:        "info",
-		TotalCost:        getTotalCartCost(),
-		Action:           action,
-		RefreshCartItems: refreshCartList,
-	}
+	data := h.newCartData(r.Context(), cartMessage, "info")
+	data.Action = action
+	data.RefreshCartItems = refreshCartList
 
 	tmpl.ExecuteTemplate(w, "updateShoppingCart", data)
 }
 
-func getTotalCartCost() float64 {
-	totalCost := 0.0
-	for _, item := range cartItems {
-		totalCost += float64(item.Quantity) * item.Product.Price
+// ApplyCoupon applies the coupon code entered on the cart. Usage limits are
+// checked here so the customer knows right away, and again when the order is
+// placed.
+func (h *Handler) ApplyCoupon(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	code := models.NormalizeCouponCode(r.FormValue("coupon_code"))
+	if code == "" {
+		tmpl.ExecuteTemplate(w, "cartItems", h.newCartData(r.Context(), "Enter a coupon code", "danger"))
+		return
+	}
+
+	coupon, err := h.Repo.Coupon.GetCouponByCode(r.Context(), code)
+	if errors.Is(err, repository.ErrNotFound) {
+		tmpl.ExecuteTemplate(w, "cartItems", h.newCartData(r.Context(), code+" is not a valid coupon code", "danger"))
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	now := time.Now()
+	priced, err := h.priceCart(r.Context(), now)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if _, err := coupons.Discount(coupon, priced.Items, now); err != nil {
+		tmpl.ExecuteTemplate(w, "cartItems", h.newCartData(r.Context(), capitalize(err.Error()), "danger"))
+		return
+	}
+	uses, err := h.Repo.Coupon.CountRedemptions(r.Context(), coupon.CouponID, cartUserID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if err := coupons.CheckUsage(coupon, uses); err != nil {
+		tmpl.ExecuteTemplate(w, "cartItems", h.newCartData(r.Context(), capitalize(err.Error()), "danger"))
+		return
+	}
+
+	h.cart.coupon = coupon
+	tmpl.ExecuteTemplate(w, "cartItems", h.newCartData(r.Context(), "Coupon "+coupon.Code+" applied", "success"))
+}
+
+func (h *Handler) RemoveCoupon(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	h.cart.coupon = nil
+	tmpl.ExecuteTemplate(w, "cartItems", h.newCartData(r.Context(), "Coupon removed", "info"))
+}
+
+// outOfStockMessage tells the shopper a tracked product has too few left.
+func outOfStockMessage(product models.Product) string {
+	if *product.Stock == 0 {
+		return product.ProductName + " is out of stock, please remove it from your cart"
+	}
+	return fmt.Sprintf("Only %d of %s left in stock, please update your cart", *product.Stock, product.ProductName)
+}
+
+// PlaceOrder turns the cart into an order at the current prices, applies the
+// running promotions and tax, charges the shipping chosen at checkout and
+// redeems the applied coupon.
+func (h *Handler) PlaceOrder(w http.ResponseWriter, r *http.Request) {
+	h.cart.mu.Lock()
+	defer h.cart.mu.Unlock()
+
+	if len(h.cart.items) == 0 {
+		h.sendCartError(w, r, "Your cart is empty")
+		return
+	}
+	if h.cart.shippingAddress == nil || h.cart.shippingMethod == nil {
+		h.sendCartError(w, r, "Choose where and how to ship your order at checkout")
+		return
+	}
+	method, err := h.shippingMethod(r.Context(), h.cart.shippingMethod.ShippingMethodID)
+	if errors.Is(err, repository.ErrNotFound) {
+		message := h.cart.shippingMethod.Name + " shipping is no longer available, choose another at checkout"
+		h.cart.shippingMethod = nil
+		h.sendCartError(w, r, message)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	// Prices may have changed since the items were added
+	now := time.Now()
+	for i, item := range h.cart.items {
+		product, err := h.Repo.Product.GetProductByID(r.Context(), item.ProductID)
+		if err != nil && !errors.Is(err, repository.ErrNotFound) {
+			http.Error(w, err.Error(), errorStatus(err))
+			return
+		}
+		if err != nil || !product.Purchasable(now) {
+			message := item.Product.ProductName + " is no longer available, please remove it from your cart"
+			h.sendCartError(w, r, message)
+			return
+		}
+		if product.Stock != nil && *product.Stock < item.Quantity {
+			h.sendCartError(w, r, outOfStockMessage(*product))
+			return
+		}
+		h.cart.items[i].Product = *product
+	}
+
+	priced, err := h.priceCart(r.Context(), now)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	order := models.Order{UserID: cartUserID, Items: priced.Items, Promotions: priced.Applied}
+	if h.cart.coupon != nil {
+		discount, err := h.cart.discount(priced.Items, now)
+		if err != nil {
+			message := "Coupon " + h.cart.coupon.Code + " cannot be used: " + err.Error()
+			h.sendCartError(w, r, message)
+			return
+		}
+		order.CouponCode = h.cart.coupon.Code
+		order.Discount = discount
+	}
+	order.ShippingAddress = h.cart.shippingAddress
+	order.BillingAddress = h.cart.billingAddress
+	order.ShippingMethod = method.Name
+	order.ShippingCost = shipping.Cost(*method, order.Items, order.Subtotal()-order.Discount)
+
+	taxed, err := h.taxCart(r.Context(), order.Items, order.Discount)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	order.Items = taxed.Items
+	order.Tax = taxed.Tax
+	order.PricesIncludeTax = h.Config.Tax.PricesIncludeTax
+
+	// The order is only confirmed once its payment is authorized
+	order.OrderStatus = models.OrderStatusPendingPayment
+	err = h.Repo.Order.PlaceOrder(r.Context(), &order)
+	if errors.Is(err, coupons.ErrUsedUp) || errors.Is(err, coupons.ErrCustomerLimit) {
+		message := "Coupon " + order.CouponCode + " cannot be used: " + err.Error()
+		h.sendCartError(w, r, message)
+		return
+	}
+	if errors.Is(err, repository.ErrOutOfStock) {
+		// Sold out between the check above and placing the order
+		h.sendCartError(w, r, "Some items in your cart just sold out, please update your cart")
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	payment, err := h.payOrder(r.Context(), &order, r.FormValue("payment_method"))
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if payment.Status == models.PaymentFailed {
+		h.sendCartError(w, r, "Payment failed: "+payment.FailureReason)
+		return
+	}
+
+	h.cart.reset()
+	h.notifyOrder(r.Context(), order)
+
+	tmpl.ExecuteTemplate(w, "orderComplete", order)
+}
+
+// notifyOrder emails the customer a confirmation of a placed order and
+// alerts the admin. The order stands either way, so failures are only
+// logged.
+func (h *Handler) notifyOrder(ctx context.Context, order models.Order) {
+	if err := h.Mail.OrderConfirmation(ctx, order); err != nil {
+		log.Printf("Failed to queue the confirmation of order %s: %v\n", order.OrderID, err)
+	}
+	if err := h.Mail.OrderAlert(ctx, order); err != nil {
+		log.Printf("Failed to queue the alert of order %s: %v\n", order.OrderID, err)
+	}
+}
+
+// sendCartError shows a message on the cart in response to a request that
+// targets another part of the page.
+func (h *Handler) sendCartError(w http.ResponseWriter, r *http.Request, message string) {
+	w.Header().Set("HX-Retarget", "#shoppingCartItems")
+	tmpl.ExecuteTemplate(w, "cartItems", h.newCartData(r.Context(), message, "danger"))
+}
+
+// priceCart applies the running promotions to the cart.
+func (h *Handler) priceCart(ctx context.Context, now time.Time) (promotions.Result, error) {
+	rules, err := h.Repo.Promotion.ListPromotions(ctx)
+	if err != nil {
+		return promotions.Result{}, err
+	}
+	return promotions.Apply(rules, h.cart.items, now), nil
+}
+
+// taxCart charges tax on the priced cart items, of which the coupon takes
+// discount off. The cart is taxed where it ships to once an address is
+// chosen, and in the configured region before that.
+func (h *Handler) taxCart(ctx context.Context, items []models.OrderItem, discount float64) (tax.Result, error) {
+	rates, err := h.Repo.Tax.ListTaxRates(ctx)
+	if err != nil {
+		return tax.Result{}, err
+	}
+	region := tax.Region{Country: h.Config.Tax.Country, State: h.Config.Tax.State}
+	if h.cart.shippingAddress != nil {
+		region = tax.Region{Country: h.cart.shippingAddress.Country, State: h.cart.shippingAddress.State}
+	}
+	return tax.Apply(rates, region, h.Config.Tax.PricesIncludeTax, items, discount), nil
+}
+
+func capitalize(s string) string {
+	if s == "" {
+		return s
 	}
-	return totalCost
+	return strings.ToUpper(s[:1]) + s[1:]
 }
@@ -0,0 +1,199 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// deliveryListSize is how many of the latest deliveries the webhooks page
+// shows.
+const deliveryListSize = 50
+
+func (h *Handler) WebhooksPage(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Events []models.WebhookEvent
+	}{
+		Events: models.WebhookEvents,
+	}
+	tmpl.ExecuteTemplate(w, "webhooks", data)
+}
+
+func (h *Handler) ListWebhookSubscriptions(w http.ResponseWriter, r *http.Request) {
+	h.sendWebhookSubscriptionList(w, r, nil, "")
+}
+
+func (h *Handler) CreateWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	sub, messages := parseWebhookSubscription(r)
+	if len(messages) > 0 {
+		h.sendWebhookSubscriptionList(w, r, messages, "danger")
+		return
+	}
+	if sub.Secret == "" {
+		sub.Secret = newWebhookSecret()
+	}
+
+	if err := h.Repo.Webhook.CreateWebhookSubscription(r.Context(), sub); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionCreate, auditEntityWebhook, strconv.FormatInt(sub.SubscriptionID, 10), nil, redactSubscription(*sub))
+
+	h.sendWebhookSubscriptionList(w, r, []string{"Subscription for " + sub.URL + " created"}, "success")
+}
+
+func (h *Handler) SetWebhookSubscriptionActive(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		return
+	}
+	active := r.FormValue("active") == "true"
+
+	if err := h.Repo.Webhook.SetWebhookSubscriptionActive(r.Context(), subscriptionID, active); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionUpdate, auditEntityWebhook, strconv.FormatInt(subscriptionID, 10),
+		map[string]bool{"Active": !active}, map[string]bool{"Active": active})
+
+	h.sendWebhookSubscriptionList(w, r, nil, "")
+}
+
+// DeleteWebhookSubscription deletes a subscription together with its
+// deliveries.
+func (h *Handler) DeleteWebhookSubscription(w http.ResponseWriter, r *http.Request) {
+	subscriptionID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid subscription ID", http.StatusBadRequest)
+		return
+	}
+	sub, err := h.Repo.Webhook.GetWebhookSubscription(r.Context(), subscriptionID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	if err := h.Repo.Webhook.DeleteWebhookSubscription(r.Context(), subscriptionID); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionDelete, auditEntityWebhook, strconv.FormatInt(subscriptionID, 10), redactSubscription(*sub), nil)
+
+	h.sendWebhookSubscriptionList(w, r, []string{"Subscription for " + sub.URL + " deleted"}, "success")
+}
+
+func (h *Handler) ListWebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	h.sendWebhookDeliveryList(w, r, nil, "")
+}
+
+// RedeliverWebhook sends a delivery again, the way it was sent the first
+// time.
+func (h *Handler) RedeliverWebhook(w http.ResponseWriter, r *http.Request) {
+	deliveryID, err := strconv.ParseInt(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "Invalid delivery ID", http.StatusBadRequest)
+		return
+	}
+	before, err := h.Repo.Webhook.GetWebhookDelivery(r.Context(), deliveryID)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	if err := h.Webhooks.Redeliver(r.Context(), deliveryID); err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+	h.recordAudit(r, auditActionRedeliver, auditEntityDelivery, strconv.FormatInt(deliveryID, 10),
+		map[string]any{"Status": before.Status}, map[string]any{"Status": models.WebhookDeliveryPending})
+	h.sendWebhookDeliveryList(w, r, []string{fmt.Sprintf("Delivery #%d was queued to be sent again", deliveryID)}, "success")
+}
+
+// parseWebhookSubscription reads a subscription from the form, returning
+// validation messages when it is invalid.
+func parseWebhookSubscription(r *http.Request) (*models.WebhookSubscription, []string) {
+	var messages []string
+	sub := &models.WebhookSubscription{URL: r.FormValue("url"), Secret: r.FormValue("secret"), Active: true}
+
+	u, err := url.Parse(sub.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		messages = append(messages, "URL must be an http or https URL")
+	}
+	for _, value := range r.Form["events"] {
+		event := models.WebhookEvent(value)
+		if !event.Valid() {
+			messages = append(messages, "Unknown event "+value)
+			continue
+		}
+		if !sub.Subscribes(event) {
+			sub.Events = append(sub.Events, event)
+		}
+	}
+	if len(sub.Events) == 0 {
+		messages = append(messages, "Choose at least one event")
+	}
+	if len(sub.Secret) > 100 {
+		messages = append(messages, "Secret must be at most 100 characters")
+	}
+	return sub, messages
+}
+
+// newWebhookSecret makes a random secret for a subscription created without
+// one.
+func newWebhookSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// redactSubscription keeps the secret of a subscription out of the activity
+// log.
+func redactSubscription(sub models.WebhookSubscription) models.WebhookSubscription {
+	sub.Secret = ""
+	return sub
+}
+
+func (h *Handler) sendWebhookSubscriptionList(w http.ResponseWriter, r *http.Request, messages []string, alertType string) {
+	subs, err := h.Repo.Webhook.ListWebhookSubscriptions(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := struct {
+		Subscriptions []models.WebhookSubscription
+		Messages      []string
+		AlertType     string
+	}{
+		Subscriptions: subs,
+		Messages:      messages,
+		AlertType:     alertType,
+	}
+	tmpl.ExecuteTemplate(w, "webhookSubscriptionList", data)
+}
+
+func (h *Handler) sendWebhookDeliveryList(w http.ResponseWriter, r *http.Request, messages []string, alertType string) {
+	subscriptionID, _ := strconv.ParseInt(r.FormValue("subscription"), 10, 64)
+	deliveries, err := h.Repo.Webhook.ListWebhookDeliveries(r.Context(), subscriptionID, deliveryListSize)
+	if err != nil {
+		http.Error(w, err.Error(), errorStatus(err))
+		return
+	}
+
+	data := struct {
+		Deliveries []models.WebhookDelivery
+		Messages   []string
+		AlertType  string
+	}{
+		Deliveries: deliveries,
+		Messages:   messages,
+		AlertType:  alertType,
+	}
+	tmpl.ExecuteTemplate(w, "webhookDeliveryList", data)
+}
@@ -0,0 +1,286 @@
+// Package jobs runs work in the background from a queue kept in the
+// database, so that queued work survives restarts.
+package jobs
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+// PurgeJob deletes the succeeded jobs that are older than the retention.
+const PurgeJob = "jobs.purge"
+
+// maxBackoff caps the wait between retries of a job.
+const maxBackoff = 6 * time.Hour
+
+// lockMargin is how long after its timeout a job whose worker died is
+// claimed again.
+const lockMargin = time.Minute
+
+// Handler does the work of a job. Returning an error fails the attempt.
+type Handler func(ctx context.Context, job *models.Job) error
+
+// Kind is a type of job whose payload is a T, stored as JSON.
+type Kind[T any] struct {
+	Name string
+}
+
+// Enqueue queues a job of the kind with payload.
+func (k Kind[T]) Enqueue(ctx context.Context, q *Queue, payload T, opts ...Option) (*models.Job, error) {
+	return q.Enqueue(ctx, k.Name, payload, opts...)
+}
+
+// Handle makes q run the jobs of the kind with fn.
+func (k Kind[T]) Handle(q *Queue, fn func(ctx context.Context, payload T) error) {
+	q.Handle(k.Name, func(ctx context.Context, job *models.Job) error {
+		var payload T
+		if err := json.Unmarshal(job.Payload, &payload); err != nil {
+			return Permanent(fmt.Errorf("decode payload: %w", err))
+		}
+		return fn(ctx, payload)
+	})
+}
+
+// Option changes a job before it is queued.
+type Option func(job *models.Job)
+
+// At runs the job at t instead of right away.
+func At(t time.Time) Option {
+	return func(job *models.Job) { job.RunAt = t }
+}
+
+// MaxAttempts overrides how many times the job is tried.
+func MaxAttempts(n int) Option {
+	return func(job *models.Job) { job.MaxAttempts = n }
+}
+
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// Permanent marks err as one that retrying will not fix, so the job is left
+// dead right away.
+func Permanent(err error) error {
+	return permanentError{err}
+}
+
+// IsPermanent reports whether err was marked with Permanent.
+func IsPermanent(err error) bool {
+	return errors.As(err, new(permanentError))
+}
+
+// Queue queues jobs in a JobStore and runs them with a pool of workers.
+// Handlers have to be registered before Run.
+type Queue struct {
+	Store        repository.JobStore
+	Workers      int
+	PollInterval time.Duration
+	Timeout      time.Duration
+	MaxAttempts  int
+	Backoff      time.Duration
+
+	handlers  map[string]Handler
+	schedules map[string]time.Duration
+	wake      chan struct{}
+}
+
+func New(store repository.JobStore, cfg config.Jobs) *Queue {
+	q := &Queue{
+		Store:        store,
+		Workers:      cfg.Workers,
+		PollInterval: cfg.PollInterval,
+		Timeout:      cfg.Timeout,
+		MaxAttempts:  cfg.MaxAttempts,
+		Backoff:      cfg.RetryBackoff,
+		handlers:     map[string]Handler{},
+		schedules:    map[string]time.Duration{},
+		wake:         make(chan struct{}, 1),
+	}
+	q.Every(PurgeJob, time.Hour, func(ctx context.Context, job *models.Job) error {
+		purged, err := store.PurgeJobs(ctx, time.Now().Add(-cfg.Retention))
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			slog.Info("Purged succeeded jobs", "count", purged)
+		}
+		return nil
+	})
+	return q
+}
+
+// Handle makes the queue run the jobs of kind with h.
+func (q *Queue) Handle(kind string, h Handler) {
+	q.handlers[kind] = h
+}
+
+// Every runs h every interval, from when the queue starts. Each run is a job
+// of kind that is tried once; the next one is queued when it finishes.
+func (q *Queue) Every(kind string, interval time.Duration, h Handler) {
+	q.handlers[kind] = h
+	q.schedules[kind] = interval
+}
+
+// Enqueue queues a job of kind with payload, which is stored as JSON, to be
+// run right away unless an option says otherwise.
+func (q *Queue) Enqueue(ctx context.Context, kind string, payload any, opts ...Option) (*models.Job, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	job := &models.Job{Kind: kind, Payload: data, MaxAttempts: q.MaxAttempts}
+	for _, opt := range opts {
+		opt(job)
+	}
+	if err := q.Store.EnqueueJob(ctx, job); err != nil {
+		return nil, err
+	}
+	q.notify()
+	return job, nil
+}
+
+// Requeue runs a dead or waiting job again right away.
+func (q *Queue) Requeue(ctx context.Context, jobID int64) error {
+	if err := q.Store.RequeueJob(ctx, jobID); err != nil {
+		return err
+	}
+	q.notify()
+	return nil
+}
+
+// notify wakes up a waiting worker.
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run queues the first run of every scheduled job and runs due jobs with
+// Workers workers until ctx is cancelled. Jobs that are running then are
+// finished before it returns.
+func (q *Queue) Run(ctx context.Context) {
+	for kind := range q.schedules {
+		q.schedule(ctx, kind, time.Now())
+	}
+
+	var wg sync.WaitGroup
+	for range q.Workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			q.work(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+func (q *Queue) work(ctx context.Context) {
+	ticker := time.NewTicker(q.PollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		if q.RunNext(ctx) {
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// RunNext claims a due job and runs it. It reports whether there was one.
+func (q *Queue) RunNext(ctx context.Context) bool {
+	now := time.Now()
+	job, err := q.Store.ClaimJob(ctx, now, now.Add(q.Timeout+lockMargin))
+	if errors.Is(err, repository.ErrNotFound) {
+		return false
+	}
+	if err != nil {
+		if ctx.Err() == nil {
+			slog.Error("Claiming job", "err", err)
+		}
+		return false
+	}
+	q.run(ctx, job)
+	return true
+}
+
+// run runs a claimed job and records the outcome. A job that started is
+// given its whole timeout, even when ctx is cancelled meanwhile.
+func (q *Queue) run(ctx context.Context, job *models.Job) {
+	ctx = context.WithoutCancel(ctx)
+	err := q.call(ctx, job)
+
+	switch {
+	case err == nil:
+		err = q.Store.CompleteJob(ctx, job)
+	case IsPermanent(err) || job.Attempts >= job.MaxAttempts:
+		slog.Error("Job failed, giving up", "job", job.JobID, "kind", job.Kind, "attempt", job.Attempts, "err", err)
+		err = q.Store.BuryJob(ctx, job, err.Error())
+	default:
+		retryIn := q.backoff(job.Attempts)
+		slog.Warn("Job failed, retrying", "job", job.JobID, "kind", job.Kind, "attempt", job.Attempts, "retry_in", retryIn, "err", err)
+		err = q.Store.RetryJob(ctx, job, time.Now().Add(retryIn), err.Error())
+	}
+	if err != nil {
+		slog.Error("Finishing job", "job", job.JobID, "kind", job.Kind, "err", err)
+	}
+
+	if interval, ok := q.schedules[job.Kind]; ok {
+		q.schedule(ctx, job.Kind, time.Now().Add(interval))
+	}
+}
+
+// call runs the handler of job within the timeout, turning a panic into an
+// error.
+func (q *Queue) call(ctx context.Context, job *models.Job) (err error) {
+	h, ok := q.handlers[job.Kind]
+	if !ok {
+		return Permanent(fmt.Errorf("no handler for jobs of kind %q", job.Kind))
+	}
+	ctx, cancel := context.WithTimeout(ctx, q.Timeout)
+	defer cancel()
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return h(ctx, job)
+}
+
+// backoff is how long to wait before retrying a job that failed attempt
+// times.
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.Backoff
+	for range attempts - 1 {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
+
+// schedule queues the next run of a scheduled job, unless one is waiting
+// already.
+func (q *Queue) schedule(ctx context.Context, kind string, at time.Time) {
+	_, err := q.Store.EnsureJob(ctx, &models.Job{Kind: kind, Payload: []byte("null"), MaxAttempts: 1, RunAt: at})
+	if err != nil && ctx.Err() == nil {
+		slog.Error("Scheduling job", "kind", kind, "err", err)
+	}
+}
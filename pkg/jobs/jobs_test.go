@@ -0,0 +1,187 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+func newTestQueue() (*Queue, repository.JobStore) {
+	store := repository.NewMemoryJobStore()
+	cfg := config.Default().Jobs
+	cfg.MaxAttempts = 3
+	cfg.RetryBackoff = time.Nanosecond
+	return New(store, cfg), store
+}
+
+// runAll runs jobs until none is due.
+func runAll(q *Queue) {
+	for q.RunNext(context.Background()) {
+	}
+}
+
+func TestRunOutcomes(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		err          error
+		wantStatus   models.JobStatus
+		wantAttempts int
+	}{
+		{"succeeds first time", 0, errors.New("connection refused"), models.JobSucceeded, 1},
+		{"succeeds on the last attempt", 2, errors.New("connection refused"), models.JobSucceeded, 3},
+		{"out of attempts", 3, errors.New("connection refused"), models.JobDead, 3},
+		{"permanent error", 1, Permanent(errors.New("no such order")), models.JobDead, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			q, store := newTestQueue()
+			calls := 0
+			q.Handle("test", func(ctx context.Context, job *models.Job) error {
+				calls++
+				if calls <= tc.failures {
+					return tc.err
+				}
+				return nil
+			})
+			job, err := q.Enqueue(context.Background(), "test", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runAll(q)
+
+			got, err := store.GetJob(context.Background(), job.JobID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Status != tc.wantStatus || got.Attempts != tc.wantAttempts {
+				t.Fatalf("job is %s after %d attempts, want %s after %d", got.Status, got.Attempts, tc.wantStatus, tc.wantAttempts)
+			}
+			if tc.wantStatus == models.JobDead && got.LastError != tc.err.Error() {
+				t.Fatalf("LastError = %q, want %q", got.LastError, tc.err)
+			}
+		})
+	}
+}
+
+func TestRunPanicAndUnknownKind(t *testing.T) {
+	q, store := newTestQueue()
+	q.Handle("panics", func(ctx context.Context, job *models.Job) error {
+		panic("boom")
+	})
+	panics, err := q.Enqueue(context.Background(), "panics", nil, MaxAttempts(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknown, err := q.Enqueue(context.Background(), "unknown", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	runAll(q)
+
+	for _, tc := range []struct {
+		jobID   int64
+		wantErr string
+	}{
+		{panics.JobID, "panic: boom"},
+		{unknown.JobID, `no handler for jobs of kind "unknown"`},
+	} {
+		got, err := store.GetJob(context.Background(), tc.jobID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Status != models.JobDead || got.Attempts != 1 || got.LastError != tc.wantErr {
+			t.Errorf("job %d is %s after %d attempts with %q, want dead after 1 with %q",
+				tc.jobID, got.Status, got.Attempts, got.LastError, tc.wantErr)
+		}
+	}
+}
+
+func TestKind(t *testing.T) {
+	type greeting struct {
+		Name string
+	}
+	kind := Kind[greeting]{Name: "greet"}
+	q, store := newTestQueue()
+	var greeted []string
+	kind.Handle(q, func(ctx context.Context, g greeting) error {
+		greeted = append(greeted, g.Name)
+		return nil
+	})
+
+	if _, err := kind.Enqueue(context.Background(), q, greeting{Name: "now"}); err != nil {
+		t.Fatal(err)
+	}
+	later, err := kind.Enqueue(context.Background(), q, greeting{Name: "later"}, At(time.Now().Add(time.Hour)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	bad, err := q.Enqueue(context.Background(), "greet", "not an object")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runAll(q)
+
+	if len(greeted) != 1 || greeted[0] != "now" {
+		t.Fatalf("greeted %v, want only the job that is due", greeted)
+	}
+	if got, _ := store.GetJob(context.Background(), later.JobID); got.Status != models.JobQueued {
+		t.Errorf("later job is %s, want queued", got.Status)
+	}
+	if got, _ := store.GetJob(context.Background(), bad.JobID); got.Status != models.JobDead || got.Attempts != 1 {
+		t.Errorf("job with a bad payload is %s after %d attempts, want dead after 1", got.Status, got.Attempts)
+	}
+}
+
+func TestEvery(t *testing.T) {
+	q, store := newTestQueue()
+	runs := 0
+	q.Every("tick", time.Hour, func(ctx context.Context, job *models.Job) error {
+		runs++
+		return errors.New("database is down")
+	})
+	start := time.Now()
+	q.schedule(context.Background(), "tick", start)
+	q.schedule(context.Background(), "tick", start)
+	runAll(q)
+
+	if runs != 1 {
+		t.Fatalf("ran %d times, want once", runs)
+	}
+	queued, err := store.ListJobs(context.Background(), models.JobQueued, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queued) != 1 || queued[0].Kind != "tick" || queued[0].RunAt.Before(start.Add(time.Hour)) {
+		t.Fatalf("queued = %+v, want the next tick in an hour", queued)
+	}
+	dead, err := store.ListJobs(context.Background(), models.JobDead, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 1 {
+		t.Fatalf("%d dead jobs, want the failed tick, which is not retried", len(dead))
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	q := &Queue{Backoff: 30 * time.Second}
+	for _, tc := range []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{4, 4 * time.Minute},
+		{20, maxBackoff},
+	} {
+		if got := q.backoff(tc.attempts); got != tc.want {
+			t.Errorf("backoff(%d) = %v, want %v", tc.attempts, got, tc.want)
+		}
+	}
+}
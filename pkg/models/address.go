@@ -0,0 +1,45 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Address is an entry in a customer's address book. Orders keep a copy of
+// the addresses they were placed with.
+type Address struct {
+	AddressID  int64
+	UserID     string
+	Name       string
+	Line1      string
+	Line2      string
+	City       string
+	State      string
+	PostalCode string
+	// Country is an ISO 3166-1 alpha-2 code.
+	Country     string
+	Phone       string
+	DateCreated time.Time
+}
+
+// Lines formats the address the way it is printed on a label, leaving out
+// empty parts.
+func (a Address) Lines() []string {
+	locality := strings.TrimSpace(strings.Join(nonEmpty(a.City, a.State, a.PostalCode), " "))
+	return nonEmpty(a.Name, a.Line1, a.Line2, locality, a.Country)
+}
+
+// Summary is the address on one line.
+func (a Address) Summary() string {
+	return strings.Join(a.Lines(), ", ")
+}
+
+func nonEmpty(parts ...string) []string {
+	var out []string
+	for _, p := range parts {
+		if p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
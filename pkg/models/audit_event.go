@@ -0,0 +1,74 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// AuditEvent records a single change made through the admin.
+type AuditEvent struct {
+	ID         int64
+	OccurredAt time.Time
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+	// Before and After hold JSON objects with only the fields that changed.
+	// Before is empty for created entities, After for deleted ones.
+	Before string
+	After  string
+	IP     string
+}
+
+type AuditChange struct {
+	Field  string
+	Before string
+	After  string
+}
+
+// Changes lists the changed fields in a form that is easy to render.
+func (e AuditEvent) Changes() []AuditChange {
+	before, after := map[string]any{}, map[string]any{}
+	json.Unmarshal([]byte(e.Before), &before)
+	json.Unmarshal([]byte(e.After), &after)
+
+	fields := map[string]bool{}
+	for k := range before {
+		fields[k] = true
+	}
+	for k := range after {
+		fields[k] = true
+	}
+
+	var changes []AuditChange
+	for field := range fields {
+		changes = append(changes, AuditChange{
+			Field:  field,
+			Before: formatAuditValue(before[field]),
+			After:  formatAuditValue(after[field]),
+		})
+	}
+	slices.SortFunc(changes, func(a, b AuditChange) int {
+		if a.Field < b.Field {
+			return -1
+		}
+		if a.Field > b.Field {
+			return 1
+		}
+		return 0
+	})
+	return changes
+}
+
+func formatAuditValue(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
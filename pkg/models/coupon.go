@@ -0,0 +1,76 @@
+package models
+
+import (
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DiscountType string
+
+const (
+	// DiscountPercent takes Amount percent off the eligible items.
+	DiscountPercent DiscountType = "percent"
+	// DiscountFixed takes Amount off the eligible items, at most their cost.
+	DiscountFixed DiscountType = "fixed"
+)
+
+var DiscountTypes = []DiscountType{DiscountPercent, DiscountFixed}
+
+func (t DiscountType) Valid() bool {
+	return t == DiscountPercent || t == DiscountFixed
+}
+
+// Coupon is a discount code customers can apply to their cart.
+type Coupon struct {
+	CouponID     int64
+	Code         string
+	DiscountType DiscountType
+	Amount       float64
+	// MinCartValue is the cart subtotal required to use the coupon.
+	MinCartValue float64
+	// ProductIDs and Categories restrict the discount to these products and
+	// the products in these categories. Without either it applies to the
+	// whole cart.
+	ProductIDs []uuid.UUID
+	Categories []string
+	StartsAt   *time.Time
+	EndsAt     *time.Time
+	// MaxUses and MaxUsesPerCustomer limit redemptions, 0 means unlimited.
+	MaxUses            int
+	MaxUsesPerCustomer int
+	TimesUsed          int
+	Active             bool
+	DateCreated        time.Time
+}
+
+// NormalizeCouponCode makes codes case and whitespace insensitive.
+func NormalizeCouponCode(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// NormalizeCategory trims a product category. Categories are compared
+// regardless of case.
+func NormalizeCategory(category string) string {
+	return strings.Join(strings.Fields(category), " ")
+}
+
+// AppliesTo reports whether the coupon discounts the product with productID
+// in category.
+func (c Coupon) AppliesTo(productID uuid.UUID, category string) bool {
+	if len(c.ProductIDs) == 0 && len(c.Categories) == 0 {
+		return true
+	}
+	for _, id := range c.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+	for _, restricted := range c.Categories {
+		if category != "" && strings.EqualFold(restricted, category) {
+			return true
+		}
+	}
+	return false
+}
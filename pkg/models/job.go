@@ -0,0 +1,43 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+type JobStatus string
+
+const (
+	// JobQueued jobs wait for RunAt to come, including failed jobs waiting
+	// to be retried.
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobSucceeded JobStatus = "succeeded"
+	// JobDead jobs failed every attempt and are only run again by hand.
+	JobDead JobStatus = "dead"
+)
+
+var JobStatuses = []JobStatus{JobQueued, JobRunning, JobSucceeded, JobDead}
+
+func (s JobStatus) Valid() bool {
+	return slices.Contains(JobStatuses, s)
+}
+
+// Job is work done in the background. Kind tells what to do and Payload,
+// which is JSON, what to do it with.
+type Job struct {
+	JobID       int64
+	Kind        string
+	Payload     []byte
+	Status      JobStatus
+	Attempts    int
+	MaxAttempts int
+	// RunAt is when the job is due to run, or was when it last ran.
+	RunAt time.Time
+	// LockedUntil is when a running job is given up on and run again, in
+	// case its worker died.
+	LockedUntil  *time.Time
+	LastError    string
+	DateCreated  time.Time
+	DateModified time.Time
+}
@@ -1,15 +1,97 @@
 package models
 
 import (
+	"slices"
 	"time"
 
 	"github.com/google/uuid"
 )
 
+// Statuses of an order. Orders are placed pending payment and confirmed
+// once the payment is authorized.
+const (
+	OrderStatusPendingPayment = "pending_payment"
+	// OrderStatusOrdered orders have an authorized payment that is yet to
+	// be captured.
+	OrderStatusOrdered       = "ordered"
+	OrderStatusPaid          = "paid"
+	OrderStatusPaymentFailed = "payment_failed"
+	// OrderStatusDisputed orders were charged back by the customer's bank
+	// and are waiting for the dispute to be settled.
+	OrderStatusDisputed = "disputed"
+	// OrderStatusPartiallyRefunded orders had part of their payment given
+	// back, usually for returned items.
+	OrderStatusPartiallyRefunded = "partially_refunded"
+	OrderStatusRefunded          = "refunded"
+)
+
+// orderTransitions are the statuses an order can move to from each status.
+var orderTransitions = map[string][]string{
+	OrderStatusPendingPayment:    {OrderStatusOrdered, OrderStatusPaid, OrderStatusPaymentFailed},
+	OrderStatusOrdered:           {OrderStatusPaid, OrderStatusPaymentFailed, OrderStatusDisputed, OrderStatusRefunded},
+	OrderStatusPaid:              {OrderStatusDisputed, OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusDisputed:          {OrderStatusPaid, OrderStatusPartiallyRefunded, OrderStatusRefunded},
+	OrderStatusPartiallyRefunded: {OrderStatusDisputed, OrderStatusRefunded},
+}
+
+// CanTransitionOrder reports whether an order can move from one status to
+// another. Failed and refunded orders are final.
+func CanTransitionOrder(from, to string) bool {
+	return slices.Contains(orderTransitions[from], to)
+}
+
 type Order struct {
 	OrderID     uuid.UUID
 	UserID      string
 	OrderStatus string
 	OrderDate   time.Time
 	Items       []OrderItem
+	// CouponCode is the coupon redeemed with the order, if any, and Discount
+	// the amount it took off the items.
+	CouponCode string
+	Discount   float64
+	// Promotions were applied automatically and are already part of the
+	// item costs.
+	Promotions []AppliedPromotion
+	// Tax is the tax on the order. With PricesIncludeTax it is part of the
+	// item costs, otherwise it is added to them.
+	Tax              float64
+	PricesIncludeTax bool
+	// ShippingAddress and BillingAddress are copies of the addresses chosen
+	// at checkout. ShippingCost was charged for ShippingMethod, on top of
+	// the items.
+	ShippingAddress *Address
+	BillingAddress  *Address
+	ShippingMethod  string
+	ShippingCost    float64
+	// RefundedAmount is how much of the total was given back to the
+	// customer.
+	RefundedAmount float64
+}
+
+// Subtotal is the cost of the items after promotions, before the coupon.
+func (o Order) Subtotal() float64 {
+	var subtotal float64
+	for _, item := range o.Items {
+		subtotal += item.Cost
+	}
+	return subtotal
+}
+
+func (o Order) Total() float64 {
+	total := o.Subtotal() - o.Discount + o.ShippingCost
+	if o.PricesIncludeTax {
+		return total
+	}
+	return total + o.Tax
+}
+
+// NetTotal is what the customer paid after refunds.
+func (o Order) NetTotal() float64 {
+	return o.Total() - o.RefundedAmount
+}
+
+// TaxLines breaks the tax down by rate.
+func (o Order) TaxLines() []TaxLine {
+	return TaxBreakdown(o.Items)
 }
@@ -9,5 +9,19 @@ type OrderItem struct {
 	ProductID uuid.UUID
 	Quantity  int
 	Product   Product
-	Cost      float64
+	// Cost is what the line costs after promotions and Discount what they
+	// took off. Promotions holds the labels of the promotions applied.
+	Cost       float64
+	Discount   float64
+	Promotions []string
+	// Tax is charged on the line after all discounts, at TaxRate percent
+	// under TaxName. The order tells whether it is included in Cost.
+	Tax     float64
+	TaxRate float64
+	TaxName string
+}
+
+// RegularCost is the cost of the line at the product's price.
+func (i OrderItem) RegularCost() float64 {
+	return float64(i.Quantity) * i.Product.Price
 }
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EventType is a change in the store that the outbox relays to whoever
+// reacts to it.
+type EventType string
+
+const (
+	EventOrderPlaced        EventType = "order.placed"
+	EventOrderStatusChanged EventType = "order.status_changed"
+	// EventStockChanged is saved when orders, failed payments or returns
+	// move the stock of a product. Edits in the admin are product updates.
+	EventStockChanged    EventType = "stock.changed"
+	EventProductUpdated  EventType = "product.updated"
+	EventProductArchived EventType = "product.archived"
+)
+
+var EventTypes = []EventType{
+	EventOrderPlaced,
+	EventOrderStatusChanged,
+	EventStockChanged,
+	EventProductUpdated,
+	EventProductArchived,
+}
+
+func (t EventType) Valid() bool {
+	return slices.Contains(EventTypes, t)
+}
+
+// OutboxEvent is a domain event, saved in the same transaction as the
+// change it is about and published afterwards. AggregateID is the ID of the
+// order or product it is about and Payload, which is JSON, an OrderEvent or
+// a ProductEvent.
+type OutboxEvent struct {
+	EventID     int64
+	Type        EventType
+	AggregateID string
+	Payload     []byte
+	// Attempts counts the attempts to publish the event that failed.
+	Attempts int
+	// AvailableAt is when the event is due to be published, which is later
+	// than DateCreated while it waits for a retry or is being published.
+	AvailableAt time.Time
+	LastError   string
+	DateCreated time.Time
+	PublishedAt *time.Time
+}
+
+// NewOutboxEvent makes an event about aggregateID with data as its payload.
+func NewOutboxEvent(eventType EventType, aggregateID string, data any) (OutboxEvent, error) {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return OutboxEvent{}, err
+	}
+	return OutboxEvent{Type: eventType, AggregateID: aggregateID, Payload: payload}, nil
+}
+
+// Decode reads the payload of the event into v.
+func (e OutboxEvent) Decode(v any) error {
+	return json.Unmarshal(e.Payload, v)
+}
+
+// OrderEvent is the payload of order events.
+type OrderEvent struct {
+	OrderID uuid.UUID `json:"order_id"`
+	UserID  string    `json:"user_id"`
+	Status  string    `json:"status"`
+	// PreviousStatus is set on order.status_changed.
+	PreviousStatus string `json:"previous_status,omitempty"`
+}
+
+// ProductEvent is the payload of product and stock events, with the product
+// as it is after the change.
+type ProductEvent struct {
+	ProductID uuid.UUID `json:"product_id"`
+	Price     float64   `json:"price"`
+	// Stock is null for products whose stock is not tracked.
+	Stock   *int `json:"stock"`
+	Version int  `json:"version"`
+}
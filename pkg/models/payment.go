@@ -0,0 +1,67 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PaymentStatus string
+
+const (
+	PaymentAuthorized PaymentStatus = "authorized"
+	PaymentFailed     PaymentStatus = "failed"
+	PaymentCaptured   PaymentStatus = "captured"
+	// PaymentRefunded payments were refunded in full. Partial refunds keep
+	// the payment captured and add to RefundedAmount.
+	PaymentRefunded PaymentStatus = "refunded"
+)
+
+// Payment is an attempt to pay for an order. An order has one payment for
+// every time the customer tried to pay it.
+type Payment struct {
+	PaymentID int64
+	OrderID   uuid.UUID
+	// Provider is the name of the payment provider and ProviderRef its ID
+	// for the payment.
+	Provider       string
+	ProviderRef    string
+	Amount         float64
+	Currency       string
+	Status         PaymentStatus
+	CapturedAmount float64
+	RefundedAmount float64
+	// FailureReason is why the payment failed, as told by the provider.
+	FailureReason string
+	DateCreated   time.Time
+	DateModified  time.Time
+}
+
+type PaymentEventStatus string
+
+const (
+	PaymentEventReceived  PaymentEventStatus = "received"
+	PaymentEventProcessed PaymentEventStatus = "processed"
+	// PaymentEventIgnored events are about nothing the store acts on.
+	PaymentEventIgnored PaymentEventStatus = "ignored"
+	// PaymentEventFailed events could not be processed and are left to be
+	// replayed.
+	PaymentEventFailed PaymentEventStatus = "failed"
+)
+
+// PaymentEvent is a webhook received from a payment provider. Events are
+// stored before they are processed, so that an event the provider sends
+// again is recognized by its ProviderEventID and processed only once.
+type PaymentEvent struct {
+	PaymentEventID  int64
+	Provider        string
+	ProviderEventID string
+	// EventType is the provider's name for the event.
+	EventType   string
+	Payload     []byte
+	Status      PaymentEventStatus
+	Error       string
+	Attempts    int
+	ReceivedAt  time.Time
+	ProcessedAt *time.Time
+}
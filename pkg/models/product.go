@@ -6,12 +6,88 @@ import (
 	"github.com/google/uuid"
 )
 
+// ProductStatus controls where a product shows up in the store.
+type ProductStatus string
+
+const (
+	// StatusDraft products are only visible in the admin.
+	StatusDraft ProductStatus = "draft"
+	// StatusPublished products are listed in the store while inside their
+	// publish window.
+	StatusPublished ProductStatus = "published"
+	// StatusUnlisted products can be bought but are not listed in the store.
+	StatusUnlisted ProductStatus = "unlisted"
+)
+
+var ProductStatuses = []ProductStatus{StatusDraft, StatusPublished, StatusUnlisted}
+
+func (s ProductStatus) Valid() bool {
+	switch s {
+	case StatusDraft, StatusPublished, StatusUnlisted:
+		return true
+	}
+	return false
+}
+
 type Product struct {
 	ProductID    uuid.UUID
 	ProductName  string
 	Price        float64
 	Description  string
 	ProductImage string
+	Status       ProductStatus
+	PublishAt    *time.Time
+	UnpublishAt  *time.Time
+	TaxClass     TaxClass
+	// Category groups products, for coupons to be restricted to. Empty
+	// means the product is in no category.
+	Category string
+	// Weight is in kilograms and prices weight-based shipping.
+	Weight float64
+	// Stock is the number of units on hand. Nil means stock is not tracked
+	// and the product never runs out.
+	Stock *int
+	// Version is incremented on every update and guards against concurrent
+	// edits overwriting each other.
+	Version      int
 	DateCreated  time.Time
 	DateModified time.Time
+	DeletedAt    *time.Time
+}
+
+// Archived reports whether the product was removed from the store. Archived
+// products are still shown on the orders that reference them.
+func (p Product) Archived() bool {
+	return p.DeletedAt != nil
+}
+
+// InPublishWindow reports whether now is between the optional publish-at and
+// unpublish-at times.
+func (p Product) InPublishWindow(now time.Time) bool {
+	if p.PublishAt != nil && now.Before(*p.PublishAt) {
+		return false
+	}
+	if p.UnpublishAt != nil && !now.Before(*p.UnpublishAt) {
+		return false
+	}
+	return true
+}
+
+// Listed reports whether the product is shown in the store at now.
+func (p Product) Listed(now time.Time) bool {
+	return !p.Archived() && p.Status == StatusPublished && p.InPublishWindow(now)
+}
+
+// Purchasable reports whether the product can be added to a cart at now.
+// Unlike Listed it includes unlisted products.
+func (p Product) Purchasable(now time.Time) bool {
+	if p.Archived() || !p.InPublishWindow(now) {
+		return false
+	}
+	return p.Status == StatusPublished || p.Status == StatusUnlisted
+}
+
+// InStock reports whether there are units left to sell.
+func (p Product) InStock() bool {
+	return p.Stock == nil || *p.Stock > 0
 }
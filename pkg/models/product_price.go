@@ -0,0 +1,24 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ProductPrice is a price a product had or is scheduled to have from
+// EffectiveAt on.
+type ProductPrice struct {
+	ID          int64
+	ProductID   uuid.UUID
+	Price       float64
+	EffectiveAt time.Time
+	// AppliedAt is nil while the price is scheduled and set once it was
+	// written to the product.
+	AppliedAt *time.Time
+	CreatedAt time.Time
+}
+
+func (p ProductPrice) Scheduled() bool {
+	return p.AppliedAt == nil
+}
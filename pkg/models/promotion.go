@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type PromotionKind string
+
+const (
+	// PromotionBuyXGetY makes FreeQuantity of every BuyQuantity +
+	// FreeQuantity units of a product free.
+	PromotionBuyXGetY PromotionKind = "buy_x_get_y"
+	// PromotionSpendThreshold takes Percent off the eligible items once the
+	// cart reaches MinSubtotal.
+	PromotionSpendThreshold PromotionKind = "spend_threshold"
+	// PromotionBundle sells one of each of ProductIDs together for
+	// BundlePrice.
+	PromotionBundle PromotionKind = "bundle"
+)
+
+var PromotionKinds = []PromotionKind{PromotionBuyXGetY, PromotionSpendThreshold, PromotionBundle}
+
+func (k PromotionKind) Valid() bool {
+	switch k {
+	case PromotionBuyXGetY, PromotionSpendThreshold, PromotionBundle:
+		return true
+	}
+	return false
+}
+
+// Promotion is a discount applied to the cart automatically when its
+// conditions are met.
+type Promotion struct {
+	PromotionID int64
+	// Name is the label shown to customers when the promotion applies.
+	Name string
+	Kind PromotionKind
+	// ProductIDs are the products the promotion applies to, all products
+	// when empty. For bundles they are the products in the bundle.
+	ProductIDs   []uuid.UUID
+	BuyQuantity  int
+	FreeQuantity int
+	MinSubtotal  float64
+	Percent      float64
+	BundlePrice  float64
+	// Promotions are applied in ascending Priority. Stackable promotions can
+	// discount items another promotion already discounted; the others only
+	// apply to undiscounted items and keep later promotions off theirs.
+	Priority    int
+	Stackable   bool
+	StartsAt    *time.Time
+	EndsAt      *time.Time
+	Active      bool
+	DateCreated time.Time
+}
+
+// Covers reports whether the promotion applies to the given product.
+func (p Promotion) Covers(productID uuid.UUID) bool {
+	if len(p.ProductIDs) == 0 {
+		return true
+	}
+	for _, id := range p.ProductIDs {
+		if id == productID {
+			return true
+		}
+	}
+	return false
+}
+
+// Running reports whether the promotion is active and inside its window.
+func (p Promotion) Running(now time.Time) bool {
+	if !p.Active {
+		return false
+	}
+	if p.StartsAt != nil && now.Before(*p.StartsAt) {
+		return false
+	}
+	return p.EndsAt == nil || now.Before(*p.EndsAt)
+}
+
+// AppliedPromotion is a promotion as it was applied to a cart or order.
+type AppliedPromotion struct {
+	PromotionID int64
+	Label       string
+	Discount    float64
+}
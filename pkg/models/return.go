@@ -0,0 +1,82 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ReturnStatus tracks a return from the customer's request to the refund.
+type ReturnStatus string
+
+const (
+	ReturnRequested ReturnStatus = "requested"
+	ReturnApproved  ReturnStatus = "approved"
+	ReturnRejected  ReturnStatus = "rejected"
+	// ReturnReceived returns had their items arrive back and put in stock,
+	// and are waiting for the refund.
+	ReturnReceived ReturnStatus = "received"
+	// ReturnRefunding returns are being refunded by the payment provider.
+	// Only one refund can claim a return, and a failed one gives it back.
+	ReturnRefunding ReturnStatus = "refunding"
+	ReturnRefunded  ReturnStatus = "refunded"
+)
+
+var ReturnStatuses = []ReturnStatus{ReturnRequested, ReturnApproved, ReturnRejected, ReturnReceived, ReturnRefunding, ReturnRefunded}
+
+func (s ReturnStatus) Valid() bool {
+	return slices.Contains(ReturnStatuses, s)
+}
+
+// returnTransitions are the statuses a return can move to from each status.
+var returnTransitions = map[ReturnStatus][]ReturnStatus{
+	ReturnRequested: {ReturnApproved, ReturnRejected},
+	ReturnApproved:  {ReturnReceived},
+	ReturnReceived:  {ReturnRefunding},
+	ReturnRefunding: {ReturnRefunded, ReturnReceived},
+}
+
+// CanTransitionReturn reports whether a return can move from one status to
+// another. Rejected and refunded returns are final.
+func CanTransitionReturn(from, to ReturnStatus) bool {
+	return slices.Contains(returnTransitions[from], to)
+}
+
+// Return is a customer's request to send back some of the items of an
+// order.
+type Return struct {
+	ReturnID int64
+	OrderID  uuid.UUID
+	UserID   string
+	Status   ReturnStatus
+	Items    []ReturnItem
+	// Reason is the customer's and Note the admin's, usually explaining a
+	// rejection.
+	Reason string
+	Note   string
+	// RefundAmount was given back to the customer under RefundRef, the
+	// payment provider's ID for the refund.
+	RefundAmount float64
+	RefundRef    string
+	DateCreated  time.Time
+	DateModified time.Time
+}
+
+// ItemsAmount is what the returned items were paid.
+func (r Return) ItemsAmount() float64 {
+	var amount float64
+	for _, item := range r.Items {
+		amount += item.Amount
+	}
+	return amount
+}
+
+type ReturnItem struct {
+	ProductID   uuid.UUID
+	ProductName string
+	Quantity    int
+	// Amount is what Quantity units were paid, after discounts and with
+	// tax.
+	Amount float64
+}
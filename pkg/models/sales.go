@@ -0,0 +1,62 @@
+package models
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SalesStatuses are the statuses of orders that count as sales. Orders
+// waiting for their payment or whose payment failed do not.
+var SalesStatuses = []string{
+	OrderStatusOrdered,
+	OrderStatusPaid,
+	OrderStatusDisputed,
+	OrderStatusPartiallyRefunded,
+	OrderStatusRefunded,
+}
+
+// IsSale reports whether orders with status count as sales.
+func IsSale(status string) bool {
+	return slices.Contains(SalesStatuses, status)
+}
+
+// SalesSummary sums up the sales of a period. Revenue is what the orders
+// were paid, with shipping and tax, net of refunds.
+type SalesSummary struct {
+	Orders  int
+	Revenue float64
+}
+
+// AverageOrderValue is the revenue per order, 0 without orders.
+func (s SalesSummary) AverageOrderValue() float64 {
+	if s.Orders == 0 {
+		return 0
+	}
+	return s.Revenue / float64(s.Orders)
+}
+
+// DailySales are the sales of the day starting at Day.
+type DailySales struct {
+	Day time.Time
+	SalesSummary
+}
+
+// ProductSales are the units of a product sold in a period and what they
+// cost after promotions.
+type ProductSales struct {
+	ProductID   uuid.UUID
+	ProductName string
+	Quantity    int
+	Revenue     float64
+}
+
+// StockAlert is a product running out of stock, with the units of it sold
+// in a period.
+type StockAlert struct {
+	ProductID   uuid.UUID
+	ProductName string
+	Stock       int
+	Sold        int
+}
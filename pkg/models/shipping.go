@@ -0,0 +1,37 @@
+package models
+
+import "time"
+
+// ShippingKind selects how a shipping method is priced.
+type ShippingKind string
+
+const (
+	// ShippingFlat methods cost Price whatever the cart holds.
+	ShippingFlat ShippingKind = "flat"
+	// ShippingWeight methods cost Price plus PerKg for every kilogram the
+	// cart weighs.
+	ShippingWeight ShippingKind = "weight"
+)
+
+var ShippingKinds = []ShippingKind{ShippingFlat, ShippingWeight}
+
+func (k ShippingKind) Valid() bool {
+	switch k {
+	case ShippingFlat, ShippingWeight:
+		return true
+	}
+	return false
+}
+
+type ShippingMethod struct {
+	ShippingMethodID int64
+	Name             string
+	Kind             ShippingKind
+	Price            float64
+	PerKg            float64
+	// FreeOver, when set, makes carts whose subtotal reaches it ship for
+	// free.
+	FreeOver    *float64
+	Active      bool
+	DateCreated time.Time
+}
@@ -0,0 +1,78 @@
+package models
+
+import (
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// TaxClass groups products that are taxed at the same rate.
+type TaxClass string
+
+const (
+	TaxClassStandard TaxClass = "standard"
+	TaxClassReduced  TaxClass = "reduced"
+	// TaxClassExempt products are never taxed.
+	TaxClassExempt TaxClass = "exempt"
+)
+
+var TaxClasses = []TaxClass{TaxClassStandard, TaxClassReduced, TaxClassExempt}
+
+func (c TaxClass) Valid() bool {
+	switch c {
+	case TaxClassStandard, TaxClassReduced, TaxClassExempt:
+		return true
+	}
+	return false
+}
+
+// TaxRate is the rate charged on a tax class in a country, or in a state of
+// it when State is set.
+type TaxRate struct {
+	TaxRateID int64
+	// Country is an ISO 3166-1 alpha-2 code.
+	Country  string
+	State    string
+	TaxClass TaxClass
+	// Name is shown in the tax breakdown, like "VAT" or "Sales tax".
+	Name string
+	// Rate is a percentage.
+	Rate float64
+}
+
+// NormalizeRegion puts country and state codes in the form they are stored
+// in.
+func NormalizeRegion(code string) string {
+	return strings.ToUpper(strings.TrimSpace(code))
+}
+
+// TaxLine is the tax charged at one rate on a cart or order.
+type TaxLine struct {
+	Name   string
+	Rate   float64
+	Amount float64
+}
+
+// Label names the line the way it is shown in the breakdown.
+func (l TaxLine) Label() string {
+	return l.Name + " " + strconv.FormatFloat(l.Rate, 'f', -1, 64) + "%"
+}
+
+// TaxBreakdown sums the tax of items by rate, in the order the rates first
+// appear.
+func TaxBreakdown(items []OrderItem) []TaxLine {
+	var lines []TaxLine
+	for _, item := range items {
+		if item.Tax == 0 {
+			continue
+		}
+		i := slices.IndexFunc(lines, func(l TaxLine) bool { return l.Name == item.TaxName && l.Rate == item.TaxRate })
+		if i < 0 {
+			lines = append(lines, TaxLine{Name: item.TaxName, Rate: item.TaxRate})
+			i = len(lines) - 1
+		}
+		lines[i].Amount = math.Round((lines[i].Amount+item.Tax)*100) / 100
+	}
+	return lines
+}
@@ -0,0 +1,82 @@
+package models
+
+import (
+	"slices"
+	"time"
+)
+
+// WebhookEvent is a change in the store that webhook subscriptions are sent.
+type WebhookEvent string
+
+const (
+	WebhookOrderCreated       WebhookEvent = "order.created"
+	WebhookOrderStatusChanged WebhookEvent = "order.status_changed"
+	WebhookProductUpdated     WebhookEvent = "product.updated"
+	// WebhookProductDeleted is sent when a product is archived, which takes
+	// it out of the store.
+	WebhookProductDeleted WebhookEvent = "product.deleted"
+)
+
+var WebhookEvents = []WebhookEvent{
+	WebhookOrderCreated,
+	WebhookOrderStatusChanged,
+	WebhookProductUpdated,
+	WebhookProductDeleted,
+}
+
+func (e WebhookEvent) Valid() bool {
+	return slices.Contains(WebhookEvents, e)
+}
+
+// WebhookSubscription sends the events in Events to URL, signed with
+// Secret, while it is active.
+type WebhookSubscription struct {
+	SubscriptionID int64
+	URL            string
+	Events         []WebhookEvent
+	Secret         string
+	Active         bool
+	DateCreated    time.Time
+}
+
+// Subscribes reports whether the subscription is sent event.
+func (s WebhookSubscription) Subscribes(event WebhookEvent) bool {
+	return slices.Contains(s.Events, event)
+}
+
+type WebhookDeliveryStatus string
+
+const (
+	// WebhookDeliveryPending deliveries are yet to be sent, including ones
+	// whose last attempt failed and that are waiting to be retried.
+	WebhookDeliveryPending   WebhookDeliveryStatus = "pending"
+	WebhookDeliveryDelivered WebhookDeliveryStatus = "delivered"
+	// WebhookDeliveryFailed deliveries failed every attempt and are only
+	// sent again by hand.
+	WebhookDeliveryFailed WebhookDeliveryStatus = "failed"
+)
+
+var WebhookDeliveryStatuses = []WebhookDeliveryStatus{WebhookDeliveryPending, WebhookDeliveryDelivered, WebhookDeliveryFailed}
+
+func (s WebhookDeliveryStatus) Valid() bool {
+	return slices.Contains(WebhookDeliveryStatuses, s)
+}
+
+// WebhookDelivery is an event sent to a subscription. Payload is the JSON
+// body, which stays the same when the event is sent again. ResponseStatus
+// is the HTTP status the last attempt got, 0 when it got none.
+type WebhookDelivery struct {
+	DeliveryID     int64
+	SubscriptionID int64
+	// URL is the URL of the subscription, filled in when listing.
+	URL            string
+	EventID        string
+	Event          WebhookEvent
+	Payload        []byte
+	Status         WebhookDeliveryStatus
+	Attempts       int
+	ResponseStatus int
+	LastError      string
+	DateCreated    time.Time
+	DeliveredAt    *time.Time
+}
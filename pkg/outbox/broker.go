@@ -0,0 +1,96 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// Publisher publishes messages to the subjects of a message broker. A NATS
+// connection is one as it is; for Kafka, wrap a producer that writes to the
+// subject as the topic.
+type Publisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// Message is the body of the messages BrokerSink publishes.
+type Message struct {
+	ID          int64            `json:"id"`
+	Type        models.EventType `json:"type"`
+	AggregateID string           `json:"aggregate_id"`
+	CreatedAt   time.Time        `json:"created_at"`
+	Data        json.RawMessage  `json:"data"`
+}
+
+// BrokerSink publishes events to a message broker, each under the subject
+// "<Prefix>.<event type>", like "mxstore.order.placed".
+type BrokerSink struct {
+	Publisher Publisher
+	Prefix    string
+}
+
+func (s BrokerSink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	data, err := json.Marshal(Message{
+		ID:          event.EventID,
+		Type:        event.Type,
+		AggregateID: event.AggregateID,
+		CreatedAt:   event.DateCreated.UTC(),
+		Data:        event.Payload,
+	})
+	if err != nil {
+		return err
+	}
+	return s.Publisher.Publish(s.Prefix+"."+string(event.Type), data)
+}
+
+// FileBroker is a local stand-in for a message broker. It appends every
+// message to a file as a JSON line with its subject, like the log of a
+// Kafka topic, to be tailed or read back.
+type FileBroker struct {
+	mu sync.Mutex
+	f  *os.File
+}
+
+// fileMessage is a line of a FileBroker file.
+type fileMessage struct {
+	Subject string          `json:"subject"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// OpenFileBroker opens the file at path for appending, creating it if
+// needed.
+func OpenFileBroker(path string) (*FileBroker, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	return &FileBroker{f: f}, nil
+}
+
+// Publish appends a message and syncs the file, so that a published message
+// survives a crash. Data that is not JSON is written as a JSON string.
+func (b *FileBroker) Publish(subject string, data []byte) error {
+	msg := fileMessage{Subject: subject, Data: data}
+	if !json.Valid(data) {
+		msg.Data, _ = json.Marshal(string(data))
+	}
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, err := b.f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	return b.f.Sync()
+}
+
+func (b *FileBroker) Close() error {
+	return b.f.Close()
+}
@@ -0,0 +1,53 @@
+package outbox
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// Handler reacts to an event in the process.
+type Handler func(ctx context.Context, event models.OutboxEvent) error
+
+type subscription struct {
+	types   []models.EventType
+	handler Handler
+}
+
+// Bus is a Sink that hands events to the subscribers in the process. The
+// zero value is ready to use.
+type Bus struct {
+	mu   sync.RWMutex
+	subs []subscription
+}
+
+// Subscribe calls h with every event of types, or with every event when no
+// types are given. Handlers are called one after the other on the relay's
+// goroutine, so they should be quick. When one fails the event is published
+// to the bus again, and so to all of its subscribers.
+func (b *Bus) Subscribe(h Handler, types ...models.EventType) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs = append(b.subs, subscription{types: types, handler: h})
+}
+
+func (b *Bus) Publish(ctx context.Context, event models.OutboxEvent) error {
+	b.mu.RLock()
+	subs := slices.Clone(b.subs)
+	b.mu.RUnlock()
+
+	var errs []error
+	for _, sub := range subs {
+		if len(sub.types) > 0 && !slices.Contains(sub.types, event.Type) {
+			continue
+		}
+		if err := sub.handler(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,204 @@
+// Package outbox relays the domain events that the repositories save in the
+// outbox, in the same transaction as the changes they are about, to the
+// sinks that react to them.
+//
+// Every event reaches every sink at least once. An event that a sink fails
+// is published again later, to the sinks that failed it, until all of them
+// took it. A sink may still be given an event twice, like when the process
+// stops while publishing it, so sinks tell events apart by their ID.
+package outbox
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/jobs"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+// PurgeJob deletes the published events that are older than the retention.
+const PurgeJob = "outbox.purge"
+
+// maxBackoff caps the wait before publishing a failed event again.
+const maxBackoff = time.Hour
+
+// claimTimeout is how long the relay has to publish a claimed batch before
+// another relay may claim its events again.
+const claimTimeout = 5 * time.Minute
+
+// sinkTimeout bounds the publishing of a single event to a sink.
+const sinkTimeout = 30 * time.Second
+
+// Sink is where the relay publishes events. Returning an error makes the
+// relay publish the event to the sink again later.
+type Sink interface {
+	Publish(ctx context.Context, event models.OutboxEvent) error
+}
+
+// SinkFunc makes a function a Sink.
+type SinkFunc func(ctx context.Context, event models.OutboxEvent) error
+
+func (f SinkFunc) Publish(ctx context.Context, event models.OutboxEvent) error {
+	return f(ctx, event)
+}
+
+type namedSink struct {
+	name string
+	sink Sink
+}
+
+// Relay publishes the events of an OutboxStore to its sinks, oldest first.
+// Sinks have to be added before Run.
+type Relay struct {
+	Store        repository.OutboxStore
+	PollInterval time.Duration
+	BatchSize    int
+	Backoff      time.Duration
+	Retention    time.Duration
+
+	sinks []namedSink
+
+	mu sync.Mutex
+	// published has the sinks that took each event that failed in another
+	// sink, so that only the failed sinks are given it again.
+	published map[int64]map[string]bool
+}
+
+func New(store repository.OutboxStore, cfg config.Outbox) *Relay {
+	return &Relay{
+		Store:        store,
+		PollInterval: cfg.PollInterval,
+		BatchSize:    cfg.BatchSize,
+		Backoff:      cfg.RetryBackoff,
+		Retention:    cfg.Retention,
+		published:    map[int64]map[string]bool{},
+	}
+}
+
+// Add makes the relay publish every event to sink. The name identifies the
+// sink in logs and errors.
+func (r *Relay) Add(name string, sink Sink) {
+	r.sinks = append(r.sinks, namedSink{name: name, sink: sink})
+}
+
+// Schedule makes q delete the published events older than Retention every
+// hour.
+func (r *Relay) Schedule(q *jobs.Queue) {
+	q.Every(PurgeJob, time.Hour, func(ctx context.Context, job *models.Job) error {
+		purged, err := r.Store.PurgeOutboxEvents(ctx, time.Now().Add(-r.Retention))
+		if err != nil {
+			return err
+		}
+		if purged > 0 {
+			slog.Info("Purged published events", "count", purged)
+		}
+		return nil
+	})
+}
+
+// Run publishes due events every PollInterval until ctx is cancelled. Events
+// that are being published then are finished before it returns.
+func (r *Relay) Run(ctx context.Context) {
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for ctx.Err() == nil {
+		claimed, err := r.PublishDue(context.WithoutCancel(ctx))
+		if err != nil && ctx.Err() == nil {
+			slog.Error("Publishing events", "err", err)
+		}
+		if claimed == r.BatchSize {
+			// There may be more waiting
+			continue
+		}
+		select {
+		case <-ctx.Done():
+		case <-ticker.C:
+		}
+	}
+}
+
+// PublishDue claims a batch of due events and publishes them. It returns
+// how many events it claimed.
+func (r *Relay) PublishDue(ctx context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	events, err := r.Store.ClaimOutboxEvents(ctx, now, now.Add(claimTimeout), r.BatchSize)
+	if err != nil {
+		return 0, err
+	}
+	var errs []error
+	for _, event := range events {
+		if err := r.publish(ctx, event); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return len(events), errors.Join(errs...)
+}
+
+// publish gives a claimed event to the sinks that did not take it yet and
+// records the outcome.
+func (r *Relay) publish(ctx context.Context, event models.OutboxEvent) error {
+	published := r.published[event.EventID]
+	if published == nil {
+		published = map[string]bool{}
+	}
+	var errs []error
+	for _, s := range r.sinks {
+		if published[s.name] {
+			continue
+		}
+		if err := r.call(ctx, s.sink, event); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", s.name, err))
+			continue
+		}
+		published[s.name] = true
+	}
+
+	if len(errs) == 0 {
+		delete(r.published, event.EventID)
+		return r.Store.MarkOutboxEventPublished(ctx, event.EventID, time.Now())
+	}
+	r.published[event.EventID] = published
+	err := errors.Join(errs...)
+	retryIn := r.backoff(event.Attempts + 1)
+	slog.Warn("Publishing event failed, retrying", "event", event.EventID, "type", event.Type, "attempt", event.Attempts+1, "retry_in", retryIn, "err", err)
+	if retryErr := r.Store.RetryOutboxEvent(ctx, event.EventID, time.Now().Add(retryIn), err.Error()); retryErr != nil {
+		return errors.Join(err, retryErr)
+	}
+	return nil
+}
+
+// call publishes an event to a sink within sinkTimeout, turning a panic
+// into an error.
+func (r *Relay) call(ctx context.Context, sink Sink, event models.OutboxEvent) (err error) {
+	ctx, cancel := context.WithTimeout(ctx, sinkTimeout)
+	defer cancel()
+	defer func() {
+		if rec := recover(); rec != nil {
+			err = fmt.Errorf("panic: %v", rec)
+		}
+	}()
+	return sink.Publish(ctx, event)
+}
+
+// backoff is how long to wait before publishing an event that failed
+// attempts times again.
+func (r *Relay) backoff(attempts int) time.Duration {
+	d := r.Backoff
+	for range attempts - 1 {
+		d *= 2
+		if d >= maxBackoff {
+			return maxBackoff
+		}
+	}
+	return d
+}
@@ -0,0 +1,223 @@
+package outbox
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+func newTestRelay() (*Relay, *repository.Repository) {
+	repo := repository.NewMemoryRepository()
+	cfg := config.Default().Outbox
+	cfg.RetryBackoff = time.Nanosecond
+	return New(repo.Outbox, cfg), repo
+}
+
+// updateProduct saves a product and updates it, which saves a
+// product.updated event.
+func updateProduct(t *testing.T, repo *repository.Repository) models.Product {
+	t.Helper()
+	ctx := context.Background()
+	product := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair"}
+	if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	product.Price = 25
+	if err := repo.Product.UpdateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	return product
+}
+
+// recorder is a sink that fails the first failures events it is given.
+type recorder struct {
+	failures  int
+	panics    bool
+	published []models.OutboxEvent
+}
+
+func (rc *recorder) Publish(ctx context.Context, event models.OutboxEvent) error {
+	rc.published = append(rc.published, event)
+	if len(rc.published) > rc.failures {
+		return nil
+	}
+	if rc.panics {
+		panic("boom")
+	}
+	return errors.New("connection refused")
+}
+
+func TestPublishDue(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		panics       bool
+		wantAttempts int
+	}{
+		{"published first time", 0, false, 0},
+		{"published on a retry", 2, false, 2},
+		{"sink panics", 1, true, 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			relay, repo := newTestRelay()
+			healthy, failing := &recorder{}, &recorder{failures: tc.failures, panics: tc.panics}
+			relay.Add("healthy", healthy)
+			relay.Add("failing", failing)
+			product := updateProduct(t, repo)
+
+			for range tc.failures + 1 {
+				if _, err := relay.PublishDue(context.Background()); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if len(healthy.published) != 1 || len(failing.published) != tc.failures+1 {
+				t.Fatalf("published %d and %d times, want once to the healthy sink and %d times to the failing one",
+					len(healthy.published), len(failing.published), tc.failures+1)
+			}
+			event := healthy.published[0]
+			var data models.ProductEvent
+			if err := event.Decode(&data); err != nil {
+				t.Fatal(err)
+			}
+			if event.Type != models.EventProductUpdated || data.ProductID != product.ProductID || data.Price != 25 {
+				t.Fatalf("event = %+v with %+v, want the product update", event, data)
+			}
+			got, err := repo.Outbox.GetOutboxEvent(context.Background(), event.EventID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.PublishedAt == nil || got.Attempts != tc.wantAttempts || got.LastError != "" {
+				t.Fatalf("event = %+v, want published after %d failed attempts", got, tc.wantAttempts)
+			}
+			if claimed, _ := relay.PublishDue(context.Background()); claimed != 0 {
+				t.Fatalf("claimed %d events, want none left", claimed)
+			}
+		})
+	}
+}
+
+func TestPublishDueWaitsForRetry(t *testing.T) {
+	relay, repo := newTestRelay()
+	relay.Backoff = time.Hour
+	relay.Add("failing", &recorder{failures: 1})
+	updateProduct(t, repo)
+
+	if _, err := relay.PublishDue(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if claimed, _ := relay.PublishDue(context.Background()); claimed != 0 {
+		t.Fatalf("claimed %d events, want the failed one to wait for its retry", claimed)
+	}
+	got, err := repo.Outbox.GetOutboxEvent(context.Background(), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.PublishedAt != nil || got.Attempts != 1 || got.LastError != "failing: connection refused" ||
+		got.AvailableAt.Before(time.Now().Add(59*time.Minute)) {
+		t.Fatalf("event = %+v, want it retried in an hour", got)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	relay := &Relay{Backoff: 5 * time.Second}
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, 5 * time.Second},
+		{2, 10 * time.Second},
+		{4, 40 * time.Second},
+		{20, maxBackoff},
+	}
+	for _, tc := range tests {
+		if got := relay.backoff(tc.attempts); got != tc.want {
+			t.Errorf("backoff(%d) = %v, want %v", tc.attempts, got, tc.want)
+		}
+	}
+}
+
+func TestBus(t *testing.T) {
+	bus := &Bus{}
+	var all, stock []models.EventType
+	bus.Subscribe(func(ctx context.Context, event models.OutboxEvent) error {
+		all = append(all, event.Type)
+		return nil
+	})
+	bus.Subscribe(func(ctx context.Context, event models.OutboxEvent) error {
+		stock = append(stock, event.Type)
+		return errors.New("busy")
+	}, models.EventStockChanged)
+
+	if err := bus.Publish(context.Background(), models.OutboxEvent{Type: models.EventProductUpdated}); err != nil {
+		t.Fatalf("publishing an event nobody failed: %v", err)
+	}
+	if err := bus.Publish(context.Background(), models.OutboxEvent{Type: models.EventStockChanged}); err == nil {
+		t.Fatal("a failed subscriber does not fail the event")
+	}
+	if len(all) != 2 || len(stock) != 1 || stock[0] != models.EventStockChanged {
+		t.Fatalf("subscribers were given %v and %v", all, stock)
+	}
+}
+
+func TestFileBroker(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	broker, err := OpenFileBroker(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink := BrokerSink{Publisher: broker, Prefix: "mxstore"}
+	event, err := models.NewOutboxEvent(models.EventOrderPlaced, "order-1", models.OrderEvent{UserID: "fk@htmxrocks.com", Status: models.OrderStatusOrdered})
+	if err != nil {
+		t.Fatal(err)
+	}
+	event.EventID = 7
+	if err := sink.Publish(context.Background(), event); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Publish("raw", []byte("not json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := broker.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var lines []fileMessage
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var line fileMessage
+		if err := json.Unmarshal(scanner.Bytes(), &line); err != nil {
+			t.Fatalf("line %q: %v", scanner.Text(), err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[0].Subject != "mxstore.order.placed" || lines[1].Subject != "raw" || string(lines[1].Data) != `"not json"` {
+		t.Fatalf("lines = %+v", lines)
+	}
+	var msg Message
+	if err := json.Unmarshal(lines[0].Data, &msg); err != nil {
+		t.Fatal(err)
+	}
+	var data models.OrderEvent
+	if err := json.Unmarshal(msg.Data, &data); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 7 || msg.Type != models.EventOrderPlaced || msg.AggregateID != "order-1" || data.Status != models.OrderStatusOrdered {
+		t.Fatalf("message = %+v with %+v", msg, data)
+	}
+}
@@ -0,0 +1,177 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// FakeSignatureHeader carries the signature of fake webhooks.
+const FakeSignatureHeader = "Fake-Signature"
+
+// fakeDeclines are the TestCards the fake provider declines, with the reason.
+var fakeDeclines = map[string]string{
+	"pm_card_chargeDeclined":                  "Your card was declined.",
+	"pm_card_chargeDeclinedInsufficientFunds": "Your card has insufficient funds.",
+}
+
+// Fake is a payment provider that keeps payments in memory. It declines the
+// declining TestCards and a missing payment method and approves everything
+// else. Its webhooks are signed like Stripe's, and SignEvent makes them for
+// trying out the webhook handling locally.
+type Fake struct {
+	WebhookSecret string
+
+	mu       sync.Mutex
+	payments map[string]*fakePayment
+}
+
+// fakePayment amounts are in cents.
+type fakePayment struct {
+	status     models.PaymentStatus
+	authorized int64
+	captured   int64
+	refunded   int64
+	refunds    int
+	// refundIDs are the IDs of the refunds made, by their keys.
+	refundIDs map[string]string
+}
+
+func NewFake(webhookSecret string) *Fake {
+	return &Fake{WebhookSecret: webhookSecret, payments: make(map[string]*fakePayment)}
+}
+
+func (f *Fake) Name() string { return "fake" }
+
+func (f *Fake) Authorize(ctx context.Context, auth Authorization) (*Charge, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	ref := fmt.Sprintf("fake_pi_%d", len(f.payments)+1)
+	payment := &fakePayment{status: models.PaymentAuthorized, authorized: cents(auth.Amount)}
+	charge := &Charge{Ref: ref, Status: models.PaymentAuthorized}
+	if reason, ok := fakeDeclines[auth.PaymentMethod]; ok || auth.PaymentMethod == "" {
+		if !ok {
+			reason = "No payment method was given."
+		}
+		payment.status = models.PaymentFailed
+		charge.Status = models.PaymentFailed
+		charge.FailureReason = reason
+	}
+	f.payments[ref] = payment
+	return charge, nil
+}
+
+func (f *Fake) Capture(ctx context.Context, ref string, amount float64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[ref]
+	if !ok {
+		return ErrUnknownPayment
+	}
+	if payment.status != models.PaymentAuthorized {
+		return fmt.Errorf("fake: cannot capture a %s payment", payment.status)
+	}
+	if cents(amount) > payment.authorized {
+		return fmt.Errorf("fake: cannot capture %.2f of %.2f authorized", amount, fromCents(payment.authorized))
+	}
+	payment.status = models.PaymentCaptured
+	payment.captured = cents(amount)
+	return nil
+}
+
+func (f *Fake) Refund(ctx context.Context, ref string, amount float64, key string) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	payment, ok := f.payments[ref]
+	if !ok {
+		return "", ErrUnknownPayment
+	}
+	if id, ok := payment.refundIDs[key]; ok && key != "" {
+		return id, nil
+	}
+	if payment.status != models.PaymentCaptured {
+		return "", fmt.Errorf("fake: cannot refund a %s payment", payment.status)
+	}
+	if payment.refunded+cents(amount) > payment.captured {
+		return "", fmt.Errorf("fake: cannot refund %.2f, only %.2f is left", amount, fromCents(payment.captured-payment.refunded))
+	}
+	payment.refunded += cents(amount)
+	if payment.refunded == payment.captured {
+		payment.status = models.PaymentRefunded
+	}
+	payment.refunds++
+	id := fmt.Sprintf("fake_re_%s_%d", ref, payment.refunds)
+	if key != "" {
+		if payment.refundIDs == nil {
+			payment.refundIDs = make(map[string]string)
+		}
+		payment.refundIDs[key] = id
+	}
+	return id, nil
+}
+
+// fakeEvent is the payload of fake webhooks.
+type fakeEvent struct {
+	ID         string    `json:"id"`
+	Type       EventType `json:"type"`
+	PaymentRef string    `json:"payment_ref"`
+	Amount     float64   `json:"amount"`
+	Reason     string    `json:"reason,omitempty"`
+}
+
+func (f *Fake) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := Verify(f.WebhookSecret, payload, header.Get(FakeSignatureHeader), time.Now()); err != nil {
+		return nil, err
+	}
+	return f.ParseWebhook(payload)
+}
+
+func (f *Fake) ParseWebhook(payload []byte) (*Event, error) {
+	var e fakeEvent
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("fake: parse webhook: %w", err)
+	}
+	event := &Event{ID: e.ID, Type: e.Type, Name: string(e.Type), PaymentRef: e.PaymentRef, Amount: e.Amount, Reason: e.Reason}
+	switch e.Type {
+	case EventCaptured, EventFailed, EventDisputed, EventRefunded:
+	default:
+		event.Type = ""
+	}
+	return event, nil
+}
+
+// SignEvent returns the payload and headers of a webhook for event, as the
+// fake provider would send it.
+func (f *Fake) SignEvent(event Event) ([]byte, http.Header, error) {
+	payload, err := json.Marshal(fakeEvent{
+		ID:         event.ID,
+		Type:       event.Type,
+		PaymentRef: event.PaymentRef,
+		Amount:     event.Amount,
+		Reason:     event.Reason,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(FakeSignatureHeader, Sign(f.WebhookSecret, payload, time.Now()))
+	return payload, header, nil
+}
@@ -0,0 +1,206 @@
+// Package payments takes payments for orders through a payment provider.
+package payments
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+var (
+	// ErrInvalidSignature is returned for webhooks that were not signed with
+	// the webhook secret, or were signed too long ago to be trusted.
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	// ErrUnknownPayment is returned when capturing or refunding a payment the
+	// provider does not know.
+	ErrUnknownPayment = errors.New("unknown payment")
+)
+
+// SignatureTolerance is how long after signing a webhook is accepted, which
+// keeps captured requests from being replayed later.
+const SignatureTolerance = 5 * time.Minute
+
+// Authorization asks a provider to hold Amount on the customer's card.
+type Authorization struct {
+	OrderID  uuid.UUID
+	Amount   float64
+	Currency string
+	// PaymentMethod is the provider's reference to the card, like the
+	// ones in TestCards.
+	PaymentMethod string
+}
+
+// Charge is what came of an authorization.
+type Charge struct {
+	// Ref is the provider's ID for the payment. Declined payments can have
+	// one too.
+	Ref string
+	// Status is models.PaymentAuthorized or models.PaymentFailed.
+	Status models.PaymentStatus
+	// FailureReason says why the payment was declined and is fit to show
+	// the customer.
+	FailureReason string
+}
+
+type EventType string
+
+const (
+	EventCaptured EventType = "captured"
+	EventFailed   EventType = "failed"
+	EventDisputed EventType = "disputed"
+	EventRefunded EventType = "refunded"
+)
+
+// Event is a verified webhook about a payment.
+type Event struct {
+	// ID is the provider's ID for the event, which stays the same when the
+	// provider sends it again.
+	ID string
+	// Type is empty for events the store does not act on.
+	Type EventType
+	// Name is the provider's own name for the event.
+	Name       string
+	PaymentRef string
+	// Amount is what was captured, refunded in total or disputed.
+	Amount float64
+	Reason string
+}
+
+// Provider is a payment provider. Amounts are in the major unit of the
+// currency, like the prices of the store.
+type Provider interface {
+	// Name is stored with every payment to tell which provider took it.
+	Name() string
+	// Authorize holds a payment. A declined card is not an error but a
+	// Charge with models.PaymentFailed; errors mean the provider could not
+	// be asked.
+	Authorize(ctx context.Context, auth Authorization) (*Charge, error)
+	// Capture takes amount, at most what was authorized, of an authorized
+	// payment.
+	Capture(ctx context.Context, ref string, amount float64) error
+	// Refund gives back amount of a captured payment and returns the
+	// provider's ID for the refund. key identifies the refund: retried with
+	// the key of a refund that went through, it is not made again and the
+	// ID of the first one is returned.
+	Refund(ctx context.Context, ref string, amount float64, key string) (string, error)
+	// VerifyWebhook checks the signature of a webhook request and parses
+	// its payload.
+	VerifyWebhook(payload []byte, header http.Header) (*Event, error)
+	// ParseWebhook parses a payload that was verified before, like a stored
+	// event whose signature has since expired.
+	ParseWebhook(payload []byte) (*Event, error)
+}
+
+// TestCard is a payment method for trying out checkout.
+type TestCard struct {
+	PaymentMethod string
+	Label         string
+}
+
+// TestCards are the cards the fake provider knows. Stripe accepts the same
+// payment methods in test mode.
+var TestCards = []TestCard{
+	{"pm_card_visa", "Visa, approved"},
+	{"pm_card_mastercard", "Mastercard, approved"},
+	{"pm_card_chargeDeclined", "Declined"},
+	{"pm_card_chargeDeclinedInsufficientFunds", "Declined, insufficient funds"},
+}
+
+// New creates the provider selected by cfg.
+func New(cfg config.Payments) (Provider, error) {
+	switch cfg.Provider {
+	case "fake":
+		secret := cfg.WebhookSecret
+		if secret == "" {
+			// Nobody can sign a webhook without knowing the secret
+			secret = randomSecret()
+			slog.Warn("payments.webhook_secret is not set, fake payment webhooks are signed with a random secret")
+		}
+		return NewFake(secret), nil
+	case "stripe":
+		return NewStripe(cfg.StripeSecretKey, cfg.WebhookSecret, cfg.StripeAPIURL), nil
+	default:
+		return nil, fmt.Errorf("unknown payment provider %q", cfg.Provider)
+	}
+}
+
+// randomSecret makes a webhook secret for a provider that has none.
+func randomSecret() string {
+	b := make([]byte, 24)
+	rand.Read(b)
+	return "whsec_" + hex.EncodeToString(b)
+}
+
+// Sign signs a webhook payload the way Stripe does: the signature is
+// "t=<unix time>,v1=<hex HMAC-SHA256 of time.payload>".
+func Sign(secret string, payload []byte, at time.Time) string {
+	t := strconv.FormatInt(at.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(mac(secret, t, payload))
+}
+
+// Verify checks a signature made by Sign, accepting it for
+// SignatureTolerance after it was made. Without a secret every signature is
+// invalid, since anyone can make one with an empty key.
+func Verify(secret string, payload []byte, signature string, now time.Time) error {
+	if secret == "" {
+		return ErrInvalidSignature
+	}
+	var t string
+	var signatures [][]byte
+	for _, part := range strings.Split(signature, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(unix, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return ErrInvalidSignature
+	}
+	want := mac(secret, t, payload)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, want) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func mac(secret, t string, payload []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(t))
+	h.Write([]byte("."))
+	h.Write(payload)
+	return h.Sum(nil)
+}
+
+// cents converts an amount to the minor unit providers charge in.
+func cents(amount float64) int64 {
+	return int64(math.Round(amount * 100))
+}
+
+func fromCents(cents int64) float64 {
+	return float64(cents) / 100
+}
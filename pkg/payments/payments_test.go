@@ -0,0 +1,414 @@
+package payments
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+func TestVerify(t *testing.T) {
+	payload := []byte(`{"id":"evt_1"}`)
+	now := time.Unix(1700000000, 0)
+
+	tests := []struct {
+		name      string
+		signature string
+		wantErr   bool
+	}{
+		{"valid", Sign("whsec", payload, now), false},
+		{"slightly old", Sign("whsec", payload, now.Add(-4*time.Minute)), false},
+		{"too old", Sign("whsec", payload, now.Add(-6*time.Minute)), true},
+		{"other secret", Sign("other", payload, now), true},
+		{"one of several", Sign("other", payload, now) + ",v1=" + Sign("whsec", payload, now)[len("t=1700000000,v1="):], false},
+		{"missing time", "v1=00", true},
+		{"empty", "", true},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Verify("whsec", payload, tc.signature, now)
+			if tc.wantErr && !errors.Is(err, ErrInvalidSignature) {
+				t.Fatalf("Verify error = %v, want ErrInvalidSignature", err)
+			}
+			if !tc.wantErr && err != nil {
+				t.Fatalf("Verify: %v", err)
+			}
+		})
+	}
+
+	if err := Verify("whsec", []byte(`{"id":"evt_2"}`), Sign("whsec", payload, now), now); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify of a changed payload error = %v, want ErrInvalidSignature", err)
+	}
+	if err := Verify("", payload, Sign("", payload, now), now); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Verify without a secret error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestNewFakeWithoutSecret(t *testing.T) {
+	provider, err := New(config.Payments{Provider: "fake"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fake := provider.(*Fake)
+	if fake.WebhookSecret == "" {
+		t.Fatal("fake provider has no webhook secret")
+	}
+	payload := []byte(`{"id":"evt_1"}`)
+	header := http.Header{}
+	header.Set(FakeSignatureHeader, Sign("", payload, time.Now()))
+	if _, err := fake.VerifyWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("VerifyWebhook of an event signed without a secret error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestFake(t *testing.T) {
+	ctx := context.Background()
+	fake := NewFake("whsec")
+
+	tests := []struct {
+		paymentMethod string
+		wantStatus    models.PaymentStatus
+		wantReason    string
+	}{
+		{"pm_card_visa", models.PaymentAuthorized, ""},
+		{"pm_card_chargeDeclined", models.PaymentFailed, "Your card was declined."},
+		{"pm_card_chargeDeclinedInsufficientFunds", models.PaymentFailed, "Your card has insufficient funds."},
+		{"", models.PaymentFailed, "No payment method was given."},
+	}
+	for _, tc := range tests {
+		charge, err := fake.Authorize(ctx, Authorization{OrderID: uuid.New(), Amount: 10, Currency: "usd", PaymentMethod: tc.paymentMethod})
+		if err != nil {
+			t.Fatalf("Authorize(%q): %v", tc.paymentMethod, err)
+		}
+		if charge.Ref == "" || charge.Status != tc.wantStatus || charge.FailureReason != tc.wantReason {
+			t.Errorf("Authorize(%q) = %+v, want %s %q", tc.paymentMethod, charge, tc.wantStatus, tc.wantReason)
+		}
+	}
+
+	charge, err := fake.Authorize(ctx, Authorization{OrderID: uuid.New(), Amount: 50, Currency: "usd", PaymentMethod: "pm_card_visa"})
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if _, err := fake.Refund(ctx, charge.Ref, 10, ""); err == nil {
+		t.Fatal("Refund of an uncaptured payment succeeded")
+	}
+	if err := fake.Capture(ctx, charge.Ref, 50.01); err == nil {
+		t.Fatal("Capture of more than was authorized succeeded")
+	}
+	if err := fake.Capture(ctx, charge.Ref, 50); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	if err := fake.Capture(ctx, charge.Ref, 50); err == nil {
+		t.Fatal("second Capture succeeded")
+	}
+	refundID, err := fake.Refund(ctx, charge.Ref, 20, "return-1")
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if again, err := fake.Refund(ctx, charge.Ref, 20, "return-1"); err != nil || again != refundID {
+		t.Fatalf("retried Refund = %q, %v, want the first refund %q", again, err, refundID)
+	}
+	if _, err := fake.Refund(ctx, charge.Ref, 30.01, ""); err == nil {
+		t.Fatal("Refund of more than is left succeeded")
+	}
+	if _, err := fake.Refund(ctx, charge.Ref, 30, ""); err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if err := fake.Capture(ctx, "fake_pi_missing", 1); !errors.Is(err, ErrUnknownPayment) {
+		t.Fatalf("Capture of a missing payment error = %v, want ErrUnknownPayment", err)
+	}
+
+	payload, header, err := fake.SignEvent(Event{ID: "evt_1", Type: EventDisputed, PaymentRef: charge.Ref, Amount: 50, Reason: "fraudulent"})
+	if err != nil {
+		t.Fatalf("SignEvent: %v", err)
+	}
+	event, err := fake.VerifyWebhook(payload, header)
+	if err != nil {
+		t.Fatalf("VerifyWebhook: %v", err)
+	}
+	want := Event{ID: "evt_1", Type: EventDisputed, Name: "disputed", PaymentRef: charge.Ref, Amount: 50, Reason: "fraudulent"}
+	if *event != want {
+		t.Errorf("VerifyWebhook = %+v, want %+v", *event, want)
+	}
+	if _, err := NewFake("other").VerifyWebhook(payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("VerifyWebhook with another secret error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestStripe(t *testing.T) {
+	ctx := context.Background()
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/payment_intents", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer sk_test" {
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, `{"error":{"type":"invalid_request_error","message":"Invalid API Key provided"}}`)
+			return
+		}
+		if r.FormValue("capture_method") != "manual" || r.FormValue("amount") != "1999" || r.FormValue("currency") != "usd" {
+			t.Errorf("unexpected payment intent form %v", r.Form)
+		}
+		if r.FormValue("payment_method") == "pm_card_chargeDeclined" {
+			w.WriteHeader(http.StatusPaymentRequired)
+			fmt.Fprint(w, `{"error":{"type":"card_error","code":"card_declined","message":"Your card was declined.","payment_intent":{"id":"pi_declined","status":"requires_payment_method"}}}`)
+			return
+		}
+		fmt.Fprint(w, `{"id":"pi_1","status":"requires_capture","amount":1999}`)
+	})
+	mux.HandleFunc("POST /v1/payment_intents/{id}/capture", func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("id") != "pi_1" || r.FormValue("amount_to_capture") != "1999" {
+			t.Errorf("unexpected capture of %s with %v", r.PathValue("id"), r.Form)
+		}
+		fmt.Fprint(w, `{"id":"pi_1","status":"succeeded","amount_received":1999}`)
+	})
+	mux.HandleFunc("POST /v1/refunds", func(w http.ResponseWriter, r *http.Request) {
+		if r.FormValue("payment_intent") != "pi_1" || r.FormValue("amount") != "500" || r.Header.Get("Idempotency-Key") != "return-1" {
+			t.Errorf("unexpected refund %v with key %q", r.Form, r.Header.Get("Idempotency-Key"))
+		}
+		fmt.Fprint(w, `{"id":"re_1","status":"succeeded"}`)
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	stripe := NewStripe("sk_test", "whsec", srv.URL+"/")
+	auth := Authorization{OrderID: uuid.New(), Amount: 19.99, Currency: "usd", PaymentMethod: "pm_card_visa"}
+
+	charge, err := stripe.Authorize(ctx, auth)
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if *charge != (Charge{Ref: "pi_1", Status: models.PaymentAuthorized}) {
+		t.Errorf("Authorize = %+v, want authorized pi_1", charge)
+	}
+	if err := stripe.Capture(ctx, "pi_1", 19.99); err != nil {
+		t.Fatalf("Capture: %v", err)
+	}
+	refundID, err := stripe.Refund(ctx, "pi_1", 5, "return-1")
+	if err != nil {
+		t.Fatalf("Refund: %v", err)
+	}
+	if refundID != "re_1" {
+		t.Errorf("Refund = %q, want re_1", refundID)
+	}
+
+	auth.PaymentMethod = "pm_card_chargeDeclined"
+	charge, err = stripe.Authorize(ctx, auth)
+	if err != nil {
+		t.Fatalf("Authorize of a declined card: %v", err)
+	}
+	if *charge != (Charge{Ref: "pi_declined", Status: models.PaymentFailed, FailureReason: "Your card was declined."}) {
+		t.Errorf("Authorize of a declined card = %+v", charge)
+	}
+
+	stripe.SecretKey = "sk_wrong"
+	if _, err := stripe.Authorize(ctx, auth); err == nil || err.Error() != "stripe: Invalid API Key provided" {
+		t.Errorf("Authorize with a wrong key error = %v", err)
+	}
+}
+
+func TestStripeWebhook(t *testing.T) {
+	stripe := NewStripe("sk_test", "whsec", "https://api.stripe.com")
+
+	tests := []struct {
+		payload string
+		want    Event
+	}{
+		{
+			`{"id":"evt_1","type":"payment_intent.succeeded","data":{"object":{"id":"pi_1","amount_received":1999}}}`,
+			Event{ID: "evt_1", Type: EventCaptured, Name: "payment_intent.succeeded", PaymentRef: "pi_1", Amount: 19.99},
+		},
+		{
+			`{"id":"evt_2","type":"payment_intent.payment_failed","data":{"object":{"id":"pi_1","amount":1999,"last_payment_error":{"message":"Your card was declined."}}}}`,
+			Event{ID: "evt_2", Type: EventFailed, Name: "payment_intent.payment_failed", PaymentRef: "pi_1", Amount: 19.99, Reason: "Your card was declined."},
+		},
+		{
+			`{"id":"evt_3","type":"charge.refunded","data":{"object":{"id":"ch_1","payment_intent":"pi_1","amount_refunded":500}}}`,
+			Event{ID: "evt_3", Type: EventRefunded, Name: "charge.refunded", PaymentRef: "pi_1", Amount: 5},
+		},
+		{
+			`{"id":"evt_4","type":"charge.dispute.created","data":{"object":{"id":"dp_1","payment_intent":"pi_1","amount":1999,"reason":"fraudulent"}}}`,
+			Event{ID: "evt_4", Type: EventDisputed, Name: "charge.dispute.created", PaymentRef: "pi_1", Amount: 19.99, Reason: "fraudulent"},
+		},
+		{
+			`{"id":"evt_5","type":"customer.created","data":{"object":{"id":"cus_1"}}}`,
+			Event{ID: "evt_5", Name: "customer.created"},
+		},
+	}
+	for _, tc := range tests {
+		t.Run(tc.want.Name, func(t *testing.T) {
+			header := http.Header{}
+			header.Set(StripeSignatureHeader, Sign("whsec", []byte(tc.payload), time.Now()))
+			event, err := stripe.VerifyWebhook([]byte(tc.payload), header)
+			if err != nil {
+				t.Fatalf("VerifyWebhook: %v", err)
+			}
+			if *event != tc.want {
+				t.Errorf("VerifyWebhook = %+v, want %+v", *event, tc.want)
+			}
+		})
+	}
+
+	if _, err := stripe.VerifyWebhook([]byte(tests[0].payload), http.Header{}); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("VerifyWebhook without a signature error = %v, want ErrInvalidSignature", err)
+	}
+}
+
+func TestWebhooks(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	fake := NewFake("whsec")
+	webhooks := NewWebhooks(repo, fake)
+
+	product := models.Product{ProductName: "Lamp", Price: 40}
+	if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	// pay places an order authorized for $40 and returns its payment ref
+	pay := func() (uuid.UUID, string) {
+		t.Helper()
+		order := models.Order{
+			UserID:      "alice@example.com",
+			OrderStatus: models.OrderStatusOrdered,
+			Items:       []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 40}},
+		}
+		if err := repo.Order.PlaceOrder(ctx, &order); err != nil {
+			t.Fatal(err)
+		}
+		charge, err := fake.Authorize(ctx, Authorization{OrderID: order.OrderID, Amount: 40, Currency: "usd", PaymentMethod: "pm_card_visa"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		payment := models.Payment{OrderID: order.OrderID, Provider: "fake", ProviderRef: charge.Ref, Amount: 40, Currency: "usd", Status: charge.Status}
+		if err := repo.Payment.CreatePayment(ctx, &payment); err != nil {
+			t.Fatal(err)
+		}
+		return order.OrderID, charge.Ref
+	}
+	receive := func(event Event) error {
+		t.Helper()
+		payload, header, err := fake.SignEvent(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return webhooks.Receive(ctx, payload, header)
+	}
+	assertOrder := func(orderID uuid.UUID, want string) {
+		t.Helper()
+		order, err := repo.Order.GetOrderWithProducts(ctx, orderID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.OrderStatus != want {
+			t.Errorf("OrderStatus = %q, want %q", order.OrderStatus, want)
+		}
+	}
+	assertRefunded := func(orderID uuid.UUID, want float64) {
+		t.Helper()
+		order, err := repo.Order.GetOrderWithProducts(ctx, orderID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if order.RefundedAmount != want {
+			t.Errorf("RefundedAmount = %.2f, want %.2f", order.RefundedAmount, want)
+		}
+	}
+	assertEvent := func(id string, want models.PaymentEventStatus, attempts int) {
+		t.Helper()
+		event, err := repo.Payment.GetPaymentEvent(ctx, "fake", id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if event.Status != want || event.Attempts != attempts {
+			t.Errorf("event %s = %s after %d attempts, want %s after %d", id, event.Status, event.Attempts, want, attempts)
+		}
+	}
+
+	orderID, ref := pay()
+	captured := Event{ID: "evt_1", Type: EventCaptured, PaymentRef: ref, Amount: 40}
+	for range 2 {
+		if err := receive(captured); err != nil {
+			t.Fatalf("Receive: %v", err)
+		}
+	}
+	assertOrder(orderID, models.OrderStatusPaid)
+	assertEvent("evt_1", models.PaymentEventProcessed, 1)
+	payment, err := repo.Payment.GetPaymentByRef(ctx, "fake", ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if payment.Status != models.PaymentCaptured || payment.CapturedAmount != 40 {
+		t.Errorf("payment = %+v, want $40 captured", payment)
+	}
+
+	// A late failure cannot take back a paid order
+	if err := receive(Event{ID: "evt_2", Type: EventFailed, PaymentRef: ref, Reason: "Your card was declined."}); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	assertOrder(orderID, models.OrderStatusPaid)
+
+	if err := receive(Event{ID: "evt_3", Type: EventDisputed, PaymentRef: ref, Amount: 40, Reason: "fraudulent"}); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	assertOrder(orderID, models.OrderStatusDisputed)
+	if err := receive(Event{ID: "evt_3b", Type: EventRefunded, PaymentRef: ref, Amount: 15}); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	assertOrder(orderID, models.OrderStatusPartiallyRefunded)
+	assertRefunded(orderID, 15)
+	if err := receive(Event{ID: "evt_4", Type: EventRefunded, PaymentRef: ref, Amount: 40}); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	assertOrder(orderID, models.OrderStatusRefunded)
+	assertRefunded(orderID, 40)
+
+	if err := receive(Event{ID: "evt_5", Type: "customer.created"}); err != nil {
+		t.Fatalf("Receive: %v", err)
+	}
+	assertEvent("evt_5", models.PaymentEventIgnored, 1)
+
+	payload, header, err := fake.SignEvent(Event{ID: "evt_6", Type: EventCaptured, PaymentRef: ref, Amount: 40})
+	if err != nil {
+		t.Fatal(err)
+	}
+	header.Set(FakeSignatureHeader, Sign("other", payload, time.Now()))
+	if err := webhooks.Receive(ctx, payload, header); !errors.Is(err, ErrInvalidSignature) {
+		t.Fatalf("Receive of a forged webhook error = %v, want ErrInvalidSignature", err)
+	}
+	if _, err := repo.Payment.GetPaymentEvent(ctx, "fake", "evt_6"); !errors.Is(err, repository.ErrNotFound) {
+		t.Fatalf("forged webhook was recorded: %v", err)
+	}
+
+	// A webhook about a payment that is not saved yet fails, and succeeds
+	// once sent again or replayed
+	early := Event{ID: "evt_7", Type: EventCaptured, PaymentRef: "fake_pi_2", Amount: 40}
+	if err := receive(early); err == nil {
+		t.Fatal("Receive of an event for a missing payment succeeded")
+	}
+	assertEvent("evt_7", models.PaymentEventFailed, 1)
+	if err := receive(early); err == nil {
+		t.Fatal("Receive of an event for a missing payment succeeded")
+	}
+	assertEvent("evt_7", models.PaymentEventFailed, 2)
+
+	laterID, laterRef := pay()
+	if laterRef != early.PaymentRef {
+		t.Fatalf("payment ref = %s, want %s", laterRef, early.PaymentRef)
+	}
+	replayed, err := webhooks.Replay(ctx)
+	if err != nil || replayed != 1 {
+		t.Fatalf("Replay = %d, %v, want 1 event replayed", replayed, err)
+	}
+	assertEvent("evt_7", models.PaymentEventProcessed, 3)
+	assertOrder(laterID, models.OrderStatusPaid)
+
+	if replayed, err := webhooks.Replay(ctx); err != nil || replayed != 0 {
+		t.Fatalf("second Replay = %d, %v, want nothing left", replayed, err)
+	}
+}
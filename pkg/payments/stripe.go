@@ -0,0 +1,226 @@
+package payments
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// StripeSignatureHeader carries the signature of Stripe webhooks.
+const StripeSignatureHeader = "Stripe-Signature"
+
+// Stripe takes payments through the Stripe PaymentIntents API. Payments are
+// authorized with manual capture so that checkout can confirm an order
+// before any money moves.
+type Stripe struct {
+	SecretKey     string
+	WebhookSecret string
+	// BaseURL is where the API is, https://api.stripe.com unless it is a
+	// stand-in.
+	BaseURL string
+	Client  *http.Client
+}
+
+func NewStripe(secretKey, webhookSecret, baseURL string) *Stripe {
+	return &Stripe{
+		SecretKey:     secretKey,
+		WebhookSecret: webhookSecret,
+		BaseURL:       strings.TrimSuffix(baseURL, "/"),
+		Client:        &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+func (s *Stripe) Name() string { return "stripe" }
+
+type stripePaymentIntent struct {
+	ID               string       `json:"id"`
+	Status           string       `json:"status"`
+	Amount           int64        `json:"amount"`
+	AmountReceived   int64        `json:"amount_received"`
+	LastPaymentError *stripeError `json:"last_payment_error"`
+}
+
+type stripeError struct {
+	Type          string               `json:"type"`
+	Code          string               `json:"code"`
+	Message       string               `json:"message"`
+	PaymentIntent *stripePaymentIntent `json:"payment_intent"`
+}
+
+func (e *stripeError) Error() string {
+	return "stripe: " + e.Message
+}
+
+func (s *Stripe) Authorize(ctx context.Context, auth Authorization) (*Charge, error) {
+	form := url.Values{
+		"amount":         {strconv.FormatInt(cents(auth.Amount), 10)},
+		"currency":       {auth.Currency},
+		"payment_method": {auth.PaymentMethod},
+		"confirm":        {"true"},
+		"capture_method": {"manual"},
+		// Checkout cannot follow redirects to the bank yet
+		"automatic_payment_methods[enabled]":         {"true"},
+		"automatic_payment_methods[allow_redirects]": {"never"},
+		"metadata[order_id]":                         {auth.OrderID.String()},
+	}
+	var intent stripePaymentIntent
+	err := s.post(ctx, "/v1/payment_intents", form, "", &intent)
+	var stripeErr *stripeError
+	if errors.As(err, &stripeErr) && stripeErr.Type == "card_error" {
+		charge := &Charge{Status: models.PaymentFailed, FailureReason: stripeErr.Message}
+		if stripeErr.PaymentIntent != nil {
+			charge.Ref = stripeErr.PaymentIntent.ID
+		}
+		return charge, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	charge := &Charge{Ref: intent.ID, Status: models.PaymentAuthorized}
+	if intent.Status != "requires_capture" {
+		charge.Status = models.PaymentFailed
+		charge.FailureReason = "Your card needs further verification, which is not supported."
+		if intent.LastPaymentError != nil {
+			charge.FailureReason = intent.LastPaymentError.Message
+		}
+	}
+	return charge, nil
+}
+
+func (s *Stripe) Capture(ctx context.Context, ref string, amount float64) error {
+	form := url.Values{"amount_to_capture": {strconv.FormatInt(cents(amount), 10)}}
+	var intent stripePaymentIntent
+	if err := s.post(ctx, "/v1/payment_intents/"+url.PathEscape(ref)+"/capture", form, "", &intent); err != nil {
+		return err
+	}
+	if intent.Status != "succeeded" {
+		return fmt.Errorf("stripe: payment %s is %s after capture", ref, intent.Status)
+	}
+	return nil
+}
+
+func (s *Stripe) Refund(ctx context.Context, ref string, amount float64, key string) (string, error) {
+	form := url.Values{
+		"payment_intent": {ref},
+		"amount":         {strconv.FormatInt(cents(amount), 10)},
+	}
+	var refund struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+	}
+	if err := s.post(ctx, "/v1/refunds", form, key, &refund); err != nil {
+		return "", err
+	}
+	if refund.Status == "failed" || refund.Status == "canceled" {
+		return refund.ID, fmt.Errorf("stripe: refund %s %s", refund.ID, refund.Status)
+	}
+	return refund.ID, nil
+}
+
+// post sends form to the API and decodes the response into v. With an
+// idempotency key, Stripe answers a retry with the response to the first
+// request rather than doing it again.
+func (s *Stripe) post(ctx context.Context, path string, form url.Values, idempotencyKey string, v any) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.BaseURL+path, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+s.SecretKey)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if idempotencyKey != "" {
+		req.Header.Set("Idempotency-Key", idempotencyKey)
+	}
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("stripe: %w", err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("stripe: read response: %w", err)
+	}
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		var e struct {
+			Error *stripeError `json:"error"`
+		}
+		if json.Unmarshal(body, &e) == nil && e.Error != nil && e.Error.Message != "" {
+			return e.Error
+		}
+		return fmt.Errorf("stripe: %s %s", path, resp.Status)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("stripe: decode response: %w", err)
+	}
+	return nil
+}
+
+func (s *Stripe) VerifyWebhook(payload []byte, header http.Header) (*Event, error) {
+	if err := Verify(s.WebhookSecret, payload, header.Get(StripeSignatureHeader), time.Now()); err != nil {
+		return nil, err
+	}
+	return s.ParseWebhook(payload)
+}
+
+func (s *Stripe) ParseWebhook(payload []byte) (*Event, error) {
+	var e struct {
+		ID   string `json:"id"`
+		Type string `json:"type"`
+		Data struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("stripe: parse webhook: %w", err)
+	}
+	event := &Event{ID: e.ID, Name: e.Type}
+
+	// Charges and disputes point at their payment intent, which is what
+	// the payments are stored by
+	var object struct {
+		ID               string       `json:"id"`
+		PaymentIntent    string       `json:"payment_intent"`
+		Amount           int64        `json:"amount"`
+		AmountReceived   int64        `json:"amount_received"`
+		AmountRefunded   int64        `json:"amount_refunded"`
+		Reason           string       `json:"reason"`
+		LastPaymentError *stripeError `json:"last_payment_error"`
+	}
+	if err := json.Unmarshal(e.Data.Object, &object); err != nil {
+		return nil, fmt.Errorf("stripe: parse webhook object: %w", err)
+	}
+	switch e.Type {
+	case "payment_intent.succeeded":
+		event.Type = EventCaptured
+		event.PaymentRef = object.ID
+		event.Amount = fromCents(object.AmountReceived)
+	case "payment_intent.payment_failed":
+		event.Type = EventFailed
+		event.PaymentRef = object.ID
+		event.Amount = fromCents(object.Amount)
+		if object.LastPaymentError != nil {
+			event.Reason = object.LastPaymentError.Message
+		}
+	case "charge.refunded":
+		event.Type = EventRefunded
+		event.PaymentRef = object.PaymentIntent
+		event.Amount = fromCents(object.AmountRefunded)
+	case "charge.dispute.created":
+		event.Type = EventDisputed
+		event.PaymentRef = object.PaymentIntent
+		event.Amount = fromCents(object.Amount)
+		event.Reason = object.Reason
+	}
+	return event, nil
+}
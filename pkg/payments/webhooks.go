@@ -0,0 +1,191 @@
+package payments
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+// Webhooks records the webhooks of a provider and applies them to the
+// payments and orders they are about.
+//
+// Every event is recorded before it is processed, and an event the provider
+// sends again is only processed again when it failed before. Processing is
+// idempotent on top of that: it sets amounts and statuses to what the event
+// says instead of adding to them.
+type Webhooks struct {
+	Repo     *repository.Repository
+	Provider Provider
+}
+
+func NewWebhooks(repo *repository.Repository, provider Provider) *Webhooks {
+	return &Webhooks{Repo: repo, Provider: provider}
+}
+
+// errIgnored marks events that are about nothing the store acts on.
+var errIgnored = errors.New("ignored")
+
+// Receive verifies a webhook and processes it. It returns
+// ErrInvalidSignature for webhooks that did not come from the provider.
+// Other errors mean the event was not processed, and the provider should
+// send it again, or it is left to Replay.
+func (w *Webhooks) Receive(ctx context.Context, payload []byte, header http.Header) error {
+	event, err := w.Provider.VerifyWebhook(payload, header)
+	if err != nil {
+		return err
+	}
+
+	stored := &models.PaymentEvent{
+		Provider:        w.Provider.Name(),
+		ProviderEventID: event.ID,
+		EventType:       event.Name,
+		Payload:         payload,
+		Status:          models.PaymentEventReceived,
+	}
+	err = w.Repo.Payment.RecordPaymentEvent(ctx, stored)
+	if errors.Is(err, repository.ErrDuplicateEvent) {
+		stored, err = w.Repo.Payment.GetPaymentEvent(ctx, w.Provider.Name(), event.ID)
+		if err != nil {
+			return err
+		}
+		if stored.Status != models.PaymentEventFailed {
+			// Processed before, or being processed by the first delivery
+			return nil
+		}
+	} else if err != nil {
+		return err
+	}
+	return w.process(ctx, stored, event)
+}
+
+// Replay processes the events of the provider that failed processing again,
+// oldest first, together with events that were received but never finished
+// processing. It returns how many of them succeeded this time.
+func (w *Webhooks) Replay(ctx context.Context) (int, error) {
+	var events []models.PaymentEvent
+	for _, status := range []models.PaymentEventStatus{models.PaymentEventReceived, models.PaymentEventFailed} {
+		list, err := w.Repo.Payment.ListPaymentEvents(ctx, status)
+		if err != nil {
+			return 0, err
+		}
+		events = append(events, list...)
+	}
+	slices.SortFunc(events, func(a, b models.PaymentEvent) int {
+		return cmp.Compare(a.PaymentEventID, b.PaymentEventID)
+	})
+	var replayed int
+	var errs []error
+	for i := range events {
+		stored := &events[i]
+		if stored.Provider != w.Provider.Name() {
+			continue
+		}
+		event, err := w.Provider.ParseWebhook(stored.Payload)
+		if err == nil {
+			err = w.process(ctx, stored, event)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("event %s: %w", stored.ProviderEventID, err))
+			continue
+		}
+		replayed++
+	}
+	return replayed, errors.Join(errs...)
+}
+
+// process applies event and saves how that went on stored.
+func (w *Webhooks) process(ctx context.Context, stored *models.PaymentEvent, event *Event) error {
+	stored.Attempts++
+	err := w.apply(ctx, event)
+	switch {
+	case errors.Is(err, errIgnored):
+		stored.Status = models.PaymentEventIgnored
+		stored.Error = ""
+		err = nil
+	case err != nil:
+		stored.Status = models.PaymentEventFailed
+		stored.Error = err.Error()
+	default:
+		now := time.Now()
+		stored.Status = models.PaymentEventProcessed
+		stored.Error = ""
+		stored.ProcessedAt = &now
+	}
+	if updateErr := w.Repo.Payment.UpdatePaymentEvent(ctx, stored); updateErr != nil {
+		return errors.Join(err, updateErr)
+	}
+	return err
+}
+
+func (w *Webhooks) apply(ctx context.Context, event *Event) error {
+	if event.Type == "" {
+		return errIgnored
+	}
+	// The webhook can arrive before checkout saved the payment, the event
+	// then fails and is sent again or replayed
+	payment, err := w.Repo.Payment.GetPaymentByRef(ctx, w.Provider.Name(), event.PaymentRef)
+	if errors.Is(err, repository.ErrNotFound) {
+		return fmt.Errorf("no payment %s", event.PaymentRef)
+	}
+	if err != nil {
+		return err
+	}
+
+	var status string
+	switch event.Type {
+	case EventCaptured:
+		if payment.Status == models.PaymentAuthorized || payment.Status == models.PaymentFailed {
+			payment.Status = models.PaymentCaptured
+			payment.FailureReason = ""
+		}
+		payment.CapturedAmount = event.Amount
+		status = models.OrderStatusPaid
+	case EventFailed:
+		if payment.Status == models.PaymentAuthorized {
+			payment.Status = models.PaymentFailed
+			payment.FailureReason = event.Reason
+		}
+		status = models.OrderStatusPaymentFailed
+	case EventRefunded:
+		payment.RefundedAmount = event.Amount
+		status = models.OrderStatusPartiallyRefunded
+		if payment.RefundedAmount >= payment.CapturedAmount {
+			payment.Status = models.PaymentRefunded
+			status = models.OrderStatusRefunded
+		}
+	case EventDisputed:
+		status = models.OrderStatusDisputed
+	}
+	save := w.Repo.Payment.UpdatePayment
+	if event.Type == EventRefunded {
+		// A refund made at the provider is news to the order too
+		save = w.Repo.Payment.RecordRefund
+	}
+	if err := save(ctx, payment); err != nil {
+		return err
+	}
+	if status == "" {
+		return nil
+	}
+
+	order, err := w.Repo.Order.GetOrderWithProducts(ctx, payment.OrderID)
+	if err != nil {
+		return err
+	}
+	if order.OrderStatus == status {
+		return nil
+	}
+	if !models.CanTransitionOrder(order.OrderStatus, status) {
+		slog.Warn("Payment event does not apply to order", "event", event.ID, "order", order.OrderID, "from", order.OrderStatus, "to", status)
+		return nil
+	}
+	return w.Repo.Order.SetOrderStatus(ctx, order.OrderID, status)
+}
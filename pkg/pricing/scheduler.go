@@ -0,0 +1,46 @@
+// Package pricing applies scheduled price changes in the background.
+package pricing
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/jobs"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+// ApplyDueJob is the job that applies the scheduled prices that became due.
+const ApplyDueJob = "prices.apply_due"
+
+// Scheduler periodically writes scheduled prices that have become due to
+// their products.
+type Scheduler struct {
+	Prices   repository.PriceStore
+	Interval time.Duration
+}
+
+func NewScheduler(prices repository.PriceStore, interval time.Duration) *Scheduler {
+	return &Scheduler{Prices: prices, Interval: interval}
+}
+
+// Schedule makes q apply due prices when it starts and then every Interval.
+func (s *Scheduler) Schedule(q *jobs.Queue) {
+	q.Every(ApplyDueJob, s.Interval, func(ctx context.Context, job *models.Job) error {
+		return s.ApplyDue(ctx, time.Now())
+	})
+}
+
+// ApplyDue applies the prices that are due at now. A failed run is not
+// retried, the next one applies what it missed.
+func (s *Scheduler) ApplyDue(ctx context.Context, now time.Time) error {
+	applied, err := s.Prices.ApplyDuePrices(ctx, now)
+	if err != nil {
+		return err
+	}
+	for _, price := range applied {
+		slog.Info("Applied scheduled price", "product", price.ProductID, "price", price.Price, "effective_at", price.EffectiveAt)
+	}
+	return nil
+}
@@ -0,0 +1,190 @@
+// Package promotions applies automatic promotion rules to a cart.
+//
+// Rules are applied one after another in ascending priority, ties broken by
+// ID, so the same cart always gets the same discounts. Each rule sees the
+// line costs left by the rules before it. A rule that is not stackable only
+// discounts lines no other rule discounted yet, and no later rule discounts
+// the lines it did.
+package promotions
+
+import (
+	"cmp"
+	"math"
+	"slices"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// Result is a cart priced with promotions.
+type Result struct {
+	// Items are copies of the cart items with Cost, Discount and Promotions
+	// set.
+	Items   []models.OrderItem
+	Applied []models.AppliedPromotion
+}
+
+// Subtotal is the cost of the items after promotions.
+func (r Result) Subtotal() float64 {
+	var subtotal float64
+	for _, item := range r.Items {
+		subtotal += item.Cost
+	}
+	return subtotal
+}
+
+// Discount is the total the promotions took off.
+func (r Result) Discount() float64 {
+	var discount float64
+	for _, p := range r.Applied {
+		discount += p.Discount
+	}
+	return discount
+}
+
+// Apply prices items with the promotions running at now.
+func Apply(rules []models.Promotion, items []models.OrderItem, now time.Time) Result {
+	result := Result{Items: make([]models.OrderItem, len(items))}
+	for i, item := range items {
+		item.Cost = item.RegularCost()
+		item.Discount = 0
+		item.Promotions = nil
+		result.Items[i] = item
+	}
+
+	rules = slices.Clone(rules)
+	slices.SortStableFunc(rules, func(a, b models.Promotion) int {
+		return cmp.Or(cmp.Compare(a.Priority, b.Priority), cmp.Compare(a.PromotionID, b.PromotionID))
+	})
+
+	discounted := make([]bool, len(items))
+	locked := make([]bool, len(items))
+	for _, rule := range rules {
+		if !rule.Running(now) {
+			continue
+		}
+
+		eligible := make([]bool, len(items))
+		for i, item := range result.Items {
+			eligible[i] = rule.Covers(item.ProductID) && !locked[i] && (rule.Stackable || !discounted[i])
+		}
+
+		var lines []float64
+		switch rule.Kind {
+		case models.PromotionBuyXGetY:
+			lines = buyXGetY(rule, result.Items, eligible)
+		case models.PromotionSpendThreshold:
+			lines = spendThreshold(rule, result.Items, eligible)
+		case models.PromotionBundle:
+			lines = bundle(rule, result.Items, eligible)
+		}
+
+		var total float64
+		for i, d := range lines {
+			d = min(round(d), result.Items[i].Cost)
+			if d <= 0 {
+				continue
+			}
+			item := &result.Items[i]
+			item.Cost = round(item.Cost - d)
+			item.Discount = round(item.Discount + d)
+			item.Promotions = append(item.Promotions, rule.Name)
+			discounted[i] = true
+			locked[i] = locked[i] || !rule.Stackable
+			total += d
+		}
+		if total > 0 {
+			result.Applied = append(result.Applied, models.AppliedPromotion{
+				PromotionID: rule.PromotionID,
+				Label:       rule.Name,
+				Discount:    round(total),
+			})
+		}
+	}
+	return result
+}
+
+// unitCost is what one unit of the line costs after earlier promotions.
+func unitCost(item models.OrderItem) float64 {
+	if item.Quantity <= 0 {
+		return 0
+	}
+	return item.Cost / float64(item.Quantity)
+}
+
+func buyXGetY(rule models.Promotion, items []models.OrderItem, eligible []bool) []float64 {
+	lines := make([]float64, len(items))
+	if rule.BuyQuantity <= 0 || rule.FreeQuantity <= 0 {
+		return lines
+	}
+	for i, item := range items {
+		if !eligible[i] {
+			continue
+		}
+		free := item.Quantity / (rule.BuyQuantity + rule.FreeQuantity) * rule.FreeQuantity
+		lines[i] = float64(free) * unitCost(item)
+	}
+	return lines
+}
+
+func spendThreshold(rule models.Promotion, items []models.OrderItem, eligible []bool) []float64 {
+	lines := make([]float64, len(items))
+	var subtotal float64
+	for _, item := range items {
+		subtotal += item.Cost
+	}
+	if subtotal < rule.MinSubtotal {
+		return lines
+	}
+	for i, item := range items {
+		if eligible[i] {
+			lines[i] = item.Cost * min(rule.Percent, 100) / 100
+		}
+	}
+	return lines
+}
+
+// bundle discounts as many complete bundles as the cart holds, spreading the
+// saving over the bundled lines by their unit cost.
+func bundle(rule models.Promotion, items []models.OrderItem, eligible []bool) []float64 {
+	lines := make([]float64, len(items))
+	if len(rule.ProductIDs) == 0 {
+		return lines
+	}
+
+	var members []int
+	bundles := math.MaxInt
+	for _, productID := range rule.ProductIDs {
+		i := slices.IndexFunc(items, func(item models.OrderItem) bool { return item.ProductID == productID })
+		if i < 0 || !eligible[i] {
+			return lines
+		}
+		members = append(members, i)
+		bundles = min(bundles, items[i].Quantity)
+	}
+
+	var regular float64
+	for _, i := range members {
+		regular += unitCost(items[i])
+	}
+	saving := regular - rule.BundlePrice
+	if bundles <= 0 || saving <= 0 {
+		return lines
+	}
+
+	// The last line takes the rounding remainder so the saving is exact
+	remaining := round(saving * float64(bundles))
+	for n, i := range members {
+		share := round(saving * float64(bundles) * unitCost(items[i]) / regular)
+		if n == len(members)-1 {
+			share = remaining
+		}
+		lines[i] = share
+		remaining = round(remaining - share)
+	}
+	return lines
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
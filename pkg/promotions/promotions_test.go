@@ -0,0 +1,65 @@
+package promotions
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+func TestApply(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour)
+	lamp, desk, chair := uuid.New(), uuid.New(), uuid.New()
+	items := []models.OrderItem{
+		{ProductID: lamp, Quantity: 3, Product: models.Product{Price: 10}},
+		{ProductID: desk, Quantity: 1, Product: models.Product{Price: 80}},
+		{ProductID: chair, Quantity: 1, Product: models.Product{Price: 40}},
+	}
+	buy2get1 := models.Promotion{PromotionID: 1, Name: "Lamps 3 for 2", Kind: models.PromotionBuyXGetY, ProductIDs: []uuid.UUID{lamp}, BuyQuantity: 2, FreeQuantity: 1, Active: true}
+	spend100 := models.Promotion{PromotionID: 2, Name: "10% over $100", Kind: models.PromotionSpendThreshold, MinSubtotal: 100, Percent: 10, Priority: 1, Active: true}
+	stacking := buy2get1
+	stacking.Stackable = true
+	office := models.Promotion{PromotionID: 3, Name: "Office set", Kind: models.PromotionBundle, ProductIDs: []uuid.UUID{desk, chair}, BundlePrice: 100, Active: true}
+
+	tests := []struct {
+		name  string
+		rules []models.Promotion
+		// costs are the line costs after promotions
+		costs    []float64
+		discount float64
+	}{
+		{"no promotions", nil, []float64{30, 80, 40}, 0},
+		{"buy x get y", []models.Promotion{buy2get1}, []float64{20, 80, 40}, 10},
+		{"spend threshold", []models.Promotion{spend100}, []float64{27, 72, 36}, 15},
+		{"threshold not met", []models.Promotion{{Kind: models.PromotionSpendThreshold, MinSubtotal: 200, Percent: 10, Active: true}}, []float64{30, 80, 40}, 0},
+		{"bundle", []models.Promotion{office}, []float64{30, 66.67, 33.33}, 20},
+		{"bundle incomplete", []models.Promotion{{Kind: models.PromotionBundle, ProductIDs: []uuid.UUID{desk, uuid.New()}, BundlePrice: 50, Active: true}}, []float64{30, 80, 40}, 0},
+		{"not stackable keeps later rules off", []models.Promotion{buy2get1, spend100}, []float64{20, 72, 36}, 22},
+		{"stackable rules combine", []models.Promotion{stacking, {PromotionID: 2, Kind: models.PromotionSpendThreshold, MinSubtotal: 100, Percent: 10, Priority: 1, Stackable: true, Active: true}}, []float64{18, 72, 36}, 24},
+		{"priority decides order", []models.Promotion{spend100, {PromotionID: 1, Kind: models.PromotionBuyXGetY, BuyQuantity: 2, FreeQuantity: 1, Priority: 2, Active: true}}, []float64{27, 72, 36}, 15},
+		{"inactive", []models.Promotion{{Kind: models.PromotionSpendThreshold, Percent: 10}}, []float64{30, 80, 40}, 0},
+		{"ended", []models.Promotion{{Kind: models.PromotionSpendThreshold, Percent: 10, EndsAt: &past, Active: true}}, []float64{30, 80, 40}, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Apply(tc.rules, items, now)
+			var costs []float64
+			for _, item := range got.Items {
+				costs = append(costs, item.Cost)
+			}
+			if !slices.Equal(costs, tc.costs) {
+				t.Fatalf("costs = %v, want %v", costs, tc.costs)
+			}
+			if got.Discount() != tc.discount {
+				t.Fatalf("discount = %v, want %v", got.Discount(), tc.discount)
+			}
+		})
+	}
+
+	if items[0].Cost != 0 {
+		t.Fatal("Apply changed the items it was given")
+	}
+}
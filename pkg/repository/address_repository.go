@@ -0,0 +1,132 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+type AddressRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewAddressRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *AddressRepository {
+	return &AddressRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+const addressColumns = `address_id, user_id, name, line1, line2, city, state, postal_code, country, phone, date_created`
+
+func scanAddress(row scanner) (models.Address, error) {
+	var address models.Address
+	err := row.Scan(
+		&address.AddressID,
+		&address.UserID,
+		&address.Name,
+		&address.Line1,
+		&address.Line2,
+		&address.City,
+		&address.State,
+		&address.PostalCode,
+		&address.Country,
+		&address.Phone,
+		&address.DateCreated,
+	)
+	return address, err
+}
+
+func (r *AddressRepository) CreateAddress(ctx context.Context, address *models.Address) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	address.DateCreated = time.Now()
+
+	query := `INSERT INTO addresses (user_id, name, line1, line2, city, state, postal_code, country, phone, date_created) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []any{
+		address.UserID,
+		address.Name,
+		address.Line1,
+		address.Line2,
+		address.City,
+		address.State,
+		address.PostalCode,
+		address.Country,
+		address.Phone,
+		address.DateCreated.UTC(),
+	}
+	if r.Dialect == Postgres {
+		return r.DB.QueryRowContext(ctx, r.Dialect.rebind(query+" RETURNING address_id"), args...).Scan(&address.AddressID)
+	}
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	address.AddressID, err = res.LastInsertId()
+	return err
+}
+
+// GetAddress returns an address from the address book of userID. Addresses
+// of other customers are not found.
+func (r *AddressRepository) GetAddress(ctx context.Context, userID string, addressID int64) (*models.Address, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = ? AND address_id = ?`
+	address, err := scanAddress(r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), userID, addressID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &address, nil
+}
+
+// ListAddresses returns the address book of userID, newest first.
+func (r *AddressRepository) ListAddresses(ctx context.Context, userID string) ([]models.Address, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + addressColumns + ` FROM addresses WHERE user_id = ? ORDER BY date_created DESC, address_id DESC`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var addresses []models.Address
+	for rows.Next() {
+		address, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	return addresses, rows.Err()
+}
+
+// DeleteAddress removes an address from the address book of userID. Orders
+// placed with it keep their copy.
+func (r *AddressRepository) DeleteAddress(ctx context.Context, userID string, addressID int64) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM addresses WHERE user_id = ? AND address_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), userID, addressID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+type AuditRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewAuditRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *AuditRepository {
+	return &AuditRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+// RecordEvent appends an event to the audit log. Events are never updated or
+// deleted.
+func (r *AuditRepository) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `INSERT INTO audit_events (occurred_at, actor, action, entity_type, entity_id, before_data, after_data, ip) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+
+	_, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query),
+		event.OccurredAt.UTC(),
+		event.Actor,
+		event.Action,
+		event.EntityType,
+		event.EntityID,
+		nullString(event.Before),
+		nullString(event.After),
+		event.IP,
+	)
+	return err
+}
+
+func auditWhere(filter AuditFilter) (string, []any) {
+	var conds []string
+	var args []any
+	for _, f := range []struct {
+		column string
+		value  string
+	}{
+		{"actor", filter.Actor},
+		{"action", filter.Action},
+		{"entity_type", filter.EntityType},
+		{"entity_id", filter.EntityID},
+	} {
+		if f.value != "" {
+			conds = append(conds, f.column+" = ?")
+			args = append(args, f.value)
+		}
+	}
+	if len(conds) == 0 {
+		return "", nil
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (r *AuditRepository) ListEvents(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEvent, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	where, args := auditWhere(filter)
+	query := `SELECT id, occurred_at, actor, action, entity_type, entity_id, before_data, after_data, ip
+              FROM audit_events` + where + ` ORDER BY occurred_at DESC, id DESC LIMIT ? OFFSET ?`
+
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), append(args, limit, offset)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.AuditEvent
+	for rows.Next() {
+		var event models.AuditEvent
+		var before, after sql.NullString
+		err := rows.Scan(
+			&event.ID,
+			&event.OccurredAt,
+			&event.Actor,
+			&event.Action,
+			&event.EntityType,
+			&event.EntityID,
+			&before,
+			&after,
+			&event.IP,
+		)
+		if err != nil {
+			return nil, err
+		}
+		event.Before = before.String
+		event.After = after.String
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+func (r *AuditRepository) CountEvents(ctx context.Context, filter AuditFilter) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	where, args := auditWhere(filter)
+	var count int
+	err := r.DB.QueryRowContext(ctx, r.Dialect.rebind("SELECT COUNT(*) FROM audit_events"+where), args...).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
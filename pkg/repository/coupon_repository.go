@@ -0,0 +1,314 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/coupons"
+	"github.com/snirkop89/mx-store/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+const couponColumns = `coupon_id, code, discount_type, amount, min_cart_value, starts_at, ends_at, max_uses,
+	max_uses_per_customer, times_used, active, date_created`
+
+type CouponRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewCouponRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *CouponRepository {
+	return &CouponRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+func scanCoupon(row scanner) (models.Coupon, error) {
+	var coupon models.Coupon
+	err := row.Scan(
+		&coupon.CouponID,
+		&coupon.Code,
+		&coupon.DiscountType,
+		&coupon.Amount,
+		&coupon.MinCartValue,
+		&coupon.StartsAt,
+		&coupon.EndsAt,
+		&coupon.MaxUses,
+		&coupon.MaxUsesPerCustomer,
+		&coupon.TimesUsed,
+		&coupon.Active,
+		&coupon.DateCreated,
+	)
+	return coupon, err
+}
+
+// CreateCoupon saves a new coupon. Codes are unique regardless of case and
+// ErrCouponCodeTaken is returned for a code that is already in use.
+func (r *CouponRepository) CreateCoupon(ctx context.Context, coupon *models.Coupon) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	coupon.Code = models.NormalizeCouponCode(coupon.Code)
+	coupon.TimesUsed = 0
+	coupon.DateCreated = time.Now()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var taken int
+	query := `SELECT COUNT(*) FROM coupons WHERE code = ?`
+	if err := tx.QueryRowContext(ctx, r.Dialect.rebind(query), coupon.Code).Scan(&taken); err != nil {
+		return err
+	}
+	if taken > 0 {
+		return ErrCouponCodeTaken
+	}
+
+	query = `INSERT INTO coupons (code, discount_type, amount, min_cart_value, starts_at, ends_at, max_uses,
+              max_uses_per_customer, active, date_created) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []any{
+		coupon.Code,
+		coupon.DiscountType,
+		coupon.Amount,
+		coupon.MinCartValue,
+		nullTime(coupon.StartsAt),
+		nullTime(coupon.EndsAt),
+		coupon.MaxUses,
+		coupon.MaxUsesPerCustomer,
+		coupon.Active,
+		coupon.DateCreated.UTC(),
+	}
+	if r.Dialect == Postgres {
+		err = tx.QueryRowContext(ctx, r.Dialect.rebind(query+" RETURNING coupon_id"), args...).Scan(&coupon.CouponID)
+	} else {
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, r.Dialect.rebind(query), args...)
+		if err == nil {
+			coupon.CouponID, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, productID := range coupon.ProductIDs {
+		query = `INSERT INTO coupon_products (coupon_id, product_id) VALUES (?, ?)`
+		if _, err := tx.ExecContext(ctx, r.Dialect.rebind(query), coupon.CouponID, productID); err != nil {
+			return err
+		}
+	}
+	for _, category := range coupon.Categories {
+		query = `INSERT INTO coupon_categories (coupon_id, category) VALUES (?, ?)`
+		if _, err := tx.ExecContext(ctx, r.Dialect.rebind(query), coupon.CouponID, category); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// loadCouponRestrictions fills in the products and categories coupons are
+// restricted to.
+func (r *CouponRepository) loadCouponRestrictions(ctx context.Context, list []models.Coupon) error {
+	for i := range list {
+		query := `SELECT product_id FROM coupon_products WHERE coupon_id = ?`
+		rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), list[i].CouponID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var productID uuid.UUID
+			if err := rows.Scan(&productID); err != nil {
+				rows.Close()
+				return err
+			}
+			list[i].ProductIDs = append(list[i].ProductIDs, productID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		query = `SELECT category FROM coupon_categories WHERE coupon_id = ? ORDER BY category`
+		rows, err = r.DB.QueryContext(ctx, r.Dialect.rebind(query), list[i].CouponID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var category string
+			if err := rows.Scan(&category); err != nil {
+				rows.Close()
+				return err
+			}
+			list[i].Categories = append(list[i].Categories, category)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *CouponRepository) GetCouponByCode(ctx context.Context, code string) (*models.Coupon, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + couponColumns + ` FROM coupons WHERE code = ?`
+	row := r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), models.NormalizeCouponCode(code))
+
+	coupon, err := scanCoupon(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	found := []models.Coupon{coupon}
+	if err := r.loadCouponRestrictions(ctx, found); err != nil {
+		return nil, err
+	}
+	return &found[0], nil
+}
+
+func (r *CouponRepository) ListCoupons(ctx context.Context) ([]models.Coupon, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + couponColumns + ` FROM coupons ORDER BY date_created DESC, coupon_id DESC`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Coupon
+	for rows.Next() {
+		coupon, err := scanCoupon(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, coupon)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	// Release the connection before loading the restrictions, SQLite only
+	// has one
+	rows.Close()
+
+	if err := r.loadCouponRestrictions(ctx, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (r *CouponRepository) SetCouponActive(ctx context.Context, couponID int64, active bool) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `UPDATE coupons SET active = ? WHERE coupon_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), active, couponID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// MySQL does not count rows that already had the value
+	if updated == 0 && r.Dialect != MySQL {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CountRedemptions returns how often userID redeemed the coupon.
+func (r *CouponRepository) CountRedemptions(ctx context.Context, couponID int64, userID string) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var count int
+	query := `SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ? AND user_id = ?`
+	err := r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), couponID, userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// redeemCoupon records the use of order.CouponCode by order as part of tx.
+// The usage limits are checked again here, as other orders may have used
+// the coupon since it was applied to the cart.
+func redeemCoupon(ctx context.Context, tx *sql.Tx, dialect Dialect, order *models.Order) error {
+	var coupon models.Coupon
+	query := `SELECT coupon_id, max_uses, max_uses_per_customer, times_used FROM coupons WHERE code = ?`
+	err := tx.QueryRowContext(ctx, dialect.rebind(query), order.CouponCode).Scan(
+		&coupon.CouponID,
+		&coupon.MaxUses,
+		&coupon.MaxUsesPerCustomer,
+		&coupon.TimesUsed,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var customerUses int
+	query = `SELECT COUNT(*) FROM coupon_redemptions WHERE coupon_id = ? AND user_id = ?`
+	if err := tx.QueryRowContext(ctx, dialect.rebind(query), coupon.CouponID, order.UserID).Scan(&customerUses); err != nil {
+		return err
+	}
+	if err := coupons.CheckUsage(&coupon, customerUses); err != nil {
+		return err
+	}
+
+	// The condition keeps concurrent orders from going over the limit
+	query = `UPDATE coupons SET times_used = times_used + 1 WHERE coupon_id = ? AND (max_uses = 0 OR times_used < max_uses)`
+	res, err := tx.ExecContext(ctx, dialect.rebind(query), coupon.CouponID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return coupons.ErrUsedUp
+	}
+
+	query = `INSERT INTO coupon_redemptions (coupon_id, order_id, user_id, discount, redeemed_at) VALUES (?, ?, ?, ?, ?)`
+	_, err = tx.ExecContext(ctx, dialect.rebind(query),
+		coupon.CouponID, order.OrderID, order.UserID, order.Discount, order.OrderDate.UTC())
+	return err
+}
+
+// releaseCoupon gives back the coupon redeemed by an order that will not be
+// paid, so that it counts neither against the coupon's limits nor the
+// customer's.
+func releaseCoupon(ctx context.Context, tx *sql.Tx, dialect Dialect, orderID uuid.UUID) error {
+	var couponID int64
+	query := `SELECT coupon_id FROM coupon_redemptions WHERE order_id = ?`
+	err := tx.QueryRowContext(ctx, dialect.rebind(query), orderID).Scan(&couponID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `DELETE FROM coupon_redemptions WHERE order_id = ?`
+	if _, err := tx.ExecContext(ctx, dialect.rebind(query), orderID); err != nil {
+		return err
+	}
+	query = `UPDATE coupons SET times_used = times_used - 1 WHERE coupon_id = ? AND times_used > 0`
+	_, err = tx.ExecContext(ctx, dialect.rebind(query), couponID)
+	return err
+}
@@ -0,0 +1,307 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+const jobColumns = `job_id, kind, payload, status, attempts, max_attempts, run_at, locked_until, last_error,
+	date_created, date_modified`
+
+type JobRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewJobRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *JobRepository {
+	return &JobRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+func scanJob(row scanner) (*models.Job, error) {
+	var job models.Job
+	var payload string
+	var lastError sql.NullString
+	err := row.Scan(
+		&job.JobID,
+		&job.Kind,
+		&payload,
+		&job.Status,
+		&job.Attempts,
+		&job.MaxAttempts,
+		&job.RunAt,
+		&job.LockedUntil,
+		&lastError,
+		&job.DateCreated,
+		&job.DateModified,
+	)
+	if err != nil {
+		return nil, err
+	}
+	job.Payload = []byte(payload)
+	job.LastError = lastError.String
+	return &job, nil
+}
+
+func (r *JobRepository) EnqueueJob(ctx context.Context, job *models.Job) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := insertJob(ctx, tx, r.Dialect, job); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// EnsureJob enqueues job unless a job of the same kind is queued or running.
+func (r *JobRepository) EnsureJob(ctx context.Context, job *models.Job) (bool, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	var pending int
+	query := `SELECT COUNT(*) FROM jobs WHERE kind = ? AND status IN (?, ?)`
+	err = tx.QueryRowContext(ctx, r.Dialect.rebind(query), job.Kind, models.JobQueued, models.JobRunning).Scan(&pending)
+	if err != nil {
+		return false, err
+	}
+	if pending > 0 {
+		return false, nil
+	}
+	if err := insertJob(ctx, tx, r.Dialect, job); err != nil {
+		return false, err
+	}
+	return true, tx.Commit()
+}
+
+// insertJob saves a new queued job. A job without a RunAt is due now.
+func insertJob(ctx context.Context, tx *sql.Tx, dialect Dialect, job *models.Job) error {
+	job.Status = models.JobQueued
+	job.Attempts = 0
+	job.LockedUntil = nil
+	job.DateCreated = time.Now()
+	job.DateModified = job.DateCreated
+	if job.RunAt.IsZero() {
+		job.RunAt = job.DateCreated
+	}
+	if job.Payload == nil {
+		job.Payload = []byte("null")
+	}
+
+	query := `INSERT INTO jobs (kind, payload, status, attempts, max_attempts, run_at, last_error, date_created, date_modified)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []any{
+		job.Kind,
+		string(job.Payload),
+		job.Status,
+		job.Attempts,
+		job.MaxAttempts,
+		job.RunAt.UTC(),
+		nullString(job.LastError),
+		job.DateCreated.UTC(),
+		job.DateModified.UTC(),
+	}
+	if dialect == Postgres {
+		return tx.QueryRowContext(ctx, dialect.rebind(query+" RETURNING job_id"), args...).Scan(&job.JobID)
+	}
+	res, err := tx.ExecContext(ctx, dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	job.JobID, err = res.LastInsertId()
+	return err
+}
+
+// ClaimJob picks the job that has been due the longest and claims it with an
+// update guarded by its status and attempts, so that of two workers picking
+// the same job only one gets it. The other one picks again.
+func (r *JobRepository) ClaimJob(ctx context.Context, now, lockUntil time.Time) (*models.Job, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	due := `SELECT job_id, status, attempts FROM jobs
+            WHERE (status = ? AND run_at <= ?) OR (status = ? AND locked_until <= ?)
+            ORDER BY run_at, job_id LIMIT 1`
+	claim := `UPDATE jobs SET status = ?, attempts = attempts + 1, locked_until = ?, date_modified = ?
+              WHERE job_id = ? AND status = ? AND attempts = ?`
+	for {
+		var jobID int64
+		var status models.JobStatus
+		var attempts int
+		err := r.DB.QueryRowContext(ctx, r.Dialect.rebind(due), models.JobQueued, now.UTC(), models.JobRunning, now.UTC()).
+			Scan(&jobID, &status, &attempts)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, ErrNotFound
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(claim),
+			models.JobRunning, lockUntil.UTC(), now.UTC(), jobID, status, attempts)
+		if err != nil {
+			return nil, err
+		}
+		claimed, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if claimed == 1 {
+			return r.getJob(ctx, jobID)
+		}
+	}
+}
+
+func (r *JobRepository) CompleteJob(ctx context.Context, job *models.Job) error {
+	return r.finishJob(ctx, job, `status = ?, locked_until = NULL`, models.JobSucceeded)
+}
+
+func (r *JobRepository) RetryJob(ctx context.Context, job *models.Job, runAt time.Time, lastError string) error {
+	return r.finishJob(ctx, job, `status = ?, locked_until = NULL, run_at = ?, last_error = ?`,
+		models.JobQueued, runAt.UTC(), lastError)
+}
+
+func (r *JobRepository) BuryJob(ctx context.Context, job *models.Job, lastError string) error {
+	return r.finishJob(ctx, job, `status = ?, locked_until = NULL, last_error = ?`, models.JobDead, lastError)
+}
+
+// finishJob sets the columns of a claimed job, unless another worker claimed
+// it since.
+func (r *JobRepository) finishJob(ctx context.Context, job *models.Job, set string, args ...any) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `UPDATE jobs SET ` + set + `, date_modified = ? WHERE job_id = ? AND status = ? AND attempts = ?`
+	args = append(args, time.Now().UTC(), job.JobID, models.JobRunning, job.Attempts)
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	finished, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if finished == 0 {
+		return ErrJobLost
+	}
+	return nil
+}
+
+func (r *JobRepository) RequeueJob(ctx context.Context, jobID int64) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	now := time.Now().UTC()
+	query := `UPDATE jobs SET status = ?, attempts = 0, run_at = ?, date_modified = ? WHERE job_id = ? AND status IN (?, ?)`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), models.JobQueued, now, now, jobID, models.JobQueued, models.JobDead)
+	if err != nil {
+		return err
+	}
+	requeued, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if requeued > 0 {
+		return nil
+	}
+	if _, err := r.getJob(ctx, jobID); err != nil {
+		return err
+	}
+	return ErrJobStatus
+}
+
+func (r *JobRepository) GetJob(ctx context.Context, jobID int64) (*models.Job, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	return r.getJob(ctx, jobID)
+}
+
+func (r *JobRepository) getJob(ctx context.Context, jobID int64) (*models.Job, error) {
+	query := `SELECT ` + jobColumns + ` FROM jobs WHERE job_id = ?`
+	job, err := scanJob(r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), jobID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return job, err
+}
+
+func (r *JobRepository) ListJobs(ctx context.Context, status models.JobStatus, limit, offset int) ([]models.Job, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + jobColumns + ` FROM jobs`
+	var args []any
+	if status != "" {
+		query += ` WHERE status = ?`
+		args = append(args, status)
+	}
+	query += ` ORDER BY run_at DESC, job_id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var jobs []models.Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, *job)
+	}
+	return jobs, rows.Err()
+}
+
+func (r *JobRepository) CountJobs(ctx context.Context) (map[models.JobStatus]int, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rows, err := r.DB.QueryContext(ctx, `SELECT status, COUNT(*) FROM jobs GROUP BY status`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	counts := map[models.JobStatus]int{}
+	for rows.Next() {
+		var status models.JobStatus
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return nil, err
+		}
+		counts[status] = count
+	}
+	return counts, rows.Err()
+}
+
+func (r *JobRepository) PurgeJobs(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM jobs WHERE status = ? AND date_modified < ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), models.JobSucceeded, before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	return int(purged), err
+}
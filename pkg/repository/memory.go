@@ -0,0 +1,2169 @@
+package repository
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/coupons"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// MemoryProductStore is a thread-safe in-memory ProductStore.
+type MemoryProductStore struct {
+	mu       sync.RWMutex
+	products map[uuid.UUID]models.Product
+
+	// ordered reports whether a product is part of an order. It is set by
+	// NewMemoryOrderStore, like the foreign key on order_items.
+	ordered func(productID uuid.UUID) bool
+	// priced adds a price to the history. It is set by NewMemoryPriceStore.
+	priced func(price models.ProductPrice)
+	// outboxed saves a domain event. It is set by NewMemoryOutboxStore.
+	outboxed func(event models.OutboxEvent)
+}
+
+func NewMemoryProductStore() *MemoryProductStore {
+	return &MemoryProductStore{products: make(map[uuid.UUID]models.Product)}
+}
+
+func (s *MemoryProductStore) GetProductByID(ctx context.Context, productID uuid.UUID) (*models.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	product, ok := s.products[productID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &product, nil
+}
+
+func (s *MemoryProductStore) CreateProduct(ctx context.Context, product *models.Product) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product.ProductID = uuid.New()
+	product.Version = 1
+	product.DateCreated = time.Now()
+	product.DateModified = time.Now()
+	productDefaults(product)
+	s.products[product.ProductID] = *product
+	s.recordPrice(product.ProductID, product.Price, product.DateCreated)
+	return nil
+}
+
+func (s *MemoryProductStore) recordPrice(productID uuid.UUID, price float64, at time.Time) {
+	if s.priced != nil {
+		s.priced(models.ProductPrice{ProductID: productID, Price: price, EffectiveAt: at, AppliedAt: &at})
+	}
+}
+
+func (s *MemoryProductStore) UpdateProduct(ctx context.Context, product *models.Product) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product.DateModified = time.Now()
+	productDefaults(product)
+	existing, ok := s.products[product.ProductID]
+	if !ok {
+		return ErrNotFound
+	}
+	if existing.Version != product.Version {
+		return ErrVersionConflict
+	}
+	if priceChanged(existing.Price, product.Price) {
+		s.recordPrice(product.ProductID, product.Price, product.DateModified)
+	}
+	product.Version++
+	existing.Version = product.Version
+	existing.ProductName = product.ProductName
+	existing.Price = product.Price
+	existing.Description = product.Description
+	existing.Status = product.Status
+	existing.PublishAt = product.PublishAt
+	existing.UnpublishAt = product.UnpublishAt
+	existing.TaxClass = product.TaxClass
+	existing.Category = product.Category
+	existing.Weight = product.Weight
+	existing.Stock = product.Stock
+	existing.DateModified = product.DateModified
+	s.products[product.ProductID] = existing
+	s.record(models.EventProductUpdated, product.ProductID)
+	return nil
+}
+
+// record saves an event about a product as it is now, like the SQL store
+// does in the transaction of the change. Callers hold the lock.
+func (s *MemoryProductStore) record(eventType models.EventType, productID uuid.UUID) {
+	product, ok := s.products[productID]
+	if !ok || s.outboxed == nil {
+		return
+	}
+	data := models.ProductEvent{ProductID: productID, Price: product.Price, Stock: product.Stock, Version: product.Version}
+	event, err := models.NewOutboxEvent(eventType, productID.String(), data)
+	if err != nil {
+		panic(err)
+	}
+	s.outboxed(event)
+}
+
+func (s *MemoryProductStore) ArchiveProduct(ctx context.Context, productID uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productID]
+	if !ok || product.DeletedAt != nil {
+		return nil
+	}
+	now := time.Now()
+	product.DeletedAt = &now
+	s.products[productID] = product
+	s.record(models.EventProductArchived, productID)
+	return nil
+}
+
+func (s *MemoryProductStore) RestoreProduct(ctx context.Context, productID uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productID]
+	if !ok || product.DeletedAt == nil {
+		return nil
+	}
+	product.DeletedAt = nil
+	s.products[productID] = product
+	s.record(models.EventProductUpdated, productID)
+	return nil
+}
+
+func (s *MemoryProductStore) DeleteProduct(ctx context.Context, productID uuid.UUID) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.ordered != nil && s.ordered(productID) {
+		return ErrProductInUse
+	}
+	if _, ok := s.products[productID]; !ok {
+		return ErrNotFound
+	}
+	delete(s.products, productID)
+	return nil
+}
+
+// applyPrice sets the price of a product the way a scheduled price change
+// does, without adding to the history again.
+func (s *MemoryProductStore) applyPrice(productID uuid.UUID, price float64, at time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	product, ok := s.products[productID]
+	if !ok {
+		return
+	}
+	product.Price = price
+	product.Version++
+	product.DateModified = at
+	s.products[productID] = product
+	s.record(models.EventProductUpdated, productID)
+}
+
+// takeStock takes the ordered quantities out of the stock of tracked
+// products, or nothing at all when one of them has too few left.
+func (s *MemoryProductStore) takeStock(items []models.OrderItem) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range items {
+		product := s.products[item.ProductID]
+		if product.Stock != nil && *product.Stock < item.Quantity {
+			return fmt.Errorf("%w: product %s", ErrOutOfStock, item.ProductID)
+		}
+	}
+	for _, item := range items {
+		s.addStock(item.ProductID, -item.Quantity)
+	}
+	return nil
+}
+
+// putBack undoes takeStock for an order that could not be placed.
+func (s *MemoryProductStore) putBack(items []models.OrderItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, item := range items {
+		s.addStock(item.ProductID, item.Quantity)
+	}
+}
+
+// restock puts the given quantities of tracked products back in stock.
+func (s *MemoryProductStore) restock(quantities map[uuid.UUID]int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for productID, quantity := range quantities {
+		s.addStock(productID, quantity)
+	}
+	s.recordStockChanges(slices.Collect(maps.Keys(quantities)))
+}
+
+// stockChanged saves the events of the stock taken for items.
+func (s *MemoryProductStore) stockChanged(items []models.OrderItem) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var productIDs []uuid.UUID
+	for _, item := range items {
+		productIDs = append(productIDs, item.ProductID)
+	}
+	s.recordStockChanges(productIDs)
+}
+
+// recordStockChanges takes a new version of every tracked product in
+// productIDs, as the stock changed under edits of the version before, and
+// saves a stock.changed event for it, in the order the SQL store saves
+// them. Callers hold the lock.
+func (s *MemoryProductStore) recordStockChanges(productIDs []uuid.UUID) {
+	slices.SortFunc(productIDs, func(a, b uuid.UUID) int { return strings.Compare(a.String(), b.String()) })
+	for _, productID := range slices.Compact(productIDs) {
+		if product, ok := s.products[productID]; ok && product.Stock != nil {
+			product.Version++
+			s.products[productID] = product
+			s.record(models.EventStockChanged, productID)
+		}
+	}
+}
+
+func (s *MemoryProductStore) addStock(productID uuid.UUID, quantity int) {
+	product, ok := s.products[productID]
+	if !ok || product.Stock == nil {
+		return
+	}
+	// A new value rather than an update through the pointer, which is
+	// shared with the products handed out
+	stock := *product.Stock + quantity
+	product.Stock = &stock
+	s.products[productID] = product
+}
+
+func (s *MemoryProductStore) ListProducts(ctx context.Context, filter ProductFilter, limit, offset int) ([]models.Product, error) {
+	products, err := s.GetProducts(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return page(products, limit, offset), nil
+}
+
+func (s *MemoryProductStore) GetTotalProductsCount(ctx context.Context, filter ProductFilter) (int, error) {
+	products, err := s.GetProducts(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return len(products), nil
+}
+
+func (s *MemoryProductStore) GetProducts(ctx context.Context, filter ProductFilter) ([]models.Product, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var products []models.Product
+	for _, p := range s.products {
+		if filter.Archived != p.Archived() {
+			continue
+		}
+		if filter.WithImage && p.ProductImage == "" {
+			continue
+		}
+		if !filter.ListedAt.IsZero() && !p.Listed(filter.ListedAt) {
+			continue
+		}
+		products = append(products, p)
+	}
+	slices.SortStableFunc(products, func(a, b models.Product) int {
+		return b.DateCreated.Compare(a.DateCreated)
+	})
+	return products, nil
+}
+
+// MemoryOrderStore is a thread-safe in-memory OrderStore. It reads products
+// from a MemoryProductStore the same way the SQL store joins on products.
+type MemoryOrderStore struct {
+	mu       sync.RWMutex
+	products *MemoryProductStore
+	orders   map[uuid.UUID]models.Order
+	items    map[uuid.UUID][]models.OrderItem
+
+	// redeem records the coupon of a placed order and release gives it
+	// back. They are set by NewMemoryCouponStore.
+	redeem  func(order *models.Order) error
+	release func(order *models.Order)
+	// outboxed saves a domain event. It is set by NewMemoryOutboxStore.
+	outboxed func(event models.OutboxEvent)
+}
+
+func NewMemoryOrderStore(products *MemoryProductStore) *MemoryOrderStore {
+	s := &MemoryOrderStore{
+		products: products,
+		orders:   make(map[uuid.UUID]models.Order),
+		items:    make(map[uuid.UUID][]models.OrderItem),
+	}
+	products.ordered = s.hasProduct
+	return s
+}
+
+func (s *MemoryOrderStore) hasProduct(productID uuid.UUID) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, items := range s.items {
+		for _, item := range items {
+			if item.ProductID == productID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (s *MemoryOrderStore) PlaceOrderWithItems(ctx context.Context, orderItems []models.OrderItem) error {
+	return s.PlaceOrder(ctx, &models.Order{
+		UserID: "fk@htmxrocks.com",
+		Items:  orderItems,
+	})
+}
+
+func (s *MemoryOrderStore) PlaceOrder(ctx context.Context, order *models.Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// Stock is handled outside the lock on orders, the product store takes
+	// it in turn when deleting a product
+	if err := s.products.takeStock(order.Items); err != nil {
+		return err
+	}
+	if err := s.place(order); err != nil {
+		// Give the stock back, like the rolled back transaction in the SQL
+		// store
+		s.products.putBack(order.Items)
+		return err
+	}
+	s.products.stockChanged(order.Items)
+	return nil
+}
+
+// record saves an event about an order. Callers hold the lock.
+func (s *MemoryOrderStore) record(eventType models.EventType, data models.OrderEvent) {
+	if s.outboxed == nil {
+		return
+	}
+	event, err := models.NewOutboxEvent(eventType, data.OrderID.String(), data)
+	if err != nil {
+		panic(err)
+	}
+	s.outboxed(event)
+}
+
+func (s *MemoryOrderStore) place(order *models.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order.OrderID = uuid.New()
+	if order.OrderStatus == "" {
+		order.OrderStatus = models.OrderStatusOrdered
+	}
+	order.OrderDate = time.Now()
+	for i := range order.Items {
+		order.Items[i].OrderID = order.OrderID
+	}
+	// Only the totals of the line discounts are stored, not their labels
+	items := slices.Clone(order.Items)
+	for i := range items {
+		items[i].Promotions = nil
+	}
+
+	// Redeem first so a used up coupon leaves nothing behind, like the
+	// rolled back transaction in the SQL store
+	if order.CouponCode != "" {
+		if s.redeem == nil {
+			return ErrNotFound
+		}
+		if err := s.redeem(order); err != nil {
+			return err
+		}
+	}
+
+	stored := *order
+	stored.Items = nil
+	stored.Promotions = slices.Clone(order.Promotions)
+	stored.ShippingAddress = orderAddress(order.ShippingAddress)
+	stored.BillingAddress = orderAddress(order.BillingAddress)
+	s.orders[order.OrderID] = stored
+	s.items[order.OrderID] = append(s.items[order.OrderID], items...)
+	s.record(models.EventOrderPlaced, models.OrderEvent{OrderID: order.OrderID, UserID: order.UserID, Status: order.OrderStatus})
+	return nil
+}
+
+func (s *MemoryOrderStore) SetOrderStatus(ctx context.Context, orderID uuid.UUID, status string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	order, ok := s.orders[orderID]
+	if !ok {
+		s.mu.Unlock()
+		return ErrNotFound
+	}
+	if order.OrderStatus == status {
+		s.mu.Unlock()
+		return nil
+	}
+	previous := order.OrderStatus
+	order.OrderStatus = status
+	s.orders[orderID] = order
+	s.mu.Unlock()
+
+	if status == models.OrderStatusPaymentFailed {
+		if order.CouponCode != "" && s.release != nil {
+			s.release(&order)
+		}
+		s.products.restock(s.orderedQuantities(orderID))
+	}
+	s.mu.Lock()
+	s.record(models.EventOrderStatusChanged, models.OrderEvent{OrderID: orderID, UserID: order.UserID, Status: status, PreviousStatus: previous})
+	s.mu.Unlock()
+	return nil
+}
+
+// orderedQuantities returns the quantity of every product of an order.
+func (s *MemoryOrderStore) orderedQuantities(orderID uuid.UUID) map[uuid.UUID]int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	quantities := make(map[uuid.UUID]int)
+	for _, item := range s.items[orderID] {
+		quantities[item.ProductID] += item.Quantity
+	}
+	return quantities
+}
+
+// addRefund adds amount to the refunded amount of an order.
+func (s *MemoryOrderStore) addRefund(orderID uuid.UUID, amount float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if order, ok := s.orders[orderID]; ok {
+		order.RefundedAmount += amount
+		s.orders[orderID] = order
+	}
+}
+
+func (s *MemoryOrderStore) ListOrders(ctx context.Context, limit, offset int) ([]models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	orders := make([]models.Order, 0, len(s.orders))
+	for _, o := range s.orders {
+		orders = append(orders, o)
+	}
+	slices.SortStableFunc(orders, func(a, b models.Order) int {
+		return b.OrderDate.Compare(a.OrderDate)
+	})
+	return page(orders, limit, offset), nil
+}
+
+func (s *MemoryOrderStore) ListUserOrders(ctx context.Context, userID string) ([]models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orders []models.Order
+	for _, o := range s.orders {
+		if o.UserID == userID {
+			orders = append(orders, o)
+		}
+	}
+	slices.SortStableFunc(orders, func(a, b models.Order) int {
+		return b.OrderDate.Compare(a.OrderDate)
+	})
+	return orders, nil
+}
+
+func (s *MemoryOrderStore) GetTotalOrdersCount(ctx context.Context) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return len(s.orders), nil
+}
+
+func (s *MemoryOrderStore) CreateOrder(ctx context.Context, order *models.Order) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	order.OrderID = uuid.New()
+	order.OrderDate = time.Now()
+	stored := *order
+	stored.Items = nil
+	s.orders[order.OrderID] = stored
+	return nil
+}
+
+func (s *MemoryOrderStore) AddOrderItem(ctx context.Context, orderItem *models.OrderItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.items[orderItem.OrderID] = append(s.items[orderItem.OrderID], *orderItem)
+	return nil
+}
+
+func (s *MemoryOrderStore) GetOrderWithProducts(ctx context.Context, orderID uuid.UUID) (*models.Order, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	order, ok := s.orders[orderID]
+	items := slices.Clone(s.items[orderID])
+	s.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	order.ShippingAddress = orderAddress(order.ShippingAddress)
+	order.BillingAddress = orderAddress(order.BillingAddress)
+
+	for _, item := range items {
+		product, err := s.products.GetProductByID(ctx, item.ProductID)
+		if err == ErrNotFound {
+			// Mirrors the inner join on products in the SQL store
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		item.Product = *product
+		// Items added without a cost have a NULL cost in the SQL store,
+		// which falls back to the current price
+		if item.Cost == 0 && item.Discount == 0 {
+			item.Cost = item.RegularCost()
+		}
+		order.Items = append(order.Items, item)
+	}
+	return &order, nil
+}
+
+// sales returns the orders that count as sales placed from from until to,
+// with their items, oldest first.
+func (s *MemoryOrderStore) sales(from, to time.Time) []models.Order {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var orders []models.Order
+	for _, order := range s.orders {
+		if !models.IsSale(order.OrderStatus) || order.OrderDate.Before(from) || !order.OrderDate.Before(to) {
+			continue
+		}
+		order.Items = slices.Clone(s.items[order.OrderID])
+		orders = append(orders, order)
+	}
+	slices.SortFunc(orders, func(a, b models.Order) int {
+		return a.OrderDate.Compare(b.OrderDate)
+	})
+	return orders
+}
+
+func (s *MemoryOrderStore) SalesSummary(ctx context.Context, from, to time.Time) (models.SalesSummary, error) {
+	if err := ctx.Err(); err != nil {
+		return models.SalesSummary{}, err
+	}
+	var summary models.SalesSummary
+	for _, order := range s.sales(from, to) {
+		summary.Orders++
+		summary.Revenue += order.NetTotal()
+	}
+	return summary, nil
+}
+
+func (s *MemoryOrderStore) DailySales(ctx context.Context, from, to time.Time, loc *time.Location) ([]models.DailySales, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	days := salesDays(from, to, loc)
+	for _, order := range s.sales(from, to) {
+		addDailySale(days, order.OrderDate, order.NetTotal())
+	}
+	return days, nil
+}
+
+// sold sums up the units and cost of every product sold from from until to.
+func (s *MemoryOrderStore) sold(from, to time.Time) map[uuid.UUID]*models.ProductSales {
+	sold := map[uuid.UUID]*models.ProductSales{}
+	for _, order := range s.sales(from, to) {
+		for _, item := range order.Items {
+			sales := sold[item.ProductID]
+			if sales == nil {
+				sales = &models.ProductSales{ProductID: item.ProductID}
+				sold[item.ProductID] = sales
+			}
+			sales.Quantity += item.Quantity
+			sales.Revenue += item.Cost
+		}
+	}
+	return sold
+}
+
+func (s *MemoryOrderStore) TopProducts(ctx context.Context, from, to time.Time, limit int) ([]models.ProductSales, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	var products []models.ProductSales
+	for _, sales := range s.sold(from, to) {
+		// Like the left join on products in the SQL store, products deleted
+		// since are listed without a name
+		if product, err := s.products.GetProductByID(ctx, sales.ProductID); err == nil {
+			sales.ProductName = product.ProductName
+		}
+		products = append(products, *sales)
+	}
+	slices.SortFunc(products, func(a, b models.ProductSales) int {
+		return cmp.Or(
+			cmp.Compare(b.Quantity, a.Quantity),
+			cmp.Compare(b.Revenue, a.Revenue),
+			strings.Compare(a.ProductName, b.ProductName),
+		)
+	})
+	return page(products, limit, 0), nil
+}
+
+func (s *MemoryOrderStore) LowStock(ctx context.Context, threshold int, from, to time.Time, limit int) ([]models.StockAlert, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	products, err := s.products.GetProducts(ctx, ProductFilter{})
+	if err != nil {
+		return nil, err
+	}
+	sold := s.sold(from, to)
+	var alerts []models.StockAlert
+	for _, product := range products {
+		if product.Stock == nil || *product.Stock > threshold {
+			continue
+		}
+		alert := models.StockAlert{ProductID: product.ProductID, ProductName: product.ProductName, Stock: *product.Stock}
+		if sales := sold[product.ProductID]; sales != nil {
+			alert.Sold = sales.Quantity
+		}
+		alerts = append(alerts, alert)
+	}
+	slices.SortFunc(alerts, func(a, b models.StockAlert) int {
+		return cmp.Or(
+			cmp.Compare(a.Stock, b.Stock),
+			cmp.Compare(b.Sold, a.Sold),
+			strings.Compare(a.ProductName, b.ProductName),
+		)
+	})
+	return page(alerts, limit, 0), nil
+}
+
+// orderAddress copies the parts of address that orders keep, like the
+// order_addresses table of the SQL store.
+func orderAddress(address *models.Address) *models.Address {
+	if address == nil {
+		return nil
+	}
+	clone := *address
+	clone.AddressID, clone.UserID, clone.DateCreated = 0, "", time.Time{}
+	return &clone
+}
+
+func page[T any](items []T, limit, offset int) []T {
+	if offset >= len(items) {
+		return nil
+	}
+	items = items[offset:]
+	if limit < len(items) {
+		items = items[:limit]
+	}
+	return items
+}
+
+// MemoryAuditStore is a thread-safe in-memory AuditStore.
+type MemoryAuditStore struct {
+	mu     sync.RWMutex
+	events []models.AuditEvent
+}
+
+func NewMemoryAuditStore() *MemoryAuditStore {
+	return &MemoryAuditStore{}
+}
+
+func (s *MemoryAuditStore) RecordEvent(ctx context.Context, event *models.AuditEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if event.OccurredAt.IsZero() {
+		event.OccurredAt = time.Now()
+	}
+	event.ID = int64(len(s.events) + 1)
+	s.events = append(s.events, *event)
+	return nil
+}
+
+func (s *MemoryAuditStore) ListEvents(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEvent, error) {
+	events, err := s.filter(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+	return page(events, limit, offset), nil
+}
+
+func (s *MemoryAuditStore) CountEvents(ctx context.Context, filter AuditFilter) (int, error) {
+	events, err := s.filter(ctx, filter)
+	if err != nil {
+		return 0, err
+	}
+	return len(events), nil
+}
+
+// filter returns the matching events, newest first.
+func (s *MemoryAuditStore) filter(ctx context.Context, filter AuditFilter) ([]models.AuditEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []models.AuditEvent
+	for i := len(s.events) - 1; i >= 0; i-- {
+		e := s.events[i]
+		if (filter.Actor != "" && e.Actor != filter.Actor) ||
+			(filter.Action != "" && e.Action != filter.Action) ||
+			(filter.EntityType != "" && e.EntityType != filter.EntityType) ||
+			(filter.EntityID != "" && e.EntityID != filter.EntityID) {
+			continue
+		}
+		events = append(events, e)
+	}
+	slices.SortStableFunc(events, func(a, b models.AuditEvent) int {
+		return b.OccurredAt.Compare(a.OccurredAt)
+	})
+	return events, nil
+}
+
+// MemoryPriceStore is a thread-safe in-memory PriceStore. It records the
+// prices set through its MemoryProductStore and applies scheduled prices to it.
+type MemoryPriceStore struct {
+	mu       sync.Mutex
+	products *MemoryProductStore
+	prices   []models.ProductPrice
+	nextID   int64
+}
+
+func NewMemoryPriceStore(products *MemoryProductStore) *MemoryPriceStore {
+	s := &MemoryPriceStore{products: products}
+	products.priced = s.add
+	return s
+}
+
+func (s *MemoryPriceStore) add(price models.ProductPrice) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	price.ID = s.nextID
+	price.CreatedAt = time.Now()
+	s.prices = append(s.prices, price)
+}
+
+func (s *MemoryPriceStore) ListPrices(ctx context.Context, productID uuid.UUID) ([]models.ProductPrice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var prices []models.ProductPrice
+	for _, p := range s.prices {
+		if p.ProductID == productID {
+			prices = append(prices, p)
+		}
+	}
+	slices.SortStableFunc(prices, func(a, b models.ProductPrice) int {
+		return a.EffectiveAt.Compare(b.EffectiveAt)
+	})
+	return prices, nil
+}
+
+func (s *MemoryPriceStore) SchedulePrice(ctx context.Context, price *models.ProductPrice) error {
+	if _, err := s.products.GetProductByID(ctx, price.ProductID); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	price.ID = s.nextID
+	price.AppliedAt = nil
+	price.CreatedAt = time.Now()
+	s.prices = append(s.prices, *price)
+	return nil
+}
+
+func (s *MemoryPriceStore) CancelScheduledPrice(ctx context.Context, productID uuid.UUID, id int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i, p := range s.prices {
+		if p.ID == id && p.ProductID == productID && p.Scheduled() {
+			s.prices = slices.Delete(s.prices, i, i+1)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryPriceStore) ApplyDuePrices(ctx context.Context, now time.Time) ([]models.ProductPrice, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	// Prices are marked under the lock and written to the products after
+	// releasing it, as UpdateProduct takes the locks the other way around.
+	s.mu.Lock()
+	var applied []models.ProductPrice
+	for i, p := range s.prices {
+		if !p.Scheduled() || p.EffectiveAt.After(now) {
+			continue
+		}
+		appliedAt := now
+		s.prices[i].AppliedAt = &appliedAt
+		applied = append(applied, s.prices[i])
+	}
+	s.mu.Unlock()
+
+	slices.SortStableFunc(applied, func(a, b models.ProductPrice) int {
+		return a.EffectiveAt.Compare(b.EffectiveAt)
+	})
+	for _, p := range applied {
+		s.products.applyPrice(p.ProductID, p.Price, now)
+	}
+	return applied, nil
+}
+
+// MemoryCouponStore is a thread-safe in-memory CouponStore. It redeems the
+// coupons of orders placed through its MemoryOrderStore.
+type MemoryCouponStore struct {
+	mu          sync.RWMutex
+	coupons     []models.Coupon
+	redemptions map[int64]map[string]int
+}
+
+func NewMemoryCouponStore(orders *MemoryOrderStore) *MemoryCouponStore {
+	s := &MemoryCouponStore{redemptions: make(map[int64]map[string]int)}
+	orders.redeem = s.redeem
+	orders.release = s.release
+	return s
+}
+
+func (s *MemoryCouponStore) CreateCoupon(ctx context.Context, coupon *models.Coupon) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	coupon.Code = models.NormalizeCouponCode(coupon.Code)
+	for _, c := range s.coupons {
+		if c.Code == coupon.Code {
+			return ErrCouponCodeTaken
+		}
+	}
+	coupon.CouponID = int64(len(s.coupons) + 1)
+	coupon.TimesUsed = 0
+	coupon.DateCreated = time.Now()
+	stored := *coupon
+	stored.ProductIDs = slices.Clone(coupon.ProductIDs)
+	stored.Categories = slices.Clone(coupon.Categories)
+	slices.Sort(stored.Categories)
+	s.coupons = append(s.coupons, stored)
+	return nil
+}
+
+func (s *MemoryCouponStore) GetCouponByCode(ctx context.Context, code string) (*models.Coupon, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	code = models.NormalizeCouponCode(code)
+	for _, c := range s.coupons {
+		if c.Code == code {
+			c.ProductIDs = slices.Clone(c.ProductIDs)
+			c.Categories = slices.Clone(c.Categories)
+			return &c, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryCouponStore) ListCoupons(ctx context.Context) ([]models.Coupon, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	coupons := make([]models.Coupon, 0, len(s.coupons))
+	for i := len(s.coupons) - 1; i >= 0; i-- {
+		c := s.coupons[i]
+		c.ProductIDs = slices.Clone(c.ProductIDs)
+		c.Categories = slices.Clone(c.Categories)
+		coupons = append(coupons, c)
+	}
+	return coupons, nil
+}
+
+func (s *MemoryCouponStore) SetCouponActive(ctx context.Context, couponID int64, active bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.coupons {
+		if s.coupons[i].CouponID == couponID {
+			s.coupons[i].Active = active
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryCouponStore) CountRedemptions(ctx context.Context, couponID int64, userID string) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.redemptions[couponID][userID], nil
+}
+
+func (s *MemoryCouponStore) redeem(order *models.Order) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.coupons, func(c models.Coupon) bool { return c.Code == order.CouponCode })
+	if i < 0 {
+		return ErrNotFound
+	}
+	coupon := &s.coupons[i]
+	if err := coupons.CheckUsage(coupon, s.redemptions[coupon.CouponID][order.UserID]); err != nil {
+		return err
+	}
+	coupon.TimesUsed++
+	if s.redemptions[coupon.CouponID] == nil {
+		s.redemptions[coupon.CouponID] = make(map[string]int)
+	}
+	s.redemptions[coupon.CouponID][order.UserID]++
+	return nil
+}
+
+func (s *MemoryCouponStore) release(order *models.Order) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.coupons, func(c models.Coupon) bool { return c.Code == order.CouponCode })
+	if i < 0 {
+		return
+	}
+	coupon := &s.coupons[i]
+	if coupon.TimesUsed > 0 {
+		coupon.TimesUsed--
+	}
+	if s.redemptions[coupon.CouponID][order.UserID] > 0 {
+		s.redemptions[coupon.CouponID][order.UserID]--
+	}
+}
+
+// MemoryPromotionStore is a thread-safe in-memory PromotionStore.
+type MemoryPromotionStore struct {
+	mu         sync.RWMutex
+	promotions []models.Promotion
+}
+
+func NewMemoryPromotionStore() *MemoryPromotionStore {
+	return &MemoryPromotionStore{}
+}
+
+func (s *MemoryPromotionStore) CreatePromotion(ctx context.Context, promotion *models.Promotion) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	promotion.PromotionID = int64(len(s.promotions) + 1)
+	promotion.DateCreated = time.Now()
+	stored := *promotion
+	stored.ProductIDs = slices.Clone(promotion.ProductIDs)
+	s.promotions = append(s.promotions, stored)
+	return nil
+}
+
+func (s *MemoryPromotionStore) ListPromotions(ctx context.Context) ([]models.Promotion, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]models.Promotion, 0, len(s.promotions))
+	for _, p := range s.promotions {
+		p.ProductIDs = slices.Clone(p.ProductIDs)
+		list = append(list, p)
+	}
+	slices.SortStableFunc(list, func(a, b models.Promotion) int {
+		return a.Priority - b.Priority
+	})
+	return list, nil
+}
+
+func (s *MemoryPromotionStore) SetPromotionActive(ctx context.Context, promotionID int64, active bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.promotions {
+		if s.promotions[i].PromotionID == promotionID {
+			s.promotions[i].Active = active
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// MemoryTaxStore is a thread-safe in-memory TaxStore.
+type MemoryTaxStore struct {
+	mu     sync.RWMutex
+	nextID int64
+	rates  []models.TaxRate
+}
+
+func NewMemoryTaxStore() *MemoryTaxStore {
+	return &MemoryTaxStore{}
+}
+
+func (s *MemoryTaxStore) ListTaxRates(ctx context.Context) ([]models.TaxRate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	rates := slices.Clone(s.rates)
+	slices.SortFunc(rates, func(a, b models.TaxRate) int {
+		return cmp.Or(cmp.Compare(a.Country, b.Country), cmp.Compare(a.State, b.State), cmp.Compare(a.TaxClass, b.TaxClass))
+	})
+	return rates, nil
+}
+
+func (s *MemoryTaxStore) SaveTaxRate(ctx context.Context, rate *models.TaxRate) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	rate.Country = models.NormalizeRegion(rate.Country)
+	rate.State = models.NormalizeRegion(rate.State)
+	for i, r := range s.rates {
+		if r.Country == rate.Country && r.State == rate.State && r.TaxClass == rate.TaxClass {
+			rate.TaxRateID = r.TaxRateID
+			s.rates[i] = *rate
+			return nil
+		}
+	}
+	s.nextID++
+	rate.TaxRateID = s.nextID
+	s.rates = append(s.rates, *rate)
+	return nil
+}
+
+func (s *MemoryTaxStore) DeleteTaxRate(ctx context.Context, taxRateID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.rates, func(r models.TaxRate) bool { return r.TaxRateID == taxRateID })
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.rates = slices.Delete(s.rates, i, i+1)
+	return nil
+}
+
+// MemoryAddressStore is a thread-safe in-memory AddressStore.
+type MemoryAddressStore struct {
+	mu        sync.RWMutex
+	nextID    int64
+	addresses []models.Address
+}
+
+func NewMemoryAddressStore() *MemoryAddressStore {
+	return &MemoryAddressStore{}
+}
+
+func (s *MemoryAddressStore) CreateAddress(ctx context.Context, address *models.Address) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	address.AddressID = s.nextID
+	address.DateCreated = time.Now()
+	s.addresses = append(s.addresses, *address)
+	return nil
+}
+
+func (s *MemoryAddressStore) GetAddress(ctx context.Context, userID string, addressID int64) (*models.Address, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, a := range s.addresses {
+		if a.UserID == userID && a.AddressID == addressID {
+			return &a, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryAddressStore) ListAddresses(ctx context.Context, userID string) ([]models.Address, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var addresses []models.Address
+	for _, a := range slices.Backward(s.addresses) {
+		if a.UserID == userID {
+			addresses = append(addresses, a)
+		}
+	}
+	return addresses, nil
+}
+
+func (s *MemoryAddressStore) DeleteAddress(ctx context.Context, userID string, addressID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.addresses, func(a models.Address) bool { return a.UserID == userID && a.AddressID == addressID })
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.addresses = slices.Delete(s.addresses, i, i+1)
+	return nil
+}
+
+// MemoryShippingStore is a thread-safe in-memory ShippingStore.
+type MemoryShippingStore struct {
+	mu      sync.RWMutex
+	methods []models.ShippingMethod
+}
+
+func NewMemoryShippingStore() *MemoryShippingStore {
+	return &MemoryShippingStore{}
+}
+
+func (s *MemoryShippingStore) CreateShippingMethod(ctx context.Context, method *models.ShippingMethod) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	method.ShippingMethodID = int64(len(s.methods) + 1)
+	method.DateCreated = time.Now()
+	stored := *method
+	if method.FreeOver != nil {
+		freeOver := *method.FreeOver
+		stored.FreeOver = &freeOver
+	}
+	s.methods = append(s.methods, stored)
+	return nil
+}
+
+func (s *MemoryShippingStore) ListShippingMethods(ctx context.Context) ([]models.ShippingMethod, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	methods := make([]models.ShippingMethod, 0, len(s.methods))
+	for _, m := range s.methods {
+		if m.FreeOver != nil {
+			freeOver := *m.FreeOver
+			m.FreeOver = &freeOver
+		}
+		methods = append(methods, m)
+	}
+	slices.SortStableFunc(methods, func(a, b models.ShippingMethod) int {
+		return cmp.Compare(a.Price, b.Price)
+	})
+	return methods, nil
+}
+
+func (s *MemoryShippingStore) SetShippingMethodActive(ctx context.Context, methodID int64, active bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.methods {
+		if s.methods[i].ShippingMethodID == methodID {
+			s.methods[i].Active = active
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// MemoryPaymentStore is a thread-safe in-memory PaymentStore.
+type MemoryPaymentStore struct {
+	mu       sync.RWMutex
+	payments []models.Payment
+	events   []models.PaymentEvent
+	orders   *MemoryOrderStore
+}
+
+func NewMemoryPaymentStore(orders *MemoryOrderStore) *MemoryPaymentStore {
+	return &MemoryPaymentStore{orders: orders}
+}
+
+func (s *MemoryPaymentStore) CreatePayment(ctx context.Context, payment *models.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	payment.PaymentID = int64(len(s.payments) + 1)
+	payment.DateCreated = time.Now()
+	payment.DateModified = payment.DateCreated
+	s.payments = append(s.payments, *payment)
+	return nil
+}
+
+func (s *MemoryPaymentStore) UpdatePayment(ctx context.Context, payment *models.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.payments {
+		p := &s.payments[i]
+		if p.PaymentID == payment.PaymentID {
+			payment.DateModified = time.Now()
+			p.ProviderRef = payment.ProviderRef
+			p.Status = payment.Status
+			p.CapturedAmount = payment.CapturedAmount
+			p.RefundedAmount = payment.RefundedAmount
+			p.FailureReason = payment.FailureReason
+			p.DateModified = payment.DateModified
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryPaymentStore) RecordRefund(ctx context.Context, payment *models.Payment) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.payments {
+		p := &s.payments[i]
+		if p.PaymentID == payment.PaymentID {
+			if more := payment.RefundedAmount - p.RefundedAmount; more > 0 {
+				s.orders.addRefund(p.OrderID, more)
+			}
+			payment.DateModified = time.Now()
+			p.ProviderRef = payment.ProviderRef
+			p.Status = payment.Status
+			p.CapturedAmount = payment.CapturedAmount
+			p.RefundedAmount = payment.RefundedAmount
+			p.FailureReason = payment.FailureReason
+			p.DateModified = payment.DateModified
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryPaymentStore) ListPayments(ctx context.Context, orderID uuid.UUID) ([]models.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var payments []models.Payment
+	for _, p := range s.payments {
+		if p.OrderID == orderID {
+			payments = append(payments, p)
+		}
+	}
+	return payments, nil
+}
+
+func (s *MemoryPaymentStore) GetPaymentByRef(ctx context.Context, provider, providerRef string) (*models.Payment, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, p := range s.payments {
+		if p.Provider == provider && p.ProviderRef == providerRef && providerRef != "" {
+			return &p, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// copyPaymentEvent keeps stored events from sharing their payload and
+// processing time with callers.
+func copyPaymentEvent(event models.PaymentEvent) models.PaymentEvent {
+	event.Payload = slices.Clone(event.Payload)
+	if event.ProcessedAt != nil {
+		processedAt := *event.ProcessedAt
+		event.ProcessedAt = &processedAt
+	}
+	return event
+}
+
+func (s *MemoryPaymentStore) RecordPaymentEvent(ctx context.Context, event *models.PaymentEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.events {
+		if e.Provider == event.Provider && e.ProviderEventID == event.ProviderEventID {
+			return ErrDuplicateEvent
+		}
+	}
+	event.PaymentEventID = int64(len(s.events) + 1)
+	event.ReceivedAt = time.Now()
+	s.events = append(s.events, copyPaymentEvent(*event))
+	return nil
+}
+
+func (s *MemoryPaymentStore) GetPaymentEvent(ctx context.Context, provider, providerEventID string) (*models.PaymentEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.events {
+		if e.Provider == provider && e.ProviderEventID == providerEventID {
+			event := copyPaymentEvent(e)
+			return &event, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryPaymentStore) UpdatePaymentEvent(ctx context.Context, event *models.PaymentEvent) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.events {
+		e := &s.events[i]
+		if e.PaymentEventID == event.PaymentEventID {
+			updated := copyPaymentEvent(*event)
+			e.Status = updated.Status
+			e.Error = updated.Error
+			e.Attempts = updated.Attempts
+			e.ProcessedAt = updated.ProcessedAt
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryPaymentStore) ListPaymentEvents(ctx context.Context, status models.PaymentEventStatus) ([]models.PaymentEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []models.PaymentEvent
+	for _, e := range s.events {
+		if e.Status == status {
+			events = append(events, copyPaymentEvent(e))
+		}
+	}
+	return events, nil
+}
+
+// MemoryReturnStore is a thread-safe in-memory ReturnStore. It reads the
+// ordered items from a MemoryOrderStore and restocks its products.
+type MemoryReturnStore struct {
+	mu      sync.RWMutex
+	orders  *MemoryOrderStore
+	returns []models.Return
+}
+
+func NewMemoryReturnStore(orders *MemoryOrderStore) *MemoryReturnStore {
+	return &MemoryReturnStore{orders: orders}
+}
+
+func (s *MemoryReturnStore) CreateReturn(ctx context.Context, ret *models.Return) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ordered := s.orders.orderedQuantities(ret.OrderID)
+	for _, r := range s.returns {
+		if r.OrderID != ret.OrderID || r.Status == models.ReturnRejected {
+			continue
+		}
+		for _, item := range r.Items {
+			ordered[item.ProductID] -= item.Quantity
+		}
+	}
+	for _, item := range ret.Items {
+		left, ok := ordered[item.ProductID]
+		if !ok {
+			return fmt.Errorf("%w: product %s was not ordered", ErrReturnQuantity, item.ProductID)
+		}
+		if item.Quantity <= 0 || item.Quantity > left {
+			return fmt.Errorf("%w: %d of product %s, %d left to return", ErrReturnQuantity, item.Quantity, item.ProductID, left)
+		}
+	}
+
+	ret.ReturnID = int64(len(s.returns) + 1)
+	ret.Status = models.ReturnRequested
+	ret.DateCreated = time.Now()
+	ret.DateModified = ret.DateCreated
+	stored := *ret
+	stored.Items = slices.Clone(ret.Items)
+	for i := range stored.Items {
+		// Names are read from the products, like the join in the SQL store
+		stored.Items[i].ProductName = ""
+	}
+	s.returns = append(s.returns, stored)
+	return nil
+}
+
+func (s *MemoryReturnStore) GetReturn(ctx context.Context, returnID int64) (*models.Return, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if returnID < 1 || returnID > int64(len(s.returns)) {
+		return nil, ErrNotFound
+	}
+	ret := s.copyReturn(s.returns[returnID-1])
+	return &ret, nil
+}
+
+func (s *MemoryReturnStore) ListReturns(ctx context.Context, filter ReturnFilter) ([]models.Return, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var returns []models.Return
+	for _, ret := range slices.Backward(s.returns) {
+		if filter.OrderID != uuid.Nil && ret.OrderID != filter.OrderID {
+			continue
+		}
+		if filter.UserID != "" && ret.UserID != filter.UserID {
+			continue
+		}
+		if filter.Status != "" && ret.Status != filter.Status {
+			continue
+		}
+		returns = append(returns, s.copyReturn(ret))
+	}
+	return returns, nil
+}
+
+// copyReturn copies a stored return and fills in the names of its products.
+func (s *MemoryReturnStore) copyReturn(ret models.Return) models.Return {
+	ret.Items = slices.Clone(ret.Items)
+	for i := range ret.Items {
+		if product, err := s.orders.products.GetProductByID(context.Background(), ret.Items[i].ProductID); err == nil {
+			ret.Items[i].ProductName = product.ProductName
+		}
+	}
+	slices.SortFunc(ret.Items, func(a, b models.ReturnItem) int {
+		return cmp.Compare(a.ProductName, b.ProductName)
+	})
+	return ret
+}
+
+func (s *MemoryReturnStore) SetReturnStatus(ctx context.Context, returnID int64, status models.ReturnStatus, note string) error {
+	if status != models.ReturnApproved && status != models.ReturnRejected {
+		return ErrReturnStatus
+	}
+	return s.move(ctx, returnID, status, func(ret *models.Return) {
+		ret.Note = note
+	})
+}
+
+func (s *MemoryReturnStore) ReceiveReturn(ctx context.Context, returnID int64) error {
+	return s.move(ctx, returnID, models.ReturnReceived, func(ret *models.Return) {
+		quantities := map[uuid.UUID]int{}
+		for _, item := range ret.Items {
+			quantities[item.ProductID] += item.Quantity
+		}
+		s.orders.products.restock(quantities)
+	})
+}
+
+func (s *MemoryReturnStore) ClaimRefund(ctx context.Context, returnID int64) error {
+	return s.move(ctx, returnID, models.ReturnRefunding, func(ret *models.Return) {})
+}
+
+func (s *MemoryReturnStore) ReleaseRefund(ctx context.Context, returnID int64) error {
+	return s.move(ctx, returnID, models.ReturnReceived, func(ret *models.Return) {})
+}
+
+func (s *MemoryReturnStore) RefundReturn(ctx context.Context, returnID int64, amount float64, refundRef string) error {
+	return s.move(ctx, returnID, models.ReturnRefunded, func(ret *models.Return) {
+		ret.RefundAmount = amount
+		ret.RefundRef = refundRef
+		s.orders.addRefund(ret.OrderID, amount)
+	})
+}
+
+// move moves a return to status if it can get there from its current status
+// and applies the rest of the change with update.
+func (s *MemoryReturnStore) move(ctx context.Context, returnID int64, status models.ReturnStatus, update func(ret *models.Return)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if returnID < 1 || returnID > int64(len(s.returns)) {
+		return ErrNotFound
+	}
+	ret := &s.returns[returnID-1]
+	if !models.CanTransitionReturn(ret.Status, status) {
+		return fmt.Errorf("%w: %s to %s", ErrReturnStatus, ret.Status, status)
+	}
+	ret.Status = status
+	ret.DateModified = time.Now()
+	update(ret)
+	return nil
+}
+
+// MemoryJobStore is a thread-safe in-memory JobStore.
+type MemoryJobStore struct {
+	mu     sync.RWMutex
+	jobs   []models.Job
+	nextID int64
+}
+
+func NewMemoryJobStore() *MemoryJobStore {
+	return &MemoryJobStore{}
+}
+
+func (s *MemoryJobStore) EnqueueJob(ctx context.Context, job *models.Job) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.insert(job)
+	return nil
+}
+
+func (s *MemoryJobStore) EnsureJob(ctx context.Context, job *models.Job) (bool, error) {
+	if err := ctx.Err(); err != nil {
+		return false, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		if j.Kind == job.Kind && (j.Status == models.JobQueued || j.Status == models.JobRunning) {
+			return false, nil
+		}
+	}
+	s.insert(job)
+	return true, nil
+}
+
+func (s *MemoryJobStore) insert(job *models.Job) {
+	s.nextID++
+	job.JobID = s.nextID
+	job.Status = models.JobQueued
+	job.Attempts = 0
+	job.LockedUntil = nil
+	job.DateCreated = time.Now()
+	job.DateModified = job.DateCreated
+	if job.RunAt.IsZero() {
+		job.RunAt = job.DateCreated
+	}
+	if job.Payload == nil {
+		job.Payload = []byte("null")
+	}
+	s.jobs = append(s.jobs, *job)
+}
+
+func (s *MemoryJobStore) ClaimJob(ctx context.Context, now, lockUntil time.Time) (*models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due *models.Job
+	for i := range s.jobs {
+		j := &s.jobs[i]
+		queued := j.Status == models.JobQueued && !j.RunAt.After(now)
+		expired := j.Status == models.JobRunning && j.LockedUntil != nil && !j.LockedUntil.After(now)
+		if (queued || expired) && (due == nil || j.RunAt.Before(due.RunAt)) {
+			due = j
+		}
+	}
+	if due == nil {
+		return nil, ErrNotFound
+	}
+	due.Status = models.JobRunning
+	due.Attempts++
+	due.LockedUntil = &lockUntil
+	due.DateModified = now
+	job := *due
+	return &job, nil
+}
+
+func (s *MemoryJobStore) CompleteJob(ctx context.Context, job *models.Job) error {
+	return s.finish(ctx, job, func(j *models.Job) {
+		j.Status = models.JobSucceeded
+	})
+}
+
+func (s *MemoryJobStore) RetryJob(ctx context.Context, job *models.Job, runAt time.Time, lastError string) error {
+	return s.finish(ctx, job, func(j *models.Job) {
+		j.Status = models.JobQueued
+		j.RunAt = runAt
+		j.LastError = lastError
+	})
+}
+
+func (s *MemoryJobStore) BuryJob(ctx context.Context, job *models.Job, lastError string) error {
+	return s.finish(ctx, job, func(j *models.Job) {
+		j.Status = models.JobDead
+		j.LastError = lastError
+	})
+}
+
+func (s *MemoryJobStore) finish(ctx context.Context, job *models.Job, update func(j *models.Job)) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j := s.find(job.JobID)
+	if j == nil || j.Status != models.JobRunning || j.Attempts != job.Attempts {
+		return ErrJobLost
+	}
+	update(j)
+	j.LockedUntil = nil
+	j.DateModified = time.Now()
+	return nil
+}
+
+func (s *MemoryJobStore) RequeueJob(ctx context.Context, jobID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j := s.find(jobID)
+	if j == nil {
+		return ErrNotFound
+	}
+	if j.Status != models.JobQueued && j.Status != models.JobDead {
+		return ErrJobStatus
+	}
+	j.Status = models.JobQueued
+	j.Attempts = 0
+	j.RunAt = time.Now()
+	j.DateModified = j.RunAt
+	return nil
+}
+
+func (s *MemoryJobStore) GetJob(ctx context.Context, jobID int64) (*models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	j := s.find(jobID)
+	if j == nil {
+		return nil, ErrNotFound
+	}
+	job := *j
+	return &job, nil
+}
+
+func (s *MemoryJobStore) find(jobID int64) *models.Job {
+	for i := range s.jobs {
+		if s.jobs[i].JobID == jobID {
+			return &s.jobs[i]
+		}
+	}
+	return nil
+}
+
+func (s *MemoryJobStore) ListJobs(ctx context.Context, status models.JobStatus, limit, offset int) ([]models.Job, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var jobs []models.Job
+	for _, j := range s.jobs {
+		if status == "" || j.Status == status {
+			jobs = append(jobs, j)
+		}
+	}
+	slices.SortStableFunc(jobs, func(a, b models.Job) int {
+		if c := b.RunAt.Compare(a.RunAt); c != 0 {
+			return c
+		}
+		return cmp.Compare(b.JobID, a.JobID)
+	})
+	return page(jobs, limit, offset), nil
+}
+
+func (s *MemoryJobStore) CountJobs(ctx context.Context) (map[models.JobStatus]int, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	counts := map[models.JobStatus]int{}
+	for _, j := range s.jobs {
+		counts[j.Status]++
+	}
+	return counts, nil
+}
+
+func (s *MemoryJobStore) PurgeJobs(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := len(s.jobs)
+	s.jobs = slices.DeleteFunc(s.jobs, func(j models.Job) bool {
+		return j.Status == models.JobSucceeded && j.DateModified.Before(before)
+	})
+	return n - len(s.jobs), nil
+}
+
+// MemoryWebhookStore is a thread-safe in-memory WebhookStore.
+type MemoryWebhookStore struct {
+	mu             sync.RWMutex
+	subscriptions  []models.WebhookSubscription
+	deliveries     []models.WebhookDelivery
+	nextSubID      int64
+	nextDeliveryID int64
+}
+
+func NewMemoryWebhookStore() *MemoryWebhookStore {
+	return &MemoryWebhookStore{}
+}
+
+func (s *MemoryWebhookStore) CreateWebhookSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextSubID++
+	sub.SubscriptionID = s.nextSubID
+	sub.DateCreated = time.Now()
+	sortWebhookEvents(sub.Events)
+	stored := *sub
+	stored.Events = slices.Clone(sub.Events)
+	s.subscriptions = append(s.subscriptions, stored)
+	return nil
+}
+
+func (s *MemoryWebhookStore) GetWebhookSubscription(ctx context.Context, subscriptionID int64) (*models.WebhookSubscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, sub := range s.subscriptions {
+		if sub.SubscriptionID == subscriptionID {
+			sub.Events = slices.Clone(sub.Events)
+			return &sub, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryWebhookStore) ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	list := make([]models.WebhookSubscription, 0, len(s.subscriptions))
+	for _, sub := range s.subscriptions {
+		sub.Events = slices.Clone(sub.Events)
+		list = append(list, sub)
+	}
+	return list, nil
+}
+
+func (s *MemoryWebhookStore) SetWebhookSubscriptionActive(ctx context.Context, subscriptionID int64, active bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.subscriptions {
+		if s.subscriptions[i].SubscriptionID == subscriptionID {
+			s.subscriptions[i].Active = active
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryWebhookStore) DeleteWebhookSubscription(ctx context.Context, subscriptionID int64) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	i := slices.IndexFunc(s.subscriptions, func(sub models.WebhookSubscription) bool {
+		return sub.SubscriptionID == subscriptionID
+	})
+	if i < 0 {
+		return ErrNotFound
+	}
+	s.subscriptions = slices.Delete(s.subscriptions, i, i+1)
+	s.deliveries = slices.DeleteFunc(s.deliveries, func(d models.WebhookDelivery) bool {
+		return d.SubscriptionID == subscriptionID
+	})
+	return nil
+}
+
+func (s *MemoryWebhookStore) CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !slices.ContainsFunc(s.subscriptions, func(sub models.WebhookSubscription) bool {
+		return sub.SubscriptionID == delivery.SubscriptionID
+	}) {
+		return ErrNotFound
+	}
+	if slices.ContainsFunc(s.deliveries, func(d models.WebhookDelivery) bool {
+		return d.SubscriptionID == delivery.SubscriptionID && d.EventID == delivery.EventID
+	}) {
+		return ErrDuplicateDelivery
+	}
+	s.nextDeliveryID++
+	delivery.DeliveryID = s.nextDeliveryID
+	delivery.DateCreated = time.Now()
+	stored := *delivery
+	stored.URL = ""
+	stored.Payload = slices.Clone(delivery.Payload)
+	s.deliveries = append(s.deliveries, stored)
+	return nil
+}
+
+// withURL copies a stored delivery, filling in the URL of its subscription.
+func (s *MemoryWebhookStore) withURL(d models.WebhookDelivery) models.WebhookDelivery {
+	for _, sub := range s.subscriptions {
+		if sub.SubscriptionID == d.SubscriptionID {
+			d.URL = sub.URL
+		}
+	}
+	d.Payload = slices.Clone(d.Payload)
+	if d.DeliveredAt != nil {
+		deliveredAt := *d.DeliveredAt
+		d.DeliveredAt = &deliveredAt
+	}
+	return d
+}
+
+func (s *MemoryWebhookStore) GetWebhookDelivery(ctx context.Context, deliveryID int64) (*models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, d := range s.deliveries {
+		if d.DeliveryID == deliveryID {
+			d = s.withURL(d)
+			return &d, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryWebhookStore) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.deliveries {
+		d := &s.deliveries[i]
+		if d.DeliveryID != delivery.DeliveryID {
+			continue
+		}
+		d.Status = delivery.Status
+		d.Attempts = delivery.Attempts
+		d.ResponseStatus = delivery.ResponseStatus
+		d.LastError = delivery.LastError
+		d.DeliveredAt = nil
+		if delivery.DeliveredAt != nil {
+			deliveredAt := *delivery.DeliveredAt
+			d.DeliveredAt = &deliveredAt
+		}
+		return nil
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryWebhookStore) ListWebhookDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]models.WebhookDelivery, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var list []models.WebhookDelivery
+	for i := len(s.deliveries) - 1; i >= 0 && len(list) < limit; i-- {
+		if subscriptionID == 0 || s.deliveries[i].SubscriptionID == subscriptionID {
+			list = append(list, s.withURL(s.deliveries[i]))
+		}
+	}
+	return list, nil
+}
+
+// MemoryOutboxStore is a thread-safe in-memory OutboxStore. The product and
+// order stores it is created with save their events to it.
+type MemoryOutboxStore struct {
+	mu     sync.RWMutex
+	events []models.OutboxEvent
+	nextID int64
+}
+
+func NewMemoryOutboxStore(products *MemoryProductStore, orders *MemoryOrderStore) *MemoryOutboxStore {
+	s := &MemoryOutboxStore{}
+	products.outboxed = s.add
+	orders.outboxed = s.add
+	return s
+}
+
+func (s *MemoryOutboxStore) add(event models.OutboxEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.nextID++
+	event.EventID = s.nextID
+	event.DateCreated = time.Now()
+	event.AvailableAt = event.DateCreated
+	s.events = append(s.events, event)
+}
+
+func (s *MemoryOutboxStore) ClaimOutboxEvents(ctx context.Context, now, lockUntil time.Time, limit int) ([]models.OutboxEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var events []models.OutboxEvent
+	for i := range s.events {
+		if len(events) == limit {
+			break
+		}
+		e := &s.events[i]
+		if e.PublishedAt != nil || e.AvailableAt.After(now) {
+			continue
+		}
+		e.AvailableAt = lockUntil
+		events = append(events, *e)
+	}
+	return events, nil
+}
+
+func (s *MemoryOutboxStore) MarkOutboxEventPublished(ctx context.Context, eventID int64, at time.Time) error {
+	return s.update(ctx, eventID, func(e *models.OutboxEvent) bool {
+		e.PublishedAt = &at
+		e.LastError = ""
+		return true
+	})
+}
+
+func (s *MemoryOutboxStore) RetryOutboxEvent(ctx context.Context, eventID int64, retryAt time.Time, lastError string) error {
+	return s.update(ctx, eventID, func(e *models.OutboxEvent) bool {
+		if e.PublishedAt != nil {
+			return false
+		}
+		e.Attempts++
+		e.AvailableAt = retryAt
+		e.LastError = lastError
+		return true
+	})
+}
+
+// update changes an event with fn, which reports whether it applies to the
+// event.
+func (s *MemoryOutboxStore) update(ctx context.Context, eventID int64, fn func(e *models.OutboxEvent) bool) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := range s.events {
+		if s.events[i].EventID == eventID {
+			if !fn(&s.events[i]) {
+				return ErrNotFound
+			}
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (s *MemoryOutboxStore) GetOutboxEvent(ctx context.Context, eventID int64) (*models.OutboxEvent, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, e := range s.events {
+		if e.EventID == eventID {
+			return &e, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+func (s *MemoryOutboxStore) PurgeOutboxEvents(ctx context.Context, before time.Time) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.events[:0]
+	for _, e := range s.events {
+		if e.PublishedAt == nil || !e.PublishedAt.Before(before) {
+			kept = append(kept, e)
+		}
+	}
+	purged := len(s.events) - len(kept)
+	s.events = kept
+	return purged, nil
+}
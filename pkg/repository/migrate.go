@@ -0,0 +1,169 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/snirkop89/mx-store/migrations"
+)
+
+type migration struct {
+	version int
+	name    string
+}
+
+// Migrate applies every up migration for dialect newer than the current
+// schema version. The version is tracked in a single row schema_migrations
+// table laid out the way golang-migrate does it, so databases migrated with
+// the migrate CLI keep working.
+func Migrate(ctx context.Context, db *sql.DB, dialect Dialect) error {
+	return migrate(ctx, db, dialect, migrations.FS)
+}
+
+func migrate(ctx context.Context, db *sql.DB, dialect Dialect, fsys fs.FS) error {
+	_, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		dirty BOOLEAN NOT NULL
+	)`)
+	if err != nil {
+		return fmt.Errorf("create schema_migrations: %w", err)
+	}
+
+	var current int
+	var dirty bool
+	err = db.QueryRowContext(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&current, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("database is dirty at migration version %d, fix it manually", current)
+	}
+
+	list, err := listMigrations(fsys, dialect)
+	if err != nil {
+		return err
+	}
+
+	for _, m := range list {
+		if m.version <= current {
+			continue
+		}
+		script, err := fs.ReadFile(fsys, m.name)
+		if err != nil {
+			return err
+		}
+		if err := applyMigration(ctx, db, dialect, current, m.version, string(script)); err != nil {
+			return fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		current = m.version
+	}
+	return nil
+}
+
+// applyMigration migrates from version from to version. The new version is
+// recorded dirty before the script runs: MySQL commits every DDL statement
+// on its own, so a script failing halfway leaves part of it applied, and it
+// must be fixed by hand before migrating again. Postgres and SQLite roll the
+// whole script back, which leaves the schema clean at from.
+func applyMigration(ctx context.Context, db *sql.DB, dialect Dialect, from, version int, script string) error {
+	if err := setVersion(ctx, db, dialect, version, true); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range splitStatements(script) {
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			tx.Rollback()
+			if dialect != MySQL {
+				if resetErr := setVersion(ctx, db, dialect, from, false); resetErr != nil {
+					return errors.Join(err, resetErr)
+				}
+			}
+			return err
+		}
+	}
+	if err := recordVersion(ctx, tx, dialect, version, false); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// setVersion records the schema version on its own.
+func setVersion(ctx context.Context, db *sql.DB, dialect Dialect, version int, dirty bool) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := recordVersion(ctx, tx, dialect, version, dirty); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func recordVersion(ctx context.Context, tx *sql.Tx, dialect Dialect, version int, dirty bool) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM schema_migrations`); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, dialect.rebind(`INSERT INTO schema_migrations (version, dirty) VALUES (?, ?)`), version, dirty)
+	return err
+}
+
+func listMigrations(fsys fs.FS, dialect Dialect) ([]migration, error) {
+	files, err := fs.Glob(fsys, string(dialect)+"/*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, errors.New("no migrations for dialect " + string(dialect))
+	}
+
+	var list []migration
+	for _, f := range files {
+		prefix, _, _ := strings.Cut(path.Base(f), "_")
+		v, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: invalid version", f)
+		}
+		list = append(list, migration{version: v, name: f})
+	}
+	slices.SortFunc(list, func(a, b migration) int { return a.version - b.version })
+	return list, nil
+}
+
+// splitStatements splits a migration script on the semicolons that end a
+// line, which is enough for the plain DDL the migrations contain.
+func splitStatements(script string) []string {
+	var stmts []string
+	var current strings.Builder
+	for _, line := range strings.Split(script, "\n") {
+		current.WriteString(line)
+		current.WriteString("\n")
+		if strings.HasSuffix(strings.TrimSpace(line), ";") {
+			stmts = appendStatement(stmts, current.String())
+			current.Reset()
+		}
+	}
+	return appendStatement(stmts, current.String())
+}
+
+func appendStatement(stmts []string, stmt string) []string {
+	stmt = strings.TrimSuffix(strings.TrimSpace(stmt), ";")
+	if stmt == "" {
+		return stmts
+	}
+	return append(stmts, stmt)
+}
@@ -0,0 +1,59 @@
+package repository
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestMigrateFailure(t *testing.T) {
+	ctx := context.Background()
+	db, dialect, err := Open("sqlite://" + filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	fsys := fstest.MapFS{
+		"sqlite/000001_create_lamps.up.sql": {Data: []byte("CREATE TABLE lamps (lamp_id INTEGER PRIMARY KEY);")},
+		"sqlite/000002_create_desks.up.sql": {Data: []byte("CREATE TABLE desks (desk_id INTEGER PRIMARY KEY);\nNOT SQL;")},
+	}
+	if err := migrate(ctx, db, dialect, fsys); err == nil || !strings.Contains(err.Error(), "000002_create_desks") {
+		t.Fatalf("Migrate error = %v, want the failed migration", err)
+	}
+	version := func() (int, bool) {
+		t.Helper()
+		var version int
+		var dirty bool
+		if err := db.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations").Scan(&version, &dirty); err != nil {
+			t.Fatal(err)
+		}
+		return version, dirty
+	}
+	// SQLite rolled the failed migration back
+	if v, dirty := version(); v != 1 || dirty {
+		t.Fatalf("schema at version %d, dirty %v, want clean at 1", v, dirty)
+	}
+	var desks int
+	if err := db.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master WHERE name = 'desks'").Scan(&desks); err != nil || desks != 0 {
+		t.Fatalf("desks table = %d, %v, want it rolled back", desks, err)
+	}
+
+	fsys["sqlite/000002_create_desks.up.sql"] = &fstest.MapFile{Data: []byte("CREATE TABLE desks (desk_id INTEGER PRIMARY KEY);")}
+	if err := migrate(ctx, db, dialect, fsys); err != nil {
+		t.Fatalf("Migrate after fixing the migration: %v", err)
+	}
+	if v, dirty := version(); v != 2 || dirty {
+		t.Fatalf("schema at version %d, dirty %v, want clean at 2", v, dirty)
+	}
+
+	// A migration that failed halfway on MySQL leaves the schema dirty
+	if err := setVersion(ctx, db, dialect, 3, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(ctx, db, dialect, fsys); err == nil || !strings.Contains(err.Error(), "dirty at migration version 3") {
+		t.Fatalf("Migrate of a dirty schema error = %v, want it refused", err)
+	}
+}
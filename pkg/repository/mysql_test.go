@@ -0,0 +1,40 @@
+package repository_test
+
+import (
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	"github.com/snirkop89/mx-store/pkg/repository"
+	"github.com/snirkop89/mx-store/pkg/repository/repotest"
+)
+
+// TestMySQLRepository runs against the database in TEST_MYSQL_DSN, which must
+// already have the migrations applied and use parseTime=true. All rows are
+// deleted before every subtest.
+func TestMySQLRepository(t *testing.T) {
+	dsn := os.Getenv("TEST_MYSQL_DSN")
+	if dsn == "" {
+		t.Skip("TEST_MYSQL_DSN not set")
+	}
+
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	repotest.Run(t, func(t *testing.T) *repository.Repository {
+		for _, table := range []string{"audit_events", "jobs", "outbox", "webhook_deliveries", "webhook_subscription_events", "webhook_subscriptions", "order_return_items", "order_returns", "payment_events", "payments", "addresses", "shipping_methods", "order_addresses", "tax_rates", "order_promotions", "promotion_products", "promotions", "coupon_redemptions", "coupon_categories", "coupon_products", "coupons", "product_prices", "order_items", "orders", "products"} {
+			if _, err := db.Exec("DELETE FROM " + table); err != nil {
+				t.Fatalf("clearing %s: %v", table, err)
+			}
+		}
+		return repository.NewRepository(db, repository.MySQL, 5*time.Second)
+	})
+}
@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/jackc/pgx/v5/pgconn"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// Dialect identifies the SQL database a repository talks to.
+type Dialect string
+
+const (
+	MySQL    Dialect = "mysql"
+	SQLite   Dialect = "sqlite"
+	Postgres Dialect = "postgres"
+)
+
+// driver returns the database/sql driver name for the dialect.
+func (d Dialect) driver() string {
+	if d == Postgres {
+		return "pgx"
+	}
+	return string(d)
+}
+
+// rebind rewrites the ? placeholders used throughout the repositories into
+// the positional $1, $2, ... placeholders Postgres expects.
+func (d Dialect) rebind(query string) string {
+	if d != Postgres {
+		return query
+	}
+
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// isForeignKeyViolation reports whether err is the database refusing to
+// delete a row that other rows still reference.
+func isForeignKeyViolation(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return mysqlErr.Number == 1451
+	}
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code == "23503"
+	}
+	// SQLite reports ON DELETE RESTRICT with the code of a trigger rather
+	// than of a foreign key, so only the message tells them apart.
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code()&0xff == sqlite3.SQLITE_CONSTRAINT &&
+			strings.Contains(sqliteErr.Error(), "FOREIGN KEY constraint failed")
+	}
+	return false
+}
+
+// ParseDSN picks the dialect from the DSN scheme. "postgres://..." selects
+// Postgres, "sqlite://path/to/file.db" and "sqlite::memory:" select SQLite,
+// anything else is handed to the MySQL driver unchanged.
+func ParseDSN(dsn string) (Dialect, string) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return Postgres, dsn
+	}
+	for _, prefix := range []string{"sqlite://", "sqlite:"} {
+		if rest, ok := strings.CutPrefix(dsn, prefix); ok {
+			// SQLite only enforces foreign keys when asked to, per connection
+			sep := "?"
+			if strings.Contains(rest, "?") {
+				sep = "&"
+			}
+			return SQLite, rest + sep + "_pragma=foreign_keys(1)"
+		}
+	}
+	return MySQL, dsn
+}
+
+// Open connects to the database described by dsn and returns it together with
+// its dialect.
+func Open(dsn string) (*sql.DB, Dialect, error) {
+	dialect, driverDSN := ParseDSN(dsn)
+
+	db, err := sql.Open(dialect.driver(), driverDSN)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if dialect == SQLite {
+		// SQLite allows a single writer, and every connection to ":memory:"
+		// would otherwise get its own empty database.
+		db.SetMaxOpenConns(1)
+	}
+
+	return db, dialect, nil
+}
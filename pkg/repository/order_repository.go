@@ -1,7 +1,11 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/snirkop89/mx-store/pkg/models"
@@ -10,112 +14,288 @@ import (
 )
 
 type OrderRepository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
 }
 
-func NewOrderRepository(db *sql.DB) *OrderRepository {
-	return &OrderRepository{DB: db}
+func NewOrderRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *OrderRepository {
+	return &OrderRepository{DB: db, Dialect: dialect, Timeout: timeout}
 }
 
-func (r *OrderRepository) PlaceOrderWithItems(orderItems []models.OrderItem) error {
+func (r *OrderRepository) PlaceOrderWithItems(ctx context.Context, orderItems []models.OrderItem) error {
+	return r.PlaceOrder(ctx, &models.Order{
+		UserID: "fk@htmxrocks.com",
+		Items:  orderItems,
+	})
+}
+
+func (r *OrderRepository) PlaceOrder(ctx context.Context, order *models.Order) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
 	// Begin transaction
-	tx, err := r.DB.Begin()
+	tx, err := r.DB.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
+	defer tx.Rollback()
 
-	order := models.Order{
-		OrderID:     uuid.New(),
-		UserID:      "fk@htmxrocks.com",
-		OrderStatus: "ordered",
-		OrderDate:   time.Now(),
-		Items:       orderItems,
+	order.OrderID = uuid.New()
+	if order.OrderStatus == "" {
+		order.OrderStatus = models.OrderStatusOrdered
 	}
+	order.OrderDate = time.Now()
 
 	// Insert order into orders table
-	_, err = tx.Exec("INSERT INTO orders (order_id, user_id, order_status, order_date) VALUES (?, ?, ?, ?)",
-		order.OrderID, order.UserID, order.OrderStatus, order.OrderDate)
+	_, err = tx.ExecContext(ctx, r.Dialect.rebind("INSERT INTO orders (order_id, user_id, order_status, order_date, coupon_code, discount, tax, prices_include_tax, shipping_method, shipping_cost) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+		order.OrderID, order.UserID, order.OrderStatus, order.OrderDate.UTC(), nullString(order.CouponCode), order.Discount, order.Tax, order.PricesIncludeTax,
+		nullString(order.ShippingMethod), order.ShippingCost)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
 
+	for kind, address := range map[string]*models.Address{"shipping": order.ShippingAddress, "billing": order.BillingAddress} {
+		if address == nil {
+			continue
+		}
+		_, err = tx.ExecContext(ctx, r.Dialect.rebind("INSERT INTO order_addresses (order_id, kind, name, line1, line2, city, state, postal_code, country, phone) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)"),
+			order.OrderID, kind, address.Name, address.Line1, address.Line2, address.City, address.State, address.PostalCode, address.Country, address.Phone)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Insert order items into order_items table
-	for _, item := range order.Items {
-		_, err = tx.Exec("INSERT INTO order_items (order_id, product_id, quantity, cost) VALUES (?, ?, ?, ?)",
-			order.OrderID, item.ProductID, item.Quantity, item.Cost)
+	for i := range order.Items {
+		item := &order.Items[i]
+		item.OrderID = order.OrderID
+		_, err = tx.ExecContext(ctx, r.Dialect.rebind("INSERT INTO order_items (order_id, product_id, quantity, cost, discount, tax, tax_rate, tax_name) VALUES (?, ?, ?, ?, ?, ?, ?, ?)"),
+			order.OrderID, item.ProductID, item.Quantity, item.Cost, item.Discount, item.Tax, item.TaxRate, nullString(item.TaxName))
 		if err != nil {
-			tx.Rollback()
 			return err
 		}
 	}
 
+	if err := takeStock(ctx, tx, r.Dialect, order.Items); err != nil {
+		return err
+	}
+
+	for _, p := range order.Promotions {
+		var promotionID *int64
+		if p.PromotionID != 0 {
+			promotionID = &p.PromotionID
+		}
+		_, err = tx.ExecContext(ctx, r.Dialect.rebind("INSERT INTO order_promotions (order_id, promotion_id, label, discount) VALUES (?, ?, ?, ?)"),
+			order.OrderID, promotionID, p.Label, p.Discount)
+		if err != nil {
+			return err
+		}
+	}
+
+	if order.CouponCode != "" {
+		if err := redeemCoupon(ctx, tx, r.Dialect, order); err != nil {
+			return err
+		}
+	}
+
+	placed := models.OrderEvent{OrderID: order.OrderID, UserID: order.UserID, Status: order.OrderStatus}
+	if err := recordOrderEvent(ctx, tx, r.Dialect, models.EventOrderPlaced, placed); err != nil {
+		return err
+	}
+	if err := recordStockChanges(ctx, tx, r.Dialect, "SELECT product_id FROM order_items WHERE order_id = ?", order.OrderID); err != nil {
+		return err
+	}
+
 	// Commit transaction
-	err = tx.Commit()
+	return tx.Commit()
+}
+
+func (r *OrderRepository) SetOrderStatus(ctx context.Context, orderID uuid.UUID, status string) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var userID, current string
+	err = tx.QueryRowContext(ctx, r.Dialect.rebind("SELECT user_id, order_status FROM orders WHERE order_id = ?"), orderID).Scan(&userID, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
 	if err != nil {
 		return err
 	}
+	if current == status {
+		return nil
+	}
 
+	if _, err := tx.ExecContext(ctx, r.Dialect.rebind("UPDATE orders SET order_status = ? WHERE order_id = ?"), status, orderID); err != nil {
+		return err
+	}
+	if status == models.OrderStatusPaymentFailed {
+		if err := releaseCoupon(ctx, tx, r.Dialect, orderID); err != nil {
+			return err
+		}
+		if err := releaseStock(ctx, tx, r.Dialect, orderID); err != nil {
+			return err
+		}
+		if err := recordStockChanges(ctx, tx, r.Dialect, "SELECT product_id FROM order_items WHERE order_id = ?", orderID); err != nil {
+			return err
+		}
+	}
+	changed := models.OrderEvent{OrderID: orderID, UserID: userID, Status: status, PreviousStatus: current}
+	if err := recordOrderEvent(ctx, tx, r.Dialect, models.EventOrderStatusChanged, changed); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// takeStock takes the ordered quantities out of the stock of tracked
+// products, failing with ErrOutOfStock when one of them has too few left.
+// Like every change of stock it takes a new version of the products, so
+// that edits of the versions before fail rather than overwrite it.
+func takeStock(ctx context.Context, tx *sql.Tx, dialect Dialect, items []models.OrderItem) error {
+	for _, item := range items {
+		res, err := tx.ExecContext(ctx, dialect.rebind("UPDATE products SET stock = stock - ?, version = version + 1 WHERE product_id = ? AND stock IS NOT NULL AND stock >= ?"),
+			item.Quantity, item.ProductID, item.Quantity)
+		if err != nil {
+			return err
+		}
+		taken, err := res.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if taken > 0 {
+			continue
+		}
+		// Nothing was taken either because the stock is not tracked or
+		// because there is not enough of it
+		var stock sql.NullInt64
+		err = tx.QueryRowContext(ctx, dialect.rebind("SELECT stock FROM products WHERE product_id = ?"), item.ProductID).Scan(&stock)
+		if err != nil {
+			return err
+		}
+		if stock.Valid {
+			return fmt.Errorf("%w: product %s", ErrOutOfStock, item.ProductID)
+		}
+	}
 	return nil
 }
 
-func (r *OrderRepository) ListOrders(limit, offset int) ([]models.Order, error) {
-	query := `SELECT order_id, user_id, order_status, order_date 
+// releaseStock puts the items of an order that will not be paid back in
+// stock.
+func releaseStock(ctx context.Context, tx *sql.Tx, dialect Dialect, orderID uuid.UUID) error {
+	query := `UPDATE products SET version = version + 1, stock = stock + (
+                  SELECT quantity FROM order_items WHERE order_id = ? AND product_id = products.product_id)
+              WHERE stock IS NOT NULL AND product_id IN (SELECT product_id FROM order_items WHERE order_id = ?)`
+	_, err := tx.ExecContext(ctx, dialect.rebind(query), orderID, orderID)
+	return err
+}
+
+func (r *OrderRepository) ListOrders(ctx context.Context, limit, offset int) ([]models.Order, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT order_id, user_id, order_status, order_date, coupon_code, discount, tax, prices_include_tax, shipping_method, shipping_cost, refunded_amount 
              FROM orders ORDER BY order_date DESC LIMIT ? OFFSET ?`
 
-	rows, err := r.DB.Query(query, limit, offset)
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	return scanOrders(rows)
+}
+
+// ListUserOrders returns every order placed by userID, newest first.
+func (r *OrderRepository) ListUserOrders(ctx context.Context, userID string) ([]models.Order, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT order_id, user_id, order_status, order_date, coupon_code, discount, tax, prices_include_tax, shipping_method, shipping_cost, refunded_amount 
+             FROM orders WHERE user_id = ? ORDER BY order_date DESC`
+
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), userID)
 	if err != nil {
 		return nil, err
 	}
 	defer rows.Close()
 
+	return scanOrders(rows)
+}
+
+func scanOrders(rows *sql.Rows) ([]models.Order, error) {
 	var orders []models.Order
 	for rows.Next() {
 		var order models.Order
+		var couponCode, shippingMethod sql.NullString
 		err := rows.Scan(
 			&order.OrderID,
 			&order.UserID,
 			&order.OrderStatus,
 			&order.OrderDate,
+			&couponCode,
+			&order.Discount,
+			&order.Tax,
+			&order.PricesIncludeTax,
+			&shippingMethod,
+			&order.ShippingCost,
+			&order.RefundedAmount,
 		)
 		if err != nil {
 			return nil, err
 		}
+		order.CouponCode = couponCode.String
+		order.ShippingMethod = shippingMethod.String
 		orders = append(orders, order)
 	}
-	return orders, nil
+	return orders, rows.Err()
 }
 
-func (r *OrderRepository) GetTotalOrdersCount() (int, error) {
+func (r *OrderRepository) GetTotalOrdersCount(ctx context.Context) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
 	var count int
-	err := r.DB.QueryRow("SELECT COUNT(*) FROM orders").Scan(&count)
+	err := r.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM orders").Scan(&count)
 	if err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func (r *OrderRepository) CreateOrder(order *models.Order) error {
+func (r *OrderRepository) CreateOrder(ctx context.Context, order *models.Order) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
 	query := `INSERT INTO orders (order_id, user_id, order_status, order_date) 
               VALUES (?, ?, ?, ?)`
 
 	order.OrderID = uuid.New()
 	order.OrderDate = time.Now()
 
-	_, err := r.DB.Exec(query,
+	_, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query),
 		order.OrderID,
 		order.UserID,
 		order.OrderStatus,
-		order.OrderDate,
+		order.OrderDate.UTC(),
 	)
 	return err
 }
 
-func (r *OrderRepository) AddOrderItem(orderItem *models.OrderItem) error {
+func (r *OrderRepository) AddOrderItem(ctx context.Context, orderItem *models.OrderItem) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
 	query := `INSERT INTO order_items (order_id, product_id, quantity) 
               VALUES (?, ?, ?)`
 
-	_, err := r.DB.Exec(query,
+	_, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query),
 		orderItem.OrderID,
 		orderItem.ProductID,
 		orderItem.Quantity,
@@ -123,31 +303,48 @@ func (r *OrderRepository) AddOrderItem(orderItem *models.OrderItem) error {
 	return err
 }
 
-func (r *OrderRepository) GetOrderWithProducts(orderID uuid.UUID) (*models.Order, error) {
+func (r *OrderRepository) GetOrderWithProducts(ctx context.Context, orderID uuid.UUID) (*models.Order, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
 	// First, get the order details
-	orderQuery := `SELECT order_id, user_id, order_status, order_date 
+	orderQuery := `SELECT order_id, user_id, order_status, order_date, coupon_code, discount, tax, prices_include_tax, shipping_method, shipping_cost, refunded_amount 
                    FROM orders WHERE order_id = ?`
 
 	var order models.Order
-	err := r.DB.QueryRow(orderQuery, orderID).Scan(
+	var couponCode, shippingMethod sql.NullString
+	err := r.DB.QueryRowContext(ctx, r.Dialect.rebind(orderQuery), orderID).Scan(
 		&order.OrderID,
 		&order.UserID,
 		&order.OrderStatus,
 		&order.OrderDate,
+		&couponCode,
+		&order.Discount,
+		&order.Tax,
+		&order.PricesIncludeTax,
+		&shippingMethod,
+		&order.ShippingCost,
+		&order.RefundedAmount,
 	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
+	order.CouponCode = couponCode.String
+	order.ShippingMethod = shippingMethod.String
 
 	// Then, get all order items with their corresponding products
 	itemsQuery := `
-        SELECT oi.product_id, oi.quantity,
-               p.product_name, p.price, p.description, p.product_image, p.date_created, p.date_modified
+        SELECT oi.product_id, oi.quantity, COALESCE(oi.cost, oi.quantity * p.price), oi.discount,
+               oi.tax, oi.tax_rate, oi.tax_name,
+               p.product_name, p.price, p.description, p.product_image, p.tax_class, p.category, p.weight, p.date_created, p.date_modified
         FROM order_items oi
         JOIN products p ON oi.product_id = p.product_id
         WHERE oi.order_id = ?
     `
-	rows, err := r.DB.Query(itemsQuery, orderID)
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(itemsQuery), orderID)
 	if err != nil {
 		return nil, err
 	}
@@ -155,13 +352,22 @@ func (r *OrderRepository) GetOrderWithProducts(orderID uuid.UUID) (*models.Order
 
 	for rows.Next() {
 		var item models.OrderItem
+		var taxName sql.NullString
 		err := rows.Scan(
 			&item.ProductID,
 			&item.Quantity,
+			&item.Cost,
+			&item.Discount,
+			&item.Tax,
+			&item.TaxRate,
+			&taxName,
 			&item.Product.ProductName,
 			&item.Product.Price,
 			&item.Product.Description,
 			&item.Product.ProductImage,
+			&item.Product.TaxClass,
+			&item.Product.Category,
+			&item.Product.Weight,
 			&item.Product.DateCreated,
 			&item.Product.DateModified,
 		)
@@ -169,10 +375,200 @@ func (r *OrderRepository) GetOrderWithProducts(orderID uuid.UUID) (*models.Order
 			return nil, err
 		}
 		item.OrderID = orderID
-		item.Cost = float64(item.Quantity) * item.Product.Price
+		item.TaxName = taxName.String
 		item.Product.ProductID = item.ProductID
 		order.Items = append(order.Items, item)
 	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	promotionsQuery := `SELECT promotion_id, label, discount FROM order_promotions WHERE order_id = ? ORDER BY id`
+	rows, err = r.DB.QueryContext(ctx, r.Dialect.rebind(promotionsQuery), orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.AppliedPromotion
+		var promotionID sql.NullInt64
+		if err := rows.Scan(&promotionID, &p.Label, &p.Discount); err != nil {
+			return nil, err
+		}
+		p.PromotionID = promotionID.Int64
+		order.Promotions = append(order.Promotions, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	addressesQuery := `SELECT kind, name, line1, line2, city, state, postal_code, country, phone FROM order_addresses WHERE order_id = ?`
+	rows, err = r.DB.QueryContext(ctx, r.Dialect.rebind(addressesQuery), orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var kind string
+		var a models.Address
+		if err := rows.Scan(&kind, &a.Name, &a.Line1, &a.Line2, &a.City, &a.State, &a.PostalCode, &a.Country, &a.Phone); err != nil {
+			return nil, err
+		}
+		switch kind {
+		case "shipping":
+			order.ShippingAddress = &a
+		case "billing":
+			order.BillingAddress = &a
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
 
 	return &order, nil
 }
+
+// orderRevenue is what an order of orders o, joined with the totals i of
+// its items, was paid net of refunds. It matches models.Order.NetTotal.
+const orderRevenue = `COALESCE(i.cost, 0) - o.discount + o.shipping_cost + CASE WHEN o.prices_include_tax THEN 0 ELSE o.tax END - o.refunded_amount`
+
+// orderItemTotals joins orders o with the totals i of their items.
+const orderItemTotals = ` LEFT JOIN (SELECT order_id, SUM(cost) AS cost FROM order_items GROUP BY order_id) i ON i.order_id = o.order_id`
+
+// salesWhere builds the conditions selecting the orders o that count as
+// sales placed from from until to.
+func salesWhere(from, to time.Time) (string, []any) {
+	var args []any
+	for _, status := range models.SalesStatuses {
+		args = append(args, status)
+	}
+	args = append(args, from.UTC(), to.UTC())
+	where := `o.order_status IN (?` + strings.Repeat(", ?", len(models.SalesStatuses)-1) + `) AND o.order_date >= ? AND o.order_date < ?`
+	return where, args
+}
+
+func (r *OrderRepository) SalesSummary(ctx context.Context, from, to time.Time) (models.SalesSummary, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	where, args := salesWhere(from, to)
+	query := `SELECT COUNT(*), COALESCE(SUM(` + orderRevenue + `), 0) FROM orders o` + orderItemTotals + ` WHERE ` + where
+
+	var summary models.SalesSummary
+	err := r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), args...).Scan(&summary.Orders, &summary.Revenue)
+	return summary, err
+}
+
+// DailySales has the database work out the revenue of each order and
+// groups the orders by day itself, as days start at midnight in loc.
+func (r *OrderRepository) DailySales(ctx context.Context, from, to time.Time, loc *time.Location) ([]models.DailySales, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	where, args := salesWhere(from, to)
+	query := `SELECT o.order_date, ` + orderRevenue + ` FROM orders o` + orderItemTotals + ` WHERE ` + where + ` ORDER BY o.order_date`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := salesDays(from, to, loc)
+	for rows.Next() {
+		var placed time.Time
+		var revenue float64
+		if err := rows.Scan(&placed, &revenue); err != nil {
+			return nil, err
+		}
+		addDailySale(days, placed, revenue)
+	}
+	return days, rows.Err()
+}
+
+// salesDays returns a DailySales for every day in loc from from until to.
+func salesDays(from, to time.Time, loc *time.Location) []models.DailySales {
+	var days []models.DailySales
+	from = from.In(loc)
+	for day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, loc); day.Before(to); day = day.AddDate(0, 0, 1) {
+		days = append(days, models.DailySales{Day: day})
+	}
+	return days
+}
+
+// addDailySale counts an order placed at placed in the day it falls on.
+func addDailySale(days []models.DailySales, placed time.Time, revenue float64) {
+	for i := len(days) - 1; i >= 0; i-- {
+		if !placed.Before(days[i].Day) {
+			days[i].Orders++
+			days[i].Revenue += revenue
+			return
+		}
+	}
+}
+
+func (r *OrderRepository) TopProducts(ctx context.Context, from, to time.Time, limit int) ([]models.ProductSales, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	where, args := salesWhere(from, to)
+	query := `SELECT oi.product_id, COALESCE(p.product_name, ''), SUM(oi.quantity), COALESCE(SUM(oi.cost), 0)
+              FROM order_items oi
+              JOIN orders o ON o.order_id = oi.order_id
+              LEFT JOIN products p ON p.product_id = oi.product_id
+              WHERE ` + where + `
+              GROUP BY oi.product_id, p.product_name
+              ORDER BY SUM(oi.quantity) DESC, COALESCE(SUM(oi.cost), 0) DESC, p.product_name
+              LIMIT ?`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), append(args, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var products []models.ProductSales
+	for rows.Next() {
+		var sales models.ProductSales
+		if err := rows.Scan(&sales.ProductID, &sales.ProductName, &sales.Quantity, &sales.Revenue); err != nil {
+			return nil, err
+		}
+		products = append(products, sales)
+	}
+	return products, rows.Err()
+}
+
+func (r *OrderRepository) LowStock(ctx context.Context, threshold int, from, to time.Time, limit int) ([]models.StockAlert, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	where, args := salesWhere(from, to)
+	query := `SELECT p.product_id, p.product_name, p.stock, COALESCE(SUM(sold.quantity), 0)
+              FROM products p
+              LEFT JOIN (
+                  SELECT oi.product_id, oi.quantity FROM order_items oi
+                  JOIN orders o ON o.order_id = oi.order_id
+                  WHERE ` + where + `
+              ) sold ON sold.product_id = p.product_id
+              WHERE p.deleted_at IS NULL AND p.stock IS NOT NULL AND p.stock <= ?
+              GROUP BY p.product_id, p.product_name, p.stock
+              ORDER BY p.stock, COALESCE(SUM(sold.quantity), 0) DESC, p.product_name
+              LIMIT ?`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), append(args, threshold, limit)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var alerts []models.StockAlert
+	for rows.Next() {
+		var alert models.StockAlert
+		if err := rows.Scan(&alert.ProductID, &alert.ProductName, &alert.Stock, &alert.Sold); err != nil {
+			return nil, err
+		}
+		alerts = append(alerts, alert)
+	}
+	return alerts, rows.Err()
+}
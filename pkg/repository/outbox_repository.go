@@ -0,0 +1,229 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+const outboxColumns = `event_id, event_type, aggregate_id, payload, attempts, available_at, last_error, date_created, published_at`
+
+type OutboxRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewOutboxRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *OutboxRepository {
+	return &OutboxRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+func scanOutboxEvent(row scanner) (*models.OutboxEvent, error) {
+	var event models.OutboxEvent
+	var payload string
+	var lastError sql.NullString
+	err := row.Scan(
+		&event.EventID,
+		&event.Type,
+		&event.AggregateID,
+		&payload,
+		&event.Attempts,
+		&event.AvailableAt,
+		&lastError,
+		&event.DateCreated,
+		&event.PublishedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	event.Payload = []byte(payload)
+	event.LastError = lastError.String
+	return &event, nil
+}
+
+// insertOutboxEvent saves an event in the transaction of the change it is
+// about. It is due to be published right away.
+func insertOutboxEvent(ctx context.Context, tx *sql.Tx, dialect Dialect, event *models.OutboxEvent) error {
+	event.DateCreated = time.Now()
+	event.AvailableAt = event.DateCreated
+	event.Attempts = 0
+	event.PublishedAt = nil
+
+	query := `INSERT INTO outbox (event_type, aggregate_id, payload, attempts, available_at, date_created) VALUES (?, ?, ?, ?, ?, ?)`
+	args := []any{event.Type, event.AggregateID, string(event.Payload), event.Attempts, event.AvailableAt.UTC(), event.DateCreated.UTC()}
+	if dialect == Postgres {
+		return tx.QueryRowContext(ctx, dialect.rebind(query+" RETURNING event_id"), args...).Scan(&event.EventID)
+	}
+	res, err := tx.ExecContext(ctx, dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	event.EventID, err = res.LastInsertId()
+	return err
+}
+
+// recordOrderEvent saves an event about an order.
+func recordOrderEvent(ctx context.Context, tx *sql.Tx, dialect Dialect, eventType models.EventType, data models.OrderEvent) error {
+	event, err := models.NewOutboxEvent(eventType, data.OrderID.String(), data)
+	if err != nil {
+		return err
+	}
+	return insertOutboxEvent(ctx, tx, dialect, &event)
+}
+
+// recordProductEvent saves an event about a product, with the product as
+// it is in the transaction.
+func recordProductEvent(ctx context.Context, tx *sql.Tx, dialect Dialect, eventType models.EventType, productID uuid.UUID) error {
+	data := models.ProductEvent{ProductID: productID}
+	query := `SELECT price, stock, version FROM products WHERE product_id = ?`
+	err := tx.QueryRowContext(ctx, dialect.rebind(query), productID).Scan(&data.Price, &data.Stock, &data.Version)
+	if err != nil {
+		return err
+	}
+	event, err := models.NewOutboxEvent(eventType, productID.String(), data)
+	if err != nil {
+		return err
+	}
+	return insertOutboxEvent(ctx, tx, dialect, &event)
+}
+
+// recordStockChanges saves a stock.changed event for every product selected
+// by query whose stock is tracked. query selects product IDs.
+func recordStockChanges(ctx context.Context, tx *sql.Tx, dialect Dialect, query string, args ...any) error {
+	query = `SELECT product_id FROM products WHERE stock IS NOT NULL AND product_id IN (` + query + `) ORDER BY product_id`
+	rows, err := tx.QueryContext(ctx, dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	var productIDs []uuid.UUID
+	for rows.Next() {
+		var productID uuid.UUID
+		if err := rows.Scan(&productID); err != nil {
+			rows.Close()
+			return err
+		}
+		productIDs = append(productIDs, productID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, productID := range productIDs {
+		if err := recordProductEvent(ctx, tx, dialect, models.EventStockChanged, productID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ClaimOutboxEvents picks the oldest due events and claims each with an
+// update guarded by its due time, so that of two relays picking the same
+// event only one gets it.
+func (r *OutboxRepository) ClaimOutboxEvents(ctx context.Context, now, lockUntil time.Time, limit int) ([]models.OutboxEvent, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT event_id FROM outbox WHERE published_at IS NULL AND available_at <= ? ORDER BY event_id LIMIT ?`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), now.UTC(), limit)
+	if err != nil {
+		return nil, err
+	}
+	var due []int64
+	for rows.Next() {
+		var eventID int64
+		if err := rows.Scan(&eventID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, eventID)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var events []models.OutboxEvent
+	for _, eventID := range due {
+		query = `UPDATE outbox SET available_at = ? WHERE event_id = ? AND published_at IS NULL AND available_at <= ?`
+		res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), lockUntil.UTC(), eventID, now.UTC())
+		if err != nil {
+			return nil, err
+		}
+		claimed, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if claimed == 0 {
+			continue
+		}
+
+		query = `SELECT ` + outboxColumns + ` FROM outbox WHERE event_id = ?`
+		event, err := scanOutboxEvent(r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), eventID))
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, nil
+}
+
+func (r *OutboxRepository) MarkOutboxEventPublished(ctx context.Context, eventID int64, at time.Time) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `UPDATE outbox SET published_at = ?, last_error = NULL WHERE event_id = ?`
+	return r.updateOutboxEvent(ctx, query, at.UTC(), eventID)
+}
+
+func (r *OutboxRepository) RetryOutboxEvent(ctx context.Context, eventID int64, retryAt time.Time, lastError string) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `UPDATE outbox SET attempts = attempts + 1, available_at = ?, last_error = ? WHERE event_id = ? AND published_at IS NULL`
+	return r.updateOutboxEvent(ctx, query, retryAt.UTC(), nullString(lastError), eventID)
+}
+
+func (r *OutboxRepository) updateOutboxEvent(ctx context.Context, query string, args ...any) error {
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *OutboxRepository) GetOutboxEvent(ctx context.Context, eventID int64) (*models.OutboxEvent, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + outboxColumns + ` FROM outbox WHERE event_id = ?`
+	event, err := scanOutboxEvent(r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), eventID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return event, err
+}
+
+func (r *OutboxRepository) PurgeOutboxEvents(ctx context.Context, before time.Time) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM outbox WHERE published_at IS NOT NULL AND published_at < ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), before.UTC())
+	if err != nil {
+		return 0, err
+	}
+	purged, err := res.RowsAffected()
+	return int(purged), err
+}
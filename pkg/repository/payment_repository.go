@@ -0,0 +1,337 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+const paymentColumns = `payment_id, order_id, provider, provider_ref, amount, currency, status, captured_amount,
+	refunded_amount, failure_reason, date_created, date_modified`
+
+const paymentEventColumns = `payment_event_id, provider, provider_event_id, event_type, payload, status, error,
+	attempts, received_at, processed_at`
+
+type PaymentRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewPaymentRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *PaymentRepository {
+	return &PaymentRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+func scanPayment(row scanner) (*models.Payment, error) {
+	var payment models.Payment
+	var providerRef, failureReason sql.NullString
+	err := row.Scan(
+		&payment.PaymentID,
+		&payment.OrderID,
+		&payment.Provider,
+		&providerRef,
+		&payment.Amount,
+		&payment.Currency,
+		&payment.Status,
+		&payment.CapturedAmount,
+		&payment.RefundedAmount,
+		&failureReason,
+		&payment.DateCreated,
+		&payment.DateModified,
+	)
+	if err != nil {
+		return nil, err
+	}
+	payment.ProviderRef = providerRef.String
+	payment.FailureReason = failureReason.String
+	return &payment, nil
+}
+
+func (r *PaymentRepository) CreatePayment(ctx context.Context, payment *models.Payment) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	payment.DateCreated = time.Now()
+	payment.DateModified = payment.DateCreated
+
+	query := `INSERT INTO payments (order_id, provider, provider_ref, amount, currency, status, captured_amount,
+              refunded_amount, failure_reason, date_created, date_modified) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []any{
+		payment.OrderID,
+		payment.Provider,
+		nullString(payment.ProviderRef),
+		payment.Amount,
+		payment.Currency,
+		payment.Status,
+		payment.CapturedAmount,
+		payment.RefundedAmount,
+		nullString(payment.FailureReason),
+		payment.DateCreated.UTC(),
+		payment.DateModified.UTC(),
+	}
+	if r.Dialect == Postgres {
+		return r.DB.QueryRowContext(ctx, r.Dialect.rebind(query+" RETURNING payment_id"), args...).Scan(&payment.PaymentID)
+	}
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	payment.PaymentID, err = res.LastInsertId()
+	return err
+}
+
+// UpdatePayment saves the status and amounts of payment.
+func (r *PaymentRepository) UpdatePayment(ctx context.Context, payment *models.Payment) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	payment.DateModified = time.Now()
+
+	query := `UPDATE payments SET provider_ref = ?, status = ?, captured_amount = ?, refunded_amount = ?,
+              failure_reason = ?, date_modified = ? WHERE payment_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query),
+		nullString(payment.ProviderRef),
+		payment.Status,
+		payment.CapturedAmount,
+		payment.RefundedAmount,
+		nullString(payment.FailureReason),
+		payment.DateModified.UTC(),
+		payment.PaymentID,
+	)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// RecordRefund updates payment and adds what it refunded since the refunded
+// amount saved before to its order. Refunds the store made itself recorded
+// theirs already, so only refunds made at the provider count.
+func (r *PaymentRepository) RecordRefund(ctx context.Context, payment *models.Payment) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var orderID uuid.UUID
+	var refunded float64
+	err = tx.QueryRowContext(ctx, r.Dialect.rebind("SELECT order_id, refunded_amount FROM payments WHERE payment_id = ?"), payment.PaymentID).
+		Scan(&orderID, &refunded)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	payment.DateModified = time.Now()
+	query := `UPDATE payments SET provider_ref = ?, status = ?, captured_amount = ?, refunded_amount = ?,
+              failure_reason = ?, date_modified = ? WHERE payment_id = ?`
+	_, err = tx.ExecContext(ctx, r.Dialect.rebind(query),
+		nullString(payment.ProviderRef),
+		payment.Status,
+		payment.CapturedAmount,
+		payment.RefundedAmount,
+		nullString(payment.FailureReason),
+		payment.DateModified.UTC(),
+		payment.PaymentID,
+	)
+	if err != nil {
+		return err
+	}
+	if more := payment.RefundedAmount - refunded; more > 0 {
+		_, err = tx.ExecContext(ctx, r.Dialect.rebind("UPDATE orders SET refunded_amount = refunded_amount + ? WHERE order_id = ?"), more, orderID)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListPayments returns the payments of an order, oldest first.
+func (r *PaymentRepository) ListPayments(ctx context.Context, orderID uuid.UUID) ([]models.Payment, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE order_id = ? ORDER BY payment_id`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), orderID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var payments []models.Payment
+	for rows.Next() {
+		payment, err := scanPayment(rows)
+		if err != nil {
+			return nil, err
+		}
+		payments = append(payments, *payment)
+	}
+	return payments, rows.Err()
+}
+
+func (r *PaymentRepository) GetPaymentByRef(ctx context.Context, provider, providerRef string) (*models.Payment, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + paymentColumns + ` FROM payments WHERE provider = ? AND provider_ref = ?`
+	payment, err := scanPayment(r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), provider, providerRef))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return payment, err
+}
+
+func scanPaymentEvent(row scanner) (*models.PaymentEvent, error) {
+	var event models.PaymentEvent
+	var errorMessage sql.NullString
+	err := row.Scan(
+		&event.PaymentEventID,
+		&event.Provider,
+		&event.ProviderEventID,
+		&event.EventType,
+		&event.Payload,
+		&event.Status,
+		&errorMessage,
+		&event.Attempts,
+		&event.ReceivedAt,
+		&event.ProcessedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	event.Error = errorMessage.String
+	return &event, nil
+}
+
+// RecordPaymentEvent saves event unless the provider's event ID is already
+// there. The insert itself skips duplicates, so that the same event
+// delivered twice at once is still recorded only once.
+func (r *PaymentRepository) RecordPaymentEvent(ctx context.Context, event *models.PaymentEvent) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	event.ReceivedAt = time.Now()
+
+	insert := "INSERT"
+	switch r.Dialect {
+	case MySQL:
+		insert = "INSERT IGNORE"
+	case SQLite:
+		insert = "INSERT OR IGNORE"
+	}
+	query := insert + ` INTO payment_events (provider, provider_event_id, event_type, payload, status, error, attempts,
+              received_at, processed_at) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []any{
+		event.Provider,
+		event.ProviderEventID,
+		event.EventType,
+		string(event.Payload),
+		event.Status,
+		nullString(event.Error),
+		event.Attempts,
+		event.ReceivedAt.UTC(),
+		nullTime(event.ProcessedAt),
+	}
+	if r.Dialect == Postgres {
+		query += " ON CONFLICT (provider, provider_event_id) DO NOTHING RETURNING payment_event_id"
+		err := r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), args...).Scan(&event.PaymentEventID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicateEvent
+		}
+		return err
+	}
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrDuplicateEvent
+	}
+	event.PaymentEventID, err = res.LastInsertId()
+	return err
+}
+
+func (r *PaymentRepository) GetPaymentEvent(ctx context.Context, provider, providerEventID string) (*models.PaymentEvent, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + paymentEventColumns + ` FROM payment_events WHERE provider = ? AND provider_event_id = ?`
+	event, err := scanPaymentEvent(r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), provider, providerEventID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return event, err
+}
+
+// UpdatePaymentEvent saves the outcome of processing event.
+func (r *PaymentRepository) UpdatePaymentEvent(ctx context.Context, event *models.PaymentEvent) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `UPDATE payment_events SET status = ?, error = ?, attempts = ?, processed_at = ? WHERE payment_event_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query),
+		event.Status,
+		nullString(event.Error),
+		event.Attempts,
+		nullTime(event.ProcessedAt),
+		event.PaymentEventID,
+	)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// MySQL does not count rows that already had the values
+	if updated == 0 && r.Dialect != MySQL {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *PaymentRepository) ListPaymentEvents(ctx context.Context, status models.PaymentEventStatus) ([]models.PaymentEvent, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + paymentEventColumns + ` FROM payment_events WHERE status = ? ORDER BY payment_event_id`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), status)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []models.PaymentEvent
+	for rows.Next() {
+		event, err := scanPaymentEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, *event)
+	}
+	return events, rows.Err()
+}
@@ -0,0 +1,38 @@
+package repository_test
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/repository"
+	"github.com/snirkop89/mx-store/pkg/repository/repotest"
+)
+
+// TestPostgresRepository runs against the database in TEST_POSTGRES_DSN
+// (postgres://...). Migrations are applied first and all tables are truncated
+// before every subtest.
+func TestPostgresRepository(t *testing.T) {
+	dsn := os.Getenv("TEST_POSTGRES_DSN")
+	if dsn == "" {
+		t.Skip("TEST_POSTGRES_DSN not set")
+	}
+
+	db, dialect, err := repository.Open(dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	if err := repository.Migrate(context.Background(), db, dialect); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	repotest.Run(t, func(t *testing.T) *repository.Repository {
+		// TRUNCATE bypasses the rules that keep audit_events append-only
+		if _, err := db.Exec("TRUNCATE audit_events, jobs, outbox, webhook_deliveries, webhook_subscription_events, webhook_subscriptions, order_return_items, order_returns, payment_events, payments, addresses, shipping_methods, order_addresses, tax_rates, order_promotions, promotion_products, promotions, coupon_redemptions, coupon_categories, coupon_products, coupons, product_prices, order_items, orders, products"); err != nil {
+			t.Fatalf("clearing tables: %v", err)
+		}
+		return repository.NewRepository(db, dialect, 5*time.Second)
+	})
+}
@@ -0,0 +1,209 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"math"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+type PriceRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewPriceRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *PriceRepository {
+	return &PriceRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+// priceChanged compares prices to the cent, since MySQL keeps them as FLOAT
+// and does not return exactly what was stored.
+func priceChanged(a, b float64) bool {
+	return math.Abs(a-b) >= 0.005
+}
+
+// insertPrice adds a row to the price history as part of tx and sets
+// price.ID.
+func insertPrice(ctx context.Context, tx *sql.Tx, dialect Dialect, price *models.ProductPrice) error {
+	query := `INSERT INTO product_prices (product_id, price, effective_at, applied_at, created_at) 
+              VALUES (?, ?, ?, ?, ?)`
+
+	price.CreatedAt = time.Now()
+	args := []any{
+		price.ProductID,
+		price.Price,
+		price.EffectiveAt.UTC(),
+		nullTime(price.AppliedAt),
+		price.CreatedAt.UTC(),
+	}
+
+	// The pgx driver does not support LastInsertId
+	if dialect == Postgres {
+		return tx.QueryRowContext(ctx, dialect.rebind(query+" RETURNING id"), args...).Scan(&price.ID)
+	}
+	res, err := tx.ExecContext(ctx, dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	price.ID, err = res.LastInsertId()
+	return err
+}
+
+func scanPrice(row scanner) (models.ProductPrice, error) {
+	var price models.ProductPrice
+	err := row.Scan(
+		&price.ID,
+		&price.ProductID,
+		&price.Price,
+		&price.EffectiveAt,
+		&price.AppliedAt,
+		&price.CreatedAt,
+	)
+	return price, err
+}
+
+// ListPrices returns the price history of a product followed by its
+// scheduled prices, oldest first.
+func (r *PriceRepository) ListPrices(ctx context.Context, productID uuid.UUID) ([]models.ProductPrice, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT id, product_id, price, effective_at, applied_at, created_at
+              FROM product_prices WHERE product_id = ? ORDER BY effective_at, id`
+
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), productID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var prices []models.ProductPrice
+	for rows.Next() {
+		price, err := scanPrice(rows)
+		if err != nil {
+			return nil, err
+		}
+		prices = append(prices, price)
+	}
+	return prices, rows.Err()
+}
+
+// SchedulePrice plans a price change for price.EffectiveAt. It is applied by
+// ApplyDuePrices.
+func (r *PriceRepository) SchedulePrice(ctx context.Context, price *models.ProductPrice) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var exists int
+	query := `SELECT COUNT(*) FROM products WHERE product_id = ?`
+	if err := tx.QueryRowContext(ctx, r.Dialect.rebind(query), price.ProductID).Scan(&exists); err != nil {
+		return err
+	}
+	if exists == 0 {
+		return ErrNotFound
+	}
+
+	price.AppliedAt = nil
+	if err := insertPrice(ctx, tx, r.Dialect, price); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// CancelScheduledPrice removes a price change that has not been applied yet.
+func (r *PriceRepository) CancelScheduledPrice(ctx context.Context, productID uuid.UUID, id int64) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM product_prices WHERE id = ? AND product_id = ? AND applied_at IS NULL`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), id, productID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// ApplyDuePrices writes every scheduled price whose effective time is at or
+// before now to its product and returns the prices it applied. Like any other
+// edit, applying a price bumps the product version.
+func (r *PriceRepository) ApplyDuePrices(ctx context.Context, now time.Time) ([]models.ProductPrice, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, product_id, price, effective_at, applied_at, created_at
+              FROM product_prices WHERE applied_at IS NULL AND effective_at <= ? ORDER BY effective_at, id`
+	rows, err := tx.QueryContext(ctx, r.Dialect.rebind(query), now.UTC())
+	if err != nil {
+		return nil, err
+	}
+	var due []models.ProductPrice
+	for rows.Next() {
+		price, err := scanPrice(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		due = append(due, price)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	var applied []models.ProductPrice
+	for _, price := range due {
+		// Another instance may have applied it in the meantime
+		query = `UPDATE product_prices SET applied_at = ? WHERE id = ? AND applied_at IS NULL`
+		res, err := tx.ExecContext(ctx, r.Dialect.rebind(query), now.UTC(), price.ID)
+		if err != nil {
+			return nil, err
+		}
+		marked, err := res.RowsAffected()
+		if err != nil {
+			return nil, err
+		}
+		if marked == 0 {
+			continue
+		}
+
+		query = `UPDATE products SET price = ?, version = version + 1, date_modified = ? WHERE product_id = ?`
+		if _, err := tx.ExecContext(ctx, r.Dialect.rebind(query), price.Price, now, price.ProductID); err != nil {
+			return nil, err
+		}
+		if err := recordProductEvent(ctx, tx, r.Dialect, models.EventProductUpdated, price.ProductID); err != nil {
+			return nil, err
+		}
+		appliedAt := now
+		price.AppliedAt = &appliedAt
+		applied = append(applied, price)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
@@ -1,7 +1,10 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"strings"
 	"time"
 
 	"github.com/snirkop89/mx-store/pkg/models"
@@ -9,19 +12,24 @@ import (
 	"github.com/google/uuid"
 )
 
+const productColumns = `product_id, product_name, price, description, product_image, status, publish_at, unpublish_at,
+	tax_class, category, weight, stock, version, date_created, date_modified, deleted_at`
+
 type ProductRepository struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
 }
 
-func NewProductRepository(db *sql.DB) *ProductRepository {
-	return &ProductRepository{DB: db}
+func NewProductRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *ProductRepository {
+	return &ProductRepository{DB: db, Dialect: dialect, Timeout: timeout}
 }
 
-func (r *ProductRepository) GetProductByID(productID uuid.UUID) (*models.Product, error) {
-	query := `SELECT product_id, product_name, price, description, product_image, date_created, date_modified 
-              FROM products WHERE product_id = ?`
-	row := r.DB.QueryRow(query, productID)
+type scanner interface {
+	Scan(dest ...any) error
+}
 
+func scanProduct(row scanner) (models.Product, error) {
 	var product models.Product
 	err := row.Scan(
 		&product.ProductID,
@@ -29,62 +37,293 @@ func (r *ProductRepository) GetProductByID(productID uuid.UUID) (*models.Product
 		&product.Price,
 		&product.Description,
 		&product.ProductImage,
+		&product.Status,
+		&product.PublishAt,
+		&product.UnpublishAt,
+		&product.TaxClass,
+		&product.Category,
+		&product.Weight,
+		&product.Stock,
+		&product.Version,
 		&product.DateCreated,
 		&product.DateModified,
+		&product.DeletedAt,
 	)
+	return product, err
+}
+
+// nullTime binds an optional time. Times are stored in UTC so that they
+// compare correctly in SQLite, which keeps them as text.
+func nullTime(t *time.Time) any {
+	if t == nil {
+		return nil
+	}
+	return t.UTC()
+}
+
+// productDefaults mirrors the column defaults for products saved without a
+// status or tax class.
+func productDefaults(product *models.Product) {
+	if product.Status == "" {
+		product.Status = models.StatusPublished
+	}
+	if product.TaxClass == "" {
+		product.TaxClass = models.TaxClassStandard
+	}
+	product.Category = models.NormalizeCategory(product.Category)
+}
+
+// productWhere builds the WHERE clause selecting the products that match
+// filter. Archived products are only returned when asked for explicitly.
+func productWhere(filter ProductFilter) (string, []any) {
+	var conds []string
+	var args []any
+
+	if filter.Archived {
+		conds = append(conds, "deleted_at IS NOT NULL")
+	} else {
+		conds = append(conds, "deleted_at IS NULL")
+	}
+	if filter.WithImage {
+		conds = append(conds, "product_image != ''")
+	}
+	if !filter.ListedAt.IsZero() {
+		at := filter.ListedAt.UTC()
+		conds = append(conds,
+			"status = ?",
+			"(publish_at IS NULL OR publish_at <= ?)",
+			"(unpublish_at IS NULL OR unpublish_at > ?)",
+		)
+		args = append(args, models.StatusPublished, at, at)
+	}
+
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+func (r *ProductRepository) GetProductByID(ctx context.Context, productID uuid.UUID) (*models.Product, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + productColumns + ` FROM products WHERE product_id = ?`
+	row := r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), productID)
+
+	product, err := scanProduct(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
 	if err != nil {
 		return nil, err
 	}
 	return &product, nil
 }
 
-func (r *ProductRepository) CreateProduct(product *models.Product) error {
-	query := `INSERT INTO products (product_id, product_name, price, description, product_image, date_created, date_modified) 
-              VALUES (?, ?, ?, ?, ?, ?, ?)`
+func (r *ProductRepository) CreateProduct(ctx context.Context, product *models.Product) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `INSERT INTO products (product_id, product_name, price, description, product_image, status, publish_at, unpublish_at, tax_class, category, weight, stock, date_created, date_modified) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	product.ProductID = uuid.New()
+	product.Version = 1
 	product.DateCreated = time.Now()
 	product.DateModified = time.Now()
+	productDefaults(product)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	_, err := r.DB.Exec(query,
+	_, err = tx.ExecContext(ctx, r.Dialect.rebind(query),
 		product.ProductID,
 		product.ProductName,
 		product.Price,
 		product.Description,
 		product.ProductImage,
+		product.Status,
+		nullTime(product.PublishAt),
+		nullTime(product.UnpublishAt),
+		product.TaxClass,
+		product.Category,
+		product.Weight,
+		product.Stock,
 		product.DateCreated,
 		product.DateModified,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	// The first entry of the price history
+	err = insertPrice(ctx, tx, r.Dialect, &models.ProductPrice{
+		ProductID:   product.ProductID,
+		Price:       product.Price,
+		EffectiveAt: product.DateCreated,
+		AppliedAt:   &product.DateCreated,
+	})
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *ProductRepository) UpdateProduct(product *models.Product) error {
-	query := `UPDATE products SET product_name = ?, price = ?, description = ?, date_modified = ? 
-              WHERE product_id = ?`
+// UpdateProduct saves the product if it is still at product.Version and
+// increments the version. It returns ErrVersionConflict when the product was
+// updated by someone else in the meantime. A changed price is added to the
+// price history.
+func (r *ProductRepository) UpdateProduct(ctx context.Context, product *models.Product) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var oldPrice float64
+	query := `SELECT price FROM products WHERE product_id = ?`
+	err = tx.QueryRowContext(ctx, r.Dialect.rebind(query), product.ProductID).Scan(&oldPrice)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	query = `UPDATE products SET product_name = ?, price = ?, description = ?, status = ?, publish_at = ?, unpublish_at = ?,
+              tax_class = ?, category = ?, weight = ?, stock = ?, version = version + 1, date_modified = ? 
+              WHERE product_id = ? AND version = ?`
 
 	product.DateModified = time.Now()
+	productDefaults(product)
 
-	_, err := r.DB.Exec(query,
+	res, err := tx.ExecContext(ctx, r.Dialect.rebind(query),
 		product.ProductName,
 		product.Price,
 		product.Description,
+		product.Status,
+		nullTime(product.PublishAt),
+		nullTime(product.UnpublishAt),
+		product.TaxClass,
+		product.Category,
+		product.Weight,
+		product.Stock,
 		product.DateModified,
 		product.ProductID,
+		product.Version,
 	)
-	return err
+	if err != nil {
+		return err
+	}
+
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated == 0 {
+		return ErrVersionConflict
+	}
+
+	if priceChanged(oldPrice, product.Price) {
+		err = insertPrice(ctx, tx, r.Dialect, &models.ProductPrice{
+			ProductID:   product.ProductID,
+			Price:       product.Price,
+			EffectiveAt: product.DateModified,
+			AppliedAt:   &product.DateModified,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err := recordProductEvent(ctx, tx, r.Dialect, models.EventProductUpdated, product.ProductID); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	product.Version++
+	return nil
+}
+
+// ArchiveProduct hides a product from the storefront and the default admin
+// list. It stays available to past orders and can be restored.
+func (r *ProductRepository) ArchiveProduct(ctx context.Context, productID uuid.UUID) error {
+	query := `UPDATE products SET deleted_at = ? WHERE product_id = ? AND deleted_at IS NULL`
+	return r.setArchived(ctx, productID, models.EventProductArchived, query, time.Now(), productID)
+}
+
+func (r *ProductRepository) RestoreProduct(ctx context.Context, productID uuid.UUID) error {
+	query := `UPDATE products SET deleted_at = NULL WHERE product_id = ? AND deleted_at IS NOT NULL`
+	return r.setArchived(ctx, productID, models.EventProductUpdated, query, productID)
+}
+
+// setArchived archives or restores a product with query and args, and saves
+// an event of eventType about productID when that changed anything.
+func (r *ProductRepository) setArchived(ctx context.Context, productID uuid.UUID, eventType models.EventType, query string, args ...any) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	changed, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		return nil
+	}
+	if err := recordProductEvent(ctx, tx, r.Dialect, eventType, productID); err != nil {
+		return err
+	}
+	return tx.Commit()
 }
 
-func (r *ProductRepository) DeleteProduct(productID uuid.UUID) error {
+// DeleteProduct permanently removes a product. Products that appear on an
+// order cannot be deleted and return ErrProductInUse; archive them instead.
+// The foreign key from order items decides, so an order placed at the same
+// time cannot lose its product.
+func (r *ProductRepository) DeleteProduct(ctx context.Context, productID uuid.UUID) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
 	query := `DELETE FROM products WHERE product_id = ?`
-	_, err := r.DB.Exec(query, productID)
-	return err
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), productID)
+	if isForeignKeyViolation(err) {
+		return ErrProductInUse
+	}
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
 }
 
-func (r *ProductRepository) ListProducts(limit, offset int) ([]models.Product, error) {
-	query := `SELECT product_id, product_name, price, description, product_image, date_created, date_modified 
-              FROM products ORDER BY date_created DESC LIMIT ? OFFSET ?`
+func (r *ProductRepository) ListProducts(ctx context.Context, filter ProductFilter, limit, offset int) ([]models.Product, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	where, args := productWhere(filter)
+	query := `SELECT ` + productColumns + ` FROM products` + where + ` ORDER BY date_created DESC LIMIT ? OFFSET ?`
 
-	rows, err := r.DB.Query(query, limit, offset)
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), append(args, limit, offset)...)
 	if err != nil {
 		return nil, err
 	}
@@ -92,46 +331,36 @@ func (r *ProductRepository) ListProducts(limit, offset int) ([]models.Product, e
 
 	var products []models.Product
 	for rows.Next() {
-		var product models.Product
-		err := rows.Scan(
-			&product.ProductID,
-			&product.ProductName,
-			&product.Price,
-			&product.Description,
-			&product.ProductImage,
-			&product.DateCreated,
-			&product.DateModified,
-		)
+		product, err := scanProduct(rows)
 		if err != nil {
 			return nil, err
 		}
 		products = append(products, product)
 	}
-	return products, nil
+	return products, rows.Err()
 }
 
-func (r *ProductRepository) GetTotalProductsCount() (int, error) {
+func (r *ProductRepository) GetTotalProductsCount(ctx context.Context, filter ProductFilter) (int, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	where, args := productWhere(filter)
 	var count int
-	err := r.DB.QueryRow("SELECT COUNT(*) FROM products").Scan(&count)
+	err := r.DB.QueryRowContext(ctx, r.Dialect.rebind("SELECT COUNT(*) FROM products"+where), args...).Scan(&count)
 	if err != nil {
 		return 0, err
 	}
 	return count, nil
 }
 
-func (r *ProductRepository) GetProducts(whereClause string) ([]models.Product, error) {
-	query := `
-		SELECT product_id, product_name, price, description, product_image, date_created, date_modified
-		FROM products
-	`
-
-	if whereClause != "" {
-		query += " WHERE " + whereClause
-	}
+func (r *ProductRepository) GetProducts(ctx context.Context, filter ProductFilter) ([]models.Product, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
 
-	query += " ORDER BY date_created DESC"
+	where, args := productWhere(filter)
+	query := `SELECT ` + productColumns + ` FROM products` + where + ` ORDER BY date_created DESC`
 
-	rows, err := r.DB.Query(query)
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), args...)
 	if err != nil {
 		return nil, err
 	}
@@ -139,8 +368,7 @@ func (r *ProductRepository) GetProducts(whereClause string) ([]models.Product, e
 
 	var products []models.Product
 	for rows.Next() {
-		var p models.Product
-		err := rows.Scan(&p.ProductID, &p.ProductName, &p.Price, &p.Description, &p.ProductImage, &p.DateCreated, &p.DateModified)
+		p, err := scanProduct(rows)
 		if err != nil {
 			return nil, err
 		}
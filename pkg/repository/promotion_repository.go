@@ -0,0 +1,164 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+const promotionColumns = `promotion_id, name, kind, buy_quantity, free_quantity, min_subtotal, percent, bundle_price,
+	priority, stackable, starts_at, ends_at, active, date_created`
+
+type PromotionRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewPromotionRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *PromotionRepository {
+	return &PromotionRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+func scanPromotion(row scanner) (models.Promotion, error) {
+	var promotion models.Promotion
+	err := row.Scan(
+		&promotion.PromotionID,
+		&promotion.Name,
+		&promotion.Kind,
+		&promotion.BuyQuantity,
+		&promotion.FreeQuantity,
+		&promotion.MinSubtotal,
+		&promotion.Percent,
+		&promotion.BundlePrice,
+		&promotion.Priority,
+		&promotion.Stackable,
+		&promotion.StartsAt,
+		&promotion.EndsAt,
+		&promotion.Active,
+		&promotion.DateCreated,
+	)
+	return promotion, err
+}
+
+func (r *PromotionRepository) CreatePromotion(ctx context.Context, promotion *models.Promotion) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	promotion.DateCreated = time.Now()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO promotions (name, kind, buy_quantity, free_quantity, min_subtotal, percent, bundle_price,
+              priority, stackable, starts_at, ends_at, active, date_created) 
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []any{
+		promotion.Name,
+		promotion.Kind,
+		promotion.BuyQuantity,
+		promotion.FreeQuantity,
+		promotion.MinSubtotal,
+		promotion.Percent,
+		promotion.BundlePrice,
+		promotion.Priority,
+		promotion.Stackable,
+		nullTime(promotion.StartsAt),
+		nullTime(promotion.EndsAt),
+		promotion.Active,
+		promotion.DateCreated.UTC(),
+	}
+	if r.Dialect == Postgres {
+		err = tx.QueryRowContext(ctx, r.Dialect.rebind(query+" RETURNING promotion_id"), args...).Scan(&promotion.PromotionID)
+	} else {
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, r.Dialect.rebind(query), args...)
+		if err == nil {
+			promotion.PromotionID, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, productID := range promotion.ProductIDs {
+		query = `INSERT INTO promotion_products (promotion_id, product_id) VALUES (?, ?)`
+		if _, err := tx.ExecContext(ctx, r.Dialect.rebind(query), promotion.PromotionID, productID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// ListPromotions returns all promotions in the order they are applied.
+func (r *PromotionRepository) ListPromotions(ctx context.Context) ([]models.Promotion, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + promotionColumns + ` FROM promotions ORDER BY priority, promotion_id`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.Promotion
+	for rows.Next() {
+		promotion, err := scanPromotion(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, promotion)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range list {
+		query := `SELECT product_id FROM promotion_products WHERE promotion_id = ?`
+		rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), list[i].PromotionID)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var productID uuid.UUID
+			if err := rows.Scan(&productID); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			list[i].ProductIDs = append(list[i].ProductIDs, productID)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return list, nil
+}
+
+func (r *PromotionRepository) SetPromotionActive(ctx context.Context, promotionID int64, active bool) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `UPDATE promotions SET active = ? WHERE promotion_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), active, promotionID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// MySQL does not count rows that already had the value
+	if updated == 0 && r.Dialect != MySQL {
+		return ErrNotFound
+	}
+	return nil
+}
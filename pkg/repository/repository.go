@@ -1,17 +1,356 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+var (
+	// ErrNotFound is returned when a requested product or order does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrProductInUse is returned when deleting a product that is part of an order.
+	ErrProductInUse = errors.New("product is part of an order")
+	// ErrVersionConflict is returned when updating a product that was changed
+	// by someone else since it was read.
+	ErrVersionConflict = errors.New("product was modified concurrently")
+	// ErrCouponCodeTaken is returned when creating a coupon with a code that
+	// already exists.
+	ErrCouponCodeTaken = errors.New("coupon code already exists")
+	// ErrDuplicateEvent is returned when recording a payment event that was
+	// recorded before.
+	ErrDuplicateEvent = errors.New("payment event already recorded")
+	// ErrDuplicateDelivery is returned when creating a delivery of an event
+	// that the subscription has a delivery of already.
+	ErrDuplicateDelivery = errors.New("webhook delivery already exists")
+	// ErrOutOfStock is returned when placing an order for more units of a
+	// product than are in stock.
+	ErrOutOfStock = errors.New("not enough stock")
+	// ErrReturnStatus is returned when a return cannot move to the requested
+	// status from its current one.
+	ErrReturnStatus = errors.New("return cannot move to that status")
+	// ErrReturnQuantity is returned when returning more units of a product
+	// than were ordered and not returned yet.
+	ErrReturnQuantity = errors.New("more items than can be returned")
+	// ErrJobLost is returned when finishing a job whose lock expired and
+	// that another worker claimed since.
+	ErrJobLost = errors.New("job was claimed by another worker")
+	// ErrJobStatus is returned when running a job again that is running or
+	// has succeeded.
+	ErrJobStatus = errors.New("job cannot be run again in its status")
 )
 
+// ProductFilter narrows down the products returned by GetProducts.
+type ProductFilter struct {
+	// WithImage only returns products that have an image, which is what the
+	// storefront shows.
+	WithImage bool
+	// Archived returns only archived products instead of only live ones.
+	Archived bool
+	// ListedAt, when set, only returns published products whose publish
+	// window contains the given time.
+	ListedAt time.Time
+}
+
+type ProductStore interface {
+	GetProductByID(ctx context.Context, productID uuid.UUID) (*models.Product, error)
+	CreateProduct(ctx context.Context, product *models.Product) error
+	UpdateProduct(ctx context.Context, product *models.Product) error
+	ArchiveProduct(ctx context.Context, productID uuid.UUID) error
+	RestoreProduct(ctx context.Context, productID uuid.UUID) error
+	DeleteProduct(ctx context.Context, productID uuid.UUID) error
+	ListProducts(ctx context.Context, filter ProductFilter, limit, offset int) ([]models.Product, error)
+	GetTotalProductsCount(ctx context.Context, filter ProductFilter) (int, error)
+	GetProducts(ctx context.Context, filter ProductFilter) ([]models.Product, error)
+}
+
+type OrderStore interface {
+	// PlaceOrder saves order with its items and applied promotions, takes
+	// the items out of stock and redeems order.CouponCode, if set. It fails
+	// with ErrOutOfStock when a product has too few units left and with one
+	// of the coupons errors when the coupon is used up.
+	PlaceOrder(ctx context.Context, order *models.Order) error
+	// SetOrderStatus moves an order to status. Moving it to
+	// models.OrderStatusPaymentFailed gives back its coupon and stock.
+	SetOrderStatus(ctx context.Context, orderID uuid.UUID, status string) error
+	PlaceOrderWithItems(ctx context.Context, orderItems []models.OrderItem) error
+	ListOrders(ctx context.Context, limit, offset int) ([]models.Order, error)
+	ListUserOrders(ctx context.Context, userID string) ([]models.Order, error)
+	GetTotalOrdersCount(ctx context.Context) (int, error)
+	CreateOrder(ctx context.Context, order *models.Order) error
+	AddOrderItem(ctx context.Context, orderItem *models.OrderItem) error
+	GetOrderWithProducts(ctx context.Context, orderID uuid.UUID) (*models.Order, error)
+	// SalesSummary sums up the orders that count as sales placed from from
+	// until to.
+	SalesSummary(ctx context.Context, from, to time.Time) (models.SalesSummary, error)
+	// DailySales breaks the sales from from until to down by the days they
+	// were placed on in loc, oldest first. Days without sales are included.
+	DailySales(ctx context.Context, from, to time.Time, loc *time.Location) ([]models.DailySales, error)
+	// TopProducts returns the limit products sold the most units of from
+	// from until to, ties going to the higher revenue.
+	TopProducts(ctx context.Context, from, to time.Time, limit int) ([]models.ProductSales, error)
+	// LowStock returns up to limit live products with at most threshold
+	// units in stock, fewest first, with the units sold from from until to.
+	// Products whose stock is not tracked never run low.
+	LowStock(ctx context.Context, threshold int, from, to time.Time, limit int) ([]models.StockAlert, error)
+}
+
+// AuditFilter narrows down the events returned by ListEvents. Empty fields
+// match everything.
+type AuditFilter struct {
+	Actor      string
+	Action     string
+	EntityType string
+	EntityID   string
+}
+
+type AuditStore interface {
+	RecordEvent(ctx context.Context, event *models.AuditEvent) error
+	ListEvents(ctx context.Context, filter AuditFilter, limit, offset int) ([]models.AuditEvent, error)
+	CountEvents(ctx context.Context, filter AuditFilter) (int, error)
+}
+
+// PriceStore keeps the price history of products and applies scheduled
+// price changes. Product stores add to the history whenever a price changes.
+type PriceStore interface {
+	ListPrices(ctx context.Context, productID uuid.UUID) ([]models.ProductPrice, error)
+	SchedulePrice(ctx context.Context, price *models.ProductPrice) error
+	CancelScheduledPrice(ctx context.Context, productID uuid.UUID, id int64) error
+	ApplyDuePrices(ctx context.Context, now time.Time) ([]models.ProductPrice, error)
+}
+
+type CouponStore interface {
+	CreateCoupon(ctx context.Context, coupon *models.Coupon) error
+	GetCouponByCode(ctx context.Context, code string) (*models.Coupon, error)
+	ListCoupons(ctx context.Context) ([]models.Coupon, error)
+	SetCouponActive(ctx context.Context, couponID int64, active bool) error
+	CountRedemptions(ctx context.Context, couponID int64, userID string) (int, error)
+}
+
+// PromotionStore keeps the automatic promotions. Order stores save the
+// promotions applied to an order with it.
+type PromotionStore interface {
+	CreatePromotion(ctx context.Context, promotion *models.Promotion) error
+	ListPromotions(ctx context.Context) ([]models.Promotion, error)
+	SetPromotionActive(ctx context.Context, promotionID int64, active bool) error
+}
+
+// TaxStore keeps the tax rates. Order stores save the tax of every line with
+// the order.
+type TaxStore interface {
+	ListTaxRates(ctx context.Context) ([]models.TaxRate, error)
+	SaveTaxRate(ctx context.Context, rate *models.TaxRate) error
+	DeleteTaxRate(ctx context.Context, taxRateID int64) error
+}
+
+// AddressStore keeps the address books of customers. Order stores save a
+// copy of the addresses an order was placed with.
+type AddressStore interface {
+	CreateAddress(ctx context.Context, address *models.Address) error
+	GetAddress(ctx context.Context, userID string, addressID int64) (*models.Address, error)
+	ListAddresses(ctx context.Context, userID string) ([]models.Address, error)
+	DeleteAddress(ctx context.Context, userID string, addressID int64) error
+}
+
+type ShippingStore interface {
+	CreateShippingMethod(ctx context.Context, method *models.ShippingMethod) error
+	ListShippingMethods(ctx context.Context) ([]models.ShippingMethod, error)
+	SetShippingMethodActive(ctx context.Context, methodID int64, active bool) error
+}
+
+// PaymentStore keeps every attempt to pay for an order and the webhooks
+// received about them.
+type PaymentStore interface {
+	CreatePayment(ctx context.Context, payment *models.Payment) error
+	UpdatePayment(ctx context.Context, payment *models.Payment) error
+	// RecordRefund updates a payment whose refunded amount the provider
+	// reported, and adds what it refunded on top of what the payment had
+	// recorded to the refunded amount of its order, in one transaction.
+	RecordRefund(ctx context.Context, payment *models.Payment) error
+	ListPayments(ctx context.Context, orderID uuid.UUID) ([]models.Payment, error)
+	GetPaymentByRef(ctx context.Context, provider, providerRef string) (*models.Payment, error)
+
+	// RecordPaymentEvent saves a received webhook, or returns
+	// ErrDuplicateEvent when the provider sent the event before.
+	RecordPaymentEvent(ctx context.Context, event *models.PaymentEvent) error
+	GetPaymentEvent(ctx context.Context, provider, providerEventID string) (*models.PaymentEvent, error)
+	UpdatePaymentEvent(ctx context.Context, event *models.PaymentEvent) error
+	// ListPaymentEvents returns the events with status, oldest first.
+	ListPaymentEvents(ctx context.Context, status models.PaymentEventStatus) ([]models.PaymentEvent, error)
+}
+
+// ReturnFilter narrows down the returns returned by ListReturns. Empty
+// fields match everything.
+type ReturnFilter struct {
+	OrderID uuid.UUID
+	UserID  string
+	Status  models.ReturnStatus
+}
+
+// ReturnStore keeps the returns of ordered items. Moving a return along
+// fails with ErrReturnStatus unless models.CanTransitionReturn allows it.
+type ReturnStore interface {
+	// CreateReturn saves a requested return with its items, failing with
+	// ErrReturnQuantity when more is returned than was ordered.
+	CreateReturn(ctx context.Context, ret *models.Return) error
+	GetReturn(ctx context.Context, returnID int64) (*models.Return, error)
+	ListReturns(ctx context.Context, filter ReturnFilter) ([]models.Return, error)
+	// SetReturnStatus approves or rejects a requested return.
+	SetReturnStatus(ctx context.Context, returnID int64, status models.ReturnStatus, note string) error
+	// ReceiveReturn marks an approved return received and puts its items
+	// back in stock.
+	ReceiveReturn(ctx context.Context, returnID int64) error
+	// ClaimRefund moves a received return to refunding, which only one
+	// caller can do, before its refund is made.
+	ClaimRefund(ctx context.Context, returnID int64) error
+	// ReleaseRefund moves a refunding return back to received when its
+	// refund failed, to be refunded again.
+	ReleaseRefund(ctx context.Context, returnID int64) error
+	// RefundReturn marks a refunding return refunded and adds amount to the
+	// refunded amount of its order.
+	RefundReturn(ctx context.Context, returnID int64, amount float64, refundRef string) error
+}
+
+// JobStore keeps the background jobs. A worker claims a due job and then
+// completes, retries or buries it, which fails with ErrJobLost when the
+// claim expired and another worker claimed the job since.
+type JobStore interface {
+	EnqueueJob(ctx context.Context, job *models.Job) error
+	// EnsureJob enqueues job unless a job of its kind is queued or running
+	// already, and reports whether it did.
+	EnsureJob(ctx context.Context, job *models.Job) (bool, error)
+	// ClaimJob marks the queued job that has been due at now the longest
+	// running until lockUntil, counting an attempt. Running jobs whose lock
+	// expired are claimed again. It returns ErrNotFound when nothing is due.
+	ClaimJob(ctx context.Context, now, lockUntil time.Time) (*models.Job, error)
+	CompleteJob(ctx context.Context, job *models.Job) error
+	// RetryJob queues a claimed job that failed to run again at runAt.
+	RetryJob(ctx context.Context, job *models.Job, runAt time.Time, lastError string) error
+	// BuryJob marks a claimed job that failed its last attempt dead.
+	BuryJob(ctx context.Context, job *models.Job, lastError string) error
+	// RequeueJob makes a dead or queued job due now with all its attempts
+	// left. It fails with ErrJobStatus for other jobs.
+	RequeueJob(ctx context.Context, jobID int64) error
+	GetJob(ctx context.Context, jobID int64) (*models.Job, error)
+	// ListJobs returns the jobs with status, or all jobs when it is empty,
+	// most recently due first.
+	ListJobs(ctx context.Context, status models.JobStatus, limit, offset int) ([]models.Job, error)
+	// CountJobs counts the jobs in each status.
+	CountJobs(ctx context.Context) (map[models.JobStatus]int, error)
+	// PurgeJobs deletes the jobs that succeeded before before and returns
+	// how many it deleted.
+	PurgeJobs(ctx context.Context, before time.Time) (int, error)
+}
+
+// WebhookStore keeps the webhook subscriptions and the deliveries of events
+// to them. Deleting a subscription deletes its deliveries.
+type WebhookStore interface {
+	CreateWebhookSubscription(ctx context.Context, sub *models.WebhookSubscription) error
+	GetWebhookSubscription(ctx context.Context, subscriptionID int64) (*models.WebhookSubscription, error)
+	// ListWebhookSubscriptions returns every subscription, oldest first.
+	ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error)
+	SetWebhookSubscriptionActive(ctx context.Context, subscriptionID int64, active bool) error
+	DeleteWebhookSubscription(ctx context.Context, subscriptionID int64) error
+
+	// CreateWebhookDelivery saves a delivery, or returns ErrDuplicateDelivery
+	// when the subscription has a delivery of the same event ID.
+	CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	GetWebhookDelivery(ctx context.Context, deliveryID int64) (*models.WebhookDelivery, error)
+	// UpdateWebhookDelivery saves the outcome of sending a delivery: its
+	// status, attempts, response status, last error and delivery time.
+	UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error
+	// ListWebhookDeliveries returns the latest deliveries to a subscription,
+	// or to every subscription when subscriptionID is 0, newest first.
+	ListWebhookDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]models.WebhookDelivery, error)
+}
+
+// OutboxStore keeps the domain events that the other stores save with the
+// changes they are about, until the relay has published them. A relay claims
+// due events until lockUntil, so that other relays skip them meanwhile, and
+// then marks them published or retries them.
+type OutboxStore interface {
+	// ClaimOutboxEvents claims at most limit unpublished events that are
+	// due at now, oldest first.
+	ClaimOutboxEvents(ctx context.Context, now, lockUntil time.Time, limit int) ([]models.OutboxEvent, error)
+	MarkOutboxEventPublished(ctx context.Context, eventID int64, at time.Time) error
+	// RetryOutboxEvent counts a failed attempt to publish an event and makes
+	// it due again at retryAt.
+	RetryOutboxEvent(ctx context.Context, eventID int64, retryAt time.Time, lastError string) error
+	GetOutboxEvent(ctx context.Context, eventID int64) (*models.OutboxEvent, error)
+	// PurgeOutboxEvents deletes the events published before before and
+	// returns how many it deleted.
+	PurgeOutboxEvents(ctx context.Context, before time.Time) (int, error)
+}
+
 type Repository struct {
-	Product *ProductRepository
-	Order   *OrderRepository
+	Product   ProductStore
+	Order     OrderStore
+	Audit     AuditStore
+	Price     PriceStore
+	Coupon    CouponStore
+	Promotion PromotionStore
+	Tax       TaxStore
+	Address   AddressStore
+	Shipping  ShippingStore
+	Payment   PaymentStore
+	Return    ReturnStore
+	Job       JobStore
+	Webhook   WebhookStore
+	Outbox    OutboxStore
 }
 
-func NewRepository(db *sql.DB) *Repository {
+// NewRepository creates the repositories. Every query is bounded by timeout,
+// on top of whatever deadline the caller's context already carries. A zero
+// timeout disables the per-query limit.
+func NewRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *Repository {
 	return &Repository{
-		Product: NewProductRepository(db),
-		Order:   NewOrderRepository(db),
+		Product:   NewProductRepository(db, dialect, timeout),
+		Order:     NewOrderRepository(db, dialect, timeout),
+		Audit:     NewAuditRepository(db, dialect, timeout),
+		Price:     NewPriceRepository(db, dialect, timeout),
+		Coupon:    NewCouponRepository(db, dialect, timeout),
+		Promotion: NewPromotionRepository(db, dialect, timeout),
+		Tax:       NewTaxRepository(db, dialect, timeout),
+		Address:   NewAddressRepository(db, dialect, timeout),
+		Shipping:  NewShippingRepository(db, dialect, timeout),
+		Payment:   NewPaymentRepository(db, dialect, timeout),
+		Return:    NewReturnRepository(db, dialect, timeout),
+		Job:       NewJobRepository(db, dialect, timeout),
+		Webhook:   NewWebhookRepository(db, dialect, timeout),
+		Outbox:    NewOutboxRepository(db, dialect, timeout),
+	}
+}
+
+// NewMemoryRepository creates repositories that keep everything in memory.
+// They are meant for tests and behave like the database backed ones.
+func NewMemoryRepository() *Repository {
+	products := NewMemoryProductStore()
+	orders := NewMemoryOrderStore(products)
+	return &Repository{
+		Product:   products,
+		Order:     orders,
+		Audit:     NewMemoryAuditStore(),
+		Price:     NewMemoryPriceStore(products),
+		Coupon:    NewMemoryCouponStore(orders),
+		Promotion: NewMemoryPromotionStore(),
+		Tax:       NewMemoryTaxStore(),
+		Address:   NewMemoryAddressStore(),
+		Shipping:  NewMemoryShippingStore(),
+		Payment:   NewMemoryPaymentStore(orders),
+		Return:    NewMemoryReturnStore(orders),
+		Job:       NewMemoryJobStore(),
+		Webhook:   NewMemoryWebhookStore(),
+		Outbox:    NewMemoryOutboxStore(products, orders),
+	}
+}
+
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
 	}
+	return context.WithTimeout(ctx, timeout)
 }
@@ -0,0 +1,2368 @@
+// Package repotest contains the conformance suite every repository
+// implementation has to pass.
+package repotest
+
+import (
+	"context"
+	"errors"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/coupons"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+// Run runs the whole suite. newRepo must return a repository backed by an
+// empty store every time it is called.
+func Run(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	t.Run("Products", func(t *testing.T) { RunProductStore(t, newRepo) })
+	t.Run("Orders", func(t *testing.T) { RunOrderStore(t, newRepo) })
+	t.Run("Audit", func(t *testing.T) { RunAuditStore(t, newRepo) })
+	t.Run("Prices", func(t *testing.T) { RunPriceStore(t, newRepo) })
+	t.Run("Coupons", func(t *testing.T) { RunCouponStore(t, newRepo) })
+	t.Run("Promotions", func(t *testing.T) { RunPromotionStore(t, newRepo) })
+	t.Run("Tax", func(t *testing.T) { RunTaxStore(t, newRepo) })
+	t.Run("Addresses", func(t *testing.T) { RunAddressStore(t, newRepo) })
+	t.Run("Shipping", func(t *testing.T) { RunShippingStore(t, newRepo) })
+	t.Run("Payments", func(t *testing.T) { RunPaymentStore(t, newRepo) })
+	t.Run("Returns", func(t *testing.T) { RunReturnStore(t, newRepo) })
+	t.Run("Jobs", func(t *testing.T) { RunJobStore(t, newRepo) })
+	t.Run("Webhooks", func(t *testing.T) { RunWebhookStore(t, newRepo) })
+	t.Run("Outbox", func(t *testing.T) { RunOutboxStore(t, newRepo) })
+}
+
+func RunProductStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		store := newRepo(t).Product
+		product := newProduct("Laptop", 12.5, "laptop.jpeg")
+		if err := store.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		if product.ProductID == uuid.Nil {
+			t.Fatal("CreateProduct did not assign an ID")
+		}
+
+		got, err := store.GetProductByID(ctx, product.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID: %v", err)
+		}
+		assertProduct(t, got, product)
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		store := newRepo(t).Product
+		_, err := store.GetProductByID(ctx, uuid.New())
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("GetProductByID error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		store := newRepo(t).Product
+		product := newProduct("Phone", 100, "phone.jpeg")
+		if err := store.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+
+		update := models.Product{
+			ProductID:   product.ProductID,
+			ProductName: "Better Phone",
+			Price:       150.25,
+			Description: "Now with more phone",
+			TaxClass:    models.TaxClassReduced,
+			Category:    " Mobile  phones ",
+			Weight:      0.35,
+			Version:     product.Version,
+		}
+		if err := store.UpdateProduct(ctx, &update); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+		if update.Version != product.Version+1 {
+			t.Errorf("Version after update = %d, want %d", update.Version, product.Version+1)
+		}
+
+		got, err := store.GetProductByID(ctx, product.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID: %v", err)
+		}
+		update.ProductImage = product.ProductImage
+		if update.Category != "Mobile phones" {
+			t.Errorf("Category = %q, want it trimmed to %q", update.Category, "Mobile phones")
+		}
+		assertProduct(t, got, update)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		store := newRepo(t).Product
+		product := newProduct("Camera", 75, "camera.jpeg")
+		if err := store.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		if err := store.DeleteProduct(ctx, product.ProductID); err != nil {
+			t.Fatalf("DeleteProduct: %v", err)
+		}
+		_, err := store.GetProductByID(ctx, product.ProductID)
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("GetProductByID after delete error = %v, want ErrNotFound", err)
+		}
+		if err := store.DeleteProduct(ctx, product.ProductID); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("DeleteProduct of a deleted product error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("UpdateConflict", func(t *testing.T) {
+		store := newRepo(t).Product
+		product := newProduct("Headphones", 50, "headphones.jpeg")
+		if err := store.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+
+		first, second := product, product
+		first.Price = 55
+		if err := store.UpdateProduct(ctx, &first); err != nil {
+			t.Fatalf("first UpdateProduct: %v", err)
+		}
+		second.Price = 45
+		if err := store.UpdateProduct(ctx, &second); !errors.Is(err, repository.ErrVersionConflict) {
+			t.Fatalf("stale UpdateProduct error = %v, want ErrVersionConflict", err)
+		}
+
+		got, err := store.GetProductByID(ctx, product.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID: %v", err)
+		}
+		assertFloat(t, "Price", got.Price, 55)
+		if got.Version != first.Version {
+			t.Errorf("Version = %d, want %d", got.Version, first.Version)
+		}
+
+		missing := newProduct("Missing", 1, "")
+		missing.ProductID = uuid.New()
+		if err := store.UpdateProduct(ctx, &missing); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("UpdateProduct of missing product error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("ArchiveAndRestore", func(t *testing.T) {
+		store := newRepo(t).Product
+		product := newProduct("Tablet", 60, "tablet.jpeg")
+		if err := store.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		if err := store.ArchiveProduct(ctx, product.ProductID); err != nil {
+			t.Fatalf("ArchiveProduct: %v", err)
+		}
+
+		got, err := store.GetProductByID(ctx, product.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID of archived product: %v", err)
+		}
+		if !got.Archived() {
+			t.Fatal("product is not archived")
+		}
+		assertCount(t, store, repository.ProductFilter{}, 0)
+		assertCount(t, store, repository.ProductFilter{Archived: true}, 1)
+		live, err := store.GetProducts(ctx, repository.ProductFilter{WithImage: true})
+		if err != nil {
+			t.Fatalf("GetProducts: %v", err)
+		}
+		if len(live) != 0 {
+			t.Fatalf("storefront lists %d products, want 0", len(live))
+		}
+
+		if err := store.RestoreProduct(ctx, product.ProductID); err != nil {
+			t.Fatalf("RestoreProduct: %v", err)
+		}
+		got, err = store.GetProductByID(ctx, product.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID: %v", err)
+		}
+		if got.Archived() {
+			t.Fatal("product is still archived after restore")
+		}
+		assertCount(t, store, repository.ProductFilter{}, 1)
+		assertCount(t, store, repository.ProductFilter{Archived: true}, 0)
+	})
+
+	t.Run("ListAndCount", func(t *testing.T) {
+		store := newRepo(t).Product
+		for i := range 5 {
+			product := newProduct("Product", float64(i), "p.jpeg")
+			if err := store.CreateProduct(ctx, &product); err != nil {
+				t.Fatalf("CreateProduct: %v", err)
+			}
+		}
+
+		count, err := store.GetTotalProductsCount(ctx, repository.ProductFilter{})
+		if err != nil {
+			t.Fatalf("GetTotalProductsCount: %v", err)
+		}
+		if count != 5 {
+			t.Fatalf("GetTotalProductsCount = %d, want 5", count)
+		}
+
+		seen := map[uuid.UUID]bool{}
+		for _, tc := range []struct{ limit, offset, want int }{
+			{2, 0, 2}, {2, 2, 2}, {2, 4, 1}, {2, 6, 0},
+		} {
+			products, err := store.ListProducts(ctx, repository.ProductFilter{}, tc.limit, tc.offset)
+			if err != nil {
+				t.Fatalf("ListProducts(%d, %d): %v", tc.limit, tc.offset, err)
+			}
+			if len(products) != tc.want {
+				t.Fatalf("ListProducts(%d, %d) returned %d products, want %d", tc.limit, tc.offset, len(products), tc.want)
+			}
+			for _, p := range products {
+				seen[p.ProductID] = true
+			}
+		}
+		if len(seen) != 5 {
+			t.Fatalf("paging returned %d distinct products, want 5", len(seen))
+		}
+	})
+
+	t.Run("FilterWithImage", func(t *testing.T) {
+		store := newRepo(t).Product
+		withImage := newProduct("Speaker", 20, "speaker.jpeg")
+		withoutImage := newProduct("Monitor", 30, "")
+		for _, p := range []*models.Product{&withImage, &withoutImage} {
+			if err := store.CreateProduct(ctx, p); err != nil {
+				t.Fatalf("CreateProduct: %v", err)
+			}
+		}
+
+		all, err := store.GetProducts(ctx, repository.ProductFilter{})
+		if err != nil {
+			t.Fatalf("GetProducts: %v", err)
+		}
+		if len(all) != 2 {
+			t.Fatalf("GetProducts returned %d products, want 2", len(all))
+		}
+
+		filtered, err := store.GetProducts(ctx, repository.ProductFilter{WithImage: true})
+		if err != nil {
+			t.Fatalf("GetProducts: %v", err)
+		}
+		if len(filtered) != 1 || filtered[0].ProductID != withImage.ProductID {
+			t.Fatalf("GetProducts(WithImage) = %v, want only %s", filtered, withImage.ProductID)
+		}
+	})
+
+	t.Run("Publishing", func(t *testing.T) {
+		store := newRepo(t).Product
+		now := time.Now().Truncate(time.Second)
+		hourAgo, inAnHour := now.Add(-time.Hour), now.Add(time.Hour)
+
+		published := newProduct("Published", 1, "a.jpeg")
+		draft := newProduct("Draft", 1, "a.jpeg")
+		draft.Status = models.StatusDraft
+		unlisted := newProduct("Unlisted", 1, "a.jpeg")
+		unlisted.Status = models.StatusUnlisted
+		scheduled := newProduct("Scheduled", 1, "a.jpeg")
+		scheduled.PublishAt = &inAnHour
+		expired := newProduct("Expired", 1, "a.jpeg")
+		expired.UnpublishAt = &hourAgo
+		window := newProduct("Window", 1, "a.jpeg")
+		window.PublishAt = &hourAgo
+		window.UnpublishAt = &inAnHour
+
+		for _, p := range []*models.Product{&published, &draft, &unlisted, &scheduled, &expired, &window} {
+			if err := store.CreateProduct(ctx, p); err != nil {
+				t.Fatalf("CreateProduct(%s): %v", p.ProductName, err)
+			}
+		}
+
+		got, err := store.GetProductByID(ctx, scheduled.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID: %v", err)
+		}
+		if got.Status != models.StatusPublished {
+			t.Errorf("Status = %q, want %q", got.Status, models.StatusPublished)
+		}
+		if got.PublishAt == nil || !got.PublishAt.Equal(inAnHour) {
+			t.Errorf("PublishAt = %v, want %v", got.PublishAt, inAnHour)
+		}
+
+		assertListed(t, store, now, published, window)
+		assertListed(t, store, now.Add(2*time.Hour), published, scheduled)
+
+		draft.Status = models.StatusPublished
+		if err := store.UpdateProduct(ctx, &draft); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+		assertListed(t, store, now, published, window, draft)
+	})
+
+	t.Run("CancelledContext", func(t *testing.T) {
+		store := newRepo(t).Product
+		ctx, cancel := context.WithCancel(ctx)
+		cancel()
+		if _, err := store.GetTotalProductsCount(ctx, repository.ProductFilter{}); !errors.Is(err, context.Canceled) {
+			t.Fatalf("GetTotalProductsCount error = %v, want context.Canceled", err)
+		}
+	})
+}
+
+func RunOrderStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("PlaceOrderWithItems", func(t *testing.T) {
+		repo := newRepo(t)
+		laptop := newProduct("Laptop", 500, "laptop.jpeg")
+		mouse := newProduct("Mouse", 12.5, "mouse.jpeg")
+		for _, p := range []*models.Product{&laptop, &mouse} {
+			if err := repo.Product.CreateProduct(ctx, p); err != nil {
+				t.Fatalf("CreateProduct: %v", err)
+			}
+		}
+
+		items := []models.OrderItem{
+			{ProductID: laptop.ProductID, Quantity: 1, Cost: 500},
+			{ProductID: mouse.ProductID, Quantity: 2, Cost: 25},
+		}
+		if err := repo.Order.PlaceOrderWithItems(ctx, items); err != nil {
+			t.Fatalf("PlaceOrderWithItems: %v", err)
+		}
+
+		orders, err := repo.Order.ListOrders(ctx, 10, 0)
+		if err != nil {
+			t.Fatalf("ListOrders: %v", err)
+		}
+		if len(orders) != 1 {
+			t.Fatalf("ListOrders returned %d orders, want 1", len(orders))
+		}
+		if orders[0].OrderStatus != "ordered" {
+			t.Errorf("OrderStatus = %q, want %q", orders[0].OrderStatus, "ordered")
+		}
+
+		order, err := repo.Order.GetOrderWithProducts(ctx, orders[0].OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		if len(order.Items) != 2 {
+			t.Fatalf("order has %d items, want 2", len(order.Items))
+		}
+		for _, item := range order.Items {
+			switch item.ProductID {
+			case laptop.ProductID:
+				assertFloat(t, "laptop cost", item.Cost, 500)
+				assertProduct(t, &item.Product, laptop)
+			case mouse.ProductID:
+				assertFloat(t, "mouse cost", item.Cost, 25)
+				assertProduct(t, &item.Product, mouse)
+			default:
+				t.Fatalf("unexpected product %s in order", item.ProductID)
+			}
+		}
+	})
+
+	t.Run("CreateOrderAndAddItem", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Watch", 80, "watch.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+
+		order := models.Order{UserID: "someone@example.com", OrderStatus: "ordered"}
+		if err := repo.Order.CreateOrder(ctx, &order); err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		if order.OrderID == uuid.Nil {
+			t.Fatal("CreateOrder did not assign an ID")
+		}
+		item := models.OrderItem{OrderID: order.OrderID, ProductID: product.ProductID, Quantity: 3}
+		if err := repo.Order.AddOrderItem(ctx, &item); err != nil {
+			t.Fatalf("AddOrderItem: %v", err)
+		}
+
+		got, err := repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		if got.UserID != order.UserID {
+			t.Errorf("UserID = %q, want %q", got.UserID, order.UserID)
+		}
+		if len(got.Items) != 1 {
+			t.Fatalf("order has %d items, want 1", len(got.Items))
+		}
+		assertFloat(t, "cost", got.Items[0].Cost, 240)
+
+		count, err := repo.Order.GetTotalOrdersCount(ctx)
+		if err != nil {
+			t.Fatalf("GetTotalOrdersCount: %v", err)
+		}
+		if count != 1 {
+			t.Fatalf("GetTotalOrdersCount = %d, want 1", count)
+		}
+	})
+
+	t.Run("ArchivedProductStaysOnOrder", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Printer", 40, "printer.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		order := models.Order{UserID: "someone@example.com", OrderStatus: "ordered"}
+		if err := repo.Order.CreateOrder(ctx, &order); err != nil {
+			t.Fatalf("CreateOrder: %v", err)
+		}
+		item := models.OrderItem{OrderID: order.OrderID, ProductID: product.ProductID, Quantity: 1}
+		if err := repo.Order.AddOrderItem(ctx, &item); err != nil {
+			t.Fatalf("AddOrderItem: %v", err)
+		}
+
+		if err := repo.Product.DeleteProduct(ctx, product.ProductID); !errors.Is(err, repository.ErrProductInUse) {
+			t.Fatalf("DeleteProduct of ordered product error = %v, want ErrProductInUse", err)
+		}
+		if err := repo.Product.ArchiveProduct(ctx, product.ProductID); err != nil {
+			t.Fatalf("ArchiveProduct: %v", err)
+		}
+
+		got, err := repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		if len(got.Items) != 1 || got.Items[0].Product.ProductName != product.ProductName {
+			t.Fatalf("order items = %+v, want the archived product", got.Items)
+		}
+	})
+
+	t.Run("SetStatus", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Chair", 40, "chair.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		order := &models.Order{
+			UserID:      "someone@example.com",
+			OrderStatus: models.OrderStatusPendingPayment,
+			Items:       []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 40}},
+		}
+		if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+		got, err := repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		if got.OrderStatus != models.OrderStatusPendingPayment {
+			t.Errorf("OrderStatus = %q, want %q", got.OrderStatus, models.OrderStatusPendingPayment)
+		}
+
+		if err := repo.Order.SetOrderStatus(ctx, order.OrderID, models.OrderStatusPaid); err != nil {
+			t.Fatalf("SetOrderStatus: %v", err)
+		}
+		got, err = repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		if got.OrderStatus != models.OrderStatusPaid {
+			t.Errorf("OrderStatus = %q, want %q", got.OrderStatus, models.OrderStatusPaid)
+		}
+
+		if err := repo.Order.SetOrderStatus(ctx, uuid.New(), models.OrderStatusPaid); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("SetOrderStatus of a missing order error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Stock", func(t *testing.T) {
+		repo := newRepo(t)
+		chair := newProduct("Chair", 40, "chair.jpeg")
+		chair.Stock = intPtr(3)
+		table := newProduct("Table", 100, "table.jpeg")
+		for _, p := range []*models.Product{&chair, &table} {
+			if err := repo.Product.CreateProduct(ctx, p); err != nil {
+				t.Fatalf("CreateProduct: %v", err)
+			}
+		}
+
+		order := &models.Order{
+			UserID:      "alice@example.com",
+			OrderStatus: models.OrderStatusPendingPayment,
+			Items: []models.OrderItem{
+				{ProductID: chair.ProductID, Quantity: 2, Cost: 80},
+				{ProductID: table.ProductID, Quantity: 5, Cost: 500},
+			},
+		}
+		stale, err := repo.Product.GetProductByID(ctx, chair.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID: %v", err)
+		}
+		if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+		assertStock(t, repo, chair.ProductID, intPtr(1))
+		assertStock(t, repo, table.ProductID, nil)
+		// An edit opened before the sale does not overwrite its stock
+		if err := repo.Product.UpdateProduct(ctx, stale); !errors.Is(err, repository.ErrVersionConflict) {
+			t.Fatalf("UpdateProduct of the version before the sale error = %v, want ErrVersionConflict", err)
+		}
+		assertStock(t, repo, chair.ProductID, intPtr(1))
+
+		tooMany := &models.Order{
+			UserID: "bob@example.com",
+			Items: []models.OrderItem{
+				{ProductID: table.ProductID, Quantity: 1, Cost: 100},
+				{ProductID: chair.ProductID, Quantity: 2, Cost: 80},
+			},
+		}
+		if err := repo.Order.PlaceOrder(ctx, tooMany); !errors.Is(err, repository.ErrOutOfStock) {
+			t.Fatalf("PlaceOrder of more than in stock error = %v, want ErrOutOfStock", err)
+		}
+		assertStock(t, repo, chair.ProductID, intPtr(1))
+		if count, err := repo.Order.GetTotalOrdersCount(ctx); err != nil || count != 1 {
+			t.Fatalf("GetTotalOrdersCount = %d, %v, want 1 after the failed order", count, err)
+		}
+
+		// An order that will not be paid gives its stock back
+		if err := repo.Order.SetOrderStatus(ctx, order.OrderID, models.OrderStatusPaymentFailed); err != nil {
+			t.Fatalf("SetOrderStatus: %v", err)
+		}
+		assertStock(t, repo, chair.ProductID, intPtr(3))
+		assertStock(t, repo, table.ProductID, nil)
+
+		// Editing the product sets the stock
+		got, err := repo.Product.GetProductByID(ctx, chair.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID: %v", err)
+		}
+		got.Stock = nil
+		if err := repo.Product.UpdateProduct(ctx, got); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+		assertStock(t, repo, chair.ProductID, nil)
+	})
+
+	t.Run("ListUserOrders", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Chair", 40, "chair.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		var placed []uuid.UUID
+		for _, user := range []string{"alice@example.com", "bob@example.com", "alice@example.com"} {
+			order := &models.Order{UserID: user, Items: []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 40}}}
+			if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+				t.Fatalf("PlaceOrder: %v", err)
+			}
+			placed = append(placed, order.OrderID)
+		}
+
+		orders, err := repo.Order.ListUserOrders(ctx, "alice@example.com")
+		if err != nil {
+			t.Fatalf("ListUserOrders: %v", err)
+		}
+		if len(orders) != 2 || orders[0].OrderID != placed[2] || orders[1].OrderID != placed[0] {
+			t.Fatalf("ListUserOrders = %+v, want alice's orders newest first", orders)
+		}
+		if orders, err := repo.Order.ListUserOrders(ctx, "carol@example.com"); err != nil || len(orders) != 0 {
+			t.Errorf("ListUserOrders of a user without orders = %+v, %v, want none", orders, err)
+		}
+	})
+
+	t.Run("Sales", func(t *testing.T) {
+		repo := newRepo(t)
+		chair := newProduct("Chair", 40, "chair.jpeg")
+		chair.Stock = intPtr(10)
+		lamp := newProduct("Lamp", 15, "lamp.jpeg")
+		lamp.Stock = intPtr(3)
+		desk := newProduct("Desk", 100, "desk.jpeg")
+		for _, product := range []*models.Product{&chair, &lamp, &desk} {
+			if err := repo.Product.CreateProduct(ctx, product); err != nil {
+				t.Fatalf("CreateProduct: %v", err)
+			}
+		}
+		from := time.Now().Add(-time.Hour)
+		placedAt := time.Now().Add(-time.Second)
+		orders := []struct {
+			order  models.Order
+			status string
+		}{
+			{models.Order{ShippingCost: 5, Tax: 8, Items: []models.OrderItem{
+				{ProductID: chair.ProductID, Quantity: 2, Cost: 80},
+				{ProductID: lamp.ProductID, Quantity: 1, Cost: 15},
+			}}, models.OrderStatusPaid},
+			{models.Order{Discount: 4, Tax: 3, PricesIncludeTax: true, Items: []models.OrderItem{
+				{ProductID: chair.ProductID, Quantity: 1, Cost: 40},
+			}}, models.OrderStatusOrdered},
+			// Not sales
+			{models.Order{Items: []models.OrderItem{{ProductID: lamp.ProductID, Quantity: 2, Cost: 30}}}, models.OrderStatusPendingPayment},
+			{models.Order{Items: []models.OrderItem{{ProductID: desk.ProductID, Quantity: 1, Cost: 100}}}, models.OrderStatusPaymentFailed},
+		}
+		for _, o := range orders {
+			o.order.UserID = "someone@example.com"
+			o.order.OrderStatus = models.OrderStatusPendingPayment
+			if err := repo.Order.PlaceOrder(ctx, &o.order); err != nil {
+				t.Fatalf("PlaceOrder: %v", err)
+			}
+			if o.status == o.order.OrderStatus {
+				continue
+			}
+			if err := repo.Order.SetOrderStatus(ctx, o.order.OrderID, o.status); err != nil {
+				t.Fatalf("SetOrderStatus: %v", err)
+			}
+		}
+		to := time.Now().Add(time.Hour)
+
+		summary, err := repo.Order.SalesSummary(ctx, from, to)
+		if err != nil {
+			t.Fatalf("SalesSummary: %v", err)
+		}
+		if summary.Orders != 2 {
+			t.Errorf("Orders = %d, want 2", summary.Orders)
+		}
+		assertFloat(t, "Revenue", summary.Revenue, 108+36)
+		assertFloat(t, "AverageOrderValue", summary.AverageOrderValue(), 72)
+		if summary, err := repo.Order.SalesSummary(ctx, to, to.Add(time.Hour)); err != nil || summary.Orders != 0 || summary.Revenue != 0 {
+			t.Errorf("SalesSummary of a period without orders = %+v, %v, want none", summary, err)
+		}
+		// Orders keep the time they were placed at, not only their day
+		if summary, err := repo.Order.SalesSummary(ctx, placedAt, to); err != nil || summary.Orders != 2 {
+			t.Errorf("SalesSummary since the orders were placed = %+v, %v, want both", summary, err)
+		}
+		if summary, err := repo.Order.SalesSummary(ctx, from, placedAt); err != nil || summary.Orders != 0 {
+			t.Errorf("SalesSummary until the orders were placed = %+v, %v, want none", summary, err)
+		}
+
+		days, err := repo.Order.DailySales(ctx, to.AddDate(0, 0, -2), to, time.UTC)
+		if err != nil {
+			t.Fatalf("DailySales: %v", err)
+		}
+		if len(days) != 3 {
+			t.Fatalf("DailySales = %+v, want 3 days", days)
+		}
+		var total models.SalesSummary
+		for i, day := range days {
+			if day.Day.Hour() != 0 || (i > 0 && !day.Day.Equal(days[i-1].Day.AddDate(0, 0, 1))) {
+				t.Errorf("Day = %v, want consecutive midnights", day.Day)
+			}
+			total.Orders += day.Orders
+			total.Revenue += day.Revenue
+		}
+		if total.Orders != 2 || days[0].Orders != 0 {
+			t.Errorf("DailySales = %+v, want the 2 orders on the last days", days)
+		}
+		assertFloat(t, "daily Revenue", total.Revenue, 144)
+
+		top, err := repo.Order.TopProducts(ctx, from, to, 5)
+		if err != nil {
+			t.Fatalf("TopProducts: %v", err)
+		}
+		if len(top) != 2 || top[0].ProductID != chair.ProductID || top[0].ProductName != "Chair" || top[0].Quantity != 3 ||
+			top[1].ProductID != lamp.ProductID || top[1].Quantity != 1 {
+			t.Fatalf("TopProducts = %+v, want 3 chairs and a lamp", top)
+		}
+		assertFloat(t, "chair Revenue", top[0].Revenue, 120)
+		if top, err := repo.Order.TopProducts(ctx, from, to, 1); err != nil || len(top) != 1 {
+			t.Errorf("TopProducts limited to 1 = %+v, %v", top, err)
+		}
+
+		alerts, err := repo.Order.LowStock(ctx, 7, from, to, 5)
+		if err != nil {
+			t.Fatalf("LowStock: %v", err)
+		}
+		want := []models.StockAlert{
+			{ProductID: lamp.ProductID, ProductName: "Lamp", Stock: 0, Sold: 1},
+			{ProductID: chair.ProductID, ProductName: "Chair", Stock: 7, Sold: 3},
+		}
+		if !slices.Equal(alerts, want) {
+			t.Fatalf("LowStock = %+v, want %+v", alerts, want)
+		}
+		if alerts, err := repo.Order.LowStock(ctx, 6, from, to, 5); err != nil || len(alerts) != 1 {
+			t.Errorf("LowStock below the chairs = %+v, %v, want the lamp", alerts, err)
+		}
+	})
+
+	t.Run("GetMissing", func(t *testing.T) {
+		repo := newRepo(t)
+		_, err := repo.Order.GetOrderWithProducts(ctx, uuid.New())
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("GetOrderWithProducts error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func RunAuditStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("RecordAndList", func(t *testing.T) {
+		store := newRepo(t).Audit
+		start := time.Now().Add(-time.Minute)
+		events := []models.AuditEvent{
+			{Actor: "alice", Action: "create", EntityType: "product", EntityID: "p1", After: `{"Price":10}`, IP: "10.0.0.1", OccurredAt: start},
+			{Actor: "bob", Action: "update", EntityType: "product", EntityID: "p1", Before: `{"Price":10}`, After: `{"Price":12}`, IP: "10.0.0.2", OccurredAt: start.Add(time.Second)},
+			{Actor: "alice", Action: "archive", EntityType: "product", EntityID: "p2", IP: "10.0.0.1", OccurredAt: start.Add(2 * time.Second)},
+		}
+		for i := range events {
+			if err := store.RecordEvent(ctx, &events[i]); err != nil {
+				t.Fatalf("RecordEvent: %v", err)
+			}
+		}
+
+		all, err := store.ListEvents(ctx, repository.AuditFilter{}, 10, 0)
+		if err != nil {
+			t.Fatalf("ListEvents: %v", err)
+		}
+		if len(all) != 3 {
+			t.Fatalf("ListEvents returned %d events, want 3", len(all))
+		}
+		if all[0].Action != "archive" || all[2].Action != "create" {
+			t.Errorf("events are not ordered newest first: %s, %s, %s", all[0].Action, all[1].Action, all[2].Action)
+		}
+		update := all[1]
+		if update.Actor != "bob" || update.IP != "10.0.0.2" || update.EntityID != "p1" {
+			t.Errorf("update event = %+v", update)
+		}
+		if changes := update.Changes(); len(changes) != 1 || changes[0].Before != "10" || changes[0].After != "12" {
+			t.Errorf("update changes = %+v, want Price 10 -> 12", changes)
+		}
+
+		for _, tc := range []struct {
+			filter repository.AuditFilter
+			want   int
+		}{
+			{repository.AuditFilter{Actor: "alice"}, 2},
+			{repository.AuditFilter{Action: "update"}, 1},
+			{repository.AuditFilter{EntityType: "product", EntityID: "p1"}, 2},
+			{repository.AuditFilter{Actor: "carol"}, 0},
+		} {
+			count, err := store.CountEvents(ctx, tc.filter)
+			if err != nil {
+				t.Fatalf("CountEvents: %v", err)
+			}
+			if count != tc.want {
+				t.Errorf("CountEvents(%+v) = %d, want %d", tc.filter, count, tc.want)
+			}
+			events, err := store.ListEvents(ctx, tc.filter, 10, 0)
+			if err != nil {
+				t.Fatalf("ListEvents: %v", err)
+			}
+			if len(events) != tc.want {
+				t.Errorf("ListEvents(%+v) returned %d events, want %d", tc.filter, len(events), tc.want)
+			}
+		}
+	})
+}
+
+func RunPriceStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("HistoryOnUpdate", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Lamp", 20, "lamp.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+
+		// Only a changed price is added to the history
+		product.Description = "A brighter lamp"
+		if err := repo.Product.UpdateProduct(ctx, &product); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+		product.Price = 25
+		if err := repo.Product.UpdateProduct(ctx, &product); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+
+		prices, err := repo.Price.ListPrices(ctx, product.ProductID)
+		if err != nil {
+			t.Fatalf("ListPrices: %v", err)
+		}
+		if len(prices) != 2 {
+			t.Fatalf("ListPrices returned %d prices, want 2", len(prices))
+		}
+		assertFloat(t, "first price", prices[0].Price, 20)
+		assertFloat(t, "second price", prices[1].Price, 25)
+		for _, p := range prices {
+			if p.Scheduled() {
+				t.Errorf("price %v is scheduled, want applied", p.Price)
+			}
+		}
+	})
+
+	t.Run("ScheduleAndApply", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Desk", 100, "desk.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+
+		now := time.Now()
+		soon := models.ProductPrice{ProductID: product.ProductID, Price: 90, EffectiveAt: now.Add(time.Hour)}
+		later := models.ProductPrice{ProductID: product.ProductID, Price: 80, EffectiveAt: now.Add(2 * time.Hour)}
+		for _, p := range []*models.ProductPrice{&soon, &later} {
+			if err := repo.Price.SchedulePrice(ctx, p); err != nil {
+				t.Fatalf("SchedulePrice: %v", err)
+			}
+		}
+
+		applied, err := repo.Price.ApplyDuePrices(ctx, now)
+		if err != nil {
+			t.Fatalf("ApplyDuePrices: %v", err)
+		}
+		if len(applied) != 0 {
+			t.Fatalf("ApplyDuePrices applied %d prices before they were due", len(applied))
+		}
+
+		applied, err = repo.Price.ApplyDuePrices(ctx, now.Add(90*time.Minute))
+		if err != nil {
+			t.Fatalf("ApplyDuePrices: %v", err)
+		}
+		if len(applied) != 1 {
+			t.Fatalf("ApplyDuePrices applied %d prices, want 1", len(applied))
+		}
+		got, err := repo.Product.GetProductByID(ctx, product.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID: %v", err)
+		}
+		assertFloat(t, "Price", got.Price, 90)
+		if got.Version != product.Version+1 {
+			t.Errorf("Version = %d, want %d", got.Version, product.Version+1)
+		}
+
+		prices, err := repo.Price.ListPrices(ctx, product.ProductID)
+		if err != nil {
+			t.Fatalf("ListPrices: %v", err)
+		}
+		if len(prices) != 3 || prices[1].Scheduled() || !prices[2].Scheduled() {
+			t.Fatalf("prices = %+v, want two applied and one scheduled", prices)
+		}
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Chair", 50, "chair.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		price := models.ProductPrice{ProductID: product.ProductID, Price: 40, EffectiveAt: time.Now().Add(time.Hour)}
+		if err := repo.Price.SchedulePrice(ctx, &price); err != nil {
+			t.Fatalf("SchedulePrice: %v", err)
+		}
+
+		if err := repo.Price.CancelScheduledPrice(ctx, product.ProductID, price.ID); err != nil {
+			t.Fatalf("CancelScheduledPrice: %v", err)
+		}
+		err := repo.Price.CancelScheduledPrice(ctx, product.ProductID, price.ID)
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("second CancelScheduledPrice error = %v, want ErrNotFound", err)
+		}
+		applied, err := repo.Price.ApplyDuePrices(ctx, time.Now().Add(2*time.Hour))
+		if err != nil {
+			t.Fatalf("ApplyDuePrices: %v", err)
+		}
+		if len(applied) != 0 {
+			t.Fatalf("ApplyDuePrices applied a cancelled price")
+		}
+	})
+
+	t.Run("ScheduleMissingProduct", func(t *testing.T) {
+		repo := newRepo(t)
+		price := models.ProductPrice{ProductID: uuid.New(), Price: 1, EffectiveAt: time.Now().Add(time.Hour)}
+		err := repo.Price.SchedulePrice(ctx, &price)
+		if !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("SchedulePrice error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func RunCouponStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("CreateAndGet", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Lamp", 20, "lamp.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		ends := time.Now().Add(24 * time.Hour)
+		coupon := models.Coupon{
+			Code:               " spring10 ",
+			DiscountType:       models.DiscountPercent,
+			Amount:             10,
+			MinCartValue:       15,
+			ProductIDs:         []uuid.UUID{product.ProductID},
+			Categories:         []string{"Lighting", "Desks"},
+			EndsAt:             &ends,
+			MaxUses:            100,
+			MaxUsesPerCustomer: 1,
+			Active:             true,
+		}
+		if err := repo.Coupon.CreateCoupon(ctx, &coupon); err != nil {
+			t.Fatalf("CreateCoupon: %v", err)
+		}
+
+		got, err := repo.Coupon.GetCouponByCode(ctx, "Spring10")
+		if err != nil {
+			t.Fatalf("GetCouponByCode: %v", err)
+		}
+		if got.CouponID != coupon.CouponID || got.Code != "SPRING10" || got.DiscountType != models.DiscountPercent {
+			t.Errorf("coupon = %+v", got)
+		}
+		assertFloat(t, "Amount", got.Amount, 10)
+		assertFloat(t, "MinCartValue", got.MinCartValue, 15)
+		if got.MaxUses != 100 || got.MaxUsesPerCustomer != 1 || !got.Active || got.EndsAt == nil || got.StartsAt != nil {
+			t.Errorf("coupon limits = %+v", got)
+		}
+		if len(got.ProductIDs) != 1 || got.ProductIDs[0] != product.ProductID {
+			t.Errorf("ProductIDs = %v, want [%s]", got.ProductIDs, product.ProductID)
+		}
+		if !slices.Equal(got.Categories, []string{"Desks", "Lighting"}) {
+			t.Errorf("Categories = %v, want [Desks Lighting]", got.Categories)
+		}
+
+		duplicate := models.Coupon{Code: "SPRING10", DiscountType: models.DiscountFixed, Amount: 5, Active: true}
+		if err := repo.Coupon.CreateCoupon(ctx, &duplicate); !errors.Is(err, repository.ErrCouponCodeTaken) {
+			t.Fatalf("CreateCoupon with a taken code error = %v, want ErrCouponCodeTaken", err)
+		}
+		if _, err := repo.Coupon.GetCouponByCode(ctx, "NOPE"); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("GetCouponByCode error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("SetActive", func(t *testing.T) {
+		repo := newRepo(t)
+		coupon := models.Coupon{Code: "FIVE", DiscountType: models.DiscountFixed, Amount: 5, Active: true}
+		if err := repo.Coupon.CreateCoupon(ctx, &coupon); err != nil {
+			t.Fatalf("CreateCoupon: %v", err)
+		}
+		if err := repo.Coupon.SetCouponActive(ctx, coupon.CouponID, false); err != nil {
+			t.Fatalf("SetCouponActive: %v", err)
+		}
+		list, err := repo.Coupon.ListCoupons(ctx)
+		if err != nil {
+			t.Fatalf("ListCoupons: %v", err)
+		}
+		if len(list) != 1 || list[0].Active {
+			t.Fatalf("ListCoupons = %+v, want one inactive coupon", list)
+		}
+	})
+
+	t.Run("RedeemWithOrder", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Desk", 100, "desk.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		coupon := models.Coupon{Code: "ONCE", DiscountType: models.DiscountFixed, Amount: 15, MaxUses: 2, MaxUsesPerCustomer: 1, Active: true}
+		if err := repo.Coupon.CreateCoupon(ctx, &coupon); err != nil {
+			t.Fatalf("CreateCoupon: %v", err)
+		}
+
+		place := func(userID string) (*models.Order, error) {
+			order := &models.Order{
+				UserID:     userID,
+				Items:      []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 100}},
+				CouponCode: "ONCE",
+				Discount:   15,
+			}
+			return order, repo.Order.PlaceOrder(ctx, order)
+		}
+
+		order, err := place("alice@example.com")
+		if err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+		got, err := repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		if got.CouponCode != "ONCE" {
+			t.Errorf("CouponCode = %q, want ONCE", got.CouponCode)
+		}
+		assertFloat(t, "Discount", got.Discount, 15)
+
+		if _, err := place("alice@example.com"); !errors.Is(err, coupons.ErrCustomerLimit) {
+			t.Fatalf("second PlaceOrder by the same customer error = %v, want ErrCustomerLimit", err)
+		}
+		if _, err := place("bob@example.com"); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+		if _, err := place("carol@example.com"); !errors.Is(err, coupons.ErrUsedUp) {
+			t.Fatalf("PlaceOrder over the limit error = %v, want ErrUsedUp", err)
+		}
+
+		count, err := repo.Order.GetTotalOrdersCount(ctx)
+		if err != nil {
+			t.Fatalf("GetTotalOrdersCount: %v", err)
+		}
+		if count != 2 {
+			t.Errorf("GetTotalOrdersCount = %d, want 2, rejected orders must not be saved", count)
+		}
+		uses, err := repo.Coupon.CountRedemptions(ctx, coupon.CouponID, "alice@example.com")
+		if err != nil {
+			t.Fatalf("CountRedemptions: %v", err)
+		}
+		if uses != 1 {
+			t.Errorf("CountRedemptions = %d, want 1", uses)
+		}
+		used, err := repo.Coupon.GetCouponByCode(ctx, "ONCE")
+		if err != nil {
+			t.Fatalf("GetCouponByCode: %v", err)
+		}
+		if used.TimesUsed != 2 {
+			t.Errorf("TimesUsed = %d, want 2", used.TimesUsed)
+		}
+	})
+
+	t.Run("ReleasedWhenPaymentFails", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Desk", 100, "desk.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		coupon := models.Coupon{Code: "ONCE", DiscountType: models.DiscountFixed, Amount: 15, MaxUses: 1, Active: true}
+		if err := repo.Coupon.CreateCoupon(ctx, &coupon); err != nil {
+			t.Fatalf("CreateCoupon: %v", err)
+		}
+		order := &models.Order{
+			UserID:      "alice@example.com",
+			OrderStatus: models.OrderStatusPendingPayment,
+			Items:       []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 100}},
+			CouponCode:  "ONCE",
+			Discount:    15,
+		}
+		if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+
+		// Failing twice must give the coupon back only once
+		for range 2 {
+			if err := repo.Order.SetOrderStatus(ctx, order.OrderID, models.OrderStatusPaymentFailed); err != nil {
+				t.Fatalf("SetOrderStatus: %v", err)
+			}
+		}
+		used, err := repo.Coupon.GetCouponByCode(ctx, "ONCE")
+		if err != nil {
+			t.Fatalf("GetCouponByCode: %v", err)
+		}
+		if used.TimesUsed != 0 {
+			t.Errorf("TimesUsed = %d, want 0", used.TimesUsed)
+		}
+		uses, err := repo.Coupon.CountRedemptions(ctx, coupon.CouponID, "alice@example.com")
+		if err != nil {
+			t.Fatalf("CountRedemptions: %v", err)
+		}
+		if uses != 0 {
+			t.Errorf("CountRedemptions = %d, want 0", uses)
+		}
+
+		retry := &models.Order{
+			UserID:     "alice@example.com",
+			Items:      []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 100}},
+			CouponCode: "ONCE",
+			Discount:   15,
+		}
+		if err := repo.Order.PlaceOrder(ctx, retry); err != nil {
+			t.Fatalf("PlaceOrder after the failed payment: %v", err)
+		}
+	})
+}
+
+func RunPromotionStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("CreateAndList", func(t *testing.T) {
+		repo := newRepo(t)
+		lamp := newProduct("Lamp", 20, "lamp.jpeg")
+		desk := newProduct("Desk", 100, "desk.jpeg")
+		for _, p := range []*models.Product{&lamp, &desk} {
+			if err := repo.Product.CreateProduct(ctx, p); err != nil {
+				t.Fatalf("CreateProduct: %v", err)
+			}
+		}
+		starts := time.Now().Add(-time.Hour)
+		bundle := models.Promotion{
+			Name:        "Desk and lamp",
+			Kind:        models.PromotionBundle,
+			ProductIDs:  []uuid.UUID{desk.ProductID, lamp.ProductID},
+			BundlePrice: 110,
+			Priority:    2,
+			StartsAt:    &starts,
+			Active:      true,
+		}
+		threshold := models.Promotion{Name: "10% over $200", Kind: models.PromotionSpendThreshold, MinSubtotal: 200, Percent: 10, Priority: 1, Stackable: true, Active: true}
+		for _, p := range []*models.Promotion{&bundle, &threshold} {
+			if err := repo.Promotion.CreatePromotion(ctx, p); err != nil {
+				t.Fatalf("CreatePromotion: %v", err)
+			}
+		}
+
+		list, err := repo.Promotion.ListPromotions(ctx)
+		if err != nil {
+			t.Fatalf("ListPromotions: %v", err)
+		}
+		if len(list) != 2 || list[0].PromotionID != threshold.PromotionID || list[1].PromotionID != bundle.PromotionID {
+			t.Fatalf("ListPromotions = %+v, want the promotions by priority", list)
+		}
+		got := list[1]
+		if got.Name != bundle.Name || got.Kind != models.PromotionBundle || got.Priority != 2 || got.Stackable || !got.Active {
+			t.Errorf("promotion = %+v", got)
+		}
+		assertFloat(t, "BundlePrice", got.BundlePrice, 110)
+		if got.StartsAt == nil || got.EndsAt != nil {
+			t.Errorf("window = %v - %v, want a start only", got.StartsAt, got.EndsAt)
+		}
+		if len(got.ProductIDs) != 2 || !got.Covers(desk.ProductID) || !got.Covers(lamp.ProductID) {
+			t.Errorf("ProductIDs = %v, want the desk and the lamp", got.ProductIDs)
+		}
+		assertFloat(t, "Percent", list[0].Percent, 10)
+		if !list[0].Stackable || len(list[0].ProductIDs) != 0 {
+			t.Errorf("promotion = %+v", list[0])
+		}
+	})
+
+	t.Run("SetActive", func(t *testing.T) {
+		repo := newRepo(t)
+		promotion := models.Promotion{Name: "3 for 2", Kind: models.PromotionBuyXGetY, BuyQuantity: 2, FreeQuantity: 1, Active: true}
+		if err := repo.Promotion.CreatePromotion(ctx, &promotion); err != nil {
+			t.Fatalf("CreatePromotion: %v", err)
+		}
+		if err := repo.Promotion.SetPromotionActive(ctx, promotion.PromotionID, false); err != nil {
+			t.Fatalf("SetPromotionActive: %v", err)
+		}
+		list, err := repo.Promotion.ListPromotions(ctx)
+		if err != nil {
+			t.Fatalf("ListPromotions: %v", err)
+		}
+		if len(list) != 1 || list[0].Active {
+			t.Fatalf("ListPromotions = %+v, want one inactive promotion", list)
+		}
+		if list[0].BuyQuantity != 2 || list[0].FreeQuantity != 1 {
+			t.Errorf("quantities = %d/%d, want 2/1", list[0].BuyQuantity, list[0].FreeQuantity)
+		}
+	})
+
+	t.Run("SavedWithOrder", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Mug", 10, "mug.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		promotion := models.Promotion{Name: "3 for 2", Kind: models.PromotionBuyXGetY, BuyQuantity: 2, FreeQuantity: 1, Active: true}
+		if err := repo.Promotion.CreatePromotion(ctx, &promotion); err != nil {
+			t.Fatalf("CreatePromotion: %v", err)
+		}
+
+		order := &models.Order{
+			UserID:     "alice@example.com",
+			Items:      []models.OrderItem{{ProductID: product.ProductID, Quantity: 3, Cost: 20, Discount: 10}},
+			Promotions: []models.AppliedPromotion{{PromotionID: promotion.PromotionID, Label: "3 for 2", Discount: 10}},
+		}
+		if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+
+		got, err := repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		if len(got.Items) != 1 {
+			t.Fatalf("order has %d items, want 1", len(got.Items))
+		}
+		assertFloat(t, "Cost", got.Items[0].Cost, 20)
+		assertFloat(t, "Discount", got.Items[0].Discount, 10)
+		if len(got.Promotions) != 1 || got.Promotions[0].PromotionID != promotion.PromotionID || got.Promotions[0].Label != "3 for 2" {
+			t.Fatalf("Promotions = %+v, want the 3 for 2", got.Promotions)
+		}
+		assertFloat(t, "promotion discount", got.Promotions[0].Discount, 10)
+		assertFloat(t, "Total", got.Total(), 20)
+	})
+}
+
+func RunTaxStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("SaveAndList", func(t *testing.T) {
+		repo := newRepo(t)
+		ny := models.TaxRate{Country: "us", State: "ny", TaxClass: models.TaxClassStandard, Name: "Sales tax", Rate: 8.875}
+		us := models.TaxRate{Country: "US", TaxClass: models.TaxClassStandard, Name: "Sales tax", Rate: 5}
+		for _, rate := range []*models.TaxRate{&ny, &us} {
+			if err := repo.Tax.SaveTaxRate(ctx, rate); err != nil {
+				t.Fatalf("SaveTaxRate: %v", err)
+			}
+		}
+
+		// Saving the same region and class again replaces the rate
+		update := models.TaxRate{Country: "US", State: "NY", TaxClass: models.TaxClassStandard, Name: "NY sales tax", Rate: 9}
+		if err := repo.Tax.SaveTaxRate(ctx, &update); err != nil {
+			t.Fatalf("SaveTaxRate: %v", err)
+		}
+		if update.TaxRateID != ny.TaxRateID {
+			t.Errorf("TaxRateID = %d, want the existing %d", update.TaxRateID, ny.TaxRateID)
+		}
+
+		rates, err := repo.Tax.ListTaxRates(ctx)
+		if err != nil {
+			t.Fatalf("ListTaxRates: %v", err)
+		}
+		if len(rates) != 2 || rates[0].TaxRateID != us.TaxRateID || rates[1].State != "NY" || rates[1].Name != "NY sales tax" {
+			t.Fatalf("ListTaxRates = %+v, want the US rate and the updated NY rate", rates)
+		}
+		assertFloat(t, "Rate", rates[1].Rate, 9)
+
+		if err := repo.Tax.DeleteTaxRate(ctx, us.TaxRateID); err != nil {
+			t.Fatalf("DeleteTaxRate: %v", err)
+		}
+		if err := repo.Tax.DeleteTaxRate(ctx, us.TaxRateID); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("DeleteTaxRate of a deleted rate error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("SavedWithOrder", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Book", 20, "book.jpeg")
+		product.TaxClass = models.TaxClassReduced
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+
+		order := &models.Order{
+			UserID: "alice@example.com",
+			Items:  []models.OrderItem{{ProductID: product.ProductID, Quantity: 2, Cost: 40, Tax: 2.8, TaxRate: 7, TaxName: "VAT"}},
+			Tax:    2.8,
+		}
+		if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+
+		got, err := repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		assertFloat(t, "Tax", got.Tax, 2.8)
+		assertFloat(t, "Total", got.Total(), 42.8)
+		if got.PricesIncludeTax {
+			t.Error("PricesIncludeTax = true, want false")
+		}
+		if len(got.Items) != 1 || got.Items[0].TaxName != "VAT" || got.Items[0].Product.TaxClass != models.TaxClassReduced {
+			t.Fatalf("Items = %+v, want the book taxed as VAT", got.Items)
+		}
+		assertFloat(t, "item Tax", got.Items[0].Tax, 2.8)
+		assertFloat(t, "item TaxRate", got.Items[0].TaxRate, 7)
+	})
+}
+
+func RunAddressStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("CreateListAndDelete", func(t *testing.T) {
+		repo := newRepo(t)
+		home := models.Address{UserID: "alice@example.com", Name: "Alice", Line1: "1 Main St", City: "Springfield", State: "IL", PostalCode: "62701", Country: "US"}
+		work := models.Address{UserID: "alice@example.com", Name: "Alice", Line1: "2 Office Rd", Line2: "Floor 3", City: "Chicago", PostalCode: "60601", Country: "US", Phone: "555-0100"}
+		other := models.Address{UserID: "bob@example.com", Name: "Bob", Line1: "3 Elm St", City: "Berlin", PostalCode: "10115", Country: "DE"}
+		for _, a := range []*models.Address{&home, &work, &other} {
+			if err := repo.Address.CreateAddress(ctx, a); err != nil {
+				t.Fatalf("CreateAddress: %v", err)
+			}
+			if a.AddressID == 0 {
+				t.Fatal("CreateAddress did not assign an ID")
+			}
+		}
+
+		list, err := repo.Address.ListAddresses(ctx, "alice@example.com")
+		if err != nil {
+			t.Fatalf("ListAddresses: %v", err)
+		}
+		if len(list) != 2 || list[0].AddressID != work.AddressID || list[1].AddressID != home.AddressID {
+			t.Fatalf("ListAddresses = %+v, want alice's addresses, newest first", list)
+		}
+		if list[0].Line2 != "Floor 3" || list[0].Phone != "555-0100" {
+			t.Errorf("address = %+v, want it as created", list[0])
+		}
+
+		got, err := repo.Address.GetAddress(ctx, "alice@example.com", home.AddressID)
+		if err != nil {
+			t.Fatalf("GetAddress: %v", err)
+		}
+		if got.Summary() != home.Summary() {
+			t.Errorf("GetAddress = %q, want %q", got.Summary(), home.Summary())
+		}
+		if _, err := repo.Address.GetAddress(ctx, "alice@example.com", other.AddressID); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("GetAddress of another customer's address error = %v, want ErrNotFound", err)
+		}
+
+		if err := repo.Address.DeleteAddress(ctx, "bob@example.com", home.AddressID); !errors.Is(err, repository.ErrNotFound) {
+			t.Fatalf("DeleteAddress of another customer's address error = %v, want ErrNotFound", err)
+		}
+		if err := repo.Address.DeleteAddress(ctx, "alice@example.com", home.AddressID); err != nil {
+			t.Fatalf("DeleteAddress: %v", err)
+		}
+		list, err = repo.Address.ListAddresses(ctx, "alice@example.com")
+		if err != nil {
+			t.Fatalf("ListAddresses: %v", err)
+		}
+		if len(list) != 1 || list[0].AddressID != work.AddressID {
+			t.Fatalf("ListAddresses after delete = %+v, want the work address", list)
+		}
+	})
+}
+
+func RunShippingStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("CreateAndList", func(t *testing.T) {
+		repo := newRepo(t)
+		freeOver := 50.0
+		express := models.ShippingMethod{Name: "Express", Kind: models.ShippingWeight, Price: 9.5, PerKg: 1.5, Active: true}
+		standard := models.ShippingMethod{Name: "Standard", Kind: models.ShippingFlat, Price: 4.99, FreeOver: &freeOver, Active: true}
+		for _, m := range []*models.ShippingMethod{&express, &standard} {
+			if err := repo.Shipping.CreateShippingMethod(ctx, m); err != nil {
+				t.Fatalf("CreateShippingMethod: %v", err)
+			}
+		}
+		if err := repo.Shipping.SetShippingMethodActive(ctx, express.ShippingMethodID, false); err != nil {
+			t.Fatalf("SetShippingMethodActive: %v", err)
+		}
+
+		list, err := repo.Shipping.ListShippingMethods(ctx)
+		if err != nil {
+			t.Fatalf("ListShippingMethods: %v", err)
+		}
+		if len(list) != 2 || list[0].Name != "Standard" || list[1].Name != "Express" {
+			t.Fatalf("ListShippingMethods = %+v, want Standard then Express", list)
+		}
+		if list[0].FreeOver == nil || list[1].FreeOver != nil {
+			t.Fatalf("FreeOver = %v, %v, want only Standard to have one", list[0].FreeOver, list[1].FreeOver)
+		}
+		assertFloat(t, "FreeOver", *list[0].FreeOver, 50)
+		assertFloat(t, "PerKg", list[1].PerKg, 1.5)
+		if list[1].Kind != models.ShippingWeight || list[1].Active {
+			t.Errorf("Express = %+v, want an inactive weight method", list[1])
+		}
+	})
+
+	t.Run("SavedWithOrder", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Lamp", 30, "lamp.jpeg")
+		product.Weight = 2
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+
+		shipping := &models.Address{AddressID: 7, UserID: "alice@example.com", Name: "Alice", Line1: "1 Main St", City: "Springfield", State: "IL", PostalCode: "62701", Country: "US"}
+		billing := &models.Address{Name: "Alice Ltd", Line1: "2 Office Rd", City: "Chicago", PostalCode: "60601", Country: "US", Phone: "555-0100"}
+		order := &models.Order{
+			UserID:          "alice@example.com",
+			Items:           []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 30}},
+			ShippingAddress: shipping,
+			BillingAddress:  billing,
+			ShippingMethod:  "Standard",
+			ShippingCost:    4.99,
+		}
+		if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+
+		got, err := repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		if got.ShippingMethod != "Standard" {
+			t.Errorf("ShippingMethod = %q, want Standard", got.ShippingMethod)
+		}
+		assertFloat(t, "ShippingCost", got.ShippingCost, 4.99)
+		assertFloat(t, "Total", got.Total(), 34.99)
+		if got.ShippingAddress == nil || got.ShippingAddress.Summary() != shipping.Summary() {
+			t.Fatalf("ShippingAddress = %+v, want %q", got.ShippingAddress, shipping.Summary())
+		}
+		if got.BillingAddress == nil || got.BillingAddress.Summary() != billing.Summary() || got.BillingAddress.Phone != "555-0100" {
+			t.Fatalf("BillingAddress = %+v, want %q", got.BillingAddress, billing.Summary())
+		}
+		if len(got.Items) != 1 {
+			t.Fatalf("order has %d items, want 1", len(got.Items))
+		}
+		assertFloat(t, "Weight", got.Items[0].Product.Weight, 2)
+	})
+}
+
+func RunPaymentStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	t.Run("CreateUpdateAndList", func(t *testing.T) {
+		repo := newRepo(t)
+		product := newProduct("Chair", 40, "chair.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		order := &models.Order{
+			UserID: "alice@example.com",
+			Items:  []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 40}},
+		}
+		if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+
+		declined := models.Payment{OrderID: order.OrderID, Provider: "fake", Amount: 40, Currency: "usd", Status: models.PaymentFailed, FailureReason: "Your card was declined."}
+		if err := repo.Payment.CreatePayment(ctx, &declined); err != nil {
+			t.Fatalf("CreatePayment: %v", err)
+		}
+		payment := models.Payment{OrderID: order.OrderID, Provider: "fake", ProviderRef: "pi_1", Amount: 40, Currency: "usd", Status: models.PaymentAuthorized}
+		if err := repo.Payment.CreatePayment(ctx, &payment); err != nil {
+			t.Fatalf("CreatePayment: %v", err)
+		}
+		if payment.PaymentID == 0 || payment.PaymentID == declined.PaymentID {
+			t.Fatalf("PaymentID = %d, want a new ID", payment.PaymentID)
+		}
+
+		payment.Status = models.PaymentCaptured
+		payment.CapturedAmount = 40
+		if err := repo.Payment.UpdatePayment(ctx, &payment); err != nil {
+			t.Fatalf("UpdatePayment: %v", err)
+		}
+
+		payments, err := repo.Payment.ListPayments(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("ListPayments: %v", err)
+		}
+		if len(payments) != 2 {
+			t.Fatalf("ListPayments returned %d payments, want 2", len(payments))
+		}
+		if got := payments[0]; got.Status != models.PaymentFailed || got.ProviderRef != "" || got.FailureReason != "Your card was declined." {
+			t.Errorf("first payment = %+v, want the declined one", got)
+		}
+		got := payments[1]
+		if got.PaymentID != payment.PaymentID || got.OrderID != order.OrderID || got.ProviderRef != "pi_1" || got.Currency != "usd" {
+			t.Errorf("second payment = %+v, want %+v", got, payment)
+		}
+		if got.Status != models.PaymentCaptured {
+			t.Errorf("Status = %q, want %q", got.Status, models.PaymentCaptured)
+		}
+		assertFloat(t, "Amount", got.Amount, 40)
+		assertFloat(t, "CapturedAmount", got.CapturedAmount, 40)
+
+		others, err := repo.Payment.ListPayments(ctx, uuid.New())
+		if err != nil {
+			t.Fatalf("ListPayments: %v", err)
+		}
+		if len(others) != 0 {
+			t.Errorf("ListPayments of another order returned %d payments, want 0", len(others))
+		}
+
+		missing := models.Payment{PaymentID: 999, Status: models.PaymentCaptured}
+		if err := repo.Payment.UpdatePayment(ctx, &missing); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("UpdatePayment of a missing payment error = %v, want ErrNotFound", err)
+		}
+		if err := repo.Payment.RecordRefund(ctx, &missing); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RecordRefund of a missing payment error = %v, want ErrNotFound", err)
+		}
+
+		// Only what the payment refunded since last time adds to the order
+		payment.RefundedAmount = 15
+		for range 2 {
+			if err := repo.Payment.RecordRefund(ctx, &payment); err != nil {
+				t.Fatalf("RecordRefund: %v", err)
+			}
+		}
+		refunded, err := repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		assertFloat(t, "order RefundedAmount", refunded.RefundedAmount, 15)
+		payment.RefundedAmount = 40
+		if err := repo.Payment.RecordRefund(ctx, &payment); err != nil {
+			t.Fatalf("RecordRefund: %v", err)
+		}
+		if refunded, err = repo.Order.GetOrderWithProducts(ctx, order.OrderID); err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		assertFloat(t, "order RefundedAmount", refunded.RefundedAmount, 40)
+
+		byRef, err := repo.Payment.GetPaymentByRef(ctx, "fake", "pi_1")
+		if err != nil {
+			t.Fatalf("GetPaymentByRef: %v", err)
+		}
+		if byRef.PaymentID != payment.PaymentID || byRef.Status != models.PaymentCaptured {
+			t.Errorf("GetPaymentByRef = %+v, want %+v", byRef, payment)
+		}
+		if _, err := repo.Payment.GetPaymentByRef(ctx, "stripe", "pi_1"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetPaymentByRef of another provider error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Events", func(t *testing.T) {
+		repo := newRepo(t)
+		event := models.PaymentEvent{
+			Provider:        "fake",
+			ProviderEventID: "evt_1",
+			EventType:       "captured",
+			Payload:         []byte(`{"id":"evt_1"}`),
+			Status:          models.PaymentEventReceived,
+		}
+		if err := repo.Payment.RecordPaymentEvent(ctx, &event); err != nil {
+			t.Fatalf("RecordPaymentEvent: %v", err)
+		}
+		if event.PaymentEventID == 0 {
+			t.Fatal("RecordPaymentEvent did not set PaymentEventID")
+		}
+		again := event
+		if err := repo.Payment.RecordPaymentEvent(ctx, &again); !errors.Is(err, repository.ErrDuplicateEvent) {
+			t.Fatalf("RecordPaymentEvent of the same event error = %v, want ErrDuplicateEvent", err)
+		}
+		other := models.PaymentEvent{Provider: "stripe", ProviderEventID: "evt_1", EventType: "charge.refunded", Payload: []byte("{}"), Status: models.PaymentEventReceived}
+		if err := repo.Payment.RecordPaymentEvent(ctx, &other); err != nil {
+			t.Fatalf("RecordPaymentEvent of another provider's event: %v", err)
+		}
+
+		event.Status = models.PaymentEventFailed
+		event.Error = "no payment pi_1"
+		event.Attempts = 1
+		if err := repo.Payment.UpdatePaymentEvent(ctx, &event); err != nil {
+			t.Fatalf("UpdatePaymentEvent: %v", err)
+		}
+		failed, err := repo.Payment.ListPaymentEvents(ctx, models.PaymentEventFailed)
+		if err != nil {
+			t.Fatalf("ListPaymentEvents: %v", err)
+		}
+		if len(failed) != 1 || failed[0].PaymentEventID != event.PaymentEventID || failed[0].Error != "no payment pi_1" || failed[0].Attempts != 1 {
+			t.Fatalf("failed events = %+v, want the updated event", failed)
+		}
+		if string(failed[0].Payload) != `{"id":"evt_1"}` || failed[0].ProcessedAt != nil {
+			t.Errorf("event = %+v, want the payload and no ProcessedAt", failed[0])
+		}
+
+		processedAt := time.Now()
+		event.Status = models.PaymentEventProcessed
+		event.Error = ""
+		event.Attempts = 2
+		event.ProcessedAt = &processedAt
+		if err := repo.Payment.UpdatePaymentEvent(ctx, &event); err != nil {
+			t.Fatalf("UpdatePaymentEvent: %v", err)
+		}
+		got, err := repo.Payment.GetPaymentEvent(ctx, "fake", "evt_1")
+		if err != nil {
+			t.Fatalf("GetPaymentEvent: %v", err)
+		}
+		if got.Status != models.PaymentEventProcessed || got.Error != "" || got.Attempts != 2 || got.ProcessedAt == nil || got.EventType != "captured" {
+			t.Errorf("GetPaymentEvent = %+v, want the processed event", got)
+		}
+		if _, err := repo.Payment.GetPaymentEvent(ctx, "fake", "evt_2"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetPaymentEvent of a missing event error = %v, want ErrNotFound", err)
+		}
+		failed, err = repo.Payment.ListPaymentEvents(ctx, models.PaymentEventFailed)
+		if err != nil {
+			t.Fatalf("ListPaymentEvents: %v", err)
+		}
+		if len(failed) != 0 {
+			t.Errorf("failed events = %+v, want none", failed)
+		}
+	})
+}
+
+func RunReturnStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+
+	// placeOrder places a paid order of 3 chairs, 2 of them in stock after
+	// the order, and a table.
+	placeOrder := func(t *testing.T, repo *repository.Repository) (*models.Order, models.Product, models.Product) {
+		t.Helper()
+		chair := newProduct("Chair", 40, "chair.jpeg")
+		chair.Stock = intPtr(5)
+		table := newProduct("Table", 100, "table.jpeg")
+		for _, p := range []*models.Product{&chair, &table} {
+			if err := repo.Product.CreateProduct(ctx, p); err != nil {
+				t.Fatalf("CreateProduct: %v", err)
+			}
+		}
+		order := &models.Order{
+			UserID:      "alice@example.com",
+			OrderStatus: models.OrderStatusPaid,
+			Items: []models.OrderItem{
+				{ProductID: chair.ProductID, Quantity: 3, Cost: 120},
+				{ProductID: table.ProductID, Quantity: 1, Cost: 100},
+			},
+		}
+		if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+		return order, chair, table
+	}
+
+	t.Run("RequestReceiveAndRefund", func(t *testing.T) {
+		repo := newRepo(t)
+		order, chair, table := placeOrder(t, repo)
+		assertStock(t, repo, chair.ProductID, intPtr(2))
+
+		ret := &models.Return{
+			OrderID: order.OrderID,
+			UserID:  order.UserID,
+			Reason:  "Too wobbly",
+			Items: []models.ReturnItem{
+				{ProductID: chair.ProductID, Quantity: 2, Amount: 80},
+				{ProductID: table.ProductID, Quantity: 1, Amount: 100},
+			},
+		}
+		if err := repo.Return.CreateReturn(ctx, ret); err != nil {
+			t.Fatalf("CreateReturn: %v", err)
+		}
+		if ret.ReturnID == 0 || ret.Status != models.ReturnRequested {
+			t.Fatalf("CreateReturn left ID %d and status %q, want an ID and %q", ret.ReturnID, ret.Status, models.ReturnRequested)
+		}
+
+		// Only one chair is left to return
+		more := &models.Return{OrderID: order.OrderID, UserID: order.UserID, Items: []models.ReturnItem{{ProductID: chair.ProductID, Quantity: 2}}}
+		if err := repo.Return.CreateReturn(ctx, more); !errors.Is(err, repository.ErrReturnQuantity) {
+			t.Errorf("CreateReturn of more than ordered error = %v, want ErrReturnQuantity", err)
+		}
+		other := &models.Return{OrderID: order.OrderID, UserID: order.UserID, Items: []models.ReturnItem{{ProductID: uuid.New(), Quantity: 1}}}
+		if err := repo.Return.CreateReturn(ctx, other); !errors.Is(err, repository.ErrReturnQuantity) {
+			t.Errorf("CreateReturn of a product not ordered error = %v, want ErrReturnQuantity", err)
+		}
+
+		if err := repo.Return.ReceiveReturn(ctx, ret.ReturnID); !errors.Is(err, repository.ErrReturnStatus) {
+			t.Errorf("ReceiveReturn of a requested return error = %v, want ErrReturnStatus", err)
+		}
+		if err := repo.Return.SetReturnStatus(ctx, ret.ReturnID, models.ReturnApproved, "Send them back"); err != nil {
+			t.Fatalf("SetReturnStatus: %v", err)
+		}
+		if err := repo.Return.SetReturnStatus(ctx, ret.ReturnID, models.ReturnRejected, ""); !errors.Is(err, repository.ErrReturnStatus) {
+			t.Errorf("rejecting an approved return error = %v, want ErrReturnStatus", err)
+		}
+		if err := repo.Return.ReceiveReturn(ctx, ret.ReturnID); err != nil {
+			t.Fatalf("ReceiveReturn: %v", err)
+		}
+		assertStock(t, repo, chair.ProductID, intPtr(4))
+		assertStock(t, repo, table.ProductID, nil)
+
+		if err := repo.Return.RefundReturn(ctx, ret.ReturnID, 150, "re_1"); !errors.Is(err, repository.ErrReturnStatus) {
+			t.Errorf("RefundReturn of an unclaimed return error = %v, want ErrReturnStatus", err)
+		}
+		// A failed refund gives the return back to be refunded again
+		if err := repo.Return.ClaimRefund(ctx, ret.ReturnID); err != nil {
+			t.Fatalf("ClaimRefund: %v", err)
+		}
+		if err := repo.Return.ClaimRefund(ctx, ret.ReturnID); !errors.Is(err, repository.ErrReturnStatus) {
+			t.Errorf("claiming a refunding return error = %v, want ErrReturnStatus", err)
+		}
+		if err := repo.Return.ReleaseRefund(ctx, ret.ReturnID); err != nil {
+			t.Fatalf("ReleaseRefund: %v", err)
+		}
+		if err := repo.Return.ClaimRefund(ctx, ret.ReturnID); err != nil {
+			t.Fatalf("ClaimRefund after a release: %v", err)
+		}
+		if err := repo.Return.RefundReturn(ctx, ret.ReturnID, 150, "re_1"); err != nil {
+			t.Fatalf("RefundReturn: %v", err)
+		}
+		if err := repo.Return.RefundReturn(ctx, ret.ReturnID, 150, "re_2"); !errors.Is(err, repository.ErrReturnStatus) {
+			t.Errorf("refunding twice error = %v, want ErrReturnStatus", err)
+		}
+		if err := repo.Return.ClaimRefund(ctx, ret.ReturnID); !errors.Is(err, repository.ErrReturnStatus) {
+			t.Errorf("claiming a refunded return error = %v, want ErrReturnStatus", err)
+		}
+
+		got, err := repo.Return.GetReturn(ctx, ret.ReturnID)
+		if err != nil {
+			t.Fatalf("GetReturn: %v", err)
+		}
+		if got.Status != models.ReturnRefunded || got.RefundRef != "re_1" || got.Reason != "Too wobbly" || got.Note != "Send them back" {
+			t.Errorf("GetReturn = %+v, want the refunded return", got)
+		}
+		if got.OrderID != order.OrderID || got.UserID != order.UserID {
+			t.Errorf("GetReturn order and user = %s, %s, want %s, %s", got.OrderID, got.UserID, order.OrderID, order.UserID)
+		}
+		assertFloat(t, "RefundAmount", got.RefundAmount, 150)
+		assertFloat(t, "ItemsAmount", got.ItemsAmount(), 180)
+		if len(got.Items) != 2 || got.Items[0].ProductName != "Chair" || got.Items[0].Quantity != 2 || got.Items[1].ProductName != "Table" {
+			t.Errorf("Items = %+v, want 2 chairs and a table", got.Items)
+		}
+
+		placed, err := repo.Order.GetOrderWithProducts(ctx, order.OrderID)
+		if err != nil {
+			t.Fatalf("GetOrderWithProducts: %v", err)
+		}
+		assertFloat(t, "RefundedAmount", placed.RefundedAmount, 150)
+
+		if _, err := repo.Return.GetReturn(ctx, 999); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetReturn of a missing return error = %v, want ErrNotFound", err)
+		}
+		if err := repo.Return.SetReturnStatus(ctx, 999, models.ReturnApproved, ""); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("SetReturnStatus of a missing return error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("RejectAndList", func(t *testing.T) {
+		repo := newRepo(t)
+		order, chair, _ := placeOrder(t, repo)
+
+		first := &models.Return{OrderID: order.OrderID, UserID: order.UserID, Items: []models.ReturnItem{{ProductID: chair.ProductID, Quantity: 3, Amount: 120}}}
+		if err := repo.Return.CreateReturn(ctx, first); err != nil {
+			t.Fatalf("CreateReturn: %v", err)
+		}
+		if err := repo.Return.SetReturnStatus(ctx, first.ReturnID, models.ReturnRejected, "Used"); err != nil {
+			t.Fatalf("SetReturnStatus: %v", err)
+		}
+		if err := repo.Return.SetReturnStatus(ctx, first.ReturnID, models.ReturnReceived, ""); !errors.Is(err, repository.ErrReturnStatus) {
+			t.Errorf("SetReturnStatus to received error = %v, want ErrReturnStatus", err)
+		}
+
+		// The rejected chairs can be returned again
+		second := &models.Return{OrderID: order.OrderID, UserID: order.UserID, Items: []models.ReturnItem{{ProductID: chair.ProductID, Quantity: 1, Amount: 40}}}
+		if err := repo.Return.CreateReturn(ctx, second); err != nil {
+			t.Fatalf("CreateReturn after a rejection: %v", err)
+		}
+
+		returns, err := repo.Return.ListReturns(ctx, repository.ReturnFilter{UserID: order.UserID})
+		if err != nil {
+			t.Fatalf("ListReturns: %v", err)
+		}
+		if len(returns) != 2 || returns[0].ReturnID != second.ReturnID || returns[1].ReturnID != first.ReturnID {
+			t.Fatalf("ListReturns = %+v, want both returns newest first", returns)
+		}
+		if returns[1].Status != models.ReturnRejected || returns[1].Note != "Used" || len(returns[1].Items) != 1 {
+			t.Errorf("rejected return = %+v, want the note and its item", returns[1])
+		}
+
+		for _, filter := range []repository.ReturnFilter{
+			{Status: models.ReturnRequested},
+			{OrderID: order.OrderID, Status: models.ReturnRequested},
+		} {
+			returns, err := repo.Return.ListReturns(ctx, filter)
+			if err != nil {
+				t.Fatalf("ListReturns: %v", err)
+			}
+			if len(returns) != 1 || returns[0].ReturnID != second.ReturnID {
+				t.Errorf("ListReturns(%+v) = %+v, want the requested return", filter, returns)
+			}
+		}
+		for _, filter := range []repository.ReturnFilter{{UserID: "bob@example.com"}, {OrderID: uuid.New()}} {
+			returns, err := repo.Return.ListReturns(ctx, filter)
+			if err != nil {
+				t.Fatalf("ListReturns: %v", err)
+			}
+			if len(returns) != 0 {
+				t.Errorf("ListReturns(%+v) = %+v, want none", filter, returns)
+			}
+		}
+	})
+}
+
+func intPtr(n int) *int {
+	return &n
+}
+
+func assertStock(t *testing.T, repo *repository.Repository, productID uuid.UUID, want *int) {
+	t.Helper()
+	product, err := repo.Product.GetProductByID(context.Background(), productID)
+	if err != nil {
+		t.Fatalf("GetProductByID: %v", err)
+	}
+	switch {
+	case want == nil && product.Stock != nil:
+		t.Errorf("%s stock = %d, want untracked", product.ProductName, *product.Stock)
+	case want != nil && product.Stock == nil:
+		t.Errorf("%s stock is untracked, want %d", product.ProductName, *want)
+	case want != nil && *product.Stock != *want:
+		t.Errorf("%s stock = %d, want %d", product.ProductName, *product.Stock, *want)
+	}
+}
+
+func assertCount(t *testing.T, store repository.ProductStore, filter repository.ProductFilter, want int) {
+	t.Helper()
+	count, err := store.GetTotalProductsCount(context.Background(), filter)
+	if err != nil {
+		t.Fatalf("GetTotalProductsCount: %v", err)
+	}
+	if count != want {
+		t.Fatalf("GetTotalProductsCount(%+v) = %d, want %d", filter, count, want)
+	}
+	products, err := store.ListProducts(context.Background(), filter, 10, 0)
+	if err != nil {
+		t.Fatalf("ListProducts: %v", err)
+	}
+	if len(products) != want {
+		t.Fatalf("ListProducts(%+v) returned %d products, want %d", filter, len(products), want)
+	}
+}
+
+func assertListed(t *testing.T, store repository.ProductStore, at time.Time, want ...models.Product) {
+	t.Helper()
+	products, err := store.GetProducts(context.Background(), repository.ProductFilter{ListedAt: at})
+	if err != nil {
+		t.Fatalf("GetProducts: %v", err)
+	}
+	got := map[string]bool{}
+	for _, p := range products {
+		got[p.ProductName] = true
+	}
+	for _, p := range want {
+		if !got[p.ProductName] {
+			t.Errorf("listed at %v: missing %s", at, p.ProductName)
+		}
+	}
+	if len(products) != len(want) {
+		t.Errorf("listed at %v: got %d products %v, want %d", at, len(products), got, len(want))
+	}
+}
+
+func newProduct(name string, price float64, image string) models.Product {
+	return models.Product{
+		ProductName:  name,
+		Price:        price,
+		Description:  name + " description",
+		ProductImage: image,
+	}
+}
+
+func assertProduct(t *testing.T, got *models.Product, want models.Product) {
+	t.Helper()
+	if got.ProductID != want.ProductID {
+		t.Errorf("ProductID = %s, want %s", got.ProductID, want.ProductID)
+	}
+	if got.ProductName != want.ProductName {
+		t.Errorf("ProductName = %q, want %q", got.ProductName, want.ProductName)
+	}
+	assertFloat(t, "Price", got.Price, want.Price)
+	if got.Description != want.Description {
+		t.Errorf("Description = %q, want %q", got.Description, want.Description)
+	}
+	if got.ProductImage != want.ProductImage {
+		t.Errorf("ProductImage = %q, want %q", got.ProductImage, want.ProductImage)
+	}
+	if got.TaxClass != want.TaxClass {
+		t.Errorf("TaxClass = %q, want %q", got.TaxClass, want.TaxClass)
+	}
+	if got.Category != want.Category {
+		t.Errorf("Category = %q, want %q", got.Category, want.Category)
+	}
+	assertFloat(t, "Weight", got.Weight, want.Weight)
+}
+
+// assertFloat compares with a tolerance because the MySQL schema stores
+// prices as FLOAT.
+func assertFloat(t *testing.T, name string, got, want float64) {
+	t.Helper()
+	if math.Abs(got-want) > 0.001 {
+		t.Errorf("%s = %v, want %v", name, got, want)
+	}
+}
+
+func RunJobStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+	now := time.Now()
+	enqueue := func(t *testing.T, store repository.JobStore, kind string, runAt time.Time) models.Job {
+		t.Helper()
+		job := models.Job{Kind: kind, Payload: []byte(`{"to":"alice@example.com"}`), MaxAttempts: 3, RunAt: runAt}
+		if err := store.EnqueueJob(ctx, &job); err != nil {
+			t.Fatalf("EnqueueJob: %v", err)
+		}
+		if job.JobID == 0 || job.Status != models.JobQueued {
+			t.Fatalf("EnqueueJob left job = %+v, want a queued job with an ID", job)
+		}
+		return job
+	}
+	claim := func(t *testing.T, store repository.JobStore, at time.Time) *models.Job {
+		t.Helper()
+		job, err := store.ClaimJob(ctx, at, at.Add(time.Minute))
+		if err != nil {
+			t.Fatalf("ClaimJob: %v", err)
+		}
+		return job
+	}
+
+	t.Run("ClaimDueJobs", func(t *testing.T) {
+		store := newRepo(t).Job
+		later := enqueue(t, store, "email.send", now.Add(-time.Minute))
+		first := enqueue(t, store, "email.send", now.Add(-2*time.Minute))
+		scheduled := enqueue(t, store, "prices.apply_due", now.Add(time.Hour))
+
+		got := claim(t, store, now)
+		if got.JobID != first.JobID || got.Status != models.JobRunning || got.Attempts != 1 || got.LockedUntil == nil {
+			t.Fatalf("first claim = %+v, want job %d running with one attempt", got, first.JobID)
+		}
+		if got.Kind != "email.send" || string(got.Payload) != `{"to":"alice@example.com"}` || got.MaxAttempts != 3 {
+			t.Errorf("claimed job = %+v, want it as enqueued", got)
+		}
+		second := claim(t, store, now)
+		if second.JobID != later.JobID {
+			t.Errorf("second claim = job %d, want %d", second.JobID, later.JobID)
+		}
+		if _, err := store.ClaimJob(ctx, now, now.Add(time.Minute)); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ClaimJob with nothing due error = %v, want ErrNotFound", err)
+		}
+		for _, job := range []*models.Job{got, second} {
+			if err := store.CompleteJob(ctx, job); err != nil {
+				t.Fatalf("CompleteJob: %v", err)
+			}
+		}
+		if got := claim(t, store, now.Add(2*time.Hour)); got.JobID != scheduled.JobID {
+			t.Errorf("claim in two hours = job %d, want the scheduled job %d", got.JobID, scheduled.JobID)
+		}
+	})
+
+	t.Run("RetryCompleteAndBury", func(t *testing.T) {
+		store := newRepo(t).Job
+		job := enqueue(t, store, "email.send", now.Add(-time.Minute))
+
+		first := claim(t, store, now)
+		if err := store.RetryJob(ctx, first, now.Add(time.Minute), "connection refused"); err != nil {
+			t.Fatalf("RetryJob: %v", err)
+		}
+		if _, err := store.ClaimJob(ctx, now, now.Add(time.Minute)); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ClaimJob before the retry is due error = %v, want ErrNotFound", err)
+		}
+		second := claim(t, store, now.Add(2*time.Minute))
+		if second.JobID != job.JobID || second.Attempts != 2 || second.LastError != "connection refused" {
+			t.Fatalf("retried job = %+v, want the second attempt of job %d", second, job.JobID)
+		}
+		if err := store.CompleteJob(ctx, first); !errors.Is(err, repository.ErrJobLost) {
+			t.Errorf("CompleteJob of an earlier claim error = %v, want ErrJobLost", err)
+		}
+		if err := store.CompleteJob(ctx, second); err != nil {
+			t.Fatalf("CompleteJob: %v", err)
+		}
+		got, err := store.GetJob(ctx, job.JobID)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if got.Status != models.JobSucceeded || got.LockedUntil != nil {
+			t.Errorf("completed job = %+v, want it succeeded without a lock", got)
+		}
+		if err := store.BuryJob(ctx, second, "too late"); !errors.Is(err, repository.ErrJobLost) {
+			t.Errorf("BuryJob of a completed job error = %v, want ErrJobLost", err)
+		}
+
+		dead := enqueue(t, store, "email.send", now.Add(-time.Minute))
+		if err := store.BuryJob(ctx, claim(t, store, now), "mailbox unavailable"); err != nil {
+			t.Fatalf("BuryJob: %v", err)
+		}
+		got, err = store.GetJob(ctx, dead.JobID)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if got.Status != models.JobDead || got.LastError != "mailbox unavailable" {
+			t.Errorf("buried job = %+v, want it dead with its error", got)
+		}
+		if _, err := store.ClaimJob(ctx, now.Add(time.Hour), now.Add(2*time.Hour)); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ClaimJob with only finished jobs error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("ClaimExpiredLock", func(t *testing.T) {
+		store := newRepo(t).Job
+		job := enqueue(t, store, "email.send", now.Add(-time.Minute))
+
+		crashed := claim(t, store, now)
+		if _, err := store.ClaimJob(ctx, now.Add(30*time.Second), now.Add(time.Minute)); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("ClaimJob of a locked job error = %v, want ErrNotFound", err)
+		}
+		again := claim(t, store, now.Add(2*time.Minute))
+		if again.JobID != job.JobID || again.Attempts != 2 {
+			t.Fatalf("claim after the lock expired = %+v, want the second attempt of job %d", again, job.JobID)
+		}
+		if err := store.RetryJob(ctx, crashed, now, "late"); !errors.Is(err, repository.ErrJobLost) {
+			t.Errorf("RetryJob of the expired claim error = %v, want ErrJobLost", err)
+		}
+		if err := store.CompleteJob(ctx, again); err != nil {
+			t.Fatalf("CompleteJob: %v", err)
+		}
+	})
+
+	t.Run("EnsureJob", func(t *testing.T) {
+		store := newRepo(t).Job
+		ensure := func(kind string) bool {
+			t.Helper()
+			ok, err := store.EnsureJob(ctx, &models.Job{Kind: kind, MaxAttempts: 1, RunAt: now.Add(-time.Second)})
+			if err != nil {
+				t.Fatalf("EnsureJob: %v", err)
+			}
+			return ok
+		}
+
+		if !ensure("prices.apply_due") {
+			t.Fatal("EnsureJob did not enqueue the first job")
+		}
+		if ensure("prices.apply_due") {
+			t.Error("EnsureJob enqueued a second queued job of the kind")
+		}
+		if !ensure("jobs.purge") {
+			t.Error("EnsureJob did not enqueue a job of another kind")
+		}
+		job := claim(t, store, now)
+		if ensure(job.Kind) {
+			t.Error("EnsureJob enqueued a job of a kind that is running")
+		}
+		if err := store.CompleteJob(ctx, job); err != nil {
+			t.Fatalf("CompleteJob: %v", err)
+		}
+		if !ensure(job.Kind) {
+			t.Error("EnsureJob did not enqueue the next job after the last one succeeded")
+		}
+	})
+
+	t.Run("RequeueListCountAndPurge", func(t *testing.T) {
+		store := newRepo(t).Job
+		dead := enqueue(t, store, "email.send", now.Add(-3*time.Minute))
+		succeeded := enqueue(t, store, "email.send", now.Add(-2*time.Minute))
+		running := enqueue(t, store, "email.send", now.Add(-time.Minute))
+		queued := enqueue(t, store, "email.send", now.Add(time.Hour))
+		if err := store.BuryJob(ctx, claim(t, store, now), "mailbox unavailable"); err != nil {
+			t.Fatalf("BuryJob: %v", err)
+		}
+		if err := store.CompleteJob(ctx, claim(t, store, now)); err != nil {
+			t.Fatalf("CompleteJob: %v", err)
+		}
+		claim(t, store, now)
+
+		counts, err := store.CountJobs(ctx)
+		if err != nil {
+			t.Fatalf("CountJobs: %v", err)
+		}
+		for status, want := range map[models.JobStatus]int{models.JobQueued: 1, models.JobRunning: 1, models.JobSucceeded: 1, models.JobDead: 1} {
+			if counts[status] != want {
+				t.Errorf("CountJobs[%s] = %d, want %d", status, counts[status], want)
+			}
+		}
+
+		all, err := store.ListJobs(ctx, "", 10, 0)
+		if err != nil {
+			t.Fatalf("ListJobs: %v", err)
+		}
+		var ids []int64
+		for _, job := range all {
+			ids = append(ids, job.JobID)
+		}
+		if want := []int64{queued.JobID, running.JobID, succeeded.JobID, dead.JobID}; !slices.Equal(ids, want) {
+			t.Errorf("ListJobs = %v, want %v, latest due first", ids, want)
+		}
+		deadJobs, err := store.ListJobs(ctx, models.JobDead, 10, 0)
+		if err != nil {
+			t.Fatalf("ListJobs: %v", err)
+		}
+		if len(deadJobs) != 1 || deadJobs[0].JobID != dead.JobID {
+			t.Errorf("ListJobs(dead) = %+v, want job %d", deadJobs, dead.JobID)
+		}
+		if page, err := store.ListJobs(ctx, "", 2, 1); err != nil || len(page) != 2 || page[0].JobID != running.JobID {
+			t.Errorf("ListJobs(limit 2, offset 1) = %+v, %v", page, err)
+		}
+
+		if err := store.RequeueJob(ctx, dead.JobID); err != nil {
+			t.Fatalf("RequeueJob: %v", err)
+		}
+		got, err := store.GetJob(ctx, dead.JobID)
+		if err != nil {
+			t.Fatalf("GetJob: %v", err)
+		}
+		if got.Status != models.JobQueued || got.Attempts != 0 || got.RunAt.After(time.Now().Add(time.Second)) {
+			t.Errorf("requeued job = %+v, want it queued now with no attempts", got)
+		}
+		if err := store.RequeueJob(ctx, running.JobID); !errors.Is(err, repository.ErrJobStatus) {
+			t.Errorf("RequeueJob of a running job error = %v, want ErrJobStatus", err)
+		}
+		if err := store.RequeueJob(ctx, 999999); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RequeueJob of a missing job error = %v, want ErrNotFound", err)
+		}
+
+		purged, err := store.PurgeJobs(ctx, time.Now().Add(time.Minute))
+		if err != nil {
+			t.Fatalf("PurgeJobs: %v", err)
+		}
+		if purged != 1 {
+			t.Errorf("PurgeJobs = %d, want the one succeeded job", purged)
+		}
+		if _, err := store.GetJob(ctx, succeeded.JobID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetJob of a purged job error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func RunWebhookStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+	subscribe := func(t *testing.T, store repository.WebhookStore, url string, events ...models.WebhookEvent) models.WebhookSubscription {
+		t.Helper()
+		sub := models.WebhookSubscription{URL: url, Events: events, Secret: "whsec_test", Active: true}
+		if err := store.CreateWebhookSubscription(ctx, &sub); err != nil {
+			t.Fatalf("CreateWebhookSubscription: %v", err)
+		}
+		if sub.SubscriptionID == 0 {
+			t.Fatal("CreateWebhookSubscription did not assign an ID")
+		}
+		return sub
+	}
+
+	t.Run("Subscriptions", func(t *testing.T) {
+		store := newRepo(t).Webhook
+		erp := subscribe(t, store, "https://erp.example/hooks", models.WebhookProductDeleted, models.WebhookOrderCreated)
+		subscribe(t, store, "https://warehouse.example/hooks", models.WebhookOrderStatusChanged)
+
+		got, err := store.GetWebhookSubscription(ctx, erp.SubscriptionID)
+		if err != nil {
+			t.Fatalf("GetWebhookSubscription: %v", err)
+		}
+		wantEvents := []models.WebhookEvent{models.WebhookOrderCreated, models.WebhookProductDeleted}
+		if got.URL != erp.URL || got.Secret != "whsec_test" || !got.Active || !slices.Equal(got.Events, wantEvents) {
+			t.Errorf("subscription = %+v, want %+v with events %v", got, erp, wantEvents)
+		}
+
+		if err := store.SetWebhookSubscriptionActive(ctx, erp.SubscriptionID, false); err != nil {
+			t.Fatalf("SetWebhookSubscriptionActive: %v", err)
+		}
+		list, err := store.ListWebhookSubscriptions(ctx)
+		if err != nil {
+			t.Fatalf("ListWebhookSubscriptions: %v", err)
+		}
+		if len(list) != 2 || list[0].SubscriptionID != erp.SubscriptionID || list[0].Active || len(list[1].Events) != 1 {
+			t.Fatalf("subscriptions = %+v, want the disabled ERP one first", list)
+		}
+		if err := store.SetWebhookSubscriptionActive(ctx, 9999, true); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("SetWebhookSubscriptionActive of a missing subscription error = %v, want ErrNotFound", err)
+		}
+		if _, err := store.GetWebhookSubscription(ctx, 9999); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetWebhookSubscription of a missing subscription error = %v, want ErrNotFound", err)
+		}
+	})
+
+	t.Run("Deliveries", func(t *testing.T) {
+		store := newRepo(t).Webhook
+		erp := subscribe(t, store, "https://erp.example/hooks", models.WebhookOrderCreated)
+		warehouse := subscribe(t, store, "https://warehouse.example/hooks", models.WebhookOrderCreated)
+
+		var deliveries []models.WebhookDelivery
+		for _, sub := range []models.WebhookSubscription{erp, warehouse, erp} {
+			delivery := models.WebhookDelivery{
+				SubscriptionID: sub.SubscriptionID,
+				EventID:        uuid.NewString(),
+				Event:          models.WebhookOrderCreated,
+				Payload:        []byte(`{"type":"order.created"}`),
+				Status:         models.WebhookDeliveryPending,
+			}
+			if err := store.CreateWebhookDelivery(ctx, &delivery); err != nil {
+				t.Fatalf("CreateWebhookDelivery: %v", err)
+			}
+			deliveries = append(deliveries, delivery)
+		}
+		again := deliveries[0]
+		if err := store.CreateWebhookDelivery(ctx, &again); !errors.Is(err, repository.ErrDuplicateDelivery) {
+			t.Errorf("CreateWebhookDelivery of the same event error = %v, want ErrDuplicateDelivery", err)
+		}
+
+		delivered := deliveries[0]
+		deliveredAt := time.Now()
+		delivered.Status = models.WebhookDeliveryDelivered
+		delivered.Attempts = 2
+		delivered.ResponseStatus = 204
+		delivered.LastError = "connection refused"
+		delivered.DeliveredAt = &deliveredAt
+		if err := store.UpdateWebhookDelivery(ctx, &delivered); err != nil {
+			t.Fatalf("UpdateWebhookDelivery: %v", err)
+		}
+		got, err := store.GetWebhookDelivery(ctx, delivered.DeliveryID)
+		if err != nil {
+			t.Fatalf("GetWebhookDelivery: %v", err)
+		}
+		if got.URL != erp.URL || got.EventID != delivered.EventID || string(got.Payload) != `{"type":"order.created"}` {
+			t.Errorf("delivery = %+v, want it as created with the URL of its subscription", got)
+		}
+		if got.Status != models.WebhookDeliveryDelivered || got.Attempts != 2 || got.ResponseStatus != 204 ||
+			got.LastError != "connection refused" || got.DeliveredAt == nil {
+			t.Errorf("delivery = %+v, want it as updated", got)
+		}
+
+		all, err := store.ListWebhookDeliveries(ctx, 0, 10)
+		if err != nil {
+			t.Fatalf("ListWebhookDeliveries: %v", err)
+		}
+		if len(all) != 3 || all[0].DeliveryID != deliveries[2].DeliveryID || all[1].URL != warehouse.URL {
+			t.Fatalf("deliveries = %+v, want all three, newest first", all)
+		}
+		toERP, err := store.ListWebhookDeliveries(ctx, erp.SubscriptionID, 1)
+		if err != nil {
+			t.Fatalf("ListWebhookDeliveries: %v", err)
+		}
+		if len(toERP) != 1 || toERP[0].DeliveryID != deliveries[2].DeliveryID {
+			t.Fatalf("deliveries to ERP = %+v, want the latest one", toERP)
+		}
+
+		if err := store.DeleteWebhookSubscription(ctx, erp.SubscriptionID); err != nil {
+			t.Fatalf("DeleteWebhookSubscription: %v", err)
+		}
+		if _, err := store.GetWebhookDelivery(ctx, delivered.DeliveryID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetWebhookDelivery of a deleted subscription error = %v, want ErrNotFound", err)
+		}
+		if err := store.DeleteWebhookSubscription(ctx, erp.SubscriptionID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("DeleteWebhookSubscription twice error = %v, want ErrNotFound", err)
+		}
+	})
+}
+
+func RunOutboxStore(t *testing.T, newRepo func(t *testing.T) *repository.Repository) {
+	ctx := context.Background()
+	now := time.Now()
+	// pending claims every event saved so far.
+	pending := func(t *testing.T, store repository.OutboxStore) []models.OutboxEvent {
+		t.Helper()
+		events, err := store.ClaimOutboxEvents(ctx, time.Now(), time.Now().Add(time.Minute), 100)
+		if err != nil {
+			t.Fatalf("ClaimOutboxEvents: %v", err)
+		}
+		return events
+	}
+
+	t.Run("EventsOfChanges", func(t *testing.T) {
+		repo := newRepo(t)
+		chair := newProduct("Chair", 40, "chair.jpeg")
+		chair.Stock = intPtr(5)
+		table := newProduct("Table", 100, "table.jpeg")
+		for _, p := range []*models.Product{&chair, &table} {
+			if err := repo.Product.CreateProduct(ctx, p); err != nil {
+				t.Fatalf("CreateProduct: %v", err)
+			}
+		}
+		order := &models.Order{
+			UserID:      "alice@example.com",
+			OrderStatus: models.OrderStatusPendingPayment,
+			Items: []models.OrderItem{
+				{ProductID: chair.ProductID, Quantity: 2, Cost: 80},
+				{ProductID: table.ProductID, Quantity: 1, Cost: 100},
+			},
+		}
+		if err := repo.Order.PlaceOrder(ctx, order); err != nil {
+			t.Fatalf("PlaceOrder: %v", err)
+		}
+		// More chairs than are left leaves nothing behind
+		tooMany := &models.Order{UserID: "bob@example.com", Items: []models.OrderItem{{ProductID: chair.ProductID, Quantity: 9}}}
+		if err := repo.Order.PlaceOrder(ctx, tooMany); !errors.Is(err, repository.ErrOutOfStock) {
+			t.Fatalf("PlaceOrder error = %v, want ErrOutOfStock", err)
+		}
+		if err := repo.Order.SetOrderStatus(ctx, order.OrderID, models.OrderStatusPaymentFailed); err != nil {
+			t.Fatalf("SetOrderStatus: %v", err)
+		}
+		edited, err := repo.Product.GetProductByID(ctx, chair.ProductID)
+		if err != nil {
+			t.Fatalf("GetProductByID: %v", err)
+		}
+		edited.Price = 45
+		if err := repo.Product.UpdateProduct(ctx, edited); err != nil {
+			t.Fatalf("UpdateProduct: %v", err)
+		}
+		for _, change := range []func(context.Context, uuid.UUID) error{
+			repo.Product.ArchiveProduct, repo.Product.ArchiveProduct, repo.Product.RestoreProduct, repo.Product.RestoreProduct,
+		} {
+			if err := change(ctx, table.ProductID); err != nil {
+				t.Fatalf("archiving or restoring: %v", err)
+			}
+		}
+		price := &models.ProductPrice{ProductID: table.ProductID, Price: 90, EffectiveAt: now.Add(-time.Minute)}
+		if err := repo.Price.SchedulePrice(ctx, price); err != nil {
+			t.Fatalf("SchedulePrice: %v", err)
+		}
+		if _, err := repo.Price.ApplyDuePrices(ctx, now); err != nil {
+			t.Fatalf("ApplyDuePrices: %v", err)
+		}
+
+		type event struct {
+			Type        models.EventType
+			AggregateID uuid.UUID
+		}
+		want := []event{
+			{models.EventOrderPlaced, order.OrderID},
+			{models.EventStockChanged, chair.ProductID},
+			{models.EventStockChanged, chair.ProductID},
+			{models.EventOrderStatusChanged, order.OrderID},
+			{models.EventProductUpdated, chair.ProductID},
+			{models.EventProductArchived, table.ProductID},
+			{models.EventProductUpdated, table.ProductID},
+			{models.EventProductUpdated, table.ProductID},
+		}
+		events := pending(t, repo.Outbox)
+		var got []event
+		for _, e := range events {
+			got = append(got, event{e.Type, uuid.MustParse(e.AggregateID)})
+		}
+		if !slices.Equal(got, want) {
+			t.Fatalf("events = %v, want %v", got, want)
+		}
+
+		var placed, changed models.OrderEvent
+		var taken, released, updated, applied models.ProductEvent
+		for i, v := range map[int]any{0: &placed, 1: &taken, 2: &released, 3: &changed, 4: &updated, 7: &applied} {
+			if err := events[i].Decode(v); err != nil {
+				t.Fatalf("Decode %s: %v", events[i].Type, err)
+			}
+		}
+		if placed.UserID != "alice@example.com" || placed.Status != models.OrderStatusPendingPayment {
+			t.Errorf("order.placed = %+v", placed)
+		}
+		if changed.Status != models.OrderStatusPaymentFailed || changed.PreviousStatus != models.OrderStatusPendingPayment {
+			t.Errorf("order.status_changed = %+v, want from pending payment to failed", changed)
+		}
+		if taken.Stock == nil || *taken.Stock != 3 || taken.Version != 2 || released.Stock == nil || *released.Stock != 5 || released.Version != 3 {
+			t.Errorf("stock.changed = %+v then %+v, want 3 then 5 in stock in new versions", taken, released)
+		}
+		if updated.Price != 45 || updated.Version != 4 || applied.Price != 90 || applied.Stock != nil {
+			t.Errorf("product.updated = %+v and %+v, want the new prices", updated, applied)
+		}
+	})
+
+	t.Run("ClaimRetryPublishAndPurge", func(t *testing.T) {
+		repo := newRepo(t)
+		store := repo.Outbox
+		product := newProduct("Lamp", 20, "lamp.jpeg")
+		if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+			t.Fatalf("CreateProduct: %v", err)
+		}
+		for range 3 {
+			if err := repo.Product.UpdateProduct(ctx, &product); err != nil {
+				t.Fatalf("UpdateProduct: %v", err)
+			}
+		}
+		now := time.Now()
+
+		first, err := store.ClaimOutboxEvents(ctx, now, now.Add(time.Minute), 2)
+		if err != nil {
+			t.Fatalf("ClaimOutboxEvents: %v", err)
+		}
+		rest, err := store.ClaimOutboxEvents(ctx, now, now.Add(time.Minute), 2)
+		if err != nil {
+			t.Fatalf("ClaimOutboxEvents: %v", err)
+		}
+		if len(first) != 2 || len(rest) != 1 || first[0].EventID >= first[1].EventID || rest[0].EventID <= first[1].EventID {
+			t.Fatalf("claimed %v then %v, want the two oldest events then the last one", first, rest)
+		}
+
+		if err := store.RetryOutboxEvent(ctx, first[0].EventID, now.Add(time.Hour), "broker is down"); err != nil {
+			t.Fatalf("RetryOutboxEvent: %v", err)
+		}
+		if err := store.MarkOutboxEventPublished(ctx, rest[0].EventID, now); err != nil {
+			t.Fatalf("MarkOutboxEventPublished: %v", err)
+		}
+		// The claim of the second event expired
+		again, err := store.ClaimOutboxEvents(ctx, now.Add(2*time.Minute), now.Add(3*time.Minute), 10)
+		if err != nil {
+			t.Fatalf("ClaimOutboxEvents: %v", err)
+		}
+		if len(again) != 1 || again[0].EventID != first[1].EventID {
+			t.Fatalf("claimed %v after two minutes, want event %d", again, first[1].EventID)
+		}
+
+		retried, err := store.GetOutboxEvent(ctx, first[0].EventID)
+		if err != nil {
+			t.Fatalf("GetOutboxEvent: %v", err)
+		}
+		if retried.Attempts != 1 || retried.LastError != "broker is down" || retried.PublishedAt != nil {
+			t.Errorf("retried event = %+v, want one failed attempt", retried)
+		}
+		if err := store.RetryOutboxEvent(ctx, rest[0].EventID, now, "late"); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("RetryOutboxEvent of a published event error = %v, want ErrNotFound", err)
+		}
+		if _, err := store.GetOutboxEvent(ctx, 9999); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetOutboxEvent of a missing event error = %v, want ErrNotFound", err)
+		}
+
+		purged, err := store.PurgeOutboxEvents(ctx, now.Add(time.Second))
+		if err != nil {
+			t.Fatalf("PurgeOutboxEvents: %v", err)
+		}
+		if purged != 1 {
+			t.Errorf("PurgeOutboxEvents purged %d events, want the published one", purged)
+		}
+		if _, err := store.GetOutboxEvent(ctx, rest[0].EventID); !errors.Is(err, repository.ErrNotFound) {
+			t.Errorf("GetOutboxEvent of a purged event error = %v, want ErrNotFound", err)
+		}
+	})
+}
@@ -0,0 +1,359 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+
+	"github.com/google/uuid"
+)
+
+const returnColumns = `return_id, order_id, user_id, status, reason, note, refund_amount, refund_ref,
+	date_created, date_modified`
+
+type ReturnRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewReturnRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *ReturnRepository {
+	return &ReturnRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+func scanReturn(row scanner) (*models.Return, error) {
+	var ret models.Return
+	var refundRef sql.NullString
+	err := row.Scan(
+		&ret.ReturnID,
+		&ret.OrderID,
+		&ret.UserID,
+		&ret.Status,
+		&ret.Reason,
+		&ret.Note,
+		&ret.RefundAmount,
+		&refundRef,
+		&ret.DateCreated,
+		&ret.DateModified,
+	)
+	if err != nil {
+		return nil, err
+	}
+	ret.RefundRef = refundRef.String
+	return &ret, nil
+}
+
+// CreateReturn saves a requested return with its items. It fails with
+// ErrReturnQuantity when an item was not ordered or would be returned more
+// times than it was ordered, counting the returns that were not rejected.
+func (r *ReturnRepository) CreateReturn(ctx context.Context, ret *models.Return) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	returnable := `SELECT oi.quantity - COALESCE((
+                       SELECT SUM(ri.quantity) FROM order_return_items ri
+                       JOIN order_returns r ON r.return_id = ri.return_id
+                       WHERE r.order_id = oi.order_id AND ri.product_id = oi.product_id AND r.status <> ?), 0)
+                   FROM order_items oi WHERE oi.order_id = ? AND oi.product_id = ?`
+	for _, item := range ret.Items {
+		var left int
+		err := tx.QueryRowContext(ctx, r.Dialect.rebind(returnable), models.ReturnRejected, ret.OrderID, item.ProductID).Scan(&left)
+		if errors.Is(err, sql.ErrNoRows) {
+			return fmt.Errorf("%w: product %s was not ordered", ErrReturnQuantity, item.ProductID)
+		}
+		if err != nil {
+			return err
+		}
+		if item.Quantity <= 0 || item.Quantity > left {
+			return fmt.Errorf("%w: %d of product %s, %d left to return", ErrReturnQuantity, item.Quantity, item.ProductID, left)
+		}
+	}
+
+	ret.Status = models.ReturnRequested
+	ret.DateCreated = time.Now()
+	ret.DateModified = ret.DateCreated
+
+	query := `INSERT INTO order_returns (order_id, user_id, status, reason, note, refund_amount, date_created, date_modified)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []any{
+		ret.OrderID,
+		ret.UserID,
+		ret.Status,
+		ret.Reason,
+		ret.Note,
+		ret.RefundAmount,
+		ret.DateCreated.UTC(),
+		ret.DateModified.UTC(),
+	}
+	if r.Dialect == Postgres {
+		err = tx.QueryRowContext(ctx, r.Dialect.rebind(query+" RETURNING return_id"), args...).Scan(&ret.ReturnID)
+		if err != nil {
+			return err
+		}
+	} else {
+		res, err := tx.ExecContext(ctx, r.Dialect.rebind(query), args...)
+		if err != nil {
+			return err
+		}
+		if ret.ReturnID, err = res.LastInsertId(); err != nil {
+			return err
+		}
+	}
+
+	for _, item := range ret.Items {
+		_, err := tx.ExecContext(ctx, r.Dialect.rebind("INSERT INTO order_return_items (return_id, product_id, quantity, amount) VALUES (?, ?, ?, ?)"),
+			ret.ReturnID, item.ProductID, item.Quantity, item.Amount)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *ReturnRepository) GetReturn(ctx context.Context, returnID int64) (*models.Return, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + returnColumns + ` FROM order_returns WHERE return_id = ?`
+	ret, err := scanReturn(r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), returnID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ret.Items, err = r.returnItems(ctx, returnID); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ListReturns returns the returns matching filter with their items, newest
+// first.
+func (r *ReturnRepository) ListReturns(ctx context.Context, filter ReturnFilter) ([]models.Return, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	var where []string
+	var args []any
+	if filter.OrderID != uuid.Nil {
+		where = append(where, "order_id = ?")
+		args = append(args, filter.OrderID)
+	}
+	if filter.UserID != "" {
+		where = append(where, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	query := `SELECT ` + returnColumns + ` FROM order_returns`
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY return_id DESC"
+
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var returns []models.Return
+	for rows.Next() {
+		ret, err := scanReturn(rows)
+		if err != nil {
+			return nil, err
+		}
+		returns = append(returns, *ret)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	rows.Close()
+
+	for i := range returns {
+		if returns[i].Items, err = r.returnItems(ctx, returns[i].ReturnID); err != nil {
+			return nil, err
+		}
+	}
+	return returns, nil
+}
+
+func (r *ReturnRepository) returnItems(ctx context.Context, returnID int64) ([]models.ReturnItem, error) {
+	query := `SELECT ri.product_id, p.product_name, ri.quantity, ri.amount
+              FROM order_return_items ri
+              JOIN products p ON p.product_id = ri.product_id
+              WHERE ri.return_id = ? ORDER BY p.product_name`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), returnID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var items []models.ReturnItem
+	for rows.Next() {
+		var item models.ReturnItem
+		if err := rows.Scan(&item.ProductID, &item.ProductName, &item.Quantity, &item.Amount); err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	return items, rows.Err()
+}
+
+// SetReturnStatus approves or rejects a requested return. note is shown to
+// the customer.
+func (r *ReturnRepository) SetReturnStatus(ctx context.Context, returnID int64, status models.ReturnStatus, note string) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	if status != models.ReturnApproved && status != models.ReturnRejected {
+		return ErrReturnStatus
+	}
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := r.moveReturn(ctx, tx, returnID, status); err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, r.Dialect.rebind("UPDATE order_returns SET note = ? WHERE return_id = ?"), note, returnID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ReceiveReturn marks an approved return received and puts its items back
+// in stock.
+func (r *ReturnRepository) ReceiveReturn(ctx context.Context, returnID int64) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := r.moveReturn(ctx, tx, returnID, models.ReturnReceived); err != nil {
+		return err
+	}
+	query := `UPDATE products SET version = version + 1, stock = stock + (
+                  SELECT quantity FROM order_return_items WHERE return_id = ? AND product_id = products.product_id)
+              WHERE stock IS NOT NULL AND product_id IN (SELECT product_id FROM order_return_items WHERE return_id = ?)`
+	if _, err := tx.ExecContext(ctx, r.Dialect.rebind(query), returnID, returnID); err != nil {
+		return err
+	}
+	if err := recordStockChanges(ctx, tx, r.Dialect, "SELECT product_id FROM order_return_items WHERE return_id = ?", returnID); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// ClaimRefund moves a received return to refunding. Of two concurrent
+// claims only one succeeds, the other fails with ErrReturnStatus.
+func (r *ReturnRepository) ClaimRefund(ctx context.Context, returnID int64) error {
+	return r.moveTo(ctx, returnID, models.ReturnRefunding)
+}
+
+// ReleaseRefund moves a refunding return back to received after its refund
+// failed.
+func (r *ReturnRepository) ReleaseRefund(ctx context.Context, returnID int64) error {
+	return r.moveTo(ctx, returnID, models.ReturnReceived)
+}
+
+// moveTo moves a return to status and changes nothing else.
+func (r *ReturnRepository) moveTo(ctx context.Context, returnID int64, status models.ReturnStatus) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := r.moveReturn(ctx, tx, returnID, status); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// RefundReturn marks a refunding return refunded with amount, which the
+// payment provider refunded under refundRef, and adds amount to the
+// refunded amount of the order.
+func (r *ReturnRepository) RefundReturn(ctx context.Context, returnID int64, amount float64, refundRef string) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	orderID, err := r.moveReturn(ctx, tx, returnID, models.ReturnRefunded)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, r.Dialect.rebind("UPDATE order_returns SET refund_amount = ?, refund_ref = ? WHERE return_id = ?"),
+		amount, nullString(refundRef), returnID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, r.Dialect.rebind("UPDATE orders SET refunded_amount = refunded_amount + ? WHERE order_id = ?"),
+		amount, orderID)
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// moveReturn moves a return to status if it can get there from its current
+// status, and returns the ID of its order.
+func (r *ReturnRepository) moveReturn(ctx context.Context, tx *sql.Tx, returnID int64, status models.ReturnStatus) (uuid.UUID, error) {
+	var orderID uuid.UUID
+	var current models.ReturnStatus
+	err := tx.QueryRowContext(ctx, r.Dialect.rebind("SELECT order_id, status FROM order_returns WHERE return_id = ?"), returnID).Scan(&orderID, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return uuid.Nil, ErrNotFound
+	}
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if !models.CanTransitionReturn(current, status) {
+		return uuid.Nil, fmt.Errorf("%w: %s to %s", ErrReturnStatus, current, status)
+	}
+	// Guarded by the current status so that of two concurrent moves only
+	// one succeeds
+	res, err := tx.ExecContext(ctx, r.Dialect.rebind("UPDATE order_returns SET status = ?, date_modified = ? WHERE return_id = ? AND status = ?"),
+		status, time.Now().UTC(), returnID, current)
+	if err != nil {
+		return uuid.Nil, err
+	}
+	moved, err := res.RowsAffected()
+	if err != nil {
+		return uuid.Nil, err
+	}
+	if moved == 0 {
+		return uuid.Nil, fmt.Errorf("%w: %s to %s", ErrReturnStatus, current, status)
+	}
+	return orderID, nil
+}
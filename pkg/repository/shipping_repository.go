@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+type ShippingRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewShippingRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *ShippingRepository {
+	return &ShippingRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+func (r *ShippingRepository) CreateShippingMethod(ctx context.Context, method *models.ShippingMethod) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	method.DateCreated = time.Now()
+
+	query := `INSERT INTO shipping_methods (name, kind, price, per_kg, free_over, active, date_created) 
+              VALUES (?, ?, ?, ?, ?, ?, ?)`
+	args := []any{
+		method.Name,
+		method.Kind,
+		method.Price,
+		method.PerKg,
+		method.FreeOver,
+		method.Active,
+		method.DateCreated.UTC(),
+	}
+	if r.Dialect == Postgres {
+		return r.DB.QueryRowContext(ctx, r.Dialect.rebind(query+" RETURNING shipping_method_id"), args...).Scan(&method.ShippingMethodID)
+	}
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	method.ShippingMethodID, err = res.LastInsertId()
+	return err
+}
+
+// ListShippingMethods returns every method, cheapest first.
+func (r *ShippingRepository) ListShippingMethods(ctx context.Context) ([]models.ShippingMethod, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT shipping_method_id, name, kind, price, per_kg, free_over, active, date_created 
+              FROM shipping_methods ORDER BY price, shipping_method_id`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var methods []models.ShippingMethod
+	for rows.Next() {
+		var method models.ShippingMethod
+		err := rows.Scan(
+			&method.ShippingMethodID,
+			&method.Name,
+			&method.Kind,
+			&method.Price,
+			&method.PerKg,
+			&method.FreeOver,
+			&method.Active,
+			&method.DateCreated,
+		)
+		if err != nil {
+			return nil, err
+		}
+		methods = append(methods, method)
+	}
+	return methods, rows.Err()
+}
+
+func (r *ShippingRepository) SetShippingMethodActive(ctx context.Context, methodID int64, active bool) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `UPDATE shipping_methods SET active = ? WHERE shipping_method_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), active, methodID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// MySQL does not count rows that already had the value
+	if updated == 0 && r.Dialect != MySQL {
+		return ErrNotFound
+	}
+	return nil
+}
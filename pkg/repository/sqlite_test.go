@@ -0,0 +1,59 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+	"github.com/snirkop89/mx-store/pkg/repository/repotest"
+)
+
+func TestSQLiteRepository(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) *repository.Repository {
+		db, dialect, err := repository.Open("sqlite://" + filepath.Join(t.TempDir(), "store.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+
+		if err := repository.Migrate(context.Background(), db, dialect); err != nil {
+			t.Fatalf("Migrate: %v", err)
+		}
+		return repository.NewRepository(db, dialect, 5*time.Second)
+	})
+}
+
+func TestSQLiteForeignKeys(t *testing.T) {
+	ctx := context.Background()
+	db, dialect, err := repository.Open("sqlite://" + filepath.Join(t.TempDir(), "store.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := repository.Migrate(ctx, db, dialect); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	repo := repository.NewRepository(db, dialect, 5*time.Second)
+
+	product := models.Product{ProductName: "Laptop", Price: 10, Description: "A laptop"}
+	if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	err = repo.Order.PlaceOrderWithItems(ctx, []models.OrderItem{{ProductID: product.ProductID, Quantity: 1, Cost: 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := repo.Product.DeleteProduct(ctx, product.ProductID); !errors.Is(err, repository.ErrProductInUse) {
+		t.Fatalf("DeleteProduct of an ordered product error = %v, want ErrProductInUse", err)
+	}
+	err = repo.Order.AddOrderItem(ctx, &models.OrderItem{OrderID: uuid.New(), ProductID: product.ProductID, Quantity: 1})
+	if err == nil {
+		t.Fatal("adding an item to a missing order succeeded, want foreign key error")
+	}
+}
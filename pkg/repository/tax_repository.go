@@ -0,0 +1,101 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+type TaxRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewTaxRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *TaxRepository {
+	return &TaxRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+func (r *TaxRepository) ListTaxRates(ctx context.Context) ([]models.TaxRate, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT tax_rate_id, country, state, tax_class, name, rate FROM tax_rates ORDER BY country, state, tax_class`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var rates []models.TaxRate
+	for rows.Next() {
+		var rate models.TaxRate
+		if err := rows.Scan(&rate.TaxRateID, &rate.Country, &rate.State, &rate.TaxClass, &rate.Name, &rate.Rate); err != nil {
+			return nil, err
+		}
+		rates = append(rates, rate)
+	}
+	return rates, rows.Err()
+}
+
+// SaveTaxRate sets the rate for the region and tax class of rate, replacing
+// the rate already set for them.
+func (r *TaxRepository) SaveTaxRate(ctx context.Context, rate *models.TaxRate) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	rate.Country = models.NormalizeRegion(rate.Country)
+	rate.State = models.NormalizeRegion(rate.State)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT tax_rate_id FROM tax_rates WHERE country = ? AND state = ? AND tax_class = ?`
+	err = tx.QueryRowContext(ctx, r.Dialect.rebind(query), rate.Country, rate.State, rate.TaxClass).Scan(&rate.TaxRateID)
+	switch {
+	case err == nil:
+		query = `UPDATE tax_rates SET name = ?, rate = ? WHERE tax_rate_id = ?`
+		_, err = tx.ExecContext(ctx, r.Dialect.rebind(query), rate.Name, rate.Rate, rate.TaxRateID)
+	case errors.Is(err, sql.ErrNoRows):
+		query = `INSERT INTO tax_rates (country, state, tax_class, name, rate) VALUES (?, ?, ?, ?, ?)`
+		args := []any{rate.Country, rate.State, rate.TaxClass, rate.Name, rate.Rate}
+		if r.Dialect == Postgres {
+			err = tx.QueryRowContext(ctx, r.Dialect.rebind(query+" RETURNING tax_rate_id"), args...).Scan(&rate.TaxRateID)
+		} else {
+			var res sql.Result
+			res, err = tx.ExecContext(ctx, r.Dialect.rebind(query), args...)
+			if err == nil {
+				rate.TaxRateID, err = res.LastInsertId()
+			}
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (r *TaxRepository) DeleteTaxRate(ctx context.Context, taxRateID int64) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM tax_rates WHERE tax_rate_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), taxRateID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
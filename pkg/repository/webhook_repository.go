@@ -0,0 +1,331 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"slices"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+const webhookDeliveryColumns = `d.delivery_id, d.subscription_id, s.url, d.event_id, d.event, d.payload, d.status,
+	d.attempts, d.response_status, d.last_error, d.date_created, d.delivered_at`
+
+type WebhookRepository struct {
+	DB      *sql.DB
+	Dialect Dialect
+	Timeout time.Duration
+}
+
+func NewWebhookRepository(db *sql.DB, dialect Dialect, timeout time.Duration) *WebhookRepository {
+	return &WebhookRepository{DB: db, Dialect: dialect, Timeout: timeout}
+}
+
+// sortWebhookEvents orders events the way models.WebhookEvents lists them.
+func sortWebhookEvents(events []models.WebhookEvent) {
+	slices.SortFunc(events, func(a, b models.WebhookEvent) int {
+		return slices.Index(models.WebhookEvents, a) - slices.Index(models.WebhookEvents, b)
+	})
+}
+
+func (r *WebhookRepository) CreateWebhookSubscription(ctx context.Context, sub *models.WebhookSubscription) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	sub.DateCreated = time.Now()
+	sortWebhookEvents(sub.Events)
+
+	tx, err := r.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `INSERT INTO webhook_subscriptions (url, secret, active, date_created) VALUES (?, ?, ?, ?)`
+	args := []any{sub.URL, sub.Secret, sub.Active, sub.DateCreated.UTC()}
+	if r.Dialect == Postgres {
+		err = tx.QueryRowContext(ctx, r.Dialect.rebind(query+" RETURNING subscription_id"), args...).Scan(&sub.SubscriptionID)
+	} else {
+		var res sql.Result
+		res, err = tx.ExecContext(ctx, r.Dialect.rebind(query), args...)
+		if err == nil {
+			sub.SubscriptionID, err = res.LastInsertId()
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, event := range sub.Events {
+		query = `INSERT INTO webhook_subscription_events (subscription_id, event) VALUES (?, ?)`
+		if _, err := tx.ExecContext(ctx, r.Dialect.rebind(query), sub.SubscriptionID, event); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (r *WebhookRepository) GetWebhookSubscription(ctx context.Context, subscriptionID int64) (*models.WebhookSubscription, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT subscription_id, url, secret, active, date_created FROM webhook_subscriptions WHERE subscription_id = ?`
+	var sub models.WebhookSubscription
+	err := r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), subscriptionID).
+		Scan(&sub.SubscriptionID, &sub.URL, &sub.Secret, &sub.Active, &sub.DateCreated)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	list := []models.WebhookSubscription{sub}
+	if err := r.loadSubscriptionEvents(ctx, list); err != nil {
+		return nil, err
+	}
+	return &list[0], nil
+}
+
+func (r *WebhookRepository) ListWebhookSubscriptions(ctx context.Context) ([]models.WebhookSubscription, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT subscription_id, url, secret, active, date_created FROM webhook_subscriptions ORDER BY subscription_id`
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query))
+	if err != nil {
+		return nil, err
+	}
+	var list []models.WebhookSubscription
+	for rows.Next() {
+		var sub models.WebhookSubscription
+		if err := rows.Scan(&sub.SubscriptionID, &sub.URL, &sub.Secret, &sub.Active, &sub.DateCreated); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		list = append(list, sub)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return list, r.loadSubscriptionEvents(ctx, list)
+}
+
+// loadSubscriptionEvents fills in the events of subscriptions.
+func (r *WebhookRepository) loadSubscriptionEvents(ctx context.Context, list []models.WebhookSubscription) error {
+	for i := range list {
+		query := `SELECT event FROM webhook_subscription_events WHERE subscription_id = ?`
+		rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), list[i].SubscriptionID)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var event models.WebhookEvent
+			if err := rows.Scan(&event); err != nil {
+				rows.Close()
+				return err
+			}
+			list[i].Events = append(list[i].Events, event)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+		sortWebhookEvents(list[i].Events)
+	}
+	return nil
+}
+
+func (r *WebhookRepository) SetWebhookSubscriptionActive(ctx context.Context, subscriptionID int64, active bool) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `UPDATE webhook_subscriptions SET active = ? WHERE subscription_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), active, subscriptionID)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// MySQL does not count rows that already had the value
+	if updated == 0 && r.Dialect != MySQL {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *WebhookRepository) DeleteWebhookSubscription(ctx context.Context, subscriptionID int64) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `DELETE FROM webhook_subscriptions WHERE subscription_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), subscriptionID)
+	if err != nil {
+		return err
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateWebhookDelivery saves delivery unless its subscription has one of the
+// event already. Like RecordPaymentEvent the insert itself skips duplicates,
+// so that an event published twice at once is still delivered once.
+func (r *WebhookRepository) CreateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	delivery.DateCreated = time.Now()
+
+	insert := "INSERT"
+	switch r.Dialect {
+	case MySQL:
+		insert = "INSERT IGNORE"
+	case SQLite:
+		insert = "INSERT OR IGNORE"
+	}
+	query := insert + ` INTO webhook_deliveries (subscription_id, event_id, event, payload, status, attempts, response_status,
+                  last_error, date_created, delivered_at)
+              VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	args := []any{
+		delivery.SubscriptionID,
+		delivery.EventID,
+		delivery.Event,
+		string(delivery.Payload),
+		delivery.Status,
+		delivery.Attempts,
+		delivery.ResponseStatus,
+		nullString(delivery.LastError),
+		delivery.DateCreated.UTC(),
+		nullTime(delivery.DeliveredAt),
+	}
+	if r.Dialect == Postgres {
+		query += " ON CONFLICT (subscription_id, event_id) DO NOTHING RETURNING delivery_id"
+		err := r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), args...).Scan(&delivery.DeliveryID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDuplicateDelivery
+		}
+		return err
+	}
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return err
+	}
+	inserted, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if inserted == 0 {
+		return ErrDuplicateDelivery
+	}
+	delivery.DeliveryID, err = res.LastInsertId()
+	return err
+}
+
+func scanWebhookDelivery(row scanner) (*models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	var payload string
+	var lastError sql.NullString
+	err := row.Scan(
+		&delivery.DeliveryID,
+		&delivery.SubscriptionID,
+		&delivery.URL,
+		&delivery.EventID,
+		&delivery.Event,
+		&payload,
+		&delivery.Status,
+		&delivery.Attempts,
+		&delivery.ResponseStatus,
+		&lastError,
+		&delivery.DateCreated,
+		&delivery.DeliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	delivery.Payload = []byte(payload)
+	delivery.LastError = lastError.String
+	return &delivery, nil
+}
+
+func (r *WebhookRepository) GetWebhookDelivery(ctx context.Context, deliveryID int64) (*models.WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + webhookDeliveryColumns + `
+              FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.subscription_id = d.subscription_id
+              WHERE d.delivery_id = ?`
+	delivery, err := scanWebhookDelivery(r.DB.QueryRowContext(ctx, r.Dialect.rebind(query), deliveryID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	return delivery, err
+}
+
+func (r *WebhookRepository) UpdateWebhookDelivery(ctx context.Context, delivery *models.WebhookDelivery) error {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `UPDATE webhook_deliveries SET status = ?, attempts = ?, response_status = ?, last_error = ?, delivered_at = ?
+              WHERE delivery_id = ?`
+	res, err := r.DB.ExecContext(ctx, r.Dialect.rebind(query),
+		delivery.Status,
+		delivery.Attempts,
+		delivery.ResponseStatus,
+		nullString(delivery.LastError),
+		nullTime(delivery.DeliveredAt),
+		delivery.DeliveryID,
+	)
+	if err != nil {
+		return err
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// MySQL does not count rows that already had the values
+	if updated == 0 && r.Dialect != MySQL {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *WebhookRepository) ListWebhookDeliveries(ctx context.Context, subscriptionID int64, limit int) ([]models.WebhookDelivery, error) {
+	ctx, cancel := withTimeout(ctx, r.Timeout)
+	defer cancel()
+
+	query := `SELECT ` + webhookDeliveryColumns + `
+              FROM webhook_deliveries d JOIN webhook_subscriptions s ON s.subscription_id = d.subscription_id`
+	var args []any
+	if subscriptionID != 0 {
+		query += ` WHERE d.subscription_id = ?`
+		args = append(args, subscriptionID)
+	}
+	query += ` ORDER BY d.delivery_id DESC LIMIT ?`
+	args = append(args, limit)
+
+	rows, err := r.DB.QueryContext(ctx, r.Dialect.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var deliveries []models.WebhookDelivery
+	for rows.Next() {
+		delivery, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, *delivery)
+	}
+	return deliveries, rows.Err()
+}
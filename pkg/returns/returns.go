@@ -0,0 +1,119 @@
+// Package returns decides what can be returned from an order and what the
+// returned items are worth.
+package returns
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+var (
+	// ErrNotReturnable is returned for orders that were not paid, or were
+	// refunded in full.
+	ErrNotReturnable = errors.New("this order cannot be returned")
+	ErrNoItems       = errors.New("choose at least one item to return")
+	ErrTooMany       = errors.New("more items than can be returned")
+)
+
+// Returnable reports whether items of order can be returned. Only paid
+// orders can, including those that were partly refunded already.
+func Returnable(order models.Order) bool {
+	switch order.OrderStatus {
+	case models.OrderStatusPaid, models.OrderStatusPartiallyRefunded:
+		return true
+	}
+	return false
+}
+
+// Left returns how many units of every product of order can still be
+// returned, given the returns requested before. Rejected returns do not
+// count.
+func Left(order models.Order, previous []models.Return) map[uuid.UUID]int {
+	left := make(map[uuid.UUID]int, len(order.Items))
+	for _, item := range order.Items {
+		left[item.ProductID] += item.Quantity
+	}
+	for _, ret := range previous {
+		if ret.OrderID != order.OrderID || ret.Status == models.ReturnRejected {
+			continue
+		}
+		for _, item := range ret.Items {
+			left[item.ProductID] -= item.Quantity
+		}
+	}
+	return left
+}
+
+// Value is what quantity units of item were paid: their share of the line
+// cost after the order's coupon, with tax when it was added to the cost.
+// Shipping is not part of it.
+func Value(order models.Order, item models.OrderItem, quantity int) float64 {
+	if item.Quantity <= 0 {
+		return 0
+	}
+	paid := item.Cost
+	if subtotal := order.Subtotal(); order.Discount > 0 && subtotal > 0 {
+		paid -= order.Discount * item.Cost / subtotal
+	}
+	if !order.PricesIncludeTax {
+		paid += item.Tax
+	}
+	return round(paid * float64(quantity) / float64(item.Quantity))
+}
+
+// Items prices the quantities of every product to return from order. It
+// fails with ErrNoItems when every quantity is zero and with ErrTooMany
+// when one is more than Left.
+func Items(order models.Order, previous []models.Return, quantities map[uuid.UUID]int) ([]models.ReturnItem, error) {
+	if !Returnable(order) {
+		return nil, ErrNotReturnable
+	}
+	left := Left(order, previous)
+	for productID, quantity := range quantities {
+		if quantity < 0 || quantity > left[productID] {
+			return nil, fmt.Errorf("%w: %d of %d left", ErrTooMany, quantity, max(left[productID], 0))
+		}
+	}
+
+	var items []models.ReturnItem
+	for _, item := range order.Items {
+		quantity := quantities[item.ProductID]
+		if quantity == 0 {
+			continue
+		}
+		items = append(items, models.ReturnItem{
+			ProductID:   item.ProductID,
+			ProductName: item.Product.ProductName,
+			Quantity:    quantity,
+			Amount:      Value(order, item, quantity),
+		})
+	}
+	if len(items) == 0 {
+		return nil, ErrNoItems
+	}
+	return items, nil
+}
+
+// Refundable is the most that can be refunded for ret: what its items were
+// paid, or what is left of the order to refund when that is less.
+func Refundable(order models.Order, ret models.Return) float64 {
+	return max(round(min(ret.ItemsAmount(), order.NetTotal())), 0)
+}
+
+// RefundedStatus is the status of order once refunded was given back in
+// total: refunded when it covers the whole order, partially refunded
+// otherwise.
+func RefundedStatus(order models.Order, refunded float64) string {
+	if round(refunded) >= round(order.Total()) {
+		return models.OrderStatusRefunded
+	}
+	return models.OrderStatusPartiallyRefunded
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
@@ -0,0 +1,153 @@
+package returns
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+func TestValue(t *testing.T) {
+	line := models.OrderItem{Quantity: 4, Cost: 80, Tax: 16}
+	other := models.OrderItem{Quantity: 1, Cost: 20, Tax: 4}
+
+	tests := []struct {
+		name     string
+		order    models.Order
+		quantity int
+		want     float64
+	}{
+		{"whole line", models.Order{PricesIncludeTax: true}, 4, 80},
+		{"part of the line", models.Order{PricesIncludeTax: true}, 1, 20},
+		{"tax added", models.Order{}, 1, 24},
+		{"coupon shared by the lines", models.Order{Discount: 10, PricesIncludeTax: true}, 4, 72},
+		{"coupon and tax", models.Order{Discount: 10}, 2, 44},
+		{"rounded to the cent", models.Order{Discount: 1, PricesIncludeTax: true}, 3, 59.4},
+		{"nothing", models.Order{}, 0, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			tc.order.Items = []models.OrderItem{line, other}
+			if got := Value(tc.order, line, tc.quantity); got != tc.want {
+				t.Fatalf("Value = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestItems(t *testing.T) {
+	chair, table := uuid.New(), uuid.New()
+	order := models.Order{
+		OrderID:          uuid.New(),
+		OrderStatus:      models.OrderStatusPaid,
+		PricesIncludeTax: true,
+		Items: []models.OrderItem{
+			{ProductID: chair, Quantity: 3, Cost: 120, Product: models.Product{ProductName: "Chair"}},
+			{ProductID: table, Quantity: 1, Cost: 100, Product: models.Product{ProductName: "Table"}},
+		},
+	}
+	previous := []models.Return{
+		{OrderID: order.OrderID, Status: models.ReturnRequested, Items: []models.ReturnItem{{ProductID: chair, Quantity: 1}}},
+		{OrderID: order.OrderID, Status: models.ReturnRejected, Items: []models.ReturnItem{{ProductID: table, Quantity: 1}}},
+		{OrderID: uuid.New(), Status: models.ReturnRefunded, Items: []models.ReturnItem{{ProductID: chair, Quantity: 2}}},
+	}
+
+	tests := []struct {
+		name       string
+		status     string
+		quantities map[uuid.UUID]int
+		want       []models.ReturnItem
+		err        error
+	}{
+		{
+			name:       "both products",
+			quantities: map[uuid.UUID]int{chair: 2, table: 1},
+			want: []models.ReturnItem{
+				{ProductID: chair, ProductName: "Chair", Quantity: 2, Amount: 80},
+				{ProductID: table, ProductName: "Table", Quantity: 1, Amount: 100},
+			},
+		},
+		{
+			name:       "zero quantities skipped",
+			quantities: map[uuid.UUID]int{chair: 0, table: 1},
+			want:       []models.ReturnItem{{ProductID: table, ProductName: "Table", Quantity: 1, Amount: 100}},
+		},
+		{name: "more than left", quantities: map[uuid.UUID]int{chair: 3}, err: ErrTooMany},
+		{name: "negative", quantities: map[uuid.UUID]int{table: -1}, err: ErrTooMany},
+		{name: "not ordered", quantities: map[uuid.UUID]int{uuid.New(): 1}, err: ErrTooMany},
+		{name: "nothing", quantities: map[uuid.UUID]int{chair: 0}, err: ErrNoItems},
+		{name: "partially refunded", status: models.OrderStatusPartiallyRefunded, quantities: map[uuid.UUID]int{table: 1},
+			want: []models.ReturnItem{{ProductID: table, ProductName: "Table", Quantity: 1, Amount: 100}}},
+		{name: "not paid", status: models.OrderStatusOrdered, quantities: map[uuid.UUID]int{table: 1}, err: ErrNotReturnable},
+		{name: "refunded", status: models.OrderStatusRefunded, quantities: map[uuid.UUID]int{table: 1}, err: ErrNotReturnable},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			order := order
+			if tc.status != "" {
+				order.OrderStatus = tc.status
+			}
+			got, err := Items(order, previous, tc.quantities)
+			if !errors.Is(err, tc.err) {
+				t.Fatalf("Items error = %v, want %v", err, tc.err)
+			}
+			if len(got) != len(tc.want) {
+				t.Fatalf("Items = %+v, want %+v", got, tc.want)
+			}
+			for i := range got {
+				if got[i] != tc.want[i] {
+					t.Errorf("item %d = %+v, want %+v", i, got[i], tc.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRefundedStatus(t *testing.T) {
+	order := models.Order{
+		Items:            []models.OrderItem{{Quantity: 1, Cost: 100}},
+		ShippingCost:     5,
+		PricesIncludeTax: true,
+	}
+
+	tests := []struct {
+		refunded float64
+		want     string
+	}{
+		{40, models.OrderStatusPartiallyRefunded},
+		{100, models.OrderStatusPartiallyRefunded},
+		{105, models.OrderStatusRefunded},
+		{104.999, models.OrderStatusRefunded},
+	}
+	for _, tc := range tests {
+		if got := RefundedStatus(order, tc.refunded); got != tc.want {
+			t.Errorf("RefundedStatus(%v) = %q, want %q", tc.refunded, got, tc.want)
+		}
+	}
+}
+
+func TestRefundable(t *testing.T) {
+	order := models.Order{
+		Items:            []models.OrderItem{{Quantity: 2, Cost: 200}},
+		ShippingCost:     5,
+		PricesIncludeTax: true,
+	}
+	ret := models.Return{Items: []models.ReturnItem{{Quantity: 1, Amount: 100}}}
+
+	tests := []struct {
+		name     string
+		refunded float64
+		want     float64
+	}{
+		{"nothing refunded", 0, 100},
+		{"less left of the order", 150, 55},
+		{"order refunded in full", 205, 0},
+	}
+	for _, tc := range tests {
+		order.RefundedAmount = tc.refunded
+		if got := Refundable(order, ret); got != tc.want {
+			t.Errorf("%s: Refundable = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
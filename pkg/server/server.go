@@ -0,0 +1,108 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/snirkop89/mx-store/pkg/config"
+)
+
+type Server struct {
+	HTTP   *http.Server
+	Config config.Server
+	certs  *CertReloader
+}
+
+func New(cfg config.Server, handler http.Handler) (*Server, error) {
+	srv := &Server{
+		HTTP: &http.Server{
+			Addr:              cfg.Addr,
+			Handler:           handler,
+			ReadTimeout:       cfg.ReadTimeout,
+			ReadHeaderTimeout: cfg.ReadHeaderTimeout,
+			WriteTimeout:      cfg.WriteTimeout,
+			IdleTimeout:       cfg.IdleTimeout,
+		},
+		Config: cfg,
+	}
+
+	if cfg.TLSCertFile != "" {
+		certs, err := NewCertReloader(cfg.TLSCertFile, cfg.TLSKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		srv.certs = certs
+		srv.HTTP.TLSConfig = &tls.Config{
+			MinVersion:     tls.VersionTLS12,
+			GetCertificate: certs.GetCertificate,
+		}
+	}
+
+	return srv, nil
+}
+
+// Run serves requests until ctx is cancelled or SIGINT/SIGTERM is received,
+// then stops accepting connections and waits up to the configured shutdown
+// timeout for in-flight requests to finish. SIGHUP reloads the TLS
+// certificate without dropping connections.
+func (s *Server) Run(ctx context.Context) error {
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	if s.certs != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		defer signal.Stop(hup)
+		go func() {
+			for {
+				select {
+				case <-hup:
+					if err := s.certs.Reload(); err != nil {
+						slog.Error("Reloading TLS certificate", "err", err)
+						continue
+					}
+					slog.Info("Reloaded TLS certificate")
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	errCh := make(chan error, 1)
+	go func() {
+		var err error
+		if s.certs != nil {
+			slog.Info("Starting server", "addr", s.Config.Addr, "tls", true)
+			err = s.HTTP.ListenAndServeTLS("", "")
+		} else {
+			slog.Info("Starting server", "addr", s.Config.Addr)
+			err = s.HTTP.ListenAndServe()
+		}
+		errCh <- err
+	}()
+
+	select {
+	case err := <-errCh:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("Shutting down server", "timeout", s.Config.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Config.ShutdownTimeout)
+	defer cancel()
+
+	if err := s.HTTP.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
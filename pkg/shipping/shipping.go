@@ -0,0 +1,59 @@
+// Package shipping prices the shipping methods for a cart.
+package shipping
+
+import (
+	"math"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// Weight is what items weigh together, in kilograms.
+func Weight(items []models.OrderItem) float64 {
+	var weight float64
+	for _, item := range items {
+		weight += float64(item.Quantity) * item.Product.Weight
+	}
+	return weight
+}
+
+// Cost prices method for items. subtotal is what the items cost after
+// discounts and decides whether the cart ships for free.
+func Cost(method models.ShippingMethod, items []models.OrderItem, subtotal float64) float64 {
+	if method.FreeOver != nil && subtotal >= *method.FreeOver {
+		return 0
+	}
+	switch method.Kind {
+	case models.ShippingWeight:
+		return round(method.Price + method.PerKg*Weight(items))
+	default:
+		return round(method.Price)
+	}
+}
+
+// Quote is a shipping method with its cost for a cart.
+type Quote struct {
+	Method models.ShippingMethod
+	Cost   float64
+}
+
+// Free reports whether the cart ships for free, rather than the method
+// costing nothing.
+func (q Quote) Free() bool {
+	return q.Cost == 0 && q.Method.FreeOver != nil
+}
+
+// Quotes prices every active method in methods for items.
+func Quotes(methods []models.ShippingMethod, items []models.OrderItem, subtotal float64) []Quote {
+	var quotes []Quote
+	for _, method := range methods {
+		if !method.Active {
+			continue
+		}
+		quotes = append(quotes, Quote{Method: method, Cost: Cost(method, items, subtotal)})
+	}
+	return quotes
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
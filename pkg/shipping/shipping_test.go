@@ -0,0 +1,54 @@
+package shipping
+
+import (
+	"testing"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+func TestCost(t *testing.T) {
+	items := []models.OrderItem{
+		{Quantity: 2, Cost: 20, Product: models.Product{Weight: 1.25}},
+		{Quantity: 1, Cost: 30, Product: models.Product{Weight: 0.5}},
+		{Quantity: 3, Cost: 15},
+	}
+	threshold := 60.0
+
+	tests := []struct {
+		name     string
+		method   models.ShippingMethod
+		subtotal float64
+		want     float64
+	}{
+		{"flat", models.ShippingMethod{Kind: models.ShippingFlat, Price: 4.99}, 65, 4.99},
+		{"flat ignores per kg", models.ShippingMethod{Kind: models.ShippingFlat, Price: 5, PerKg: 2}, 65, 5},
+		{"weight", models.ShippingMethod{Kind: models.ShippingWeight, Price: 3, PerKg: 2}, 65, 9},
+		{"weight rounded to the cent", models.ShippingMethod{Kind: models.ShippingWeight, PerKg: 0.333}, 65, 1},
+		{"below threshold", models.ShippingMethod{Kind: models.ShippingFlat, Price: 5, FreeOver: &threshold}, 59.99, 5},
+		{"at threshold", models.ShippingMethod{Kind: models.ShippingWeight, Price: 3, PerKg: 2, FreeOver: &threshold}, 60, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := Cost(tc.method, items, tc.subtotal); got != tc.want {
+				t.Fatalf("Cost = %v, want %v", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestQuotes(t *testing.T) {
+	threshold := 10.0
+	methods := []models.ShippingMethod{
+		{Name: "Standard", Kind: models.ShippingFlat, Price: 5, FreeOver: &threshold, Active: true},
+		{Name: "Retired", Kind: models.ShippingFlat, Price: 1},
+		{Name: "Pickup", Kind: models.ShippingFlat, Active: true},
+	}
+
+	quotes := Quotes(methods, nil, 20)
+	if len(quotes) != 2 || quotes[0].Method.Name != "Standard" || quotes[1].Method.Name != "Pickup" {
+		t.Fatalf("Quotes = %+v, want the active methods", quotes)
+	}
+	if !quotes[0].Free() || quotes[1].Free() {
+		t.Fatalf("Free = %v, %v, want true, false", quotes[0].Free(), quotes[1].Free())
+	}
+}
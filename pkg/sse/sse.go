@@ -0,0 +1,250 @@
+// Package sse streams live events to browsers as server-sent events.
+//
+// A Hub fans every published event out to the clients subscribed to its
+// topic. Each client has a buffer of its own, so a slow client never holds
+// the others up: one that falls behind is disconnected, and its browser
+// reconnects with the ID of the last event it got in the Last-Event-ID
+// header. The hub keeps the latest events to replay what the client missed
+// from. When they are no longer kept, or the client was connected to an
+// earlier run of the server, it is sent a ResyncEvent instead, to reload
+// what it shows.
+package sse
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/config"
+)
+
+// ResyncEvent tells a client that it missed events that cannot be replayed.
+const ResyncEvent = "resync"
+
+// Event is a server-sent event. Name is the event type the browser
+// dispatches and Data, usually HTML, what it carries.
+type Event struct {
+	ID    string
+	Topic string
+	Name  string
+	Data  string
+}
+
+type entry struct {
+	seq   uint64
+	event Event
+}
+
+// Hub fans events out to the clients streaming them.
+type Hub struct {
+	History      int
+	ClientBuffer int
+	Heartbeat    time.Duration
+	Retry        time.Duration
+
+	mu sync.Mutex
+	// run tells the event IDs of this hub apart from those of an earlier
+	// run of the server, as each starts counting from zero.
+	run     string
+	seq     uint64
+	history []entry
+	subs    map[*Subscription]struct{}
+	closed  bool
+}
+
+func NewHub(cfg config.Events) *Hub {
+	return &Hub{
+		History:      cfg.History,
+		ClientBuffer: cfg.ClientBuffer,
+		Heartbeat:    cfg.Heartbeat,
+		Retry:        cfg.Retry,
+		run:          strconv.FormatInt(time.Now().UnixNano(), 36),
+		subs:         map[*Subscription]struct{}{},
+	}
+}
+
+// Subscription is a client's stream of events. C is closed when the client
+// fell behind or the hub was closed.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	topics []string
+}
+
+// Publish sends an event to the clients subscribed to topic and keeps it for
+// the clients that reconnect. Clients whose buffer is full are dropped.
+func (h *Hub) Publish(topic, name, data string) Event {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.seq++
+	event := Event{ID: h.id(h.seq), Topic: topic, Name: name, Data: data}
+	h.history = append(h.history, entry{seq: h.seq, event: event})
+	if len(h.history) > h.History {
+		h.history = slices.Delete(h.history, 0, len(h.history)-h.History)
+	}
+	for sub := range h.subs {
+		if !slices.Contains(sub.topics, topic) {
+			continue
+		}
+		select {
+		case sub.c <- event:
+		default:
+			slog.Warn("Dropping slow event stream client", "topics", sub.topics, "event", event.ID)
+			h.drop(sub)
+		}
+	}
+	return event
+}
+
+// Subscribe starts a stream of the events of topics. With the ID of the
+// last event a client got, the events since are sent first, or a
+// ResyncEvent when they are no longer kept.
+func (h *Hub) Subscribe(lastEventID string, topics ...string) *Subscription {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	var replay []Event
+	if lastEventID != "" {
+		var ok bool
+		replay, ok = h.since(lastEventID, topics)
+		if !ok {
+			replay = []Event{{ID: h.id(h.seq), Name: ResyncEvent, Data: ResyncEvent}}
+		}
+	}
+	c := make(chan Event, h.ClientBuffer+len(replay))
+	for _, event := range replay {
+		c <- event
+	}
+	sub := &Subscription{C: c, c: c, topics: topics}
+	if h.closed {
+		close(c)
+		return sub
+	}
+	h.subs[sub] = struct{}{}
+	return sub
+}
+
+// since returns the kept events of topics published after lastEventID. It
+// reports false when some of them are no longer kept.
+func (h *Hub) since(lastEventID string, topics []string) ([]Event, bool) {
+	run, seqText, found := strings.Cut(lastEventID, "-")
+	if !found || run != h.run {
+		return nil, false
+	}
+	seq, err := strconv.ParseUint(seqText, 10, 64)
+	if err != nil || seq > h.seq {
+		return nil, false
+	}
+	if seq == h.seq {
+		return nil, true
+	}
+	if len(h.history) == 0 || h.history[0].seq > seq+1 {
+		return nil, false
+	}
+	var events []Event
+	for _, e := range h.history {
+		if e.seq > seq && slices.Contains(topics, e.event.Topic) {
+			events = append(events, e.event)
+		}
+	}
+	return events, true
+}
+
+func (h *Hub) id(seq uint64) string {
+	return h.run + "-" + strconv.FormatUint(seq, 10)
+}
+
+// Unsubscribe ends a stream.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.drop(sub)
+}
+
+func (h *Hub) drop(sub *Subscription) {
+	if _, ok := h.subs[sub]; !ok {
+		return
+	}
+	delete(h.subs, sub)
+	close(sub.c)
+}
+
+// Close ends every stream, so that the server can shut down without waiting
+// for them. Browsers reconnect to the next run of the server.
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.closed = true
+	for sub := range h.subs {
+		h.drop(sub)
+	}
+}
+
+// Serve streams the events of topics to the client of r until it goes
+// away, falls behind or the hub is closed.
+func (h *Hub) Serve(w http.ResponseWriter, r *http.Request, topics ...string) {
+	rc := http.NewResponseController(w)
+	// The stream outlives the server's write timeout
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	sub := h.Subscribe(r.Header.Get("Last-Event-ID"), topics...)
+	defer h.Unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep proxies like nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", h.Retry.Milliseconds()); err != nil {
+		return
+	}
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	heartbeat := time.NewTicker(h.Heartbeat)
+	defer heartbeat.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case event, ok := <-sub.C:
+			if !ok {
+				return
+			}
+			err = write(w, event)
+		case <-heartbeat.C:
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// write writes an event in the text/event-stream format.
+func write(w http.ResponseWriter, event Event) error {
+	var b strings.Builder
+	fmt.Fprintf(&b, "id: %s\nevent: %s\n", event.ID, event.Name)
+	for _, line := range strings.Split(event.Data, "\n") {
+		fmt.Fprintf(&b, "data: %s\n", strings.TrimSuffix(line, "\r"))
+	}
+	b.WriteString("\n")
+	_, err := fmt.Fprint(w, b.String())
+	return err
+}
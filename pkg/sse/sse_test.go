@@ -0,0 +1,156 @@
+package sse
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/config"
+)
+
+func newTestHub(history, buffer int) *Hub {
+	cfg := config.Default().Events
+	cfg.History = history
+	cfg.ClientBuffer = buffer
+	return NewHub(cfg)
+}
+
+// received drains the events a subscription has buffered.
+func received(sub *Subscription) []Event {
+	var events []Event
+	for {
+		select {
+		case event, ok := <-sub.C:
+			if !ok {
+				return events
+			}
+			events = append(events, event)
+		default:
+			return events
+		}
+	}
+}
+
+func names(events []Event) string {
+	var names []string
+	for _, event := range events {
+		names = append(names, event.Name)
+	}
+	return strings.Join(names, ",")
+}
+
+func TestSubscribeReplay(t *testing.T) {
+	hub := newTestHub(3, 10)
+	var ids []string
+	for _, name := range []string{"a", "b", "c", "d"} {
+		ids = append(ids, hub.Publish("store", name, name).ID)
+	}
+	hub.Publish("admin", "order", "order")
+
+	earlier := newTestHub(3, 10)
+	earlier.Publish("store", "a", "a")
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        string
+	}{
+		{"new client", "", ""},
+		{"up to date", hub.id(hub.seq), ""},
+		{"missed some", ids[2], "d"},
+		{"missed what is kept", ids[1], "c,d"},
+		{"missed more than is kept", ids[0], ResyncEvent},
+		{"earlier run", earlier.id(1), ResyncEvent},
+		{"unknown", "nonsense", ResyncEvent},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			sub := hub.Subscribe(tc.lastEventID, "store")
+			defer hub.Unsubscribe(sub)
+			if got := names(received(sub)); got != tc.want {
+				t.Fatalf("replayed %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestSlowClient(t *testing.T) {
+	hub := newTestHub(10, 2)
+	slow := hub.Subscribe("", "store")
+	other := hub.Subscribe("", "admin")
+	for _, name := range []string{"a", "b", "c"} {
+		hub.Publish("store", name, name)
+	}
+
+	events := received(slow)
+	if names(events) != "a,b" {
+		t.Fatalf("slow client got %q, want what fit its buffer", names(events))
+	}
+	if _, ok := <-slow.C; ok {
+		t.Fatal("slow client was not dropped")
+	}
+	// It catches up when it reconnects
+	again := hub.Subscribe(events[len(events)-1].ID, "store")
+	if got := names(received(again)); got != "c" {
+		t.Fatalf("reconnected client got %q, want the event it missed", got)
+	}
+
+	hub.Close()
+	if _, ok := <-other.C; ok {
+		t.Fatal("closing the hub does not end the streams")
+	}
+}
+
+func TestServe(t *testing.T) {
+	hub := newTestHub(10, 10)
+	first := hub.Publish("store", "product-1", "old")
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hub.Serve(w, r, "store")
+	}))
+	defer srv.Close()
+
+	req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Last-Event-ID", first.ID)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("Content-Type = %q", ct)
+	}
+
+	// Wait for the client to be subscribed
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		hub.mu.Lock()
+		subscribed := len(hub.subs)
+		hub.mu.Unlock()
+		if subscribed == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("client never subscribed")
+		}
+	}
+	second := hub.Publish("store", "product-1", "<p>$25</p>\n<p>Out of stock</p>")
+	hub.Close()
+
+	var lines []string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	want := []string{
+		"retry: 3000", "",
+		"id: " + second.ID, "event: product-1", "data: <p>$25</p>", "data: <p>Out of stock</p>", "",
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Fatalf("stream =\n%s\nwant\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,118 @@
+// Package tax calculates the tax on a cart.
+//
+// Tax is charged per line on what the customer pays for it, so promotions
+// and coupon discounts lower the tax. A coupon discount is spread over the
+// lines by their cost. With inclusive pricing the tax is the part of the
+// line cost that is tax, otherwise it comes on top of it.
+package tax
+
+import (
+	"math"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+// Region is where the tax is charged. An empty Country charges no tax.
+type Region struct {
+	Country string
+	State   string
+}
+
+// Lookup finds the rate for class in region. A state without its own rate
+// falls back to the rate of its country.
+func Lookup(rates []models.TaxRate, region Region, class models.TaxClass) (models.TaxRate, bool) {
+	if class == "" {
+		class = models.TaxClassStandard
+	}
+	if region.Country == "" || class == models.TaxClassExempt {
+		return models.TaxRate{}, false
+	}
+	country, state := models.NormalizeRegion(region.Country), models.NormalizeRegion(region.State)
+
+	var fallback *models.TaxRate
+	for i, rate := range rates {
+		if rate.Country != country || rate.TaxClass != class {
+			continue
+		}
+		if state != "" && rate.State == state {
+			return rate, true
+		}
+		if rate.State == "" {
+			fallback = &rates[i]
+		}
+	}
+	if fallback != nil {
+		return *fallback, true
+	}
+	return models.TaxRate{}, false
+}
+
+// Result is a cart with tax.
+type Result struct {
+	// Items are copies of the cart items with Tax, TaxRate and TaxName set.
+	Items []models.OrderItem
+	Tax   float64
+}
+
+// Lines breaks the tax down by rate.
+func (r Result) Lines() []models.TaxLine {
+	return models.TaxBreakdown(r.Items)
+}
+
+// Apply taxes items in region. discount is the coupon discount on the items,
+// whose Cost already has the promotions taken off.
+func Apply(rates []models.TaxRate, region Region, inclusive bool, items []models.OrderItem, discount float64) Result {
+	result := Result{Items: make([]models.OrderItem, len(items))}
+	shares := spread(items, discount)
+	for i, item := range items {
+		item.Tax, item.TaxRate, item.TaxName = 0, 0, ""
+		if rate, ok := Lookup(rates, region, item.Product.TaxClass); ok && rate.Rate > 0 {
+			taxable := max(item.Cost-shares[i], 0)
+			if inclusive {
+				item.Tax = round(taxable * rate.Rate / (100 + rate.Rate))
+			} else {
+				item.Tax = round(taxable * rate.Rate / 100)
+			}
+			item.TaxRate, item.TaxName = rate.Rate, rate.Name
+		}
+		result.Items[i] = item
+		result.Tax = round(result.Tax + item.Tax)
+	}
+	return result
+}
+
+// spread divides discount over items by their cost. The last line with a
+// cost takes the rounding remainder so the shares add up to discount.
+func spread(items []models.OrderItem, discount float64) []float64 {
+	shares := make([]float64, len(items))
+	var total float64
+	last := -1
+	for i, item := range items {
+		if item.Cost > 0 {
+			total += item.Cost
+			last = i
+		}
+	}
+	if discount <= 0 || total <= 0 {
+		return shares
+	}
+
+	discount = round(min(discount, total))
+	remaining := discount
+	for i, item := range items {
+		if item.Cost <= 0 {
+			continue
+		}
+		share := round(discount * item.Cost / total)
+		if i == last {
+			share = remaining
+		}
+		shares[i] = share
+		remaining = round(remaining - share)
+	}
+	return shares
+}
+
+func round(v float64) float64 {
+	return math.Round(v*100) / 100
+}
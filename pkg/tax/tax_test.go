@@ -0,0 +1,89 @@
+package tax
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/snirkop89/mx-store/pkg/models"
+)
+
+func TestLookup(t *testing.T) {
+	rates := []models.TaxRate{
+		{Country: "US", TaxClass: models.TaxClassStandard, Name: "Sales tax", Rate: 5},
+		{Country: "US", State: "NY", TaxClass: models.TaxClassStandard, Name: "Sales tax", Rate: 8.875},
+		{Country: "DE", TaxClass: models.TaxClassStandard, Name: "VAT", Rate: 19},
+		{Country: "DE", TaxClass: models.TaxClassReduced, Name: "VAT", Rate: 7},
+	}
+
+	tests := []struct {
+		name   string
+		region Region
+		class  models.TaxClass
+		want   float64
+		found  bool
+	}{
+		{"state rate", Region{"us", "ny"}, models.TaxClassStandard, 8.875, true},
+		{"country fallback", Region{"US", "TX"}, models.TaxClassStandard, 5, true},
+		{"no state", Region{"US", ""}, models.TaxClassStandard, 5, true},
+		{"class", Region{"DE", ""}, models.TaxClassReduced, 7, true},
+		{"default class", Region{"DE", ""}, "", 19, true},
+		{"class without rate", Region{"US", "NY"}, models.TaxClassReduced, 0, false},
+		{"exempt", Region{"DE", ""}, models.TaxClassExempt, 0, false},
+		{"unknown country", Region{"FR", ""}, models.TaxClassStandard, 0, false},
+		{"no region", Region{}, models.TaxClassStandard, 0, false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rate, found := Lookup(rates, tc.region, tc.class)
+			if found != tc.found || rate.Rate != tc.want {
+				t.Fatalf("Lookup = %v, %v, want %v, %v", rate.Rate, found, tc.want, tc.found)
+			}
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	rates := []models.TaxRate{
+		{Country: "DE", TaxClass: models.TaxClassStandard, Name: "VAT", Rate: 20},
+		{Country: "DE", TaxClass: models.TaxClassReduced, Name: "VAT", Rate: 10},
+	}
+	items := []models.OrderItem{
+		{Quantity: 1, Cost: 60, Product: models.Product{TaxClass: models.TaxClassStandard}},
+		{Quantity: 2, Cost: 40, Product: models.Product{TaxClass: models.TaxClassReduced}},
+		{Quantity: 1, Cost: 10, Product: models.Product{TaxClass: models.TaxClassExempt}},
+	}
+
+	tests := []struct {
+		name      string
+		inclusive bool
+		discount  float64
+		taxes     []float64
+		total     float64
+	}{
+		{"exclusive", false, 0, []float64{12, 4, 0}, 16},
+		{"inclusive", true, 0, []float64{10, 3.64, 0}, 13.64},
+		{"discount lowers the tax", false, 11, []float64{10.8, 3.6, 0}, 14.4},
+		{"discount over the cart", false, 500, []float64{0, 0, 0}, 0},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := Apply(rates, Region{Country: "DE"}, tc.inclusive, items, tc.discount)
+			var taxes []float64
+			for _, item := range got.Items {
+				taxes = append(taxes, item.Tax)
+			}
+			if !slices.Equal(taxes, tc.taxes) {
+				t.Fatalf("taxes = %v, want %v", taxes, tc.taxes)
+			}
+			if got.Tax != tc.total {
+				t.Fatalf("Tax = %v, want %v", got.Tax, tc.total)
+			}
+		})
+	}
+
+	got := Apply(rates, Region{Country: "DE"}, false, items, 0)
+	lines := got.Lines()
+	if len(lines) != 2 || lines[0].Label() != "VAT 20%" || lines[1].Amount != 4 {
+		t.Fatalf("Lines = %+v, want VAT at 20%% and 10%%", lines)
+	}
+}
@@ -0,0 +1,191 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+// Order is the data of order events.
+type Order struct {
+	ID     uuid.UUID `json:"id"`
+	UserID string    `json:"user_id"`
+	Status string    `json:"status"`
+	// PreviousStatus is set on order.status_changed.
+	PreviousStatus  string      `json:"previous_status,omitempty"`
+	OrderDate       time.Time   `json:"order_date"`
+	Items           []OrderItem `json:"items"`
+	CouponCode      string      `json:"coupon_code,omitempty"`
+	Subtotal        float64     `json:"subtotal"`
+	Discount        float64     `json:"discount"`
+	Tax             float64     `json:"tax"`
+	ShippingMethod  string      `json:"shipping_method,omitempty"`
+	ShippingCost    float64     `json:"shipping_cost"`
+	Total           float64     `json:"total"`
+	RefundedAmount  float64     `json:"refunded_amount"`
+	ShippingAddress *Address    `json:"shipping_address,omitempty"`
+}
+
+type OrderItem struct {
+	ProductID   uuid.UUID `json:"product_id"`
+	ProductName string    `json:"product_name"`
+	Quantity    int       `json:"quantity"`
+	Cost        float64   `json:"cost"`
+	Tax         float64   `json:"tax"`
+}
+
+type Address struct {
+	Name       string `json:"name"`
+	Line1      string `json:"line1"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city"`
+	State      string `json:"state,omitempty"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+	Phone      string `json:"phone,omitempty"`
+}
+
+// Product is the data of product events.
+type Product struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Price       float64   `json:"price"`
+	Status      string    `json:"status"`
+	TaxClass    string    `json:"tax_class"`
+	Category    string    `json:"category"`
+	Weight      float64   `json:"weight"`
+	// Stock is null for products whose stock is not tracked.
+	Stock      *int       `json:"stock"`
+	Version    int        `json:"version"`
+	ModifiedAt time.Time  `json:"modified_at"`
+	DeletedAt  *time.Time `json:"deleted_at,omitempty"`
+}
+
+func newOrder(order models.Order) Order {
+	data := Order{
+		ID:             order.OrderID,
+		UserID:         order.UserID,
+		Status:         order.OrderStatus,
+		OrderDate:      order.OrderDate,
+		Items:          []OrderItem{},
+		CouponCode:     order.CouponCode,
+		Subtotal:       order.Subtotal(),
+		Discount:       order.Discount,
+		Tax:            order.Tax,
+		ShippingMethod: order.ShippingMethod,
+		ShippingCost:   order.ShippingCost,
+		Total:          order.Total(),
+		RefundedAmount: order.RefundedAmount,
+	}
+	for _, item := range order.Items {
+		data.Items = append(data.Items, OrderItem{
+			ProductID:   item.ProductID,
+			ProductName: item.Product.ProductName,
+			Quantity:    item.Quantity,
+			Cost:        item.Cost,
+			Tax:         item.Tax,
+		})
+	}
+	if a := order.ShippingAddress; a != nil {
+		data.ShippingAddress = &Address{
+			Name:       a.Name,
+			Line1:      a.Line1,
+			Line2:      a.Line2,
+			City:       a.City,
+			State:      a.State,
+			PostalCode: a.PostalCode,
+			Country:    a.Country,
+			Phone:      a.Phone,
+		}
+	}
+	return data
+}
+
+func newProduct(product models.Product) Product {
+	return Product{
+		ID:          product.ProductID,
+		Name:        product.ProductName,
+		Description: product.Description,
+		Price:       product.Price,
+		Status:      string(product.Status),
+		TaxClass:    string(product.TaxClass),
+		Category:    product.Category,
+		Weight:      product.Weight,
+		Stock:       product.Stock,
+		Version:     product.Version,
+		ModifiedAt:  product.DateModified,
+		DeletedAt:   product.DeletedAt,
+	}
+}
+
+// Sink publishes the domain events of the outbox that subscribers are told
+// about. Orders and products are sent as they are when the event is
+// published, with the status an order event is about.
+type Sink struct {
+	Dispatcher *Dispatcher
+	Orders     repository.OrderStore
+	Products   repository.ProductStore
+}
+
+func NewSink(d *Dispatcher, repo *repository.Repository) *Sink {
+	return &Sink{Dispatcher: d, Orders: repo.Order, Products: repo.Product}
+}
+
+// Publish turns a domain event into a webhook event. Orders are created for
+// subscribers once they are confirmed, so orders waiting for payment are
+// only told about when their payment goes through. Stock changes are
+// product updates.
+func (s *Sink) Publish(ctx context.Context, event models.OutboxEvent) error {
+	id := strconv.FormatInt(event.EventID, 10)
+	switch event.Type {
+	case models.EventOrderPlaced, models.EventOrderStatusChanged:
+		var data models.OrderEvent
+		if err := event.Decode(&data); err != nil {
+			return err
+		}
+		order, err := s.Orders.GetOrderWithProducts(ctx, data.OrderID)
+		if err != nil {
+			return err
+		}
+		order.OrderStatus = data.Status
+		confirmed := data.Status == models.OrderStatusOrdered || data.Status == models.OrderStatusPaid
+		if event.Type == models.EventOrderPlaced || data.PreviousStatus == models.OrderStatusPendingPayment {
+			if !confirmed {
+				return nil
+			}
+			return s.Dispatcher.Publish(ctx, id, models.WebhookOrderCreated, newOrder(*order))
+		}
+		payload := newOrder(*order)
+		payload.PreviousStatus = data.PreviousStatus
+		return s.Dispatcher.Publish(ctx, id, models.WebhookOrderStatusChanged, payload)
+
+	case models.EventProductUpdated, models.EventStockChanged, models.EventProductArchived:
+		var data models.ProductEvent
+		if err := event.Decode(&data); err != nil {
+			return err
+		}
+		product, err := s.Products.GetProductByID(ctx, data.ProductID)
+		if errors.Is(err, repository.ErrNotFound) {
+			// Purged since
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if event.Type == models.EventProductArchived {
+			return s.Dispatcher.Publish(ctx, id, models.WebhookProductDeleted, newProduct(*product))
+		}
+		if product.Archived() {
+			// Subscribers were told it is deleted
+			return nil
+		}
+		return s.Dispatcher.Publish(ctx, id, models.WebhookProductUpdated, newProduct(*product))
+	}
+	return nil
+}
@@ -0,0 +1,204 @@
+// Package webhooks sends the store's events to the URLs subscribed to them.
+// The events come from the outbox, through a Sink.
+//
+// Every event is stored as a delivery for each active subscription to it
+// and sent by a background job, which retries it while the subscriber fails
+// to accept it. Deliveries are signed like the payment provider's webhooks:
+// the SignatureHeader is "t=<unix time>,v1=<hex HMAC-SHA256 of
+// time.body>", made with the secret of the subscription.
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/snirkop89/mx-store/pkg/jobs"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/payments"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+// Headers of every delivery. The delivery header stays the same when a
+// delivery is sent again, so that subscribers can tell it apart.
+const (
+	SignatureHeader = "MX-Store-Signature"
+	EventHeader     = "MX-Store-Event"
+	DeliveryHeader  = "MX-Store-Delivery"
+)
+
+// DeliverJob is the job that sends a delivery.
+const DeliverJob = "webhooks.deliver"
+
+// sendTimeout bounds a single attempt to send a delivery.
+const sendTimeout = 30 * time.Second
+
+// Dispatcher publishes events to the subscriptions in Store.
+type Dispatcher struct {
+	Store  repository.WebhookStore
+	Jobs   *jobs.Queue
+	Client *http.Client
+}
+
+// New creates a dispatcher whose deliveries are sent by jobs in q.
+func New(store repository.WebhookStore, q *jobs.Queue) *Dispatcher {
+	d := &Dispatcher{Store: store, Jobs: q, Client: &http.Client{Timeout: sendTimeout}}
+	q.Handle(DeliverJob, d.deliver)
+	return d
+}
+
+// Envelope is the body of a delivery.
+type Envelope struct {
+	ID        string              `json:"id"`
+	Type      models.WebhookEvent `json:"type"`
+	CreatedAt time.Time           `json:"created_at"`
+	Data      any                 `json:"data"`
+}
+
+// deliverPayload is the payload of DeliverJob.
+type deliverPayload struct {
+	DeliveryID int64 `json:"delivery_id"`
+}
+
+// Publish queues a delivery of event with data to every active subscription
+// to it. id identifies the event to subscribers, publishing an event again
+// has to keep it. Subscriptions that have a delivery of the event already
+// are skipped.
+func (d *Dispatcher) Publish(ctx context.Context, id string, event models.WebhookEvent, data any) error {
+	subs, err := d.Store.ListWebhookSubscriptions(ctx)
+	if err != nil {
+		return err
+	}
+	envelope := Envelope{ID: id, Type: event, CreatedAt: time.Now().UTC(), Data: data}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, sub := range subs {
+		if !sub.Active || !sub.Subscribes(event) {
+			continue
+		}
+		delivery := models.WebhookDelivery{
+			SubscriptionID: sub.SubscriptionID,
+			EventID:        envelope.ID,
+			Event:          event,
+			Payload:        payload,
+			Status:         models.WebhookDeliveryPending,
+		}
+		err := d.Store.CreateWebhookDelivery(ctx, &delivery)
+		if errors.Is(err, repository.ErrDuplicateDelivery) {
+			continue
+		}
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := d.enqueue(ctx, delivery.DeliveryID); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Redeliver sends a delivery again, whatever happened to it before.
+func (d *Dispatcher) Redeliver(ctx context.Context, deliveryID int64) error {
+	delivery, err := d.Store.GetWebhookDelivery(ctx, deliveryID)
+	if err != nil {
+		return err
+	}
+	delivery.Status = models.WebhookDeliveryPending
+	if err := d.Store.UpdateWebhookDelivery(ctx, delivery); err != nil {
+		return err
+	}
+	return d.enqueue(ctx, deliveryID)
+}
+
+func (d *Dispatcher) enqueue(ctx context.Context, deliveryID int64) error {
+	_, err := d.Jobs.Enqueue(ctx, DeliverJob, deliverPayload{DeliveryID: deliveryID})
+	return err
+}
+
+// deliver sends the delivery of a job and records how that went. A failed
+// delivery stays pending while the job has attempts left.
+func (d *Dispatcher) deliver(ctx context.Context, job *models.Job) error {
+	var payload deliverPayload
+	if err := json.Unmarshal(job.Payload, &payload); err != nil {
+		return jobs.Permanent(fmt.Errorf("decode payload: %w", err))
+	}
+	delivery, err := d.Store.GetWebhookDelivery(ctx, payload.DeliveryID)
+	if errors.Is(err, repository.ErrNotFound) {
+		// The subscription was deleted with its deliveries
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if delivery.Status == models.WebhookDeliveryDelivered {
+		return nil
+	}
+	sub, err := d.Store.GetWebhookSubscription(ctx, delivery.SubscriptionID)
+	if err != nil {
+		return err
+	}
+
+	if sub.Active {
+		delivery.Attempts++
+		delivery.ResponseStatus, err = d.send(ctx, sub, delivery)
+	} else {
+		err = jobs.Permanent(errors.New("subscription is disabled"))
+	}
+	switch {
+	case err == nil:
+		now := time.Now()
+		delivery.Status = models.WebhookDeliveryDelivered
+		delivery.LastError = ""
+		delivery.DeliveredAt = &now
+	case jobs.IsPermanent(err) || job.Attempts >= job.MaxAttempts:
+		delivery.Status = models.WebhookDeliveryFailed
+		delivery.LastError = err.Error()
+	default:
+		delivery.LastError = err.Error()
+	}
+	if updateErr := d.Store.UpdateWebhookDelivery(ctx, delivery); updateErr != nil {
+		return errors.Join(err, updateErr)
+	}
+	if err == nil {
+		slog.Info("Delivered webhook", "delivery", delivery.DeliveryID, "event", delivery.Event, "url", sub.URL)
+	}
+	return err
+}
+
+// send posts a delivery to the subscription and returns the response
+// status. Any status other than 2xx fails the attempt.
+func (d *Dispatcher) send(ctx context.Context, sub *models.WebhookSubscription, delivery *models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, sub.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, jobs.Permanent(err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "MX-Store-Webhooks")
+	req.Header.Set(EventHeader, string(delivery.Event))
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.DeliveryID, 10))
+	req.Header.Set(SignatureHeader, payments.Sign(sub.Secret, delivery.Payload, time.Now()))
+
+	resp, err := d.Client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s responded %s", sub.URL, resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,311 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/snirkop89/mx-store/pkg/config"
+	"github.com/snirkop89/mx-store/pkg/jobs"
+	"github.com/snirkop89/mx-store/pkg/models"
+	"github.com/snirkop89/mx-store/pkg/payments"
+	"github.com/snirkop89/mx-store/pkg/repository"
+)
+
+// receiver is a subscriber that fails the first failures deliveries.
+type receiver struct {
+	mu       sync.Mutex
+	failures int
+	received []*http.Request
+	bodies   [][]byte
+}
+
+func (rc *receiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	rc.received = append(rc.received, r)
+	rc.bodies = append(rc.bodies, body)
+	if len(rc.received) <= rc.failures {
+		http.Error(w, "busy", http.StatusServiceUnavailable)
+	}
+}
+
+func newTestDispatcher(t *testing.T, maxAttempts int) (*Dispatcher, *jobs.Queue, repository.WebhookStore) {
+	t.Helper()
+	repo := repository.NewMemoryRepository()
+	cfg := config.Default().Jobs
+	cfg.MaxAttempts = maxAttempts
+	cfg.RetryBackoff = time.Nanosecond
+	q := jobs.New(repo.Job, cfg)
+	return New(repo.Webhook, q), q, repo.Webhook
+}
+
+func subscribe(t *testing.T, store repository.WebhookStore, url string, active bool, events ...models.WebhookEvent) models.WebhookSubscription {
+	t.Helper()
+	sub := models.WebhookSubscription{URL: url, Events: events, Secret: "whsec_test", Active: active}
+	if err := store.CreateWebhookSubscription(context.Background(), &sub); err != nil {
+		t.Fatal(err)
+	}
+	return sub
+}
+
+func runAll(q *jobs.Queue) {
+	for q.RunNext(context.Background()) {
+	}
+}
+
+func TestPublish(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	d, q, store := newTestDispatcher(t, 3)
+	sub := subscribe(t, store, srv.URL, true, models.WebhookOrderCreated)
+	subscribe(t, store, srv.URL, true, models.WebhookProductUpdated)
+	subscribe(t, store, srv.URL, false, models.WebhookOrderCreated)
+
+	order := models.Order{
+		OrderID:     uuid.New(),
+		UserID:      "fk@htmxrocks.com",
+		OrderStatus: models.OrderStatusPaid,
+		Items:       []models.OrderItem{{Quantity: 2, Cost: 20, Product: models.Product{ProductName: "Laptop"}}},
+	}
+	if err := d.Publish(context.Background(), "42", models.WebhookOrderCreated, newOrder(order)); err != nil {
+		t.Fatal(err)
+	}
+	runAll(q)
+
+	if len(rc.received) != 1 {
+		t.Fatalf("received %d deliveries, want 1 for the active order subscription", len(rc.received))
+	}
+	req, body := rc.received[0], rc.bodies[0]
+	if err := payments.Verify(sub.Secret, body, req.Header.Get(SignatureHeader), time.Now()); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+	if req.Header.Get(EventHeader) != "order.created" || req.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("headers = %v", req.Header)
+	}
+	var envelope struct {
+		ID   string
+		Type string
+		Data Order
+	}
+	if err := json.Unmarshal(body, &envelope); err != nil {
+		t.Fatal(err)
+	}
+	if envelope.ID != "42" || envelope.Type != "order.created" || envelope.Data.ID != order.OrderID ||
+		envelope.Data.Total != 20 || len(envelope.Data.Items) != 1 || envelope.Data.Items[0].ProductName != "Laptop" {
+		t.Errorf("body = %s", body)
+	}
+
+	deliveries, err := store.ListWebhookDeliveries(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deliveries) != 1 || deliveries[0].Status != models.WebhookDeliveryDelivered || deliveries[0].ResponseStatus != 200 {
+		t.Fatalf("deliveries = %+v, want one delivered", deliveries)
+	}
+
+	// The outbox publishes an event again when publishing it failed
+	if err := d.Publish(context.Background(), "42", models.WebhookOrderCreated, newOrder(order)); err != nil {
+		t.Fatal(err)
+	}
+	runAll(q)
+	if len(rc.received) != 1 {
+		t.Errorf("received %d deliveries after publishing again, want 1", len(rc.received))
+	}
+	if deliveries, err = store.ListWebhookDeliveries(context.Background(), 0, 10); err != nil || len(deliveries) != 1 {
+		t.Errorf("deliveries after publishing again = %+v, %v, want still one", deliveries, err)
+	}
+}
+
+func TestDeliveryRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		failures     int
+		wantStatus   models.WebhookDeliveryStatus
+		wantAttempts int
+	}{
+		{"delivered on a retry", 2, models.WebhookDeliveryDelivered, 3},
+		{"out of attempts", 3, models.WebhookDeliveryFailed, 3},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rc := &receiver{failures: tc.failures}
+			srv := httptest.NewServer(rc)
+			defer srv.Close()
+			d, q, store := newTestDispatcher(t, 3)
+			subscribe(t, store, srv.URL, true, models.WebhookProductDeleted)
+
+			if err := d.Publish(context.Background(), "1", models.WebhookProductDeleted, newProduct(models.Product{ProductID: uuid.New()})); err != nil {
+				t.Fatal(err)
+			}
+			runAll(q)
+
+			deliveries, err := store.ListWebhookDeliveries(context.Background(), 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := deliveries[0]
+			if got.Status != tc.wantStatus || got.Attempts != tc.wantAttempts {
+				t.Fatalf("delivery is %s after %d attempts, want %s after %d", got.Status, got.Attempts, tc.wantStatus, tc.wantAttempts)
+			}
+			if tc.wantStatus == models.WebhookDeliveryFailed && (got.ResponseStatus != 503 || got.LastError == "") {
+				t.Errorf("failed delivery = %+v, want the last response recorded", got)
+			}
+		})
+	}
+}
+
+func TestRedeliver(t *testing.T) {
+	rc := &receiver{failures: 1}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	d, q, store := newTestDispatcher(t, 1)
+	subscribe(t, store, srv.URL, true, models.WebhookProductUpdated)
+
+	if err := d.Publish(context.Background(), "1", models.WebhookProductUpdated, newProduct(models.Product{ProductID: uuid.New()})); err != nil {
+		t.Fatal(err)
+	}
+	runAll(q)
+	deliveries, err := store.ListWebhookDeliveries(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deliveries[0].Status != models.WebhookDeliveryFailed {
+		t.Fatalf("delivery is %s, want failed", deliveries[0].Status)
+	}
+
+	if err := d.Redeliver(context.Background(), deliveries[0].DeliveryID); err != nil {
+		t.Fatal(err)
+	}
+	runAll(q)
+	got, err := store.GetWebhookDelivery(context.Background(), deliveries[0].DeliveryID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != models.WebhookDeliveryDelivered || got.Attempts != 2 || got.DeliveredAt == nil {
+		t.Fatalf("redelivered delivery = %+v, want delivered on the second attempt", got)
+	}
+	if string(rc.bodies[0]) != string(rc.bodies[1]) || rc.received[0].Header.Get(DeliveryHeader) != rc.received[1].Header.Get(DeliveryHeader) {
+		t.Error("redelivery is not the same delivery")
+	}
+}
+
+func TestDisabledSubscription(t *testing.T) {
+	rc := &receiver{}
+	srv := httptest.NewServer(rc)
+	defer srv.Close()
+	d, q, store := newTestDispatcher(t, 3)
+	sub := subscribe(t, store, srv.URL, true, models.WebhookOrderStatusChanged)
+
+	if err := d.Publish(context.Background(), "1", models.WebhookOrderStatusChanged, newOrder(models.Order{OrderID: uuid.New()})); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SetWebhookSubscriptionActive(context.Background(), sub.SubscriptionID, false); err != nil {
+		t.Fatal(err)
+	}
+	runAll(q)
+
+	deliveries, err := store.ListWebhookDeliveries(context.Background(), 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rc.received) != 0 || deliveries[0].Status != models.WebhookDeliveryFailed || deliveries[0].Attempts != 0 {
+		t.Fatalf("delivery to a disabled subscription = %+v, sent %d times, want failed without sending", deliveries[0], len(rc.received))
+	}
+}
+
+func TestSink(t *testing.T) {
+	ctx := context.Background()
+	repo := repository.NewMemoryRepository()
+	d := New(repo.Webhook, jobs.New(repo.Job, config.Default().Jobs))
+	sink := NewSink(d, repo)
+	subscribe(t, repo.Webhook, "https://erp.example/hooks", true, models.WebhookEvents...)
+
+	stock := 5
+	product := models.Product{ProductName: "Test Chair", Price: 30, Description: "A chair", Stock: &stock}
+	if err := repo.Product.CreateProduct(ctx, &product); err != nil {
+		t.Fatal(err)
+	}
+	order := models.Order{
+		UserID:      "fk@htmxrocks.com",
+		OrderStatus: models.OrderStatusPendingPayment,
+		Items:       []models.OrderItem{{ProductID: product.ProductID, Quantity: 2, Cost: 60}},
+	}
+
+	tests := []struct {
+		name   string
+		change func() error
+		want   []models.WebhookEvent
+	}{
+		{
+			"order waiting for payment",
+			func() error { return repo.Order.PlaceOrder(ctx, &order) },
+			[]models.WebhookEvent{models.WebhookProductUpdated},
+		},
+		{
+			"payment goes through",
+			func() error { return repo.Order.SetOrderStatus(ctx, order.OrderID, models.OrderStatusPaid) },
+			[]models.WebhookEvent{models.WebhookOrderCreated},
+		},
+		{
+			"order refunded",
+			func() error { return repo.Order.SetOrderStatus(ctx, order.OrderID, models.OrderStatusRefunded) },
+			[]models.WebhookEvent{models.WebhookOrderStatusChanged},
+		},
+		{
+			"product archived",
+			func() error { return repo.Product.ArchiveProduct(ctx, product.ProductID) },
+			[]models.WebhookEvent{models.WebhookProductDeleted},
+		},
+	}
+	published := 0
+	for _, tc := range tests {
+		if err := tc.change(); err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		events, err := repo.Outbox.ClaimOutboxEvents(ctx, time.Now(), time.Now().Add(time.Minute), 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, event := range events {
+			if err := sink.Publish(ctx, event); err != nil {
+				t.Fatalf("%s: publishing %s: %v", tc.name, event.Type, err)
+			}
+		}
+
+		deliveries, err := repo.Webhook.ListWebhookDeliveries(ctx, 0, 10)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []models.WebhookEvent
+		for _, delivery := range deliveries[:len(deliveries)-published] {
+			got = append(got, delivery.Event)
+		}
+		published = len(deliveries)
+		if !slices.Equal(got, tc.want) {
+			t.Errorf("%s: delivered %v, want %v", tc.name, got, tc.want)
+		}
+	}
+
+	deliveries, err := repo.Webhook.ListWebhookDeliveries(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var created Envelope
+	created.Data = &Order{}
+	if err := json.Unmarshal(deliveries[2].Payload, &created); err != nil {
+		t.Fatal(err)
+	}
+	if data := created.Data.(*Order); created.ID == "" || data.ID != order.OrderID || data.Status != models.OrderStatusPaid {
+		t.Errorf("order.created = %s, want the paid order", deliveries[2].Payload)
+	}
+}